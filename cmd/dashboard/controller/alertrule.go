@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/copier"
+	"gorm.io/gorm"
 
 	"github.com/nezhahq/nezha/model"
 	"github.com/nezhahq/nezha/service/singleton"
@@ -31,6 +32,30 @@ func listAlertRule(c *gin.Context) ([]*model.AlertRule, error) {
 	return ar, nil
 }
 
+// Get Alert Rule State
+// @Summary Get alert rule state
+// @Security BearerAuth
+// @Schemes
+// @Description Get the current evaluated state (ok/alarm/unknown) of an alert rule on each server it covers
+// @Tags auth required
+// @param id path uint true "Alert Rule ID"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.AlertRuleState]
+// @Router /alert-rule/{id}/state [get]
+func getAlertRuleState(c *gin.Context) (*model.AlertRuleState, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	state, ok := singleton.GetAlertRuleState(id)
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("alert id %d does not exist", id)
+	}
+	return state, nil
+}
+
 // Add Alert Rule
 // @Summary Add Alert Rule
 // @Security BearerAuth
@@ -39,15 +64,16 @@ func listAlertRule(c *gin.Context) ([]*model.AlertRule, error) {
 // @Tags auth required
 // @Accept json
 // @param request body model.AlertRuleForm true "AlertRuleForm"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[uint64]
+// @Success 200 {object} model.CommonResponse[model.AlertRule]
 // @Router /alert-rule [post]
-func createAlertRule(c *gin.Context) (uint64, error) {
+func createAlertRule(c *gin.Context) (*model.AlertRule, error) {
 	var arf model.AlertRuleForm
 	var r model.AlertRule
 
 	if err := c.ShouldBindJSON(&arf); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	r.Name = arf.Name
@@ -55,20 +81,28 @@ func createAlertRule(c *gin.Context) (uint64, error) {
 	r.FailTriggerTasks = arf.FailTriggerTasks
 	r.RecoverTriggerTasks = arf.RecoverTriggerTasks
 	r.NotificationGroupID = arf.NotificationGroupID
+	r.ServerGroupID = arf.ServerGroupID
 	enable := arf.Enable
 	r.TriggerMode = arf.TriggerMode
 	r.Enable = &enable
+	r.SilenceUntil = arf.SilenceUntil
+	r.QuietHoursStart = arf.QuietHoursStart
+	r.QuietHoursEnd = arf.QuietHoursEnd
+	r.FlapThreshold = arf.FlapThreshold
+	r.FlapWindow = arf.FlapWindow
+	r.EscalationIntervals = arf.EscalationIntervals
+	r.EscalationNotificationGroupID = arf.EscalationNotificationGroupID
 
 	if err := validateRule(&r); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	if err := singleton.DB.Create(&r).Error; err != nil {
-		return 0, newGormError("%v", err)
+		return nil, newGormError("%v", err)
 	}
 
 	singleton.OnRefreshOrAddAlert(&r)
-	return r.ID, nil
+	return &r, nil
 }
 
 // Update Alert Rule
@@ -81,9 +115,9 @@ func createAlertRule(c *gin.Context) (uint64, error) {
 // @param id path uint true "Alert ID"
 // @param request body model.AlertRuleForm true "AlertRuleForm"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[any]
+// @Success 200 {object} model.CommonResponse[model.AlertRule]
 // @Router /alert-rule/{id} [patch]
-func updateAlertRule(c *gin.Context) (any, error) {
+func updateAlertRule(c *gin.Context) (*model.AlertRule, error) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 64)
 	if err != nil {
@@ -92,33 +126,78 @@ func updateAlertRule(c *gin.Context) (any, error) {
 
 	var arf model.AlertRuleForm
 	if err := c.ShouldBindJSON(&arf); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	var r model.AlertRule
 	if err := singleton.DB.First(&r, id).Error; err != nil {
 		return nil, singleton.Localizer.ErrorT("alert id %d does not exist", id)
 	}
+	loaded := r.UpdatedAt
+	if err := checkVersion(arf.UpdatedAt, r.UpdatedAt, &r); err != nil {
+		return nil, err
+	}
 
 	r.Name = arf.Name
 	r.Rules = arf.Rules
 	r.FailTriggerTasks = arf.FailTriggerTasks
 	r.RecoverTriggerTasks = arf.RecoverTriggerTasks
 	r.NotificationGroupID = arf.NotificationGroupID
+	r.ServerGroupID = arf.ServerGroupID
 	enable := arf.Enable
 	r.TriggerMode = arf.TriggerMode
 	r.Enable = &enable
+	r.SilenceUntil = arf.SilenceUntil
+	r.QuietHoursStart = arf.QuietHoursStart
+	r.QuietHoursEnd = arf.QuietHoursEnd
+	r.FlapThreshold = arf.FlapThreshold
+	r.FlapWindow = arf.FlapWindow
+	r.EscalationIntervals = arf.EscalationIntervals
+	r.EscalationNotificationGroupID = arf.EscalationNotificationGroupID
 
 	if err := validateRule(&r); err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	if err := saveVersion(&r, r.ID, loaded); err != nil {
+		return nil, err
+	}
+
+	singleton.OnRefreshOrAddAlert(&r)
+	return &r, nil
+}
+
+// Clone Alert Rule
+// @Summary Clone Alert Rule
+// @Security BearerAuth
+// @Schemes
+// @Description Create a copy of an alert rule with the same settings, the name gets a " (copy)" suffix
+// @Tags auth required
+// @param id path uint true "Alert ID"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.AlertRule]
+// @Router /alert-rule/{id}/clone [post]
+func cloneAlertRule(c *gin.Context) (*model.AlertRule, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := singleton.DB.Save(&r).Error; err != nil {
-		return 0, newGormError("%v", err)
+	var r model.AlertRule
+	if err := singleton.DB.First(&r, id).Error; err != nil {
+		return nil, singleton.Localizer.ErrorT("alert id %d does not exist", id)
+	}
+	r.Common = model.Common{}
+	r.Name += cloneNameSuffix
+
+	if err := singleton.DB.Create(&r).Error; err != nil {
+		return nil, newGormError("%v", err)
 	}
 
 	singleton.OnRefreshOrAddAlert(&r)
-	return r.ID, nil
+	return &r, nil
 }
 
 // Batch delete Alert rules
@@ -139,7 +218,13 @@ func batchDeleteAlertRule(c *gin.Context) (any, error) {
 		return nil, err
 	}
 
-	if err := singleton.DB.Unscoped().Delete(&model.AlertRule{}, "id in (?)", ar).Error; err != nil {
+	err := singleton.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&model.AlertRule{}, "id in (?)", ar).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.AlertFlapState{}, "alert_rule_id in (?)", ar).Error
+	})
+	if err != nil {
 		return nil, newGormError("%v", err)
 	}
 
@@ -150,6 +235,11 @@ func batchDeleteAlertRule(c *gin.Context) (any, error) {
 func validateRule(r *model.AlertRule) error {
 	if len(r.Rules) > 0 {
 		for _, rule := range r.Rules {
+			if rule.IsNetworkQualityRule() {
+				if err := validateNetworkQualityRule(&rule); err != nil {
+					return err
+				}
+			}
 			if !rule.IsTransferDurationRule() {
 				if rule.Duration < 3 {
 					return singleton.Localizer.ErrorT("duration need to be at least 3")
@@ -169,5 +259,48 @@ func validateRule(r *model.AlertRule) error {
 	} else {
 		return singleton.Localizer.ErrorT("need to configure at least a single rule")
 	}
+	if r.ServerGroupID != 0 && !singleton.ServerGroupExists(r.ServerGroupID) {
+		return singleton.Localizer.ErrorT("group id %d does not exist", r.ServerGroupID)
+	}
+	for _, interval := range r.EscalationIntervals {
+		if interval < 60 {
+			return singleton.Localizer.ErrorT("escalation interval need to be at least 60 seconds")
+		}
+	}
+	if (r.QuietHoursStart == "") != (r.QuietHoursEnd == "") {
+		return singleton.Localizer.ErrorT("quiet hours need both a start and an end")
+	}
+	for _, t := range []string{r.QuietHoursStart, r.QuietHoursEnd} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", t); err != nil {
+			return singleton.Localizer.ErrorT("invalid quiet hours %s, expected HH:MM", t)
+		}
+	}
+	return nil
+}
+
+func validateNetworkQualityRule(rule *model.Rule) error {
+	if rule.SampleWindow < 1 || rule.SampleWindow > singleton.QualitySampleSize {
+		return singleton.Localizer.ErrorT("sample_window must be an integer between 1 and %d", singleton.QualitySampleSize)
+	}
+	if rule.Type == "packet_loss" && (rule.Max <= 0 || rule.Max > 100) {
+		return singleton.Localizer.ErrorT("packet loss threshold must be a percentage between 0 and 100")
+	}
+	if rule.Type == "jitter" && rule.Max <= 0 {
+		return singleton.Localizer.ErrorT("jitter threshold must be greater than 0")
+	}
+
+	singleton.ServiceSentinelShared.ServicesLock.RLock()
+	defer singleton.ServiceSentinelShared.ServicesLock.RUnlock()
+	service, ok := singleton.ServiceSentinelShared.Services[rule.ServiceID]
+	if !ok {
+		return singleton.Localizer.ErrorT("service id %d does not exist", rule.ServiceID)
+	}
+	// 仅 ICMP/TCP 监控会采集延迟与丢包数据
+	if service.Type != model.TaskTypeICMPPing && service.Type != model.TaskTypeTCPPing {
+		return singleton.Localizer.ErrorT("service %s does not collect latency samples", service.Name)
+	}
 	return nil
 }
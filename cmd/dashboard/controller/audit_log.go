@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+// auditBodyMaxSize 记录请求内容与解析响应时读取的最大字节数
+const auditBodyMaxSize = 64 * 1024
+
+// auditRedactedKeywords 字段名包含这些关键字时，记录的值会被替换
+// 与导出配置时排除的字段一致，通知与 Webhook 的地址和请求体中常带有 Bot Token 等凭据
+var auditRedactedKeywords = []string{"password", "secret", "token", "key", "otp", "header", "dsn", "url", "body"}
+
+// auditEntityModels 更新这些对象时记录修改前后有差异的字段，其余操作记录脱敏后的请求内容
+var auditEntityModels = map[string]func() any{
+	"server":       func() any { return new(model.Server) },
+	"service":      func() any { return new(model.Service) },
+	"notification": func() any { return new(model.Notification) },
+	"alert-rule":   func() any { return new(model.AlertRule) },
+	"cron":         func() any { return new(model.Cron) },
+	"ddns":         func() any { return new(model.DDNSProfile) },
+	"nat":          func() any { return new(model.NAT) },
+}
+
+// captureResponseWriter 在写出响应的同时保留响应体开头的 max 字节，用于判断操作结果
+type captureResponseWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+	max int
+}
+
+func (w *captureResponseWriter) Write(data []byte) (int, error) {
+	if remain := w.max - w.buf.Len(); remain > 0 {
+		w.buf.Write(data[:min(len(data), remain)])
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// auditLog 记录会员接口中的修改操作，批量请求中的子请求会单独记录
+func auditLog(c *gin.Context) {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead ||
+		c.Request.Method == http.MethodOptions || c.FullPath() == "/api/v1/batch" {
+		c.Next()
+		return
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		body, _ = io.ReadAll(io.LimitReader(c.Request.Body, auditBodyMaxSize+1))
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+	}
+
+	entityType := auditEntityType(c.FullPath())
+	var before map[string]any
+	if c.Request.Method != http.MethodDelete {
+		before = loadAuditEntity(entityType, c.Param("id"))
+	}
+
+	w := &captureResponseWriter{ResponseWriter: c.Writer, max: auditBodyMaxSize}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+
+	entry := model.AuditLog{
+		IP:         c.GetString(model.CtxKeyRealIPStr),
+		Method:     c.Request.Method,
+		Route:      c.FullPath(),
+		EntityType: entityType,
+		EntityID:   c.Param("id"),
+		Status:     w.Status(),
+	}
+	if before != nil {
+		entry.Changes = auditDiff(before, loadAuditEntity(entityType, entry.EntityID))
+	}
+	if entry.Changes == "" {
+		entry.Changes = auditChanges(body)
+	}
+	if entry.IP == "" {
+		entry.IP = c.RemoteIP()
+	}
+	if entry.Route == "" {
+		entry.Route = c.Request.URL.Path
+	}
+	if user, ok := c.Get(model.CtxKeyAuthorizedUser); ok {
+		entry.UserID = user.(*model.User).ID
+		entry.Username = user.(*model.User).Username
+	}
+
+	var resp model.CommonResponse[any]
+	if err := utils.Json.Unmarshal(w.buf.Bytes(), &resp); err == nil {
+		entry.Success = resp.Success
+		entry.Error = resp.Error
+		// 创建操作返回新记录或新记录的 ID
+		if entry.EntityID == "" && c.Request.Method == http.MethodPost {
+			entry.EntityID = auditCreatedID(resp.Data)
+		}
+	} else {
+		entry.Success = entry.Status < http.StatusBadRequest
+	}
+
+	if err := singleton.DB.Create(&entry).Error; err != nil {
+		slog.Error("failed to save audit log", "route", entry.Route, "error", err)
+	}
+}
+
+// auditCreatedID 从创建操作的响应中取出新记录的 ID
+func auditCreatedID(data any) string {
+	if m, ok := data.(map[string]any); ok {
+		data = m["id"]
+	}
+	if id, ok := data.(float64); ok {
+		return utils.Itoa(uint64(id))
+	}
+	return ""
+}
+
+// auditEntityType 由路由得出操作的对象类型，如 /api/v1/batch-delete/service 为 service
+func auditEntityType(route string) string {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(route, "/api/v1"), "/"), "/")
+	if len(segments) > 1 && (strings.HasPrefix(segments[0], "batch-") || segments[0] == "force-update") {
+		return segments[1]
+	}
+	return segments[0]
+}
+
+// loadAuditEntity 读取对象当前的内容，不记录差异的对象类型或对象不存在时返回 nil
+func loadAuditEntity(entityType, id string) map[string]any {
+	newEntity, ok := auditEntityModels[entityType]
+	if !ok {
+		return nil
+	}
+	entityID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil
+	}
+	entity := newEntity()
+	if err := singleton.DB.First(entity, entityID).Error; err != nil {
+		return nil
+	}
+	data, err := utils.Json.Marshal(entity)
+	if err != nil {
+		return nil
+	}
+	var v map[string]any
+	if err := utils.Json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// auditDiff 返回修改前后取值不同的字段，如 {"name":{"old":"a","new":"b"}}，敏感字段只记录发生了修改
+// 修改后的对象无法读取时返回空字符串
+func auditDiff(before, after map[string]any) string {
+	if after == nil {
+		return ""
+	}
+	changes := make(map[string]any)
+	diff := func(key string, oldValue, newValue any) {
+		if key == "created_at" || key == "updated_at" || reflect.DeepEqual(oldValue, newValue) {
+			return
+		}
+		if auditSensitiveKey(key) {
+			changes[key] = map[string]any{"old": "******", "new": "******"}
+			return
+		}
+		changes[key] = map[string]any{"old": redactAuditValue(oldValue), "new": redactAuditValue(newValue)}
+	}
+	for k, v := range after {
+		diff(k, before[k], v)
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			diff(k, v, nil)
+		}
+	}
+	data, err := utils.Json.Marshal(changes)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// auditChanges 返回脱敏后的请求内容
+func auditChanges(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if len(body) > auditBodyMaxSize {
+		return fmt.Sprintf("[request body larger than %d bytes]", auditBodyMaxSize)
+	}
+	var v any
+	if err := utils.Json.Unmarshal(body, &v); err != nil {
+		return fmt.Sprintf("[non-JSON request body, %d bytes]", len(body))
+	}
+	data, err := utils.Json.Marshal(redactAuditValue(v))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func redactAuditValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, item := range val {
+			if auditSensitiveKey(k) && item != nil && item != "" {
+				val[k] = "******"
+				continue
+			}
+			val[k] = redactAuditValue(item)
+		}
+	case []any:
+		for i, item := range val {
+			val[i] = redactAuditValue(item)
+		}
+	}
+	return v
+}
+
+func auditSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, keyword := range auditRedactedKeywords {
+		if strings.Contains(key, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// List audit logs
+// @Summary List audit logs
+// @Security BearerAuth
+// @Schemes
+// @Description List changes made through the API, newest first. Updates of servers, services, notifications, alert rules, crons, DDNS profiles and NAT record only the changed fields with their old and new values, other operations record the request body. Passwords, secrets, URLs and similar fields are redacted.
+// @Tags auth required
+// @param user_id query uint false "Only list changes made by this user"
+// @param from query int false "Start time in milliseconds"
+// @param to query int false "End time in milliseconds"
+// @param limit query int false "Page size (default 20, max 100)"
+// @param offset query int false "Offset"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[[]model.AuditLog]
+// @Router /audit-log [get]
+func listAuditLog(c *gin.Context) ([]model.AuditLog, error) {
+	var query model.AuditLogQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return nil, err
+	}
+	if query.Limit <= 0 {
+		query.Limit = 20
+	} else if query.Limit > 100 {
+		query.Limit = 100
+	}
+
+	db := singleton.DB
+	tx := db.Model(&model.AuditLog{})
+	if query.UserID > 0 {
+		tx = tx.Where("user_id = ?", query.UserID)
+	}
+	if query.From > 0 {
+		tx = tx.Where(model.DateTimeExpr(db, "created_at")+" >= "+model.DateTimeExpr(db, "?"), time.UnixMilli(query.From).UTC())
+	}
+	if query.To > 0 {
+		tx = tx.Where(model.DateTimeExpr(db, "created_at")+" <= "+model.DateTimeExpr(db, "?"), time.UnixMilli(query.To).UTC())
+	}
+
+	var logs []model.AuditLog
+	if err := tx.Order("id desc").Limit(query.Limit).Offset(query.Offset).Find(&logs).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+	return logs, nil
+}
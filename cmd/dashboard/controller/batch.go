@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+// batchMaxRequests 单次批量请求最多包含的子请求数
+const batchMaxRequests = 100
+
+// batchDeniedRoutes 不能通过批量请求执行的接口，:id 匹配单段路径，* 匹配之后的所有路径
+// 包括批量请求本身、无法建立的 WebSocket，以及流式响应和需要等待 Agent 回传的接口，它们会阻塞整个批量响应
+var batchDeniedRoutes = []string{
+	"/batch",
+	"/ws/*",
+	"/force-update/stream",
+	"/transfer/export",
+	"/server/:id/agent-log",
+}
+
+// Batch requests
+// @Summary Batch requests
+// @Security BearerAuth
+// @Schemes
+// @Description Execute API requests sequentially with the credentials of the current request, paths are relative to /api/v1. WebSocket, streaming and agent-waiting endpoints (/ws/*, /force-update/stream, /transfer/export, /server/{id}/agent-log) and /batch itself are rejected
+// @Tags auth required
+// @Accept json
+// @param request body model.BatchForm true "Batch Request"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[[]model.BatchResult]
+// @Router /batch [post]
+func batchRequest(r *gin.Engine) handlerFunc[[]model.BatchResult] {
+	return func(c *gin.Context) ([]model.BatchResult, error) {
+		var bf model.BatchForm
+		if err := c.ShouldBindJSON(&bf); err != nil {
+			return nil, err
+		}
+		if len(bf.Requests) == 0 {
+			return nil, singleton.Localizer.ErrorT("requests cannot be empty")
+		}
+		if len(bf.Requests) > batchMaxRequests {
+			return nil, singleton.Localizer.ErrorT("too many requests, at most %d", batchMaxRequests)
+		}
+		for i, br := range bf.Requests {
+			if err := validateBatchRequest(br); err != nil {
+				return nil, singleton.Localizer.ErrorT("request %d: %v", i, err)
+			}
+		}
+
+		results := make([]model.BatchResult, 0, len(bf.Requests))
+		for _, br := range bf.Requests {
+			result := serveBatchRequest(r, c.Request, br)
+			results = append(results, result)
+			if bf.StopOnError && !batchSucceeded(result) {
+				break
+			}
+		}
+		return results, nil
+	}
+}
+
+func validateBatchRequest(br model.BatchRequest) error {
+	switch br.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete:
+	default:
+		return singleton.Localizer.ErrorT("unsupported method: %s", br.Method)
+	}
+	p, _, _ := strings.Cut(br.Path, "?")
+	if !strings.HasPrefix(p, "/") {
+		return singleton.Localizer.ErrorT("unsupported path: %s", br.Path)
+	}
+	p = path.Clean(p)
+	for _, route := range batchDeniedRoutes {
+		if matchBatchRoute(route, p) {
+			return singleton.Localizer.ErrorT("unsupported path: %s", br.Path)
+		}
+	}
+	return nil
+}
+
+// matchBatchRoute 判断路径是否匹配 batchDeniedRoutes 中的路由
+func matchBatchRoute(route, p string) bool {
+	routeSegments := strings.Split(strings.Trim(route, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(p, "/"), "/")
+	for i, segment := range routeSegments {
+		if segment == "*" {
+			return true
+		}
+		if i >= len(pathSegments) {
+			return false
+		}
+		if !strings.HasPrefix(segment, ":") && segment != pathSegments[i] {
+			return false
+		}
+	}
+	return len(routeSegments) == len(pathSegments)
+}
+
+// serveBatchRequest 携带原请求的认证信息与来源地址，经由路由执行子请求
+func serveBatchRequest(r *gin.Engine, parent *http.Request, br model.BatchRequest) model.BatchResult {
+	req, err := http.NewRequestWithContext(parent.Context(), br.Method, "/api/v1"+br.Path, bytes.NewReader(br.Body))
+	if err != nil {
+		body, _ := utils.Json.Marshal(newErrorResponse(err))
+		return model.BatchResult{Status: http.StatusBadRequest, Body: body}
+	}
+	req.Header = parent.Header.Clone()
+	req.Header.Del("Content-Length")
+	req.Header.Del("Accept-Encoding")
+	req.Header.Del("If-None-Match")
+	req.Header.Del(idempotencyKeyHeader)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = parent.RemoteAddr
+	req.Host = parent.Host
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	result := model.BatchResult{Status: w.Code}
+	if body := w.Body.Bytes(); utils.Json.Valid(body) {
+		result.Body = body
+	} else if len(body) > 0 {
+		result.Body, _ = utils.Json.Marshal(string(body))
+	}
+	return result
+}
+
+// batchSucceeded 业务错误同样以 200 返回，需要检查响应中的 success 与 error 字段
+func batchSucceeded(result model.BatchResult) bool {
+	if result.Status >= http.StatusBadRequest {
+		return false
+	}
+	var resp struct {
+		Success *bool  `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := utils.Json.Unmarshal(result.Body, &resp); err != nil {
+		return true
+	}
+	if resp.Success == nil {
+		return resp.Error == ""
+	}
+	return *resp.Success
+}
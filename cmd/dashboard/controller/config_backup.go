@@ -0,0 +1,377 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+// Export configuration
+// @Summary Export configuration
+// @Security BearerAuth
+// @Schemes
+// @Description Export services, schedule tasks, notifications, alert rules, DDNS and NAT as a single document. HTTP monitor passwords and tokens are never exported and must be set again after import. Servers are listed by UUID so that references can be matched on import
+// @Tags auth required
+// @param exclude_secrets query bool false "Clear DDNS credentials and webhooks, notification URLs, bodies, headers, provider tokens and signing keys, and service request headers"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.ConfigBackup]
+// @Router /config/export [get]
+func exportConfig(c *gin.Context) (*model.ConfigBackup, error) {
+	var query model.ConfigExportQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return nil, err
+	}
+
+	backup := &model.ConfigBackup{
+		Version:    singleton.Version,
+		ExportedAt: time.Now(),
+	}
+	var (
+		notifications []model.Notification
+		services      []model.Service
+	)
+	for _, dest := range []any{
+		&notifications, &backup.NotificationGroups, &backup.NotificationGroupNotifications,
+		&backup.Crons, &services, &backup.AlertRules, &backup.DDNSProfiles, &backup.NATs,
+	} {
+		if err := singleton.DB.Order("id").Find(dest).Error; err != nil {
+			return nil, newGormError("%v", err)
+		}
+	}
+	if err := singleton.DB.Model(&model.Server{}).Order("id").Find(&backup.Servers).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+	for _, n := range notifications {
+		backup.Notifications = append(backup.Notifications, model.NotificationBackup{Notification: n, SecretKey: n.SecretKey})
+	}
+	for _, m := range services {
+		backup.Services = append(backup.Services, model.ServiceBackup{Service: m, HTTPHeaders: m.HTTPHeaders})
+	}
+
+	if query.ExcludeSecrets {
+		for i := range backup.Notifications {
+			backup.Notifications[i].URL = ""
+			backup.Notifications[i].RequestHeader = ""
+			backup.Notifications[i].RequestBody = ""
+			backup.Notifications[i].ProviderToken = ""
+			backup.Notifications[i].SecretKey = ""
+		}
+		for i := range backup.DDNSProfiles {
+			backup.DDNSProfiles[i].AccessID = ""
+			backup.DDNSProfiles[i].AccessSecret = ""
+			backup.DDNSProfiles[i].WebhookURL = ""
+			backup.DDNSProfiles[i].WebhookRequestBody = ""
+			backup.DDNSProfiles[i].WebhookHeaders = ""
+		}
+		for i := range backup.Services {
+			backup.Services[i].HTTPHeaders = nil
+		}
+	}
+
+	return backup, nil
+}
+
+// Import configuration
+// @Summary Import configuration
+// @Security BearerAuth
+// @Schemes
+// @Description Import a document produced by the export endpoint in a single transaction. IDs are reassigned and references between entities are rewired; entities whose name (or NAT domain) already exists are skipped and reused. Server references are matched by UUID, references to servers that do not exist here are dropped and NATs on them are skipped.
+// @Tags auth required
+// @Accept json
+// @param request body model.ConfigBackup true "ConfigBackup"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.ConfigImportResponse]
+// @Router /config/import [post]
+func importConfig(c *gin.Context) (*model.ConfigImportResponse, error) {
+	var backup model.ConfigBackup
+	if err := c.ShouldBindJSON(&backup); err != nil {
+		return nil, err
+	}
+
+	var (
+		resp               model.ConfigImportResponse
+		notifications      []*model.Notification
+		notificationGroups []*model.NotificationGroup
+		crons              []*model.Cron
+		services           []*model.Service
+		alertRules         []*model.AlertRule
+		ddnsProfiles       []*model.DDNSProfile
+		nats               []*model.NAT
+		groupNotifications = make(map[uint64][]uint64)
+	)
+
+	err := singleton.DB.Transaction(func(tx *gorm.DB) error {
+		// 服务器不会导入，引用的服务器按 UUID 对应到当前面板的服务器，无法对应的引用会被丢弃
+		serverIDs := make(map[uint64]uint64)
+		for _, server := range backup.Servers {
+			if server.UUID == "" {
+				continue
+			}
+			if id, err := findIDByColumn(tx, &model.Server{}, "uuid", server.UUID); err != nil {
+				return err
+			} else if id != 0 {
+				serverIDs[server.ID] = id
+			}
+		}
+
+		notificationIDs := make(map[uint64]uint64)
+		for i := range backup.Notifications {
+			n := &backup.Notifications[i].Notification
+			n.SecretKey = backup.Notifications[i].SecretKey
+			oldID := n.ID
+			if id, err := findIDByColumn(tx, &model.Notification{}, "name", n.Name); err != nil {
+				return err
+			} else if id != 0 {
+				notificationIDs[oldID] = id
+				resp.Notifications.Skipped = append(resp.Notifications.Skipped, oldID)
+				continue
+			}
+			n.Common = model.Common{}
+			if err := tx.Create(n).Error; err != nil {
+				return err
+			}
+			notificationIDs[oldID] = n.ID
+			notifications = append(notifications, n)
+			resp.Notifications.Created = append(resp.Notifications.Created, n.ID)
+		}
+
+		groupIDs := make(map[uint64]uint64)
+		createdGroups := make(map[uint64]bool)
+		for i := range backup.NotificationGroups {
+			ng := &backup.NotificationGroups[i]
+			oldID := ng.ID
+			if id, err := findIDByColumn(tx, &model.NotificationGroup{}, "name", ng.Name); err != nil {
+				return err
+			} else if id != 0 {
+				groupIDs[oldID] = id
+				resp.NotificationGroups.Skipped = append(resp.NotificationGroups.Skipped, oldID)
+				continue
+			}
+			ng.Common = model.Common{}
+			if err := tx.Create(ng).Error; err != nil {
+				return err
+			}
+			groupIDs[oldID] = ng.ID
+			createdGroups[ng.ID] = true
+			notificationGroups = append(notificationGroups, ng)
+			resp.NotificationGroups.Created = append(resp.NotificationGroups.Created, ng.ID)
+		}
+		// 仅为新建的通知组关联通知方式，已存在的通知组保持不变
+		for _, ngn := range backup.NotificationGroupNotifications {
+			groupID, nID := groupIDs[ngn.NotificationGroupID], notificationIDs[ngn.NotificationID]
+			if !createdGroups[groupID] || nID == 0 {
+				continue
+			}
+			if err := tx.Create(&model.NotificationGroupNotification{
+				NotificationGroupID: groupID,
+				NotificationID:      nID,
+			}).Error; err != nil {
+				return err
+			}
+			groupNotifications[groupID] = append(groupNotifications[groupID], nID)
+		}
+
+		cronIDs := make(map[uint64]uint64)
+		for i := range backup.Crons {
+			cr := &backup.Crons[i]
+			oldID := cr.ID
+			if id, err := findIDByColumn(tx, &model.Cron{}, "name", cr.Name); err != nil {
+				return err
+			} else if id != 0 {
+				cronIDs[oldID] = id
+				resp.Crons.Skipped = append(resp.Crons.Skipped, oldID)
+				continue
+			}
+			if cr.TaskType == model.CronTypeCronTask {
+				if err := singleton.ValidateCronSpec(cr.ScheduleSpec()); err != nil {
+					resp.Crons.Skipped = append(resp.Crons.Skipped, oldID)
+					continue
+				}
+			}
+			cr.Common = model.Common{}
+			cr.CronJobID = 0
+			cr.NotificationGroupID = groupIDs[cr.NotificationGroupID]
+			cr.Servers = remapIDs(cr.Servers, serverIDs)
+			if err := tx.Create(cr).Error; err != nil {
+				return err
+			}
+			cronIDs[oldID] = cr.ID
+			crons = append(crons, cr)
+			resp.Crons.Created = append(resp.Crons.Created, cr.ID)
+		}
+
+		serviceIDs := make(map[uint64]uint64)
+		for i := range backup.Services {
+			m := &backup.Services[i].Service
+			m.HTTPHeaders = backup.Services[i].HTTPHeaders
+			oldID := m.ID
+			if id, err := findIDByColumn(tx, &model.Service{}, "name", m.Name); err != nil {
+				return err
+			} else if id != 0 {
+				serviceIDs[oldID] = id
+				resp.Services.Skipped = append(resp.Services.Skipped, oldID)
+				continue
+			}
+			m.Common = model.Common{}
+			m.CronJobID = 0
+			m.NotificationGroupID = groupIDs[m.NotificationGroupID]
+			m.FailTriggerTasks = remapIDs(m.FailTriggerTasks, cronIDs)
+			m.RecoverTriggerTasks = remapIDs(m.RecoverTriggerTasks, cronIDs)
+			m.SkipServers = remapIDSet(m.SkipServers, serverIDs)
+			if err := tx.Create(m).Error; err != nil {
+				return err
+			}
+			serviceIDs[oldID] = m.ID
+			services = append(services, m)
+			resp.Services.Created = append(resp.Services.Created, m.ID)
+		}
+
+		for i := range backup.AlertRules {
+			r := &backup.AlertRules[i]
+			oldID := r.ID
+			if id, err := findIDByColumn(tx, &model.AlertRule{}, "name", r.Name); err != nil {
+				return err
+			} else if id != 0 {
+				resp.AlertRules.Skipped = append(resp.AlertRules.Skipped, oldID)
+				continue
+			}
+			r.Common = model.Common{}
+			r.NotificationGroupID = groupIDs[r.NotificationGroupID]
+			r.EscalationNotificationGroupID = groupIDs[r.EscalationNotificationGroupID]
+			r.FailTriggerTasks = remapIDs(r.FailTriggerTasks, cronIDs)
+			r.RecoverTriggerTasks = remapIDs(r.RecoverTriggerTasks, cronIDs)
+			for j := range r.Rules {
+				if r.Rules[j].ServiceID != 0 {
+					r.Rules[j].ServiceID = serviceIDs[r.Rules[j].ServiceID]
+				}
+				r.Rules[j].Ignore = remapIDSet(r.Rules[j].Ignore, serverIDs)
+			}
+			if err := validateRule(r); err != nil {
+				resp.AlertRules.Skipped = append(resp.AlertRules.Skipped, oldID)
+				continue
+			}
+			if err := tx.Create(r).Error; err != nil {
+				return err
+			}
+			alertRules = append(alertRules, r)
+			resp.AlertRules.Created = append(resp.AlertRules.Created, r.ID)
+		}
+
+		for i := range backup.DDNSProfiles {
+			p := &backup.DDNSProfiles[i]
+			oldID := p.ID
+			if id, err := findIDByColumn(tx, &model.DDNSProfile{}, "name", p.Name); err != nil {
+				return err
+			} else if id != 0 {
+				resp.DDNSProfiles.Skipped = append(resp.DDNSProfiles.Skipped, oldID)
+				continue
+			}
+			p.Common = model.Common{}
+			if err := tx.Create(p).Error; err != nil {
+				return err
+			}
+			ddnsProfiles = append(ddnsProfiles, p)
+			resp.DDNSProfiles.Created = append(resp.DDNSProfiles.Created, p.ID)
+		}
+
+		for i := range backup.NATs {
+			n := &backup.NATs[i]
+			oldID := n.ID
+			// 域名需要唯一
+			if id, err := findIDByColumn(tx, &model.NAT{}, "domain", n.Domain); err != nil {
+				return err
+			} else if id != 0 {
+				resp.NATs.Skipped = append(resp.NATs.Skipped, oldID)
+				continue
+			}
+			// 内网穿透所在的服务器不存在时跳过
+			if n.ServerID = serverIDs[n.ServerID]; n.ServerID == 0 {
+				resp.NATs.Skipped = append(resp.NATs.Skipped, oldID)
+				continue
+			}
+			n.Common = model.Common{}
+			if err := tx.Create(n).Error; err != nil {
+				return err
+			}
+			nats = append(nats, n)
+			resp.NATs.Created = append(resp.NATs.Created, n.ID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	// 事务提交后再加载到内存中
+	for _, n := range notifications {
+		singleton.OnRefreshOrAddNotification(n)
+	}
+	singleton.UpdateNotificationList()
+	for _, ng := range notificationGroups {
+		singleton.OnRefreshOrAddNotificationGroup(ng, groupNotifications[ng.ID])
+	}
+
+	for _, cr := range crons {
+		if cr.TaskType == model.CronTypeCronTask && !cr.Paused {
+			if cr.CronJobID, err = singleton.Cron.AddFunc(cr.ScheduleSpec(), singleton.CronTrigger(cr)); err != nil {
+				return nil, err
+			}
+		}
+		singleton.OnRefreshOrAddCron(cr)
+	}
+	singleton.UpdateCronList()
+
+	for _, m := range services {
+		if err := singleton.ServiceSentinelShared.OnServiceUpdate(*m); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range alertRules {
+		singleton.OnRefreshOrAddAlert(r)
+	}
+
+	for _, p := range ddnsProfiles {
+		singleton.OnDDNSUpdate(p)
+	}
+	singleton.UpdateDDNSList()
+	for _, n := range nats {
+		singleton.OnNATUpdate(n)
+	}
+	singleton.UpdateNATList()
+
+	return &resp, nil
+}
+
+// findIDByColumn 查找指定列等于 value 的第一条记录的 ID，不存在时返回 0
+func findIDByColumn(tx *gorm.DB, m any, column, value string) (uint64, error) {
+	var id uint64
+	err := tx.Model(m).Select("id").Where(model.QuoteColumn(tx, column)+" = ?", value).Limit(1).Scan(&id).Error
+	return id, err
+}
+
+// remapIDs 将导入文件中的 ID 替换为新分配的 ID，丢弃无法对应的 ID
+func remapIDs(ids []uint64, mapping map[uint64]uint64) []uint64 {
+	remapped := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		if newID, ok := mapping[id]; ok {
+			remapped = append(remapped, newID)
+		}
+	}
+	return remapped
+}
+
+// remapIDSet 与 remapIDs 相同，用于以 ID 为键的集合
+func remapIDSet(ids map[uint64]bool, mapping map[uint64]uint64) map[uint64]bool {
+	remapped := make(map[uint64]bool, len(ids))
+	for id, v := range ids {
+		if newID, ok := mapping[id]; ok {
+			remapped[newID] = v
+		}
+	}
+	return remapped
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-contrib/pprof"
@@ -36,9 +37,14 @@ func ServeWeb(adminFrontend, userFrontend fs.FS) http.Handler {
 		r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
 	}
 
+	// 健康检查不经过 WAF 与鉴权，避免负载均衡器的探测被拦截
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", readyz)
+
 	r.Use(waf.RealIp)
 	r.Use(waf.Waf)
 	r.Use(recordPath)
+	r.Use(compress)
 
 	routers(r, adminFrontend, userFrontend)
 
@@ -53,84 +59,128 @@ func routers(r *gin.Engine, adminFrontend, userFrontend fs.FS) {
 	if err := authMiddleware.MiddlewareInit(); err != nil {
 		log.Fatal("authMiddleware.MiddlewareInit Error:" + err.Error())
 	}
+	r.GET("/metrics", metricsHandler())
+
 	api := r.Group("api/v1")
-	api.POST("/login", authMiddleware.LoginHandler)
+	api.POST("/login", authRateLimit, authMiddleware.LoginHandler)
+	api.GET("/logout", logout(authMiddleware))
+	api.POST("/logout", logout(authMiddleware))
+	api.GET("/oauth2/login", oauth2Login)
+	api.GET("/oauth2/callback", oauth2Callback(authMiddleware))
+	api.POST("/view-password", authRateLimit, commonHandler(verifyViewPassword))
 
 	optionalAuth := api.Group("", optionalAuthMiddleware(authMiddleware))
 	optionalAuth.GET("/ws/server", commonHandler(serverStream))
 	optionalAuth.GET("/server-group", commonHandler(listServerGroup))
 
-	optionalAuth.GET("/service", commonHandler(listService))
+	optionalAuth.GET("/service", withETag(commonHandler(listService)))
 	optionalAuth.GET("/service/:id", commonHandler(listServiceHistory))
 	optionalAuth.GET("/service/server", commonHandler(listServerWithServices))
 
 	optionalAuth.GET("/setting", commonHandler(listConfig))
 
-	auth := api.Group("", authMiddleware.MiddlewareFunc())
+	auth := api.Group("", authMiddleware.MiddlewareFunc(), auditLog)
 
-	auth.GET("/refresh-token", authMiddleware.RefreshHandler)
+	auth.GET("/refresh-token", authRateLimit, authMiddleware.RefreshHandler)
+	auth.POST("/batch", commonHandler(batchRequest(r)))
 
 	auth.POST("/terminal", commonHandler(createTerminal))
 	auth.GET("/ws/terminal/:id", commonHandler(terminalStream))
 
 	auth.GET("/file", commonHandler(createFM))
 	auth.GET("/ws/file/:id", commonHandler(fmStream))
+	auth.GET("/ws/cron-run/:id", commonHandler(cronRunStream))
 
 	auth.GET("/profile", commonHandler(getProfile))
 	auth.POST("/profile", commonHandler(updateProfile))
+	auth.POST("/profile/password", commonHandler(changePassword))
+	auth.POST("/profile/2fa/enroll", authRateLimit, commonHandler(enrollTwoFactor))
+	auth.POST("/profile/2fa/verify", authRateLimit, commonHandler(verifyTwoFactorEnrollment))
+	auth.POST("/profile/2fa/disable", authRateLimit, commonHandler(disableTwoFactor))
+	auth.POST("/oauth2/bind", commonHandler(oauth2Bind))
+	auth.GET("/sessions", commonHandler(listSession))
+	auth.DELETE("/sessions/:id", commonHandler(deleteSession))
 	auth.GET("/user", commonHandler(listUser))
-	auth.POST("/user", commonHandler(createUser))
+	auth.POST("/user", idempotent, commonHandler(createUser))
 	auth.POST("/batch-delete/user", commonHandler(batchDeleteUser))
 
-	auth.POST("/service", commonHandler(createService))
+	auth.POST("/service", idempotent, commonHandler(createService))
+	auth.POST("/batch-create/service", idempotent, commonHandler(batchCreateService))
+	auth.POST("/service/test", commonHandler(testService))
 	auth.PATCH("/service/:id", commonHandler(updateService))
+	auth.POST("/service/:id/clone", idempotent, commonHandler(cloneService))
 	auth.POST("/batch-delete/service", commonHandler(batchDeleteService))
 
-	auth.POST("/server-group", commonHandler(createServerGroup))
+	auth.POST("/server-group", idempotent, commonHandler(createServerGroup))
 	auth.PATCH("/server-group/:id", commonHandler(updateServerGroup))
 	auth.POST("/batch-delete/server-group", commonHandler(batchDeleteServerGroup))
 
 	auth.GET("/notification-group", commonHandler(listNotificationGroup))
-	auth.POST("/notification-group", commonHandler(createNotificationGroup))
+	auth.POST("/notification-group", idempotent, commonHandler(createNotificationGroup))
 	auth.PATCH("/notification-group/:id", commonHandler(updateNotificationGroup))
 	auth.POST("/batch-delete/notification-group", commonHandler(batchDeleteNotificationGroup))
 
 	auth.GET("/server", commonHandler(listServer))
+	auth.GET("/server/offline", commonHandler(listOfflineServer))
+	auth.PATCH("/server/display-index", commonHandler(updateServerDisplayIndex))
 	auth.PATCH("/server/:id", commonHandler(updateServer))
+	auth.POST("/server/resort", commonHandler(resortServer))
+	auth.GET("/server/:id/agent-log", commonHandler(getAgentLog))
+	auth.POST("/server/:id/meta", commonHandler(updateServerMeta))
+	auth.POST("/server/:id/mute", commonHandler(muteServer))
 	auth.POST("/batch-delete/server", commonHandler(batchDeleteServer))
 	auth.POST("/force-update/server", commonHandler(forceUpdateServer))
+	auth.GET("/force-update/stream", forceUpdateServerStream)
+	auth.POST("/restart", commonHandler(restartServer))
 
 	auth.GET("/notification", commonHandler(listNotification))
-	auth.POST("/notification", commonHandler(createNotification))
+	auth.POST("/notification", idempotent, commonHandler(createNotification))
+	auth.POST("/notification/test", commonHandler(testNotification))
 	auth.PATCH("/notification/:id", commonHandler(updateNotification))
+	auth.GET("/notification/failed", commonHandler(listFailedNotification))
+	auth.POST("/notification/:id/replay", commonHandler(replayFailedNotification))
 	auth.POST("/batch-delete/notification", commonHandler(batchDeleteNotification))
 
 	auth.GET("/alert-rule", commonHandler(listAlertRule))
-	auth.POST("/alert-rule", commonHandler(createAlertRule))
+	auth.POST("/alert-rule", idempotent, commonHandler(createAlertRule))
 	auth.PATCH("/alert-rule/:id", commonHandler(updateAlertRule))
+	auth.POST("/alert-rule/:id/clone", idempotent, commonHandler(cloneAlertRule))
+	auth.GET("/alert-rule/:id/state", commonHandler(getAlertRuleState))
 	auth.POST("/batch-delete/alert-rule", commonHandler(batchDeleteAlertRule))
 
 	auth.GET("/cron", commonHandler(listCron))
-	auth.POST("/cron", commonHandler(createCron))
+	auth.POST("/cron", idempotent, commonHandler(createCron))
 	auth.PATCH("/cron/:id", commonHandler(updateCron))
+	auth.POST("/cron/:id/clone", idempotent, commonHandler(cloneCron))
 	auth.GET("/cron/:id/manual", commonHandler(manualTriggerCron))
+	auth.GET("/cron/:id/history", commonHandler(listCronHistory))
 	auth.POST("/batch-delete/cron", commonHandler(batchDeleteCron))
 
 	auth.GET("/ddns", commonHandler(listDDNS))
 	auth.GET("/ddns/providers", commonHandler(listProviders))
-	auth.POST("/ddns", commonHandler(createDDNS))
+	auth.POST("/ddns", idempotent, commonHandler(createDDNS))
 	auth.PATCH("/ddns/:id", commonHandler(updateDDNS))
+	auth.POST("/ddns/:id/dry-run", commonHandler(dryRunDDNS))
+	auth.GET("/ddns/:id/history", commonHandler(listDDNSHistory))
 	auth.POST("/batch-delete/ddns", commonHandler(batchDeleteDDNS))
 
 	auth.GET("/nat", commonHandler(listNAT))
-	auth.POST("/nat", commonHandler(createNAT))
+	auth.POST("/nat", idempotent, commonHandler(createNAT))
 	auth.PATCH("/nat/:id", commonHandler(updateNAT))
+	auth.GET("/nat/:id/status", commonHandler(getNATStatus))
 	auth.POST("/batch-delete/nat", commonHandler(batchDeleteNAT))
 
+	auth.GET("/transfer/export", exportTransfer)
+
 	auth.GET("/waf", commonHandler(listBlockedAddress))
 	auth.POST("/batch-delete/waf", commonHandler(batchDeleteBlockedAddress))
 
 	auth.PATCH("/setting", commonHandler(updateConfig))
+	auth.GET("/config/export", commonHandler(exportConfig))
+	auth.POST("/config/import", commonHandler(importConfig))
+	auth.POST("/maintenance/clean-history", commonHandler(cleanHistory))
+	auth.POST("/maintenance-mode", commonHandler(setMaintenanceMode))
+	auth.GET("/audit-log", commonHandler(listAuditLog))
 
 	r.NoRoute(fallbackToFrontend(adminFrontend, userFrontend))
 }
@@ -152,6 +202,9 @@ func newErrorResponse(err error) model.CommonResponse[any] {
 
 type handlerFunc[T any] func(c *gin.Context) (T, error)
 
+// cloneNameSuffix 复制监控、计划任务与报警规则时追加到名称后的后缀
+const cloneNameSuffix = " (copy)"
+
 // There are many error types in gorm, so create a custom type to represent all
 // gorm errors here instead
 type gormError struct {
@@ -186,6 +239,41 @@ func (we *wsError) Error() string {
 	return fmt.Sprintf(we.msg, we.a...)
 }
 
+// conflictError 记录在加载后已被修改，返回 409 并附带当前记录供客户端合并
+type conflictError struct {
+	current any
+}
+
+func (ce *conflictError) Error() string {
+	return singleton.Localizer.T("the record has been modified since it was loaded, please reload and try again")
+}
+
+// checkVersion 客户端提交的 updated_at 与数据库中的记录不一致时返回冲突错误，未提交时不检查
+// PostgreSQL 只保存到微秒，比较前统一截断
+func checkVersion(loaded *time.Time, current time.Time, entity any) error {
+	if loaded == nil || loaded.Truncate(time.Microsecond).Equal(current.Truncate(time.Microsecond)) {
+		return nil
+	}
+	return &conflictError{current: entity}
+}
+
+// saveVersion 以加载时的 updated_at 为条件保存记录，在此期间记录已被其他请求修改时返回冲突错误
+// checkVersion 只比较客户端提交的版本，两个请求同时通过检查时由这里保证只有一个能保存成功
+func saveVersion[T any](m *T, id uint64, loaded time.Time) error {
+	result := singleton.DB.Model(m).Where("updated_at = ?", loaded).Select("*").Updates(m)
+	if result.Error != nil {
+		return newGormError("%v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var current T
+		if err := singleton.DB.First(&current, id).Error; err != nil {
+			return newGormError("%v", err)
+		}
+		return &conflictError{current: &current}
+	}
+	return nil
+}
+
 func commonHandler[T any](handler handlerFunc[T]) func(*gin.Context) {
 	return func(c *gin.Context) {
 		data, err := handler(c)
@@ -198,6 +286,9 @@ func commonHandler[T any](handler handlerFunc[T]) func(*gin.Context) {
 			log.Printf("NEZHA>> gorm error: %v", err)
 			c.JSON(http.StatusOK, newErrorResponse(singleton.Localizer.ErrorT("database error")))
 			return
+		case *conflictError:
+			c.JSON(http.StatusConflict, model.CommonResponse[any]{Success: false, Error: err.Error(), Data: err.(*conflictError).current})
+			return
 		case *wsError:
 			// Connection is upgraded to WebSocket, so c.Writer is no longer usable
 			if msg := err.Error(); msg != "" {
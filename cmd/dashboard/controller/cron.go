@@ -3,9 +3,11 @@ package controller
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/copier"
+	"gorm.io/gorm"
 
 	"github.com/nezhahq/nezha/model"
 	"github.com/nezhahq/nezha/service/singleton"
@@ -39,15 +41,16 @@ func listCron(c *gin.Context) ([]*model.Cron, error) {
 // @Tags auth required
 // @Accept json
 // @param request body model.CronForm true "CronForm"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[uint64]
+// @Success 200 {object} model.CommonResponse[model.Cron]
 // @Router /cron [post]
-func createCron(c *gin.Context) (uint64, error) {
+func createCron(c *gin.Context) (*model.Cron, error) {
 	var cf model.CronForm
 	var cr model.Cron
 
 	if err := c.ShouldBindJSON(&cf); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	cr.TaskType = cf.TaskType
@@ -58,26 +61,52 @@ func createCron(c *gin.Context) (uint64, error) {
 	cr.PushSuccessful = cf.PushSuccessful
 	cr.NotificationGroupID = cf.NotificationGroupID
 	cr.Cover = cf.Cover
+	cr.MaxRetries = cf.MaxRetries
+	cr.RetryInterval = cf.RetryInterval
+	cr.Timeout = cf.Timeout
+	cr.SkipIfRunning = cf.SkipIfRunning
+	cr.Timezone = cf.Timezone
+	cr.Paused = cf.Paused
 
 	if cr.TaskType == model.CronTypeCronTask && cr.Cover == model.CronCoverAlertTrigger {
-		return 0, singleton.Localizer.ErrorT("scheduled tasks cannot be triggered by alarms")
+		return nil, singleton.Localizer.ErrorT("scheduled tasks cannot be triggered by alarms")
+	}
+
+	// 触发任务不依赖表达式，但填写了也需要合法，避免切换为计划任务后无法调度
+	if cr.TaskType == model.CronTypeCronTask || cr.Scheduler != "" {
+		if err := singleton.ValidateCronSpec(cr.Scheduler); err != nil {
+			return nil, err
+		}
+	}
+
+	if cr.MaxRetries > 10 {
+		return nil, singleton.Localizer.ErrorT("the retry count must be an integer between 0 and 10")
+	}
+
+	if cr.Timezone != "" {
+		if _, err := time.LoadLocation(cr.Timezone); err != nil {
+			return nil, singleton.Localizer.ErrorT("invalid timezone: %s", cr.Timezone)
+		}
 	}
 
 	// 对于计划任务类型，需要更新CronJob
 	var err error
-	if cf.TaskType == model.CronTypeCronTask {
-		if cr.CronJobID, err = singleton.Cron.AddFunc(cr.Scheduler, singleton.CronTrigger(&cr)); err != nil {
-			return 0, err
+	if cr.TaskType == model.CronTypeCronTask && !cr.Paused {
+		if cr.CronJobID, err = singleton.Cron.AddFunc(cr.ScheduleSpec(), singleton.CronTrigger(&cr)); err != nil {
+			return nil, err
 		}
 	}
 
 	if err = singleton.DB.Create(&cr).Error; err != nil {
-		return 0, newGormError("%v", err)
+		if cr.CronJobID != 0 {
+			singleton.Cron.Remove(cr.CronJobID)
+		}
+		return nil, newGormError("%v", err)
 	}
 
 	singleton.OnRefreshOrAddCron(&cr)
 	singleton.UpdateCronList()
-	return cr.ID, nil
+	return &cr, nil
 }
 
 // Update schedule task
@@ -90,9 +119,9 @@ func createCron(c *gin.Context) (uint64, error) {
 // @param id path uint true "Task ID"
 // @param request body model.CronForm true "CronForm"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[any]
+// @Success 200 {object} model.CommonResponse[model.Cron]
 // @Router /cron/{id} [patch]
-func updateCron(c *gin.Context) (any, error) {
+func updateCron(c *gin.Context) (*model.Cron, error) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 64)
 	if err != nil {
@@ -101,13 +130,17 @@ func updateCron(c *gin.Context) (any, error) {
 
 	var cf model.CronForm
 	if err := c.ShouldBindJSON(&cf); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	var cr model.Cron
 	if err := singleton.DB.First(&cr, id).Error; err != nil {
 		return nil, fmt.Errorf("task id %d does not exist", id)
 	}
+	loaded := cr.UpdatedAt
+	if err := checkVersion(cf.UpdatedAt, cr.UpdatedAt, &cr); err != nil {
+		return nil, err
+	}
 
 	cr.TaskType = cf.TaskType
 	cr.Name = cf.Name
@@ -117,39 +150,66 @@ func updateCron(c *gin.Context) (any, error) {
 	cr.PushSuccessful = cf.PushSuccessful
 	cr.NotificationGroupID = cf.NotificationGroupID
 	cr.Cover = cf.Cover
+	cr.MaxRetries = cf.MaxRetries
+	cr.RetryInterval = cf.RetryInterval
+	cr.Timeout = cf.Timeout
+	cr.SkipIfRunning = cf.SkipIfRunning
+	cr.Timezone = cf.Timezone
+	cr.Paused = cf.Paused
 
 	if cr.TaskType == model.CronTypeCronTask && cr.Cover == model.CronCoverAlertTrigger {
 		return nil, singleton.Localizer.ErrorT("scheduled tasks cannot be triggered by alarms")
 	}
 
+	// 触发任务不依赖表达式，但填写了也需要合法，避免切换为计划任务后无法调度
+	if cr.TaskType == model.CronTypeCronTask || cr.Scheduler != "" {
+		if err := singleton.ValidateCronSpec(cr.Scheduler); err != nil {
+			return nil, err
+		}
+	}
+
+	if cr.MaxRetries > 10 {
+		return nil, singleton.Localizer.ErrorT("the retry count must be an integer between 0 and 10")
+	}
+
+	if cr.Timezone != "" {
+		if _, err := time.LoadLocation(cr.Timezone); err != nil {
+			return nil, singleton.Localizer.ErrorT("invalid timezone: %s", cr.Timezone)
+		}
+	}
+
 	// 对于计划任务类型，需要更新CronJob
-	if cf.TaskType == model.CronTypeCronTask {
-		if cr.CronJobID, err = singleton.Cron.AddFunc(cr.Scheduler, singleton.CronTrigger(&cr)); err != nil {
+	if cr.TaskType == model.CronTypeCronTask && !cr.Paused {
+		if cr.CronJobID, err = singleton.Cron.AddFunc(cr.ScheduleSpec(), singleton.CronTrigger(&cr)); err != nil {
 			return nil, err
 		}
 	}
 
-	if err = singleton.DB.Save(&cr).Error; err != nil {
-		return nil, newGormError("%v", err)
+	if err = saveVersion(&cr, cr.ID, loaded); err != nil {
+		if cr.CronJobID != 0 {
+			singleton.Cron.Remove(cr.CronJobID)
+		}
+		return nil, err
 	}
 
 	singleton.OnRefreshOrAddCron(&cr)
 	singleton.UpdateCronList()
-	return nil, nil
+	return &cr, nil
 }
 
 // Trigger schedule task
 // @Summary Trigger schedule task
 // @Security BearerAuth
 // @Schemes
-// @Description Trigger schedule task
+// @Description Trigger schedule task. With live=true the response contains a run_id, connect to /ws/cron-run/{run_id} within stream_connect_timeout seconds to receive the output as it is produced. Servers whose agent does not support live output run the command normally and are not listed in streamed.
 // @Tags auth required
 // @Accept json
 // @param id path uint true "Task ID"
+// @param live query bool false "Stream the output of this run"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[any]
+// @Success 200 {object} model.CommonResponse[model.CronTriggerResponse]
 // @Router /cron/{id}/manual [get]
-func manualTriggerCron(c *gin.Context) (any, error) {
+func manualTriggerCron(c *gin.Context) (*model.CronTriggerResponse, error) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 64)
 	if err != nil {
@@ -161,8 +221,91 @@ func manualTriggerCron(c *gin.Context) (any, error) {
 		return nil, singleton.Localizer.ErrorT("task id %d does not exist", id)
 	}
 
-	singleton.ManualTrigger(&cr)
-	return nil, nil
+	var query model.CronTriggerQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return nil, err
+	}
+	if query.Live {
+		return triggerCronLive(&cr)
+	}
+	return singleton.ManualTrigger(&cr, nil), nil
+}
+
+// List execution history of a schedule task
+// @Summary List execution history of a schedule task
+// @Security BearerAuth
+// @Schemes
+// @Description List execution history of a schedule task with per-server results, newest first
+// @Tags auth required
+// @param id path uint true "Task ID"
+// @param limit query int false "Page size (default 20, max 100)"
+// @param offset query int false "Offset"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[[]model.CronHistory]
+// @Router /cron/{id}/history [get]
+func listCronHistory(c *gin.Context) ([]model.CronHistory, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var query model.PaginationQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return nil, err
+	}
+	if query.Limit <= 0 {
+		query.Limit = 20
+	} else if query.Limit > 100 {
+		query.Limit = 100
+	}
+
+	var histories []model.CronHistory
+	if err := singleton.DB.Where("cron_id = ?", id).Order("id desc").
+		Limit(query.Limit).Offset(query.Offset).Find(&histories).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	return histories, nil
+}
+
+// Clone schedule task
+// @Summary Clone schedule task
+// @Security BearerAuth
+// @Schemes
+// @Description Create a paused copy of a schedule task, the name gets a " (copy)" suffix. Resume it by editing the copy.
+// @Tags auth required
+// @param id path uint true "Task ID"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.Cron]
+// @Router /cron/{id}/clone [post]
+func cloneCron(c *gin.Context) (*model.Cron, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var cr model.Cron
+	if err := singleton.DB.First(&cr, id).Error; err != nil {
+		return nil, singleton.Localizer.ErrorT("task id %d does not exist", id)
+	}
+	cr.Common = model.Common{}
+	cr.Name += cloneNameSuffix
+	// 副本暂停且不注册调度，避免与原任务同时执行
+	cr.Paused = true
+	cr.CronJobID = 0
+	cr.LastExecutedAt = time.Time{}
+	cr.LastResult = false
+
+	if err := singleton.DB.Create(&cr).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	singleton.OnRefreshOrAddCron(&cr)
+	singleton.UpdateCronList()
+	return &cr, nil
 }
 
 // Batch delete schedule tasks
@@ -183,7 +326,13 @@ func batchDeleteCron(c *gin.Context) (any, error) {
 		return nil, err
 	}
 
-	if err := singleton.DB.Unscoped().Delete(&model.Cron{}, "id in (?)", cr).Error; err != nil {
+	err := singleton.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&model.Cron{}, "id in (?)", cr).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.CronHistory{}, "cron_id in (?)", cr).Error
+	})
+	if err != nil {
 		return nil, newGormError("%v", err)
 	}
 
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-uuid"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	"github.com/nezhahq/nezha/pkg/websocketx"
+	"github.com/nezhahq/nezha/service/rpc"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+// cronLiveRun 一次手动执行中各服务器的输出流，[server_id] -> stream_id
+type cronLiveRun map[uint64]string
+
+var (
+	cronLiveRunsLock sync.Mutex
+	cronLiveRuns     = make(map[string]cronLiveRun)
+)
+
+// triggerCronLive 手动执行计划任务并为每台服务器创建输出流
+// Agent 不支持实时回传的服务器不会创建输出流
+// 需要在 StreamConnectTimeout 内连接 /ws/cron-run/{run_id}，否则输出流会被关闭
+func triggerCronLive(cr *model.Cron) (*model.CronTriggerResponse, error) {
+	runID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	run := make(cronLiveRun)
+	resp := singleton.ManualTrigger(cr, func(serverID uint64) (string, error) {
+		streamId, err := uuid.GenerateUUID()
+		if err != nil {
+			return "", err
+		}
+		rpc.NezhaHandlerSingleton.CreateStream(streamId)
+		run[serverID] = streamId
+		return streamId, nil
+	})
+	// 下发失败的服务器不会连接输出流
+	for _, id := range resp.Failure {
+		if streamId, ok := run[id]; ok {
+			rpc.NezhaHandlerSingleton.CloseStream(streamId)
+			delete(run, id)
+		}
+	}
+	if len(run) == 0 {
+		return resp, nil
+	}
+
+	for id := range run {
+		resp.Streamed = append(resp.Streamed, id)
+	}
+	slices.Sort(resp.Streamed)
+
+	cronLiveRunsLock.Lock()
+	cronLiveRuns[runID] = run
+	cronLiveRunsLock.Unlock()
+	time.AfterFunc(time.Duration(singleton.Conf.StreamConnectTimeout)*time.Second, func() {
+		if run := takeCronLiveRun(runID); run != nil {
+			run.close()
+		}
+	})
+
+	resp.RunID = runID
+	return resp, nil
+}
+
+// takeCronLiveRun 取出并移除执行记录，每次执行只能连接一次
+func takeCronLiveRun(runID string) cronLiveRun {
+	cronLiveRunsLock.Lock()
+	defer cronLiveRunsLock.Unlock()
+	run := cronLiveRuns[runID]
+	delete(cronLiveRuns, runID)
+	return run
+}
+
+func (run cronLiveRun) close() {
+	for _, streamId := range run {
+		rpc.NezhaHandlerSingleton.CloseStream(streamId)
+	}
+}
+
+// Stream output of a manually triggered schedule task
+// @Summary Stream output of a manually triggered schedule task
+// @Description Stream stdout and stderr of a run started by /cron/{id}/manual?live=true. Each message is a JSON frame; every server ends with an "end" frame, or an "error" frame if the agent disconnected or never connected.
+// @Tags auth required
+// @Param id path string true "Run ID"
+// @Produce json
+// @Success 200 {object} model.CronRunFrame
+// @Router /ws/cron-run/{id} [get]
+func cronRunStream(c *gin.Context) (any, error) {
+	run := takeCronLiveRun(c.Param("id"))
+	if run == nil {
+		return nil, singleton.Localizer.ErrorT("run not found or already connected")
+	}
+	defer run.close()
+
+	wsConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return nil, newWsError("%v", err)
+	}
+	defer wsConn.Close()
+	conn := websocketx.NewConn(wsConn)
+
+	go streamKeepAlive(conn)
+
+	// 客户端断开后关闭输出流，结束仍在阻塞的读取
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	go func() {
+		for {
+			if _, _, err := wsConn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		run.close()
+	}()
+
+	var wg sync.WaitGroup
+	for serverID, streamId := range run {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			forwardCronOutput(ctx, conn, serverID, streamId)
+		}()
+	}
+	wg.Wait()
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return nil, newWsError("")
+}
+
+func forwardCronOutput(ctx context.Context, conn *websocketx.Conn, serverID uint64, streamId string) {
+	send := func(frameType string, data string) error {
+		msg, _ := utils.Json.Marshal(model.CronRunFrame{ServerID: serverID, Type: frameType, Data: data})
+		return conn.WriteMessage(websocket.TextMessage, msg)
+	}
+
+	agentIo, err := rpc.NezhaHandlerSingleton.AgentReader(streamId, time.Duration(singleton.Conf.StreamConnectTimeout)*time.Second)
+	if err != nil {
+		send(model.CronRunFrameError, err.Error())
+		return
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := agentIo.Read(buf)
+		if n > 0 {
+			if send(model.CronRunFrameOutput, string(buf[:n])) != nil {
+				return
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			send(model.CronRunFrameEnd, "")
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				send(model.CronRunFrameError, singleton.Localizer.Tf("agent disconnected: %v", err))
+			}
+			return
+		}
+	}
+}
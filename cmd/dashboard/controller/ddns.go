@@ -1,13 +1,18 @@
 package controller
 
 import (
+	"slices"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/copier"
 	"golang.org/x/net/idna"
+	"gorm.io/gorm"
 
 	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/ddns"
+	"github.com/nezhahq/nezha/pkg/ddns/webhook"
+	"github.com/nezhahq/nezha/pkg/utils"
 	"github.com/nezhahq/nezha/service/singleton"
 )
 
@@ -41,19 +46,20 @@ func listDDNS(c *gin.Context) ([]*model.DDNSProfile, error) {
 // @Tags auth required
 // @Accept json
 // @param request body model.DDNSForm true "DDNS Request"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[uint64]
+// @Success 200 {object} model.CommonResponse[model.DDNSProfile]
 // @Router /ddns [post]
-func createDDNS(c *gin.Context) (uint64, error) {
+func createDDNS(c *gin.Context) (*model.DDNSProfile, error) {
 	var df model.DDNSForm
 	var p model.DDNSProfile
 
 	if err := c.ShouldBindJSON(&df); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	if df.MaxRetries < 1 || df.MaxRetries > 10 {
-		return 0, singleton.Localizer.ErrorT("the retry count must be an integer between 1 and 10")
+		return nil, singleton.Localizer.ErrorT("the retry count must be an integer between 1 and 10")
 	}
 
 	p.Name = df.Name
@@ -72,23 +78,29 @@ func createDDNS(c *gin.Context) (uint64, error) {
 	p.WebhookRequestBody = df.WebhookRequestBody
 	p.WebhookHeaders = df.WebhookHeaders
 
+	if p.Provider == model.ProviderWebHook {
+		if err := webhook.ValidateProfile(&p); err != nil {
+			return nil, singleton.Localizer.ErrorT("invalid webhook settings: %v", err)
+		}
+	}
+
 	for n, domain := range p.Domains {
 		// IDN to ASCII
 		domainValid, domainErr := idna.Lookup.ToASCII(domain)
 		if domainErr != nil {
-			return 0, singleton.Localizer.ErrorT("error parsing %s: %v", domain, domainErr)
+			return nil, singleton.Localizer.ErrorT("error parsing %s: %v", domain, domainErr)
 		}
 		p.Domains[n] = domainValid
 	}
 
 	if err := singleton.DB.Create(&p).Error; err != nil {
-		return 0, newGormError("%v", err)
+		return nil, newGormError("%v", err)
 	}
 
 	singleton.OnDDNSUpdate(&p)
 	singleton.UpdateDDNSList()
 
-	return p.ID, nil
+	return &p, nil
 }
 
 // Edit DDNS profile
@@ -101,9 +113,9 @@ func createDDNS(c *gin.Context) (uint64, error) {
 // @param id path uint true "Profile ID"
 // @param request body model.DDNSForm true "DDNS Request"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[any]
+// @Success 200 {object} model.CommonResponse[model.DDNSProfile]
 // @Router /ddns/{id} [patch]
-func updateDDNS(c *gin.Context) (any, error) {
+func updateDDNS(c *gin.Context) (*model.DDNSProfile, error) {
 	idStr := c.Param("id")
 
 	id, err := strconv.ParseUint(idStr, 10, 64)
@@ -141,6 +153,12 @@ func updateDDNS(c *gin.Context) (any, error) {
 	p.WebhookRequestBody = df.WebhookRequestBody
 	p.WebhookHeaders = df.WebhookHeaders
 
+	if p.Provider == model.ProviderWebHook {
+		if err := webhook.ValidateProfile(&p); err != nil {
+			return nil, singleton.Localizer.ErrorT("invalid webhook settings: %v", err)
+		}
+	}
+
 	for n, domain := range p.Domains {
 		// IDN to ASCII
 		domainValid, domainErr := idna.Lookup.ToASCII(domain)
@@ -157,7 +175,109 @@ func updateDDNS(c *gin.Context) (any, error) {
 	singleton.OnDDNSUpdate(&p)
 	singleton.UpdateDDNSList()
 
-	return nil, nil
+	return &p, nil
+}
+
+// Dry run DDNS profile
+// @Summary Dry run DDNS profile
+// @Security BearerAuth
+// @Schemes
+// @Description Preview the DNS changes the profile would make for every server using it, without writing any record
+// @Tags auth required
+// @param id path uint true "Profile ID"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[[]model.DDNSDryRunResult]
+// @Router /ddns/{id}/dry-run [post]
+func dryRunDDNS(c *gin.Context) ([]model.DDNSDryRunResult, error) {
+	idStr := c.Param("id")
+
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	singleton.DDNSCacheLock.RLock()
+	_, ok := singleton.DDNSCache[id]
+	singleton.DDNSCacheLock.RUnlock()
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("profile id %d does not exist", id)
+	}
+
+	type serverIP struct {
+		id   uint64
+		name string
+		ip   ddns.IP
+	}
+	var servers []serverIP
+	singleton.ServerLock.RLock()
+	for _, s := range singleton.ServerList {
+		if !s.EnableDDNS || s.GeoIP == nil || !slices.Contains(s.DDNSProfiles, id) {
+			continue
+		}
+		servers = append(servers, serverIP{
+			id:   s.ID,
+			name: s.Name,
+			ip:   ddns.IP{Ipv4Addr: s.GeoIP.IP.IPv4Addr, Ipv6Addr: s.GeoIP.IP.IPv6Addr},
+		})
+	}
+	singleton.ServerLock.RUnlock()
+	slices.SortFunc(servers, func(a, b serverIP) int {
+		return utils.Compare(a.id, b.id)
+	})
+
+	results := make([]model.DDNSDryRunResult, 0, len(servers))
+	for _, s := range servers {
+		result := model.DDNSDryRunResult{ServerID: s.id, ServerName: s.name}
+		providers, err := singleton.GetDDNSProvidersFromProfiles([]uint64{id}, &s.ip)
+		if err != nil {
+			return nil, err
+		}
+		if result.Actions, err = providers[0].DryRun(c.Request.Context()); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// List DDNS update history
+// @Summary List DDNS update history
+// @Security BearerAuth
+// @Schemes
+// @Description List DDNS update history of a profile, newest first
+// @Tags auth required
+// @param id path uint true "Profile ID"
+// @param limit query int false "Page size (default 20, max 100)"
+// @param offset query int false "Offset"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[[]model.DDNSRecord]
+// @Router /ddns/{id}/history [get]
+func listDDNSHistory(c *gin.Context) ([]model.DDNSRecord, error) {
+	idStr := c.Param("id")
+
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var query model.PaginationQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return nil, err
+	}
+	if query.Limit <= 0 {
+		query.Limit = 20
+	} else if query.Limit > 100 {
+		query.Limit = 100
+	}
+
+	var records []model.DDNSRecord
+	if err := singleton.DB.Where("ddns_profile_id = ?", id).Order("id desc").
+		Limit(query.Limit).Offset(query.Offset).Find(&records).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	return records, nil
 }
 
 // Batch delete DDNS configurations
@@ -178,7 +298,13 @@ func batchDeleteDDNS(c *gin.Context) (any, error) {
 		return nil, err
 	}
 
-	if err := singleton.DB.Unscoped().Delete(&model.DDNSProfile{}, "id in (?)", ddnsConfigs).Error; err != nil {
+	err := singleton.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&model.DDNSProfile{}, "id in (?)", ddnsConfigs).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.DDNSRecord{}, "ddns_profile_id in (?)", ddnsConfigs).Error
+	})
+	if err != nil {
 		return nil, newGormError("%v", err)
 	}
 
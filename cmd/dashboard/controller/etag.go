@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etagBodyWriter 缓存响应体，计算出 ETag 后再决定是否写出
+type etagBodyWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *etagBodyWriter) Write(data []byte) (int, error) {
+	return w.buf.Write(data)
+}
+
+func (w *etagBodyWriter) WriteString(s string) (int, error) {
+	return w.buf.WriteString(s)
+}
+
+// withETag 按响应内容生成 ETag，与 If-None-Match 匹配时返回 304 且不发送响应体
+// 响应可能被压缩，因此使用弱 ETag
+func withETag(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &etagBodyWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		handler(c)
+		c.Writer = w.ResponseWriter
+
+		if w.Status() != http.StatusOK {
+			c.Writer.Write(w.buf.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(w.buf.Bytes())
+		etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", "no-cache")
+		if etagMatch(c.GetHeader("If-None-Match"), etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.Writer.Write(w.buf.Bytes())
+	}
+}
+
+// etagMatch 按弱比较判断 If-None-Match 中是否包含 etag
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
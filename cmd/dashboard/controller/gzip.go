@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipMinSize 小于该大小的响应不压缩
+const gzipMinSize = 1024
+
+var (
+	gzipWriterPool  = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression); return w }}
+	flateWriterPool = sync.Pool{New: func() any { w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression); return w }}
+)
+
+// compressWriter 先缓存响应，超过 gzipMinSize 后再决定是否压缩
+// 在此之前调用 Flush 的流式响应不压缩
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	buf      bytes.Buffer
+	decided  bool
+	w        io.Writer // 决定后实际写入的目标
+	closer   func() error
+}
+
+// compress 按 Accept-Encoding 压缩响应，WebSocket 与 HEAD 请求不处理
+func compress(c *gin.Context) {
+	encoding := acceptedEncoding(c.GetHeader("Accept-Encoding"))
+	if encoding == "" || c.Request.Method == http.MethodHead || c.GetHeader("Upgrade") != "" {
+		c.Next()
+		return
+	}
+
+	cw := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+	c.Writer = cw
+	defer func() {
+		cw.finish()
+		c.Writer = cw.ResponseWriter
+	}()
+	c.Header("Vary", "Accept-Encoding")
+	c.Next()
+}
+
+func acceptedEncoding(header string) string {
+	var deflate bool
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(strings.TrimSpace(params), " ", "") == "q=0" {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		return w.w.Write(data)
+	}
+	w.buf.Write(data)
+	if w.buf.Len() >= gzipMinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.closer != nil {
+		if f, ok := w.w.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Size() int {
+	if !w.decided {
+		return w.buf.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *compressWriter) Written() bool {
+	return w.decided || w.buf.Len() > 0 || w.ResponseWriter.Written()
+}
+
+// decide 决定是否压缩并写出已缓存的内容
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	w.w = w.ResponseWriter
+	if large && w.compressible() {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if w.encoding == "gzip" {
+			gz := gzipWriterPool.Get().(*gzip.Writer)
+			gz.Reset(w.ResponseWriter)
+			w.w = gz
+			w.closer = func() error {
+				defer gzipWriterPool.Put(gz)
+				return gz.Close()
+			}
+		} else {
+			fw := flateWriterPool.Get().(*flate.Writer)
+			fw.Reset(w.ResponseWriter)
+			w.w = fw
+			w.closer = func() error {
+				defer flateWriterPool.Put(fw)
+				return fw.Close()
+			}
+		}
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *compressWriter) compressible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	switch status := w.Status(); {
+	case status < http.StatusOK, status == http.StatusNoContent, status == http.StatusPartialContent, status == http.StatusNotModified:
+		return false
+	}
+	// 未设置 Content-Type 时由 net/http 根据内容推断，不能压缩
+	contentType := h.Get("Content-Type")
+	if contentType == "" || strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "json") || strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "xml") || strings.Contains(contentType, "svg")
+}
+
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.closer != nil {
+		w.closer()
+	}
+}
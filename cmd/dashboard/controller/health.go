@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+// healthz 存活探针，进程能处理 HTTP 请求即返回 200
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, model.HealthResponse{Status: "ok"})
+}
+
+// readyz 就绪探针，数据库可用、服务监控已初始化且计划任务调度器正常运行时返回 200，否则返回 503
+func readyz(c *gin.Context) {
+	resp := model.HealthResponse{
+		Status: "ok",
+		Checks: map[string]string{
+			"database":         "ok",
+			"service_sentinel": "ok",
+			"cron":             "ok",
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 3*time.Second)
+	defer cancel()
+	if db, err := singleton.DB.DB(); err != nil {
+		resp.Checks["database"] = err.Error()
+	} else if err := db.PingContext(ctx); err != nil {
+		resp.Checks["database"] = err.Error()
+	}
+	if singleton.ServiceSentinelShared == nil {
+		resp.Checks["service_sentinel"] = "not initialized"
+	}
+	if !singleton.CronAlive() {
+		resp.Checks["cron"] = "scheduler is not running"
+	}
+
+	code := http.StatusOK
+	for _, v := range resp.Checks {
+		if v != "ok" {
+			resp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			break
+		}
+	}
+	c.JSON(code, resp)
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+const (
+	idempotencyKeyHeader       = "Idempotency-Key"
+	idempotencyKeyMaxLen       = 255
+	idempotencyTTL             = 24 * time.Hour
+	idempotencyResponseMaxSize = 1 << 20
+)
+
+type idempotencyEntry struct {
+	hash    [sha256.Size]byte // 请求体的摘要，同一个 key 不能用于不同的请求
+	done    chan struct{}     // 首次请求完成后关闭
+	status  int
+	body    []byte
+	expires time.Time
+}
+
+// idempotencyStore 保存 Idempotency-Key 与首次请求结果的对应关系，状态只保存在内存中
+type idempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+var idempotencyKeys = &idempotencyStore{entries: make(map[string]*idempotencyEntry)}
+
+// begin 返回 key 对应的记录，created 为 true 时由调用方执行请求并在完成后调用 finish
+func (s *idempotencyStore) begin(key string, hash [sha256.Size]byte) (e *idempotencyEntry, created bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if !e.expires.IsZero() && now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if e, ok := s.entries[key]; ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		return e, false
+	}
+	e = &idempotencyEntry{hash: hash, done: make(chan struct{})}
+	s.entries[key] = e
+	return e, true
+}
+
+// finish 保存成功的结果，失败时删除记录以便使用同一个 key 重试
+func (s *idempotencyStore) finish(key string, e *idempotencyEntry, status int, body []byte, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ok {
+		e.status, e.body, e.expires = status, body, time.Now().Add(idempotencyTTL)
+	} else if s.entries[key] == e {
+		delete(s.entries, key)
+	}
+	close(e.done)
+}
+
+// idempotent 为创建接口提供 Idempotency-Key 支持，有效期内重复的请求直接返回首次创建的结果
+// 并发的重复请求会等待首次请求完成，首次请求失败时不保存结果
+func idempotent(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > idempotencyKeyMaxLen {
+		c.AbortWithStatusJSON(http.StatusBadRequest, newErrorResponse(singleton.Localizer.ErrorT("Idempotency-Key is too long, at most %d characters", idempotencyKeyMaxLen)))
+		return
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		if body, err = io.ReadAll(c.Request.Body); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, newErrorResponse(err))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	hash := sha256.Sum256(body)
+
+	var uid uint64
+	if user, ok := c.Get(model.CtxKeyAuthorizedUser); ok {
+		uid = user.(*model.User).ID
+	}
+	key = strconv.FormatUint(uid, 10) + "::" + c.FullPath() + "::" + key
+
+	var e *idempotencyEntry
+	for {
+		var created bool
+		if e, created = idempotencyKeys.begin(key, hash); created {
+			break
+		}
+		if e.hash != hash {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, newErrorResponse(singleton.Localizer.ErrorT("Idempotency-Key has already been used for a different request")))
+			return
+		}
+		select {
+		case <-e.done:
+		case <-c.Request.Context().Done():
+			c.Abort()
+			return
+		}
+		if e.body != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(e.status, "application/json; charset=utf-8", e.body)
+			c.Abort()
+			return
+		}
+	}
+
+	w := &captureResponseWriter{ResponseWriter: c.Writer, max: idempotencyResponseMaxSize}
+	c.Writer = w
+	defer func() {
+		c.Writer = w.ResponseWriter
+		var resp model.CommonResponse[any]
+		ok := w.Status() < http.StatusBadRequest && w.buf.Len() < idempotencyResponseMaxSize &&
+			utils.Json.Unmarshal(w.buf.Bytes(), &resp) == nil && resp.Success
+		idempotencyKeys.finish(key, e, w.Status(), w.buf.Bytes(), ok)
+	}()
+	c.Next()
+}
@@ -2,6 +2,8 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -15,13 +17,31 @@ import (
 	"github.com/nezhahq/nezha/service/singleton"
 )
 
+// errOTPRequired 密码正确但未提供两步验证码，前端据此展示验证码输入框
+var errOTPRequired = errors.New("ApiErrorOTPRequired")
+
+// errMustChangePassword 账户仍在使用初始密码，需要先修改密码
+var errMustChangePassword = errors.New("ApiErrorMustChangePassword")
+
+// mustChangePasswordAllowed 需要修改密码时仍允许访问的接口
+var mustChangePasswordAllowed = map[string]bool{
+	"GET /api/v1/profile":           true,
+	"POST /api/v1/profile/password": true,
+}
+
+const (
+	jwtTimeout = time.Hour
+	// claimSessionID Token 中保存会话 ID 的字段
+	claimSessionID = "sid"
+)
+
 func initParams() *jwt.GinJWTMiddleware {
 	return &jwt.GinJWTMiddleware{
 		Realm:       singleton.Conf.SiteName,
 		Key:         []byte(singleton.Conf.JWTSecretKey),
 		CookieName:  "nz-jwt",
 		SendCookie:  true,
-		Timeout:     time.Hour,
+		Timeout:     jwtTimeout,
 		MaxRefresh:  time.Hour,
 		IdentityKey: model.CtxKeyAuthorizedUser,
 		PayloadFunc: payloadFunc(),
@@ -44,14 +64,16 @@ func initParams() *jwt.GinJWTMiddleware {
 			})
 		},
 		RefreshResponse: refreshResponse,
+		LogoutResponse:  logoutResponse,
 	}
 }
 
 func payloadFunc() func(data interface{}) jwt.MapClaims {
 	return func(data interface{}) jwt.MapClaims {
-		if v, ok := data.(string); ok {
+		if v, ok := data.(*model.Session); ok {
 			return jwt.MapClaims{
-				model.CtxKeyAuthorizedUser: v,
+				model.CtxKeyAuthorizedUser: utils.Itoa(v.UserID),
+				claimSessionID:             utils.Itoa(v.ID),
 			}
 		}
 		return jwt.MapClaims{}
@@ -60,14 +82,40 @@ func payloadFunc() func(data interface{}) jwt.MapClaims {
 
 func identityHandler() func(c *gin.Context) interface{} {
 	return func(c *gin.Context) interface{} {
-		claims := jwt.ExtractClaims(c)
-		userId := claims[model.CtxKeyAuthorizedUser].(string)
-		var user model.User
-		if err := singleton.DB.First(&user, userId).Error; err != nil {
+		user, err := sessionUser(jwt.ExtractClaims(c))
+		if err != nil {
 			return nil
 		}
-		return &user
+		return user
+	}
+}
+
+// errSessionRevoked Token 签名有效且用户存在，但会话已被注销，或是升级前签发的不含会话 ID 的 Token
+var errSessionRevoked = errors.New("session revoked")
+
+// sessionUser 返回 Token 对应的用户，会话已失效时返回 errSessionRevoked
+func sessionUser(claims jwt.MapClaims) (*model.User, error) {
+	userId, _ := claims[model.CtxKeyAuthorizedUser].(string)
+	if userId == "" {
+		return nil, jwt.ErrFailedAuthentication
+	}
+	var user model.User
+	if err := singleton.DB.First(&user, userId).Error; err != nil {
+		return nil, err
+	}
+	// 会话被注销后，仍在有效期内的 Token 也不再可用
+	sessionId, _ := claims[claimSessionID].(string)
+	if sessionId == "" {
+		return nil, errSessionRevoked
+	}
+	var count int64
+	if err := singleton.DB.Model(&model.Session{}).Where("id = ? AND user_id = ?", sessionId, user.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errSessionRevoked
 	}
+	return &user, nil
 }
 
 // User Login
@@ -87,32 +135,101 @@ func authenticator() func(c *gin.Context) (interface{}, error) {
 		}
 
 		var user model.User
-		if err := singleton.DB.Select("id", "password").Where("username = ?", loginVals.Username).First(&user).Error; err != nil {
-			model.BlockIP(singleton.DB, c.GetString(model.CtxKeyRealIPStr), model.WAFBlockReasonTypeLoginFail)
-			return nil, jwt.ErrFailedAuthentication
+		fields := []string{"id", "password", "totp_enabled", "totp_secret", "totp_last_step", "recovery_codes"}
+		if loginVals.Oauth2Ticket != "" {
+			// 已通过 OIDC 登录，只需完成两步验证
+			userID, ok := singleton.Cache.Get(oauth2TicketKey(loginVals.Oauth2Ticket))
+			if !ok {
+				return nil, jwt.ErrFailedAuthentication
+			}
+			if err := singleton.DB.Select(fields).First(&user, userID).Error; err != nil {
+				return nil, jwt.ErrFailedAuthentication
+			}
+		} else {
+			if err := singleton.DB.Select(fields).Where("username = ?", loginVals.Username).First(&user).Error; err != nil {
+				model.BlockIP(singleton.DB, c.GetString(model.CtxKeyRealIPStr), model.WAFBlockReasonTypeLoginFail)
+				return nil, jwt.ErrFailedAuthentication
+			}
+
+			if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginVals.Password)); err != nil {
+				model.BlockIP(singleton.DB, c.GetString(model.CtxKeyRealIPStr), model.WAFBlockReasonTypeLoginFail)
+				return nil, jwt.ErrFailedAuthentication
+			}
 		}
 
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginVals.Password)); err != nil {
-			model.BlockIP(singleton.DB, c.GetString(model.CtxKeyRealIPStr), model.WAFBlockReasonTypeLoginFail)
-			return nil, jwt.ErrFailedAuthentication
+		if user.TOTPEnabled {
+			if loginVals.OTP == "" {
+				return nil, errOTPRequired
+			}
+			if !verifyTwoFactor(&user, loginVals.OTP) {
+				model.BlockIP(singleton.DB, c.GetString(model.CtxKeyRealIPStr), model.WAFBlockReasonTypeLoginFail)
+				return nil, jwt.ErrFailedAuthentication
+			}
 		}
 
-		return utils.Itoa(user.ID), nil
+		if loginVals.Oauth2Ticket != "" {
+			singleton.Cache.Delete(oauth2TicketKey(loginVals.Oauth2Ticket))
+			return createSession(c, user.ID)
+		}
+
+		// bcrypt_cost 修改后，登录成功时按新的 cost 重新计算哈希
+		if singleton.PasswordNeedsRehash(user.Password) {
+			if hash, err := singleton.HashPassword(loginVals.Password); err != nil {
+				slog.Warn("failed to rehash password", "user", user.ID, "error", err)
+			} else if err := singleton.DB.Model(&user).Update("password", hash).Error; err != nil {
+				slog.Warn("failed to save rehashed password", "user", user.ID, "error", err)
+			}
+		}
+
+		return createSession(c, user.ID)
 	}
 }
 
+// createSession 为登录成功的用户记录会话，返回值用于生成 Token
+func createSession(c *gin.Context, userID uint64) (*model.Session, error) {
+	ip := c.GetString(model.CtxKeyRealIPStr)
+	if ip == "" {
+		ip = c.RemoteIP()
+	}
+	s := &model.Session{
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: c.Request.UserAgent(),
+		ExpiresAt: time.Now().Add(jwtTimeout),
+	}
+	if err := singleton.DB.Create(s).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+	return s, nil
+}
+
 func authorizator() func(data interface{}, c *gin.Context) bool {
 	return func(data interface{}, c *gin.Context) bool {
-		_, ok := data.(*model.User)
-		return ok
+		user, ok := data.(*model.User)
+		if !ok {
+			return false
+		}
+		if user.MustChangePassword {
+			return mustChangePasswordAllowed[c.Request.Method+" "+c.FullPath()]
+		}
+		return true
 	}
 }
 
 func unauthorized() func(c *gin.Context, code int, message string) {
 	return func(c *gin.Context, code int, message string) {
+		errMsg := "ApiErrorUnauthorized"
+		if message == errOTPRequired.Error() {
+			errMsg = message
+		}
+		if code == http.StatusForbidden {
+			if user, ok := c.Get(model.CtxKeyAuthorizedUser); ok && user.(*model.User).MustChangePassword {
+				errMsg = errMustChangePassword.Error()
+			}
+		}
 		c.JSON(http.StatusOK, model.CommonResponse[any]{
 			Success: false,
-			Error:   "ApiErrorUnauthorized",
+			Error:   errMsg,
 		})
 	}
 }
@@ -127,6 +244,9 @@ func unauthorized() func(c *gin.Context, code int, message string) {
 // @Success 200 {object} model.CommonResponse[model.LoginResponse]
 // @Router /refresh-token [get]
 func refreshResponse(c *gin.Context, code int, token string, expire time.Time) {
+	if sessionId, ok := jwt.ExtractClaims(c)[claimSessionID].(string); ok {
+		singleton.DB.Model(&model.Session{}).Where("id = ?", sessionId).Update("expires_at", expire)
+	}
 	c.JSON(http.StatusOK, model.CommonResponse[model.LoginResponse]{
 		Success: true,
 		Data: model.LoginResponse{
@@ -136,6 +256,46 @@ func refreshResponse(c *gin.Context, code int, token string, expire time.Time) {
 	})
 }
 
+// Logout
+// @Summary Logout
+// @Schemes
+// @Description Revoke the session and clear the session cookie. POST returns the OIDC logout URL when configured, GET redirects to it (or to the home page when not configured)
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.LogoutResponse]
+// @Success 302
+// @Router /logout [post]
+// @Router /logout [get]
+func logoutResponse(c *gin.Context, code int) {
+	var resp model.LogoutResponse
+	if singleton.Conf.Oauth2.Enabled() {
+		resp.RedirectURL = singleton.Conf.Oauth2.OidcLogoutURL
+	}
+	if c.Request.Method == http.MethodGet {
+		redirectURL := resp.RedirectURL
+		if redirectURL == "" {
+			redirectURL = "/"
+		}
+		c.Redirect(http.StatusFound, redirectURL)
+		return
+	}
+	c.JSON(http.StatusOK, model.CommonResponse[model.LogoutResponse]{
+		Success: true,
+		Data:    resp,
+	})
+}
+
+// logout 注销当前 Token 对应的会话后清除 Cookie
+func logout(mw *jwt.GinJWTMiddleware) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if claims, err := mw.GetClaimsFromJWT(c); err == nil {
+			if sessionId, ok := claims[claimSessionID].(string); ok {
+				singleton.DB.Delete(&model.Session{}, "id = ? AND user_id = ?", sessionId, claims[model.CtxKeyAuthorizedUser])
+			}
+		}
+		mw.LogoutHandler(c)
+	}
+}
+
 func optionalAuthMiddleware(mw *jwt.GinJWTMiddleware) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		claims, err := mw.GetClaimsFromJWT(c)
@@ -163,11 +323,24 @@ func optionalAuthMiddleware(mw *jwt.GinJWTMiddleware) func(c *gin.Context) {
 		}
 
 		c.Set("JWT_PAYLOAD", claims)
-		identity := mw.IdentityHandler(c)
+		user, err := sessionUser(claims)
+
+		// 会话已注销的 Token 签名有效，不属于暴力破解，清除 Cookie 后按游客处理
+		if errors.Is(err, errSessionRevoked) {
+			c.SetCookie(mw.CookieName, "", -1, "/", mw.CookieDomain, mw.SecureCookie, mw.CookieHTTPOnly)
+			c.Next()
+			return
+		}
+
+		// 未修改初始密码的账户按游客处理
+		if err == nil && user.MustChangePassword {
+			c.Next()
+			return
+		}
 
-		if identity != nil {
+		if err == nil {
 			model.ClearIP(singleton.DB, c.GetString(model.CtxKeyRealIPStr))
-			c.Set(mw.IdentityKey, identity)
+			c.Set(mw.IdentityKey, user)
 		} else {
 			if err := model.BlockIP(singleton.DB, c.GetString(model.CtxKeyRealIPStr), model.WAFBlockReasonTypeBruteForceToken); err != nil {
 				waf.ShowBlockPage(c, err)
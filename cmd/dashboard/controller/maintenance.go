@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+// Clean history now
+// @Summary Clean history now
+// @Security BearerAuth
+// @Schemes
+// @Description Record pending transfer usage, clean outdated service and transfer history, then compact the database
+// @Tags auth required
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.CleanHistoryResponse]
+// @Router /maintenance/clean-history [post]
+func cleanHistory(c *gin.Context) (*model.CleanHistoryResponse, error) {
+	var resp model.CleanHistoryResponse
+	var err error
+
+	if resp.SizeBefore, err = singleton.DatabaseSize(); err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	singleton.RecordTransferHourlyUsage()
+	deleted, ok, err := singleton.TryCleanServiceHistory()
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("history cleanup is already running")
+	}
+	if err != nil {
+		return nil, newGormError("%v", err)
+	}
+	resp.Deleted = deleted
+
+	if resp.SizeAfter, err = singleton.DatabaseSize(); err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	return &resp, nil
+}
+
+// Set maintenance mode
+// @Summary Set maintenance mode
+// @Security BearerAuth
+// @Schemes
+// @Description Enable or disable maintenance mode. While enabled, alert rules are still evaluated but no notification is sent.
+// @Tags auth required
+// @Accept json
+// @param request body model.MaintenanceModeForm true "MaintenanceModeForm"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.MaintenanceModeResponse]
+// @Router /maintenance-mode [post]
+func setMaintenanceMode(c *gin.Context) (*model.MaintenanceModeResponse, error) {
+	var mf model.MaintenanceModeForm
+	if err := c.ShouldBindJSON(&mf); err != nil {
+		return nil, err
+	}
+
+	singleton.ConfLock.Lock()
+	defer singleton.ConfLock.Unlock()
+
+	conf := *singleton.Conf
+	conf.MaintenanceMode = mf.Enabled
+	conf.MaintenanceUntil = 0
+	resp := &model.MaintenanceModeResponse{Enabled: mf.Enabled}
+	if mf.Enabled && mf.Duration > 0 {
+		until := time.Now().Add(time.Duration(mf.Duration) * time.Second)
+		conf.MaintenanceUntil = until.Unix()
+		resp.Until = &until
+	}
+
+	if err := conf.Save(); err != nil {
+		return nil, newGormError("%v", err)
+	}
+	singleton.Conf = &conf
+	return resp, nil
+}
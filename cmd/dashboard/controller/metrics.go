@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+// metricsHandler 暴露 Prometheus 指标，配置了 MetricsToken 时需要携带 Bearer Token
+func metricsHandler() gin.HandlerFunc {
+	h := promhttp.Handler()
+	return func(c *gin.Context) {
+		if token := singleton.Conf.MetricsToken; token != "" {
+			if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -40,15 +40,16 @@ func listNAT(c *gin.Context) ([]*model.NAT, error) {
 // @Tags auth required
 // @Accept json
 // @param request body model.NATForm true "NAT Request"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[uint64]
+// @Success 200 {object} model.CommonResponse[model.NAT]
 // @Router /nat [post]
-func createNAT(c *gin.Context) (uint64, error) {
+func createNAT(c *gin.Context) (*model.NAT, error) {
 	var nf model.NATForm
 	var n model.NAT
 
 	if err := c.ShouldBindJSON(&nf); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	n.Name = nf.Name
@@ -57,12 +58,12 @@ func createNAT(c *gin.Context) (uint64, error) {
 	n.ServerID = nf.ServerID
 
 	if err := singleton.DB.Create(&n).Error; err != nil {
-		return 0, newGormError("%v", err)
+		return nil, newGormError("%v", err)
 	}
 
 	singleton.OnNATUpdate(&n)
 	singleton.UpdateNATList()
-	return n.ID, nil
+	return &n, nil
 }
 
 // Edit NAT profile
@@ -75,9 +76,9 @@ func createNAT(c *gin.Context) (uint64, error) {
 // @param id path uint true "Profile ID"
 // @param request body model.NATForm true "NAT Request"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[any]
+// @Success 200 {object} model.CommonResponse[model.NAT]
 // @Router /nat/{id} [patch]
-func updateNAT(c *gin.Context) (any, error) {
+func updateNAT(c *gin.Context) (*model.NAT, error) {
 	idStr := c.Param("id")
 
 	id, err := strconv.ParseUint(idStr, 10, 64)
@@ -101,12 +102,41 @@ func updateNAT(c *gin.Context) (any, error) {
 	n.ServerID = nf.ServerID
 
 	if err := singleton.DB.Save(&n).Error; err != nil {
-		return 0, newGormError("%v", err)
+		return nil, newGormError("%v", err)
 	}
 
 	singleton.OnNATUpdate(&n)
 	singleton.UpdateNATList()
-	return nil, nil
+	return &n, nil
+}
+
+// Get NAT status
+// @Summary Get NAT status
+// @Security BearerAuth
+// @Schemes
+// @Description Get the latest reachability probe result of a NAT profile
+// @Tags auth required
+// @param id path uint true "Profile ID"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.NATStatus]
+// @Router /nat/{id}/status [get]
+func getNATStatus(c *gin.Context) (*model.NATStatus, error) {
+	idStr := c.Param("id")
+
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	n := singleton.GetNATConfigByID(id)
+	if n == nil {
+		return nil, singleton.Localizer.ErrorT("profile id %d does not exist", id)
+	}
+
+	singleton.NATCacheRwLock.RLock()
+	defer singleton.NATCacheRwLock.RUnlock()
+	status := n.Status
+	return &status, nil
 }
 
 // Batch delete NAT configurations
@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/copier"
 	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
 	"github.com/nezhahq/nezha/service/singleton"
 	"gorm.io/gorm"
 )
@@ -38,24 +39,20 @@ func listNotification(c *gin.Context) ([]*model.Notification, error) {
 // @Tags auth required
 // @Accept json
 // @param request body model.NotificationForm true "NotificationForm"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[any]
+// @Success 200 {object} model.CommonResponse[model.Notification]
 // @Router /notification [post]
-func createNotification(c *gin.Context) (uint64, error) {
+func createNotification(c *gin.Context) (*model.Notification, error) {
 	var nf model.NotificationForm
 	if err := c.ShouldBindJSON(&nf); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	var n model.Notification
-	n.Name = nf.Name
-	n.RequestMethod = nf.RequestMethod
-	n.RequestType = nf.RequestType
-	n.RequestHeader = nf.RequestHeader
-	n.RequestBody = nf.RequestBody
-	n.URL = nf.URL
-	verifyTLS := nf.VerifyTLS
-	n.VerifyTLS = &verifyTLS
+	if err := applyNotificationForm(&n, &nf); err != nil {
+		return nil, err
+	}
 
 	ns := model.NotificationServerBundle{
 		Notification: &n,
@@ -65,17 +62,17 @@ func createNotification(c *gin.Context) (uint64, error) {
 	// 未勾选跳过检查
 	if !nf.SkipCheck {
 		if err := ns.Send(singleton.Localizer.T("a test message")); err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
 
 	if err := singleton.DB.Create(&n).Error; err != nil {
-		return 0, newGormError("%v", err)
+		return nil, newGormError("%v", err)
 	}
 
 	singleton.OnRefreshOrAddNotification(&n)
 	singleton.UpdateNotificationList()
-	return n.ID, nil
+	return &n, nil
 }
 
 // Edit notification
@@ -88,9 +85,9 @@ func createNotification(c *gin.Context) (uint64, error) {
 // @Param id path uint true "Notification ID"
 // @Param body body model.NotificationForm true "NotificationForm"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[any]
+// @Success 200 {object} model.CommonResponse[model.Notification]
 // @Router /notification/{id} [patch]
-func updateNotification(c *gin.Context) (any, error) {
+func updateNotification(c *gin.Context) (*model.Notification, error) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 64)
 	if err != nil {
@@ -105,15 +102,14 @@ func updateNotification(c *gin.Context) (any, error) {
 	if err := singleton.DB.First(&n, id).Error; err != nil {
 		return nil, singleton.Localizer.ErrorT("notification id %d does not exist", id)
 	}
+	loaded := n.UpdatedAt
+	if err := checkVersion(nf.UpdatedAt, n.UpdatedAt, &n); err != nil {
+		return nil, err
+	}
 
-	n.Name = nf.Name
-	n.RequestMethod = nf.RequestMethod
-	n.RequestType = nf.RequestType
-	n.RequestHeader = nf.RequestHeader
-	n.RequestBody = nf.RequestBody
-	n.URL = nf.URL
-	verifyTLS := nf.VerifyTLS
-	n.VerifyTLS = &verifyTLS
+	if err := applyNotificationForm(&n, &nf); err != nil {
+		return nil, err
+	}
 
 	ns := model.NotificationServerBundle{
 		Notification: &n,
@@ -127,13 +123,83 @@ func updateNotification(c *gin.Context) (any, error) {
 		}
 	}
 
-	if err := singleton.DB.Save(&n).Error; err != nil {
-		return nil, newGormError("%v", err)
+	if err := saveVersion(&n, n.ID, loaded); err != nil {
+		return nil, err
 	}
 
 	singleton.OnRefreshOrAddNotification(&n)
 	singleton.UpdateNotificationList()
-	return nil, nil
+	return &n, nil
+}
+
+// Test notification
+// @Summary Test notification
+// @Security BearerAuth
+// @Schemes
+// @Description Send a test message with the given settings without saving them
+// @Tags auth required
+// @Accept json
+// @param request body model.NotificationForm true "NotificationForm"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.NotificationTestResponse]
+// @Router /notification/test [post]
+func testNotification(c *gin.Context) (*model.NotificationTestResponse, error) {
+	var nf model.NotificationForm
+	if err := c.ShouldBindJSON(&nf); err != nil {
+		return nil, err
+	}
+
+	var n model.Notification
+	if err := applyNotificationForm(&n, &nf); err != nil {
+		return nil, err
+	}
+
+	ns := model.NotificationServerBundle{
+		Notification: &n,
+		Server:       nil,
+		Loc:          singleton.Loc,
+	}
+	statusCode, body, err := ns.SendWithResponse(singleton.Localizer.T("a test message"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.NotificationTestResponse{
+		StatusCode: statusCode,
+		Body:       body,
+	}, nil
+}
+
+// applyNotificationForm 将表单内容写入通知方式并校验，SecretKey 留空且未要求清除时保留原有的密钥
+func applyNotificationForm(n *model.Notification, nf *model.NotificationForm) error {
+	n.Name = nf.Name
+	n.RequestMethod = nf.RequestMethod
+	n.RequestType = nf.RequestType
+	n.RequestHeader = nf.RequestHeader
+	n.RequestBody = nf.RequestBody
+	n.URL = nf.URL
+	verifyTLS := nf.VerifyTLS
+	n.VerifyTLS = &verifyTLS
+	n.Timeout = nf.Timeout
+	n.ProxyURL = nf.ProxyURL
+	if nf.SecretKey != "" {
+		n.SecretKey = nf.SecretKey
+	} else if nf.ClearSecretKey {
+		n.SecretKey = ""
+	}
+	n.Provider = nf.Provider
+	n.ProviderToken = nf.ProviderToken
+	n.ProviderChatID = nf.ProviderChatID
+
+	if err := n.ValidateTemplate(); err != nil {
+		return singleton.Localizer.ErrorT("invalid notification template: %v", err)
+	}
+	if n.ProxyURL != "" {
+		if _, err := utils.ParseProxyURL(n.ProxyURL); err != nil {
+			return singleton.Localizer.ErrorT("invalid proxy url: %v", err)
+		}
+	}
+	return nil
 }
 
 // Batch delete notifications
@@ -172,3 +238,45 @@ func batchDeleteNotification(c *gin.Context) (any, error) {
 	singleton.UpdateNotificationList()
 	return nil, nil
 }
+
+// List failed notifications
+// @Summary List failed notifications
+// @Security BearerAuth
+// @Schemes
+// @Description List notifications that still failed after retries
+// @Tags auth required
+// @Produce json
+// @Success 200 {object} model.CommonResponse[[]model.FailedNotification]
+// @Router /notification/failed [get]
+func listFailedNotification(c *gin.Context) ([]model.FailedNotification, error) {
+	var fns []model.FailedNotification
+	if err := singleton.DB.Order("id desc").Find(&fns).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+	return fns, nil
+}
+
+// Replay failed notification
+// @Summary Replay failed notification
+// @Security BearerAuth
+// @Schemes
+// @Description Re-send a failed notification, it is removed from the failed list on success
+// @Tags auth required
+// @param id path uint true "Failed notification ID"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[any]
+// @Router /notification/{id}/replay [post]
+func replayFailedNotification(c *gin.Context) (any, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var fn model.FailedNotification
+	if err := singleton.DB.First(&fn, id).Error; err != nil {
+		return nil, singleton.Localizer.ErrorT("failed notification id %d does not exist", id)
+	}
+
+	return nil, singleton.ReplayFailedNotification(&fn)
+}
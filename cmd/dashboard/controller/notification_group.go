@@ -58,6 +58,7 @@ func listNotificationGroup(c *gin.Context) ([]model.NotificationGroupResponseIte
 // @Tags auth required
 // @Accept json
 // @Param body body model.NotificationGroupForm true "NotificationGroupForm"
+// @Param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
 // @Produce json
 // @Success 200 {object} model.CommonResponse[any]
 // @Router /notification-group [post]
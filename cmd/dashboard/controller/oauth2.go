@@ -0,0 +1,327 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+var (
+	oidcProvider       *oidc.Provider
+	oidcProviderIssuer string
+	oidcProviderLock   sync.Mutex
+)
+
+// getOidcProvider 获取 OIDC 提供方信息，发现文档在 Issuer 不变时只请求一次
+func getOidcProvider(ctx context.Context, conf *model.Oauth2Config) (*oidc.Provider, error) {
+	oidcProviderLock.Lock()
+	defer oidcProviderLock.Unlock()
+
+	if oidcProvider != nil && oidcProviderIssuer == conf.Issuer {
+		return oidcProvider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, conf.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = provider
+	oidcProviderIssuer = conf.Issuer
+	return provider, nil
+}
+
+func getOauth2Config(conf *model.Oauth2Config, provider *oidc.Provider) *oauth2.Config {
+	scopes := conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	} else if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &oauth2.Config{
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		RedirectURL:  conf.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+}
+
+// oauth2State 发起 OIDC 登录时保存的状态，BindUserID 不为 0 时回调将 OIDC 账号绑定到该用户
+type oauth2State struct {
+	Nonce      string
+	BindUserID uint64
+}
+
+// oauth2TicketTimeout 启用了两步验证的用户通过 OIDC 登录后，输入验证码的有效期
+const oauth2TicketTimeout = time.Minute * 5
+
+// oauth2AuthCodeURL 生成跳转到 OIDC 提供方的地址
+func oauth2AuthCodeURL(ctx context.Context, bindUserID uint64) (string, error) {
+	conf := singleton.Conf.Oauth2
+	if !conf.Enabled() {
+		return "", singleton.Localizer.ErrorT("oauth2 is not enabled")
+	}
+
+	provider, err := getOidcProvider(ctx, conf)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+	singleton.Cache.Set("oauth2::state::"+state, &oauth2State{Nonce: nonce, BindUserID: bindUserID}, time.Minute*5)
+
+	return getOauth2Config(conf, provider).AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// OIDC login
+// @Summary OIDC login
+// @Schemes
+// @Description Redirect to the OIDC provider
+// @Produce json
+// @Success 302
+// @Router /oauth2/login [get]
+func oauth2Login(c *gin.Context) {
+	url, err := oauth2AuthCodeURL(c.Request.Context(), 0)
+	if err != nil {
+		c.JSON(http.StatusOK, newErrorResponse(err))
+		return
+	}
+	c.Redirect(http.StatusFound, url)
+}
+
+// Bind OIDC account
+// @Summary Bind OIDC account
+// @Security BearerAuth
+// @Schemes
+// @Description Return the OIDC provider URL, the account signed in there is bound to the current user after the callback
+// @Tags auth required
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.Oauth2BindResponse]
+// @Router /oauth2/bind [post]
+func oauth2Bind(c *gin.Context) (*model.Oauth2BindResponse, error) {
+	auth, ok := c.Get(model.CtxKeyAuthorizedUser)
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("unauthorized")
+	}
+	url, err := oauth2AuthCodeURL(c.Request.Context(), auth.(*model.User).ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.Oauth2BindResponse{RedirectURL: url}, nil
+}
+
+// OIDC callback
+// @Summary OIDC callback
+// @Schemes
+// @Description Exchange the authorization code, then either bind the account to the user who started /oauth2/bind, or sign in the bound user and redirect to the dashboard. Users with two-factor authentication are redirected to the login page with an oauth2_ticket, which is submitted to /login together with the otp
+// @param code query string true "Authorization code"
+// @param state query string true "State"
+// @Produce json
+// @Success 302
+// @Router /oauth2/callback [get]
+func oauth2Callback(mw *jwt.GinJWTMiddleware) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		userID, bound, err := exchangeOauth2Code(c)
+		if err == nil && !bound {
+			err = checkOauth2User(userID)
+		}
+		if err != nil {
+			var ge *gormError
+			if errors.As(err, &ge) {
+				log.Printf("NEZHA>> gorm error: %v", err)
+				err = singleton.Localizer.ErrorT("database error")
+			}
+			c.JSON(http.StatusOK, newErrorResponse(err))
+			return
+		}
+		if bound {
+			c.Redirect(http.StatusFound, "/dashboard/")
+			return
+		}
+
+		var user model.User
+		if err := singleton.DB.Select("id", "totp_enabled").First(&user, userID).Error; err != nil {
+			c.JSON(http.StatusOK, newErrorResponse(singleton.Localizer.ErrorT("database error")))
+			return
+		}
+		// 与密码登录一样需要两步验证，由前端将 ticket 与验证码提交到 /login
+		if user.TOTPEnabled {
+			ticket, err := utils.GenerateRandomString(32)
+			if err != nil {
+				c.JSON(http.StatusOK, newErrorResponse(err))
+				return
+			}
+			singleton.Cache.Set(oauth2TicketKey(ticket), userID, oauth2TicketTimeout)
+			c.Redirect(http.StatusFound, "/dashboard/login?oauth2_ticket="+ticket)
+			return
+		}
+
+		session, err := createSession(c, userID)
+		if err != nil {
+			c.JSON(http.StatusOK, newErrorResponse(err))
+			return
+		}
+		token, _, err := mw.TokenGenerator(session)
+		if err != nil {
+			c.JSON(http.StatusOK, newErrorResponse(err))
+			return
+		}
+		mw.SetCookie(c, token)
+		c.Redirect(http.StatusFound, "/dashboard/")
+	}
+}
+
+func oauth2TicketKey(ticket string) string {
+	return "oauth2::ticket::" + ticket
+}
+
+// checkOauth2User 仍在使用初始密码的用户需要先通过密码登录并修改密码
+func checkOauth2User(userID uint64) error {
+	var user model.User
+	if err := singleton.DB.Select("id", "must_change_password").First(&user, userID).Error; err != nil {
+		return newGormError("%v", err)
+	}
+	if user.MustChangePassword {
+		return singleton.Localizer.ErrorT("sign in with your password and change it before using oauth2")
+	}
+	return nil
+}
+
+// exchangeOauth2Code 校验回调并返回对应的用户 ID，按配置在首次登录时创建用户
+// 由 /oauth2/bind 发起时将 OIDC 账号绑定到发起的用户，bound 为 true
+func exchangeOauth2Code(c *gin.Context) (userID uint64, bound bool, err error) {
+	conf := singleton.Conf.Oauth2
+	if !conf.Enabled() {
+		return 0, false, singleton.Localizer.ErrorT("oauth2 is not enabled")
+	}
+
+	stateKey := "oauth2::state::" + c.Query("state")
+	cached, ok := singleton.Cache.Get(stateKey)
+	if !ok {
+		return 0, false, singleton.Localizer.ErrorT("invalid or expired oauth2 state")
+	}
+	singleton.Cache.Delete(stateKey)
+	state := cached.(*oauth2State)
+
+	ctx := c.Request.Context()
+	provider, err := getOidcProvider(ctx, conf)
+	if err != nil {
+		return 0, false, err
+	}
+
+	token, err := getOauth2Config(conf, provider).Exchange(ctx, c.Query("code"))
+	if err != nil {
+		return 0, false, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return 0, false, singleton.Localizer.ErrorT("id_token is missing from the oauth2 response")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: conf.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return 0, false, err
+	}
+	if idToken.Nonce != state.Nonce {
+		return 0, false, singleton.Localizer.ErrorT("invalid oauth2 nonce")
+	}
+
+	var bind model.Oauth2Bind
+	err = singleton.DB.Where("issuer = ? AND subject = ?", conf.Issuer, idToken.Subject).First(&bind).Error
+	if err == nil {
+		if state.BindUserID != 0 && bind.UserID != state.BindUserID {
+			return 0, false, singleton.Localizer.ErrorT("this oauth2 account is already bound to another user")
+		}
+		return bind.UserID, state.BindUserID != 0, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, false, newGormError("%v", err)
+	}
+
+	if state.BindUserID != 0 {
+		if err := singleton.DB.Create(&model.Oauth2Bind{
+			UserID:  state.BindUserID,
+			Issuer:  conf.Issuer,
+			Subject: idToken.Subject,
+		}).Error; err != nil {
+			return 0, false, newGormError("%v", err)
+		}
+		return state.BindUserID, true, nil
+	}
+
+	if !conf.AutoCreateUser {
+		return 0, false, singleton.Localizer.ErrorT("no user is bound to this account")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return 0, false, err
+	}
+	userClaim := conf.UserClaim
+	if userClaim == "" {
+		userClaim = "preferred_username"
+	}
+	username, _ := claims[userClaim].(string)
+	if username == "" {
+		username = idToken.Subject
+	}
+
+	var count int64
+	if err := singleton.DB.Model(&model.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return 0, false, newGormError("%v", err)
+	}
+	if count > 0 {
+		return 0, false, singleton.Localizer.ErrorT("user %s already exists", username)
+	}
+
+	// 使用随机密码，该用户仅能通过 OIDC 登录
+	password, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return 0, false, err
+	}
+	hash, err := singleton.HashPassword(password)
+	if err != nil {
+		return 0, false, err
+	}
+
+	user := model.User{
+		Username: username,
+		Password: hash,
+	}
+	err = singleton.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.Oauth2Bind{
+			UserID:  user.ID,
+			Issuer:  conf.Issuer,
+			Subject: idToken.Subject,
+		}).Error
+	})
+	if err != nil {
+		return 0, false, newGormError("%v", err)
+	}
+
+	return user.ID, false, nil
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+const rateLimitBucketTTL = 10 * time.Minute
+
+type rateLimitBucket struct {
+	limiter  *rate.Limiter
+	perMin   int
+	lastSeen time.Time
+}
+
+// keyedRateLimiter 按 key 区分的令牌桶，状态只保存在内存中
+type keyedRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*rateLimitBucket
+	lastSweep time.Time
+}
+
+var authRateLimiter = &keyedRateLimiter{buckets: make(map[string]*rateLimitBucket)}
+
+// allow 消耗一个令牌，桶容量与每分钟补充的令牌数均为 perMin，失败时返回需要等待的时间
+func (l *keyedRateLimiter) allow(key string, perMin int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > rateLimitBucketTTL {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok || b.perMin != perMin {
+		b = &rateLimitBucket{
+			limiter: rate.NewLimiter(rate.Limit(float64(perMin)/60), perMin),
+			perMin:  perMin,
+		}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// authRateLimit 对登录、刷新 Token 等敏感接口按 IP 与用户限流
+// 登录接口从请求体中读取用户名，其余接口使用已登录的用户
+func authRateLimit(c *gin.Context) {
+	ip := c.GetString(model.CtxKeyRealIPStr)
+	if ip == "" {
+		ip = c.RemoteIP()
+	}
+	if !checkRateLimit(c, "ip::"+ip, singleton.Conf.AuthRateLimitPerIP) {
+		return
+	}
+
+	var user string
+	if auth, ok := c.Get(model.CtxKeyAuthorizedUser); ok {
+		user = "id::" + strconv.FormatUint(auth.(*model.User).ID, 10)
+	} else if c.Request.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<16))
+		if err == nil {
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			var form model.LoginRequest
+			if utils.Json.Unmarshal(body, &form) == nil && form.Username != "" {
+				user = "name::" + form.Username
+			}
+		}
+	}
+	if user != "" && !checkRateLimit(c, "user::"+user, singleton.Conf.AuthRateLimitPerUser) {
+		return
+	}
+
+	c.Next()
+}
+
+func checkRateLimit(c *gin.Context, key string, perMin int) bool {
+	if perMin <= 0 {
+		return true
+	}
+	ok, retryAfter := authRateLimiter.allow(key, perMin)
+	if ok {
+		return true
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, newErrorResponse(singleton.Localizer.ErrorT("too many requests, please try again later")))
+	return false
+}
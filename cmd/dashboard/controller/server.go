@@ -1,14 +1,20 @@
 package controller
 
 import (
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-uuid"
 	"github.com/jinzhu/copier"
+	"gorm.io/gorm"
 
 	"github.com/nezhahq/nezha/model"
 	"github.com/nezhahq/nezha/pkg/utils"
 	pb "github.com/nezhahq/nezha/proto"
+	"github.com/nezhahq/nezha/service/rpc"
 	"github.com/nezhahq/nezha/service/singleton"
 )
 
@@ -16,22 +22,122 @@ import (
 // @Summary List server
 // @Security BearerAuth
 // @Schemes
-// @Description List server
+// @Description List server, optionally filtered and paginated. All matching servers are returned when limit is omitted
 // @Tags auth required
+// @param q query string false "Name or IP substring"
+// @param group query uint false "Server group ID"
+// @param online query bool false "Online state"
+// @param limit query int false "Page size"
+// @param offset query int false "Page offset"
 // @Produce json
 // @Success 200 {object} model.CommonResponse[[]model.Server]
 // @Router /server [get]
 func listServer(c *gin.Context) ([]*model.Server, error) {
+	var query model.ServerListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return nil, err
+	}
+	if query.Offset < 0 || query.Limit < 0 {
+		return nil, singleton.Localizer.ErrorT("offset and limit must not be negative")
+	}
+	q := strings.ToLower(query.Q)
+
+	// 与 ReSortServer 保持相同的加锁顺序
+	singleton.ServerLock.RLock()
+	defer singleton.ServerLock.RUnlock()
 	singleton.SortedServerLock.RLock()
 	defer singleton.SortedServerLock.RUnlock()
 
-	var ssl []*model.Server
-	if err := copier.Copy(&ssl, &singleton.SortedServerList); err != nil {
+	var matched []*model.Server
+	for _, s := range singleton.SortedServerList {
+		if q != "" && !serverMatches(s, q) {
+			continue
+		}
+		if query.Group != 0 && !singleton.ServerInGroup(query.Group, s.ID) {
+			continue
+		}
+		if query.Online != nil && (s.TaskStream != nil) != *query.Online {
+			continue
+		}
+		matched = append(matched, s)
+	}
+
+	if query.Offset >= len(matched) {
+		matched = nil
+	} else {
+		matched = matched[query.Offset:]
+	}
+	if query.Limit > 0 && query.Limit < len(matched) {
+		matched = matched[:query.Limit]
+	}
+
+	ssl := make([]*model.Server, 0, len(matched))
+	if err := copier.Copy(&ssl, &matched); err != nil {
 		return nil, err
 	}
+	for i, s := range matched {
+		ssl[i].Online = s.TaskStream != nil
+	}
 	return ssl, nil
 }
 
+// List offline servers
+// @Summary List offline servers
+// @Security BearerAuth
+// @Schemes
+// @Description List servers that have not reported state or host info within the threshold, for external paging. Servers that have not reported since the dashboard started are counted from the dashboard start time.
+// @Tags auth required
+// @param threshold query string false "Duration such as 5m or 1h (default 5m)"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[[]model.ServerLastSeen]
+// @Router /server/offline [get]
+func listOfflineServer(c *gin.Context) ([]model.ServerLastSeen, error) {
+	var query model.ServerOfflineQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return nil, err
+	}
+	threshold := 5 * time.Minute
+	if query.Threshold != "" {
+		var err error
+		if threshold, err = time.ParseDuration(query.Threshold); err != nil || threshold <= 0 {
+			return nil, singleton.Localizer.ErrorT("invalid threshold %s, expected a positive duration such as 5m", query.Threshold)
+		}
+	}
+
+	now := time.Now()
+	singleton.ServerLock.RLock()
+	defer singleton.ServerLock.RUnlock()
+	singleton.SortedServerLock.RLock()
+	defer singleton.SortedServerLock.RUnlock()
+
+	servers := make([]model.ServerLastSeen, 0)
+	for _, s := range singleton.SortedServerList {
+		item := model.ServerLastSeen{ID: s.ID, Name: s.Name}
+		since := singleton.StartedAt
+		if lastActive := s.LastActiveAt(); !lastActive.IsZero() {
+			item.LastActive = &lastActive
+			since = lastActive
+		}
+		if now.Sub(since) < threshold {
+			continue
+		}
+		item.OfflineSeconds = int64(now.Sub(since).Seconds())
+		servers = append(servers, item)
+	}
+	return servers, nil
+}
+
+func serverMatches(s *model.Server, q string) bool {
+	if strings.Contains(strings.ToLower(s.Name), q) {
+		return true
+	}
+	if s.GeoIP != nil {
+		return strings.Contains(strings.ToLower(s.GeoIP.IP.IPv4Addr), q) ||
+			strings.Contains(strings.ToLower(s.GeoIP.IP.IPv6Addr), q)
+	}
+	return false
+}
+
 // Edit server
 // @Summary Edit server
 // @Security BearerAuth
@@ -72,6 +178,14 @@ func updateServer(c *gin.Context) (any, error) {
 		return nil, err
 	}
 	s.DDNSProfilesRaw = string(ddnsProfilesRaw)
+	s.TransferQuota = sf.TransferQuota
+	s.CycleStart = sf.CycleStart
+	s.CycleInterval = sf.CycleInterval
+	s.CycleUnit = sf.CycleUnit
+	s.TransferQuotaNotificationGroupID = sf.TransferQuotaNotificationGroupID
+	if err := validateTransferQuota(&s); err != nil {
+		return nil, err
+	}
 
 	if err := singleton.DB.Save(&s).Error; err != nil {
 		return nil, newGormError("%v", err)
@@ -86,6 +200,207 @@ func updateServer(c *gin.Context) (any, error) {
 	return nil, nil
 }
 
+func validateTransferQuota(s *model.Server) error {
+	if s.TransferQuota == 0 {
+		return nil
+	}
+	if s.CycleInterval < 1 {
+		return singleton.Localizer.ErrorT("cycle_interval need to be at least 1")
+	}
+	if s.CycleStart == nil {
+		return singleton.Localizer.ErrorT("cycle_start is not set")
+	}
+	if s.CycleStart.After(time.Now()) {
+		return singleton.Localizer.ErrorT("cycle_start is a future value")
+	}
+	switch strings.ToLower(s.CycleUnit) {
+	case "", "hour", "day", "week", "month", "year":
+	default:
+		return singleton.Localizer.ErrorT("invalid cycle_unit: %s", s.CycleUnit)
+	}
+	return nil
+}
+
+// serverMetadataMaxEntries 单台服务器最多保存的自定义键值数量
+const serverMetadataMaxEntries = 100
+
+// Edit server metadata
+// @Summary Edit server metadata
+// @Security BearerAuth
+// @Schemes
+// @Description Replace the admin note and custom key/value metadata of a server, neither is visible to guests
+// @Tags auth required
+// @Accept json
+// @param id path uint true "Server ID"
+// @param request body model.ServerMetaForm true "ServerMetaForm"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[any]
+// @Router /server/{id}/meta [post]
+func updateServerMeta(c *gin.Context) (any, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	var mf model.ServerMetaForm
+	if err := c.ShouldBindJSON(&mf); err != nil {
+		return nil, err
+	}
+	if len(mf.Metadata) > serverMetadataMaxEntries {
+		return nil, singleton.Localizer.ErrorT("metadata can have at most %d entries", serverMetadataMaxEntries)
+	}
+	for k := range mf.Metadata {
+		if strings.TrimSpace(k) == "" {
+			return nil, singleton.Localizer.ErrorT("metadata key cannot be empty")
+		}
+	}
+
+	singleton.ServerLock.RLock()
+	_, ok := singleton.ServerList[id]
+	singleton.ServerLock.RUnlock()
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("server id %d does not exist", id)
+	}
+
+	metadataRaw := "{}"
+	if len(mf.Metadata) > 0 {
+		data, err := utils.Json.Marshal(mf.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		metadataRaw = string(data)
+	}
+	if err := singleton.DB.Model(&model.Server{}).Where("id = ?", id).
+		Updates(map[string]any{"note": mf.Note, "metadata_raw": metadataRaw}).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	singleton.ServerLock.Lock()
+	if server, ok := singleton.ServerList[id]; ok {
+		server.Note = mf.Note
+		server.Metadata = mf.Metadata
+		server.MetadataRaw = metadataRaw
+	}
+	singleton.ServerLock.Unlock()
+
+	return nil, nil
+}
+
+// Mute server notifications
+// @Summary Mute server notifications
+// @Security BearerAuth
+// @Schemes
+// @Description Stop sending alert notifications for a server for the given duration. Alert rules are still evaluated, and rules still firing when the mute ends notify once.
+// @Tags auth required
+// @Accept json
+// @param id path uint true "Server ID"
+// @param request body model.ServerMuteForm true "ServerMuteForm"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.ServerMuteResponse]
+// @Router /server/{id}/mute [post]
+func muteServer(c *gin.Context) (*model.ServerMuteResponse, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	var mf model.ServerMuteForm
+	if err := c.ShouldBindJSON(&mf); err != nil {
+		return nil, err
+	}
+
+	singleton.ServerLock.RLock()
+	_, ok := singleton.ServerList[id]
+	singleton.ServerLock.RUnlock()
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("server id %d does not exist", id)
+	}
+
+	resp := &model.ServerMuteResponse{}
+	if mf.Duration > 0 {
+		until := time.Now().Add(time.Duration(mf.Duration) * time.Second)
+		resp.Muted = true
+		resp.Until = &until
+	}
+	if err := singleton.DB.Model(&model.Server{}).Where("id = ?", id).Update("mute_until", resp.Until).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	singleton.ServerLock.Lock()
+	if server, ok := singleton.ServerList[id]; ok {
+		server.MuteUntil = resp.Until
+	}
+	singleton.ServerLock.Unlock()
+
+	return resp, nil
+}
+
+// Batch update server display index
+// @Summary Batch update server display index
+// @Security BearerAuth
+// @Schemes
+// @Description Update the display index of multiple servers, servers with a larger index are listed first
+// @Tags auth required
+// @Accept json
+// @param request body []model.ServerDisplayIndexForm true "display index list"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[any]
+// @Router /server/display-index [patch]
+func updateServerDisplayIndex(c *gin.Context) (any, error) {
+	var forms []model.ServerDisplayIndexForm
+	if err := c.ShouldBindJSON(&forms); err != nil {
+		return nil, err
+	}
+
+	singleton.ServerLock.RLock()
+	for _, f := range forms {
+		if _, ok := singleton.ServerList[f.ID]; !ok {
+			singleton.ServerLock.RUnlock()
+			return nil, singleton.Localizer.ErrorT("server id %d does not exist", f.ID)
+		}
+	}
+	singleton.ServerLock.RUnlock()
+
+	err := singleton.DB.Transaction(func(tx *gorm.DB) error {
+		for _, f := range forms {
+			if err := tx.Model(&model.Server{}).Where("id = ?", f.ID).Update("display_index", f.DisplayIndex).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	singleton.ServerLock.Lock()
+	for _, f := range forms {
+		if server, ok := singleton.ServerList[f.ID]; ok {
+			server.DisplayIndex = f.DisplayIndex
+		}
+	}
+	singleton.ServerLock.Unlock()
+	singleton.ReSortServer()
+
+	return nil, nil
+}
+
+// Resort servers
+// @Summary Resort servers
+// @Security BearerAuth
+// @Schemes
+// @Description Reload the display index and guest visibility of all servers from the database and rebuild the sorted server lists
+// @Tags auth required
+// @Produce json
+// @Success 200 {object} model.CommonResponse[any]
+// @Router /server/resort [post]
+func resortServer(c *gin.Context) (any, error) {
+	if err := singleton.ReloadServerSortKeys(); err != nil {
+		return nil, newGormError("%v", err)
+	}
+	return nil, nil
+}
+
 // Batch delete server
 // @Summary Batch delete server
 // @Security BearerAuth
@@ -103,7 +418,13 @@ func batchDeleteServer(c *gin.Context) (any, error) {
 		return nil, err
 	}
 
-	if err := singleton.DB.Unscoped().Delete(&model.Server{}, "id in (?)", servers).Error; err != nil {
+	err := singleton.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&model.Server{}, "id in (?)", servers).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.ServerGroupServer{}, "server_id in (?)", servers).Error
+	})
+	if err != nil {
 		return nil, newGormError("%v", err)
 	}
 
@@ -119,6 +440,7 @@ func batchDeleteServer(c *gin.Context) (any, error) {
 	}
 	singleton.DB.Unscoped().Delete(&model.Transfer{}, "server_id in (?)", servers)
 	singleton.AlertsLock.Unlock()
+	singleton.OnServerDeleteAlertStates(servers)
 
 	singleton.OnServerDelete(servers)
 	singleton.ReSortServer()
@@ -146,21 +468,160 @@ func forceUpdateServer(c *gin.Context) (*model.ForceUpdateResponse, error) {
 	forceUpdateResp := new(model.ForceUpdateResponse)
 
 	for _, sid := range forceUpdateServers {
-		singleton.ServerLock.RLock()
-		server := singleton.ServerList[sid]
-		singleton.ServerLock.RUnlock()
-		if server != nil && server.TaskStream != nil {
-			if err := server.TaskStream.Send(&pb.Task{
-				Type: model.TaskTypeUpgrade,
-			}); err != nil {
-				forceUpdateResp.Failure = append(forceUpdateResp.Failure, sid)
-			} else {
-				forceUpdateResp.Success = append(forceUpdateResp.Success, sid)
-			}
-		} else {
-			forceUpdateResp.Offline = append(forceUpdateResp.Offline, sid)
-		}
+		forceUpdateResp.Append(sid, forceUpdateOne(sid))
 	}
 
 	return forceUpdateResp, nil
 }
+
+// Force update Agent and stream the results
+// @Summary Force update Agent and stream the results
+// @Security BearerAuth
+// @Schemes
+// @Description Force update Agent, emitting a server-sent "result" event per server and a final "done" event with the summary
+// @Tags auth required
+// @param id query []uint64 true "Server ID" collectionFormat(multi)
+// @Produce text/event-stream
+// @Success 200 {object} model.ForceUpdateResult
+// @Router /force-update/stream [get]
+func forceUpdateServerStream(c *gin.Context) {
+	var forceUpdateServers []uint64
+	for _, idStr := range c.QueryArray("id") {
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusOK, newErrorResponse(err))
+			return
+		}
+		forceUpdateServers = append(forceUpdateServers, id)
+	}
+
+	forceUpdateResp := new(model.ForceUpdateResponse)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	for _, sid := range forceUpdateServers {
+		// 客户端断开后不再继续下发
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		status := forceUpdateOne(sid)
+		forceUpdateResp.Append(sid, status)
+		c.SSEvent("result", model.ForceUpdateResult{ServerID: sid, Status: status})
+		c.Writer.Flush()
+	}
+
+	c.SSEvent("done", forceUpdateResp)
+	c.Writer.Flush()
+}
+
+// forceUpdateOne 向单台服务器下发升级任务，每次都重新读取服务器连接以应对中途掉线
+func forceUpdateOne(sid uint64) string {
+	return sendAgentTask(sid, model.TaskTypeUpgrade)
+}
+
+// Restart Agent
+// @Summary Restart Agent
+// @Security BearerAuth
+// @Schemes
+// @Description Restart the Agent process without upgrading it
+// @Tags auth required
+// @Accept json
+// @param request body []uint64 true "id list"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.ForceUpdateResponse]
+// @Router /restart [post]
+func restartServer(c *gin.Context) (*model.ForceUpdateResponse, error) {
+	var restartServers []uint64
+	if err := c.ShouldBindJSON(&restartServers); err != nil {
+		return nil, err
+	}
+
+	restartResp := new(model.ForceUpdateResponse)
+
+	for _, sid := range restartServers {
+		restartResp.Append(sid, sendAgentTask(sid, model.TaskTypeRestart))
+	}
+
+	return restartResp, nil
+}
+
+// sendAgentTask 向单台服务器下发不带参数的任务，返回下发结果
+func sendAgentTask(sid uint64, taskType uint64) string {
+	singleton.ServerLock.RLock()
+	server := singleton.ServerList[sid]
+	singleton.ServerLock.RUnlock()
+	if server == nil || server.TaskStream == nil {
+		return model.ForceUpdateStatusOffline
+	}
+	if err := server.TaskStream.Send(&pb.Task{
+		Type: taskType,
+	}); err != nil {
+		return model.ForceUpdateStatusFailure
+	}
+	return model.ForceUpdateStatusSuccess
+}
+
+// Fetch Agent log
+// @Summary Fetch Agent log
+// @Security BearerAuth
+// @Schemes
+// @Description Ask the Agent for the tail of its own log
+// @Tags auth required
+// @param id path uint true "Server ID"
+// @param lines query int false "Number of lines from the end of the log, default 100, at most 10000"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[string]
+// @Router /server/{id}/agent-log [get]
+func getAgentLog(c *gin.Context) (string, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	var query model.AgentLogQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return "", err
+	}
+	if query.Lines == 0 {
+		query.Lines = 100
+	}
+	if query.Lines < 1 || query.Lines > 10000 {
+		return "", singleton.Localizer.ErrorT("lines must be an integer between 1 and %d", 10000)
+	}
+
+	singleton.ServerLock.RLock()
+	server := singleton.ServerList[id]
+	singleton.ServerLock.RUnlock()
+	if server == nil || server.TaskStream == nil {
+		return "", singleton.Localizer.ErrorT("server not found or not connected")
+	}
+
+	streamId, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", err
+	}
+
+	rpc.NezhaHandlerSingleton.CreateStream(streamId)
+	defer rpc.NezhaHandlerSingleton.CloseStream(streamId)
+
+	taskData, err := utils.Json.Marshal(model.TaskFetchLogs{
+		StreamID: streamId,
+		Lines:    query.Lines,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := server.TaskStream.Send(&pb.Task{
+		Type: model.TaskTypeFetchLogs,
+		Data: string(taskData),
+	}); err != nil {
+		return "", err
+	}
+
+	data, err := rpc.NezhaHandlerSingleton.ReadAgentStream(streamId, 4<<20, time.Second*10)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -57,6 +57,7 @@ func listServerGroup(c *gin.Context) ([]model.ServerGroupResponseItem, error) {
 // @Tags auth required
 // @Accept json
 // @Param body body model.ServerGroupForm true "ServerGroupForm"
+// @Param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
 // @Produce json
 // @Success 200 {object} model.CommonResponse[uint64]
 // @Router /server-group [post]
@@ -96,6 +97,7 @@ func createServerGroup(c *gin.Context) (uint64, error) {
 		return 0, newGormError("%v", err)
 	}
 
+	singleton.OnServerGroupUpdate(sg.ID, sgf.Servers)
 	return sg.ID, nil
 }
 
@@ -161,6 +163,7 @@ func updateServerGroup(c *gin.Context) (any, error) {
 		return nil, newGormError("%v", err)
 	}
 
+	singleton.OnServerGroupUpdate(sgDB.ID, sg.Servers)
 	return nil, nil
 }
 
@@ -195,5 +198,6 @@ func batchDeleteServerGroup(c *gin.Context) (any, error) {
 		return nil, newGormError("%v", err)
 	}
 
+	singleton.OnServerGroupDelete(sgs)
 	return nil, nil
 }
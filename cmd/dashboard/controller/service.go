@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/jinzhu/copier"
 
 	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
 	"github.com/nezhahq/nezha/service/singleton"
 	"gorm.io/gorm"
 )
@@ -17,25 +19,33 @@ import (
 // @Summary List service
 // @Security BearerAuth
 // @Schemes
-// @Description List service
+// @Description List service, responds with an ETag and returns 304 when If-None-Match matches
 // @Tags common
+// @param If-None-Match header string false "ETag of a previous response"
 // @Produce json
 // @Success 200 {object} model.CommonResponse[model.ServiceResponse]
+// @Success 304
 // @Router /service [get]
 func listService(c *gin.Context) (*model.ServiceResponse, error) {
-	res, err, _ := requestGroup.Do("list-service", func() (interface{}, error) {
+	_, isMember := c.Get(model.CtxKeyAuthorizedUser)
+	authorized := isMember || isViewPasswordVerified(c)
+	// 公开状态页访问量大时，短时间内的请求直接使用缓存的结果
+	cacheKey := singleton.ServiceStatsCacheKey(authorized)
+	if cached, ok := singleton.Cache.Get(cacheKey); ok {
+		return cached.(*model.ServiceResponse), nil
+	}
+	res, err, _ := requestGroup.Do(fmt.Sprintf("list-service::%t", authorized), func() (interface{}, error) {
 		singleton.AlertsLock.RLock()
 		defer singleton.AlertsLock.RUnlock()
 		var stats map[uint64]model.ServiceResponseItem
 		var statsStore map[uint64]model.CycleTransferStats
 		copier.Copy(&stats, singleton.ServiceSentinelShared.LoadStats())
 		copier.Copy(&statsStore, singleton.AlertsCycleTransferStatsStore)
-		_, isMember := c.Get(model.CtxKeyAuthorizedUser)
-		authorized := isMember // TODO || isViewPasswordVerfied
 		for k, service := range stats {
 			if !authorized {
 				if !service.Service.EnableShowInService {
 					delete(stats, k)
+					continue
 				}
 				service.Service = &model.Service{Name: service.Service.Name}
 				stats[k] = service
@@ -50,10 +60,14 @@ func listService(c *gin.Context) (*model.ServiceResponse, error) {
 		return nil, err
 	}
 
-	return &model.ServiceResponse{
+	resp := &model.ServiceResponse{
 		Services:           res.([]interface{})[0].(map[uint64]model.ServiceResponseItem),
 		CycleTransferStats: res.([]interface{})[1].(map[uint64]model.CycleTransferStats),
-	}, nil
+	}
+	if ttl := singleton.Conf.ServiceStatsCacheTTL; ttl > 0 {
+		singleton.Cache.Set(cacheKey, resp, time.Duration(ttl)*time.Second)
+	}
+	return resp, nil
 }
 
 // List service histories by server id
@@ -63,6 +77,11 @@ func listService(c *gin.Context) (*model.ServiceResponse, error) {
 // @Description List service histories by server id
 // @Tags common
 // @param id path uint true "Server ID"
+// @param from query int false "Start of the window, unix milliseconds (default 24 hours before to)"
+// @param to query int false "End of the window, unix milliseconds (default now)"
+// @param offset query int false "Number of history rows to skip for each service"
+// @param limit query int false "Maximum number of history rows for each service, all matching rows are returned when omitted"
+// @param order query string false "Order of created_at within each service, asc (default) or desc"
 // @Produce json
 // @Success 200 {object} model.CommonResponse[[]model.ServiceInfos]
 // @Router /service/{id} [get]
@@ -73,25 +92,55 @@ func listServiceHistory(c *gin.Context) ([]*model.ServiceInfos, error) {
 		return nil, err
 	}
 
+	var query model.ServiceHistoryQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return nil, err
+	}
+
+	to := time.Now()
+	if query.To > 0 {
+		to = time.UnixMilli(query.To)
+	}
+	from := to.Add(-24 * time.Hour)
+	if query.From > 0 {
+		from = time.UnixMilli(query.From)
+	}
+	if from.After(to) {
+		return nil, singleton.Localizer.ErrorT("invalid time range")
+	}
+
 	singleton.ServerLock.RLock()
 	server, ok := singleton.ServerList[id]
 	if !ok {
+		singleton.ServerLock.RUnlock()
 		return nil, singleton.Localizer.ErrorT("server not found")
 	}
 
 	_, isMember := c.Get(model.CtxKeyAuthorizedUser)
-	authorized := isMember // TODO || isViewPasswordVerfied
+	authorized := isMember || isViewPasswordVerified(c)
 
 	if server.HideForGuest && !authorized {
+		singleton.ServerLock.RUnlock()
 		return nil, singleton.Localizer.ErrorT("unauthorized")
 	}
 	singleton.ServerLock.RUnlock()
 
-	var serviceHistories []*model.ServiceHistory
-	if err := singleton.DB.Model(&model.ServiceHistory{}).Select("service_id, created_at, server_id, avg_delay").
-		Where("server_id = ?", id).Where("created_at >= ?", time.Now().Add(-24*time.Hour)).Order("service_id, created_at").
-		Scan(&serviceHistories).Error; err != nil {
-		return nil, err
+	var orderBy string
+	switch query.Order {
+	case "", "asc":
+		orderBy = singleton.ServiceHistoryOrderAsc
+	case "desc":
+		orderBy = singleton.ServiceHistoryOrderDesc
+	default:
+		return nil, singleton.Localizer.ErrorT("invalid order %s, expected asc or desc", query.Order)
+	}
+	if query.Offset < 0 || query.Limit < 0 {
+		return nil, singleton.Localizer.ErrorT("offset and limit must not be negative")
+	}
+
+	serviceHistories, err := singleton.GetServiceHistories(map[string]any{"server_id": id}, from, to, query.Offset, query.Limit, orderBy)
+	if err != nil {
+		return nil, newGormError("%v", err)
 	}
 
 	singleton.ServiceSentinelShared.ServicesLock.RLock()
@@ -115,6 +164,16 @@ func listServiceHistory(c *gin.Context) ([]*model.ServiceInfos, error) {
 		}
 		infos.CreatedAt = append(infos.CreatedAt, history.CreatedAt.Truncate(time.Minute).Unix()*1000)
 		infos.AvgDelay = append(infos.AvgDelay, history.AvgDelay)
+		if history.EndpointDelaysRaw != "" && infos.EndpointDelays == nil {
+			infos.EndpointDelays = make([]map[string]float32, len(infos.AvgDelay)-1)
+		}
+		if infos.EndpointDelays != nil {
+			var delays map[string]float32
+			if history.EndpointDelaysRaw != "" {
+				utils.Json.Unmarshal([]byte(history.EndpointDelaysRaw), &delays)
+			}
+			infos.EndpointDelays = append(infos.EndpointDelays, delays)
+		}
 	}
 
 	ret := make([]*model.ServiceInfos, 0, len(sortedServiceIDs))
@@ -144,7 +203,7 @@ func listServerWithServices(c *gin.Context) ([]uint64, error) {
 	}
 
 	_, isMember := c.Get(model.CtxKeyAuthorizedUser)
-	authorized := isMember // TODO || isViewPasswordVerfied
+	authorized := isMember || isViewPasswordVerified(c)
 
 	var ret []uint64
 	for _, id := range serverIdsWithService {
@@ -172,34 +231,27 @@ func listServerWithServices(c *gin.Context) ([]uint64, error) {
 // @Tags auth required
 // @Accept json
 // @param request body model.ServiceForm true "Service Request"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[uint64]
+// @Success 200 {object} model.CommonResponse[model.Service]
 // @Router /service [post]
-func createService(c *gin.Context) (uint64, error) {
+func createService(c *gin.Context) (*model.Service, error) {
 	var mf model.ServiceForm
 	if err := c.ShouldBindJSON(&mf); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	var m model.Service
-	m.Name = mf.Name
-	m.Target = strings.TrimSpace(mf.Target)
-	m.Type = mf.Type
-	m.SkipServers = mf.SkipServers
-	m.Cover = mf.Cover
-	m.Notify = mf.Notify
-	m.NotificationGroupID = mf.NotificationGroupID
-	m.Duration = mf.Duration
-	m.LatencyNotify = mf.LatencyNotify
-	m.MinLatency = mf.MinLatency
-	m.MaxLatency = mf.MaxLatency
-	m.EnableShowInService = mf.EnableShowInService
-	m.EnableTriggerTask = mf.EnableTriggerTask
-	m.RecoverTriggerTasks = mf.RecoverTriggerTasks
-	m.FailTriggerTasks = mf.FailTriggerTasks
+	if err := applyServiceForm(&m, &mf); err != nil {
+		return nil, err
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, singleton.Localizer.ErrorT("invalid service: %v", err)
+	}
 
 	if err := singleton.DB.Create(&m).Error; err != nil {
-		return 0, newGormError("%v", err)
+		return nil, newGormError("%v", err)
 	}
 
 	var skipServers []uint64
@@ -214,10 +266,132 @@ func createService(c *gin.Context) (uint64, error) {
 		err = singleton.DB.Unscoped().Delete(&model.ServiceHistory{}, "service_id = ? and server_id not in (?)", m.ID, skipServers).Error
 	}
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	if err := singleton.ServiceSentinelShared.OnServiceUpdate(m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Batch create services
+// @Summary Batch create services
+// @Security BearerAuth
+// @Schemes
+// @Description Create several services sharing the same settings in one transaction. Entries that fail validation are reported and skipped without aborting the batch.
+// @Tags auth required
+// @Accept json
+// @param request body model.ServiceBatchCreateForm true "ServiceBatchCreateForm"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.ServiceBatchCreateResponse]
+// @Router /batch-create/service [post]
+func batchCreateService(c *gin.Context) (*model.ServiceBatchCreateResponse, error) {
+	var bf model.ServiceBatchCreateForm
+	if err := c.ShouldBindJSON(&bf); err != nil {
+		return nil, err
+	}
+
+	resp := &model.ServiceBatchCreateResponse{
+		Created: make([]uint64, 0, len(bf.Services)),
+	}
+	var services []model.Service
+	for i, item := range bf.Services {
+		var m model.Service
+		if err := applyServiceForm(&m, &bf.Defaults); err != nil {
+			return nil, err
+		}
+		m.Name = item.Name
+		m.Target = strings.TrimSpace(item.Target)
+		m.Type = item.Type
+		if item.BodyPattern != "" {
+			m.BodyPattern = item.BodyPattern
+		}
+
+		if m.Name == "" {
+			m.Name = m.Target
+		}
+		if err := m.Validate(); err != nil {
+			resp.Errors = append(resp.Errors, model.ServiceBatchCreateError{
+				Index: i,
+				Name:  m.Name,
+				Error: singleton.Localizer.ErrorT("invalid service: %v", err).Error(),
+			})
+			continue
+		}
+		services = append(services, m)
+	}
+
+	err := singleton.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range services {
+			if err := tx.Create(&services[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	for _, m := range services {
+		if err := singleton.ServiceSentinelShared.OnServiceUpdate(m); err != nil {
+			return nil, err
+		}
+		resp.Created = append(resp.Created, m.ID)
+	}
+	return resp, nil
+}
+
+// Test service
+// @Summary Test service
+// @Security BearerAuth
+// @Schemes
+// @Description Probe a service target once from the dashboard and return the measured latency or error, without saving anything. Multiple comma-separated targets are probed concurrently and combined according to target_mode.
+// @Tags auth required
+// @Accept json
+// @param request body model.ServiceTestForm true "ServiceTestForm"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.ServiceTestResponse]
+// @Router /service/test [post]
+func testService(c *gin.Context) (*model.ServiceTestResponse, error) {
+	var tf model.ServiceTestForm
+	if err := c.ShouldBindJSON(&tf); err != nil {
+		return nil, err
+	}
+
+	m := model.Service{
+		Target:           strings.TrimSpace(tf.Target),
+		Type:             tf.Type,
+		TargetMode:       tf.TargetMode,
+		BodyPattern:      tf.BodyPattern,
+		BodyPatternRegex: tf.BodyPatternRegex,
+		HTTPHeaders:      tf.HTTPHeaders,
+	}
+	if err := applyServiceHTTPAuth(&m, tf.HTTPAuthType, tf.HTTPAuthUsername, tf.HTTPAuthSecret); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, singleton.Localizer.ErrorT("invalid service: %v", err)
+	}
+
+	if len(m.Targets()) == 1 {
+		result := singleton.ProbeService(c.Request.Context(), &m)
+		return &model.ServiceTestResponse{
+			Successful: result.Successful,
+			Delay:      result.Delay,
+			Data:       result.Data,
+		}, nil
 	}
 
-	return m.ID, singleton.ServiceSentinelShared.OnServiceUpdate(m)
+	result, delays := singleton.ProbeServiceTargets(c.Request.Context(), &m)
+	return &model.ServiceTestResponse{
+		Successful:     result.Successful,
+		Delay:          result.Delay,
+		Data:           result.Data,
+		EndpointDelays: delays,
+	}, nil
 }
 
 // Update service
@@ -230,9 +404,9 @@ func createService(c *gin.Context) (uint64, error) {
 // @param id path uint true "Service ID"
 // @param request body model.ServiceForm true "Service Request"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[any]
+// @Success 200 {object} model.CommonResponse[model.Service]
 // @Router /service/{id} [patch]
-func updateService(c *gin.Context) (any, error) {
+func updateService(c *gin.Context) (*model.Service, error) {
 	strID := c.Param("id")
 	id, err := strconv.ParseUint(strID, 10, 64)
 	if err != nil {
@@ -246,24 +420,20 @@ func updateService(c *gin.Context) (any, error) {
 	if err := singleton.DB.First(&m, id).Error; err != nil {
 		return nil, singleton.Localizer.ErrorT("service id %d does not exist", id)
 	}
-	m.Name = mf.Name
-	m.Target = strings.TrimSpace(mf.Target)
-	m.Type = mf.Type
-	m.SkipServers = mf.SkipServers
-	m.Cover = mf.Cover
-	m.Notify = mf.Notify
-	m.NotificationGroupID = mf.NotificationGroupID
-	m.Duration = mf.Duration
-	m.LatencyNotify = mf.LatencyNotify
-	m.MinLatency = mf.MinLatency
-	m.MaxLatency = mf.MaxLatency
-	m.EnableShowInService = mf.EnableShowInService
-	m.EnableTriggerTask = mf.EnableTriggerTask
-	m.RecoverTriggerTasks = mf.RecoverTriggerTasks
-	m.FailTriggerTasks = mf.FailTriggerTasks
+	loaded := m.UpdatedAt
+	if err := checkVersion(mf.UpdatedAt, m.UpdatedAt, &m); err != nil {
+		return nil, err
+	}
+	if err := applyServiceForm(&m, &mf); err != nil {
+		return nil, err
+	}
 
-	if err := singleton.DB.Save(&m).Error; err != nil {
-		return nil, newGormError("%v", err)
+	if err := m.Validate(); err != nil {
+		return nil, singleton.Localizer.ErrorT("invalid service: %v", err)
+	}
+
+	if err := saveVersion(&m, m.ID, loaded); err != nil {
+		return nil, err
 	}
 
 	var skipServers []uint64
@@ -280,7 +450,42 @@ func updateService(c *gin.Context) (any, error) {
 		return nil, err
 	}
 
-	return nil, singleton.ServiceSentinelShared.OnServiceUpdate(m)
+	if err := singleton.ServiceSentinelShared.OnServiceUpdate(m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Clone service
+// @Summary Clone service
+// @Security BearerAuth
+// @Schemes
+// @Description Create a copy of a service with the same settings, the name gets a " (copy)" suffix
+// @Tags auth required
+// @param id path uint true "Service ID"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.Service]
+// @Router /service/{id}/clone [post]
+func cloneService(c *gin.Context) (*model.Service, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var m model.Service
+	if err := singleton.DB.First(&m, id).Error; err != nil {
+		return nil, singleton.Localizer.ErrorT("service id %d does not exist", id)
+	}
+	m.Common = model.Common{}
+	m.CronJobID = 0
+	m.Name += cloneNameSuffix
+
+	if err := singleton.DB.Create(&m).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+	return &m, singleton.ServiceSentinelShared.OnServiceUpdate(m)
 }
 
 // Batch delete service
@@ -292,22 +497,103 @@ func updateService(c *gin.Context) (any, error) {
 // @Accept json
 // @param request body []uint true "id list"
 // @Produce json
-// @Success 200 {object} model.CommonResponse[any]
+// @Success 200 {object} model.CommonResponse[model.BatchDeleteServiceResponse]
 // @Router /batch-delete/service [post]
-func batchDeleteService(c *gin.Context) (any, error) {
+func batchDeleteService(c *gin.Context) (*model.BatchDeleteServiceResponse, error) {
 	var ids []uint64
 	if err := c.ShouldBindJSON(&ids); err != nil {
 		return nil, err
 	}
+
+	var existing []uint64
 	err := singleton.DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Unscoped().Delete(&model.Service{}, "id in (?)", ids).Error; err != nil {
+		if err := tx.Model(&model.Service{}).Where("id in (?)", ids).Pluck("id", &existing).Error; err != nil {
 			return err
 		}
-		return tx.Unscoped().Delete(&model.ServiceHistory{}, "service_id in (?)", ids).Error
+		if len(existing) == 0 {
+			return nil
+		}
+		if err := tx.Unscoped().Delete(&model.Service{}, "id in (?)", existing).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.ServiceHistory{}, "service_id in (?)", existing).Error
 	})
 	if err != nil {
-		return nil, err
+		return nil, newGormError("%v", err)
+	}
+	singleton.ServiceSentinelShared.OnServiceDelete(existing)
+
+	deleted := make(map[uint64]bool, len(existing))
+	for _, id := range existing {
+		deleted[id] = true
+	}
+
+	resp := new(model.BatchDeleteServiceResponse)
+	for _, id := range ids {
+		if deleted[id] {
+			resp.Success = append(resp.Success, id)
+		} else {
+			resp.Failure = append(resp.Failure, id)
+		}
+	}
+	return resp, nil
+}
+
+// applyServiceForm 将表单内容写入服务监控，HTTPAuthSecret 与请求头的值留空时保留原有的值
+func applyServiceForm(m *model.Service, mf *model.ServiceForm) error {
+	m.Name = mf.Name
+	m.Target = strings.TrimSpace(mf.Target)
+	m.Type = mf.Type
+	m.TargetMode = mf.TargetMode
+	m.SkipServers = mf.SkipServers
+	m.Cover = mf.Cover
+	m.Notify = mf.Notify
+	m.NotificationGroupID = mf.NotificationGroupID
+	m.Duration = mf.Duration
+	m.LatencyNotify = mf.LatencyNotify
+	m.MinLatency = mf.MinLatency
+	m.MaxLatency = mf.MaxLatency
+	m.EnableShowInService = mf.EnableShowInService
+	m.EnableTriggerTask = mf.EnableTriggerTask
+	m.RecoverTriggerTasks = mf.RecoverTriggerTasks
+	m.FailTriggerTasks = mf.FailTriggerTasks
+	m.Paused = mf.Paused
+	m.BodyPattern = mf.BodyPattern
+	m.BodyPatternRegex = mf.BodyPatternRegex
+	m.HTTPHeaders = mergeServiceHTTPHeaders(m.HTTPHeaders, mf.HTTPHeaders)
+	m.SetHTTPHeaderNames()
+	return applyServiceHTTPAuth(m, mf.HTTPAuthType, mf.HTTPAuthUsername, mf.HTTPAuthSecret)
+}
+
+// mergeServiceHTTPHeaders 返回表单中的请求头，值为空的请求头沿用已保存的值
+// API 不返回请求头的值，编辑时未修改的请求头以空值提交
+func mergeServiceHTTPHeaders(saved, form map[string]string) map[string]string {
+	if len(form) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(form))
+	for k, v := range form {
+		if v == "" {
+			v = saved[k]
+		}
+		headers[k] = v
+	}
+	return headers
+}
+
+// applyServiceHTTPAuth 设置 HTTP 监控的认证信息，密码或 Token 加密后保存
+func applyServiceHTTPAuth(m *model.Service, authType uint8, username, secret string) error {
+	m.HTTPAuthType = authType
+	m.HTTPAuthUsername = username
+	if authType == model.ServiceHTTPAuthNone {
+		m.HTTPAuthSecret = ""
+	} else if secret != "" {
+		encrypted, err := utils.EncryptString(singleton.Conf.JWTSecretKey, secret)
+		if err != nil {
+			return err
+		}
+		m.HTTPAuthSecret = encrypted
 	}
-	singleton.ServiceSentinelShared.OnServiceDelete(ids)
-	return nil, nil
+	m.HTTPAuthIsSet = m.HTTPAuthSecret != ""
+	return nil
 }
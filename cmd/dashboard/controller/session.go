@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+// List sessions
+// @Summary List sessions
+// @Security BearerAuth
+// @Schemes
+// @Description List active browser sessions of the current user
+// @Tags auth required
+// @Produce json
+// @Success 200 {object} model.CommonResponse[[]model.Session]
+// @Router /sessions [get]
+func listSession(c *gin.Context) ([]model.Session, error) {
+	auth := c.MustGet(model.CtxKeyAuthorizedUser).(*model.User)
+
+	var sessions []model.Session
+	if err := singleton.DB.Where("user_id = ? AND expires_at > ?", auth.ID, time.Now()).Order("id desc").Find(&sessions).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	current, _ := jwt.ExtractClaims(c)[claimSessionID].(string)
+	for i := range sessions {
+		sessions[i].Current = utils.Itoa(sessions[i].ID) == current
+	}
+	return sessions, nil
+}
+
+// Delete session
+// @Summary Delete session
+// @Security BearerAuth
+// @Schemes
+// @Description Revoke a session of the current user, tokens issued for it stop working immediately
+// @Tags auth required
+// @param id path uint true "Session ID"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[any]
+// @Router /sessions/{id} [delete]
+func deleteSession(c *gin.Context) (any, error) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	auth := c.MustGet(model.CtxKeyAuthorizedUser).(*model.User)
+
+	result := singleton.DB.Delete(&model.Session{}, "id = ? AND user_id = ?", id, auth.ID)
+	if result.Error != nil {
+		return nil, newGormError("%v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, singleton.Localizer.ErrorT("session id %d does not exist", id)
+	}
+	return nil, nil
+}
@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"slices"
+	"time"
+
 	"github.com/gin-gonic/gin"
 
 	"github.com/nezhahq/nezha/model"
@@ -10,17 +13,19 @@ import (
 // List settings
 // @Summary List settings
 // @Schemes
-// @Description List settings
+// @Description List settings, secrets such as the JWT key are never returned
 // @Security BearerAuth
 // @Tags common
 // @Produce json
 // @Success 200 {object} model.CommonResponse[model.Config]
 // @Router /setting [get]
 func listConfig(c *gin.Context) (model.Config, error) {
-	_, isMember := c.Get(model.CtxKeyAuthorizedUser)
-	authorized := isMember // TODO || isViewPasswordVerfied
+	// 访问密码仅用于查看隐藏的服务器，不开放完整配置
+	_, authorized := c.Get(model.CtxKeyAuthorizedUser)
 
 	conf := *singleton.Conf
+	// 已到期的维护模式不再显示为开启
+	conf.MaintenanceMode = conf.InMaintenance(time.Now())
 	if !authorized {
 		conf = model.Config{
 			SiteName:            conf.SiteName,
@@ -50,23 +55,74 @@ func updateConfig(c *gin.Context) (any, error) {
 		return nil, err
 	}
 
-	singleton.Conf.Language = sf.Language
-	singleton.Conf.EnableIPChangeNotification = sf.EnableIPChangeNotification
-	singleton.Conf.EnablePlainIPInNotification = sf.EnablePlainIPInNotification
-	singleton.Conf.Cover = sf.Cover
-	singleton.Conf.InstallHost = sf.InstallHost
-	singleton.Conf.IgnoredIPNotification = sf.IgnoredIPNotification
-	singleton.Conf.IPChangeNotificationGroupID = sf.IPChangeNotificationGroupID
-	singleton.Conf.SiteName = sf.SiteName
-	singleton.Conf.DNSServers = sf.CustomNameservers
-	singleton.Conf.CustomCode = sf.CustomCode
-	singleton.Conf.CustomCodeDashboard = sf.CustomCodeDashboard
-	singleton.Conf.RealIPHeader = sf.RealIPHeader
+	singleton.ConfLock.Lock()
+	defer singleton.ConfLock.Unlock()
+
+	// 在副本上修改并校验，保存成功后再替换当前配置
+	conf := *singleton.Conf
+	conf.Language = sf.Language
+	conf.EnableIPChangeNotification = sf.EnableIPChangeNotification
+	conf.EnablePlainIPInNotification = sf.EnablePlainIPInNotification
+	conf.Cover = sf.Cover
+	conf.InstallHost = sf.InstallHost
+	conf.IgnoredIPNotification = sf.IgnoredIPNotification
+	conf.IPChangeNotificationGroupID = sf.IPChangeNotificationGroupID
+	conf.EnableServerStateNotification = sf.EnableServerStateNotification
+	conf.ServerStateNotificationGroupID = sf.ServerStateNotificationGroupID
+	conf.SiteName = sf.SiteName
+	conf.DNSServers = sf.CustomNameservers
+	conf.CustomCode = sf.CustomCode
+	conf.CustomCodeDashboard = sf.CustomCodeDashboard
+	conf.RealIPHeader = sf.RealIPHeader
+	if sf.CleanHistorySchedule != "" {
+		conf.CleanHistorySchedule = sf.CleanHistorySchedule
+	}
+	if sf.ServiceHistoryRetentionDays != 0 {
+		conf.ServiceHistoryRetentionDays = sf.ServiceHistoryRetentionDays
+	}
+	if sf.TransferHistoryRetentionDays != 0 {
+		conf.TransferHistoryRetentionDays = sf.TransferHistoryRetentionDays
+	}
+	if sf.AuditLogRetentionDays != 0 {
+		conf.AuditLogRetentionDays = sf.AuditLogRetentionDays
+	}
+	if len(sf.TransferQuotaThresholds) > 0 {
+		conf.TransferQuotaThresholds = slices.Clone(sf.TransferQuotaThresholds)
+		slices.Sort(conf.TransferQuotaThresholds)
+	}
+	if sf.IPChangeNotificationConfirmations != 0 {
+		conf.IPChangeNotificationConfirmations = sf.IPChangeNotificationConfirmations
+	}
+	if sf.ServerOfflineThreshold != 0 {
+		conf.ServerOfflineThreshold = sf.ServerOfflineThreshold
+	}
+	if sf.LogLevel != "" {
+		conf.LogLevel = sf.LogLevel
+	}
+
+	if err := singleton.ValidateConfig(&conf); err != nil {
+		return nil, err
+	}
+	if conf.DNSServers != singleton.Conf.DNSServers {
+		if err := singleton.CheckNameservers(conf.DNSServers); err != nil {
+			return nil, err
+		}
+	}
 
-	if err := singleton.Conf.Save(); err != nil {
+	if err := conf.Save(); err != nil {
 		return nil, newGormError("%v", err)
 	}
+	scheduleChanged := conf.CleanHistorySchedule != singleton.Conf.CleanHistorySchedule
+	singleton.Conf = &conf
 
+	if scheduleChanged {
+		if err := singleton.SetCleanHistorySchedule(conf.CleanHistorySchedule); err != nil {
+			return nil, err
+		}
+	}
+	if err := singleton.SetLogLevel(conf.LogLevel); err != nil {
+		return nil, err
+	}
 	singleton.OnNameserverUpdate()
 	singleton.OnUpdateLang(singleton.Conf.Language)
 	return nil, nil
@@ -4,7 +4,6 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 	"github.com/hashicorp/go-uuid"
 	"github.com/nezhahq/nezha/model"
 	"github.com/nezhahq/nezha/pkg/utils"
@@ -81,21 +80,13 @@ func terminalStream(c *gin.Context) (any, error) {
 	defer wsConn.Close()
 	conn := websocketx.NewConn(wsConn)
 
-	go func() {
-		// PING 保活
-		for {
-			if err = conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
-				return
-			}
-			time.Sleep(time.Second * 10)
-		}
-	}()
+	go streamKeepAlive(conn)
 
 	if err = rpc.NezhaHandlerSingleton.UserConnected(streamId, conn); err != nil {
 		return nil, newWsError("%v", err)
 	}
 
-	if err = rpc.NezhaHandlerSingleton.StartStream(streamId, time.Second*10); err != nil {
+	if err = rpc.NezhaHandlerSingleton.StartStream(streamId, time.Duration(singleton.Conf.StreamConnectTimeout)*time.Second); err != nil {
 		return nil, newWsError("%v", err)
 	}
 
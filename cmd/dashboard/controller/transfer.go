@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+// Export transfer usage
+// @Summary Export transfer usage
+// @Security BearerAuth
+// @Schemes
+// @Description Export hourly inbound and outbound transfer of each server as CSV, with a subtotal row per server and a grand total row at the end. If reading fails midway, the output ends with an "error" row instead of the totals
+// @Tags auth required
+// @param from query int false "Start time in milliseconds, defaults to 30 days before to"
+// @param to query int false "End time in milliseconds, defaults to now"
+// @param server_id query uint false "Only export this server"
+// @Produce text/csv
+// @Success 200 {string} string
+// @Router /transfer/export [get]
+func exportTransfer(c *gin.Context) {
+	var query model.TransferExportQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusOK, newErrorResponse(err))
+		return
+	}
+
+	to := time.Now()
+	if query.To > 0 {
+		to = time.UnixMilli(query.To)
+	}
+	from := to.AddDate(0, 0, -30)
+	if query.From > 0 {
+		from = time.UnixMilli(query.From)
+	}
+	if from.After(to) {
+		c.JSON(http.StatusOK, newErrorResponse(singleton.Localizer.ErrorT("invalid time range")))
+		return
+	}
+
+	db := singleton.DB
+	tx := db.Model(&model.Transfer{}).
+		Where(model.DateTimeExpr(db, "created_at")+" >= "+model.DateTimeExpr(db, "?")+" AND "+
+			model.DateTimeExpr(db, "created_at")+" <= "+model.DateTimeExpr(db, "?"), from.UTC(), to.UTC())
+	if query.ServerID > 0 {
+		tx = tx.Where("server_id = ?", query.ServerID)
+	}
+	rows, err := tx.Order("server_id, created_at").Rows()
+	if err != nil {
+		c.JSON(http.StatusOK, newErrorResponse(newGormError("%v", err)))
+		return
+	}
+	defer rows.Close()
+
+	serverNames := make(map[uint64]string)
+	singleton.ServerLock.RLock()
+	for id, server := range singleton.ServerList {
+		serverNames[id] = server.Name
+	}
+	singleton.ServerLock.RUnlock()
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="transfer-%s-%s.csv"`,
+		from.In(singleton.Loc).Format("20060102"), to.In(singleton.Loc).Format("20060102")))
+	c.Status(http.StatusOK)
+
+	// 按服务器排序逐行读取，服务器切换时写出该服务器的小计，避免将整个区间的数据读入内存
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"time", "server_id", "server_name", "in", "out"})
+
+	var current, serverIn, serverOut, totalIn, totalOut uint64
+	writeSubtotal := func() {
+		w.Write([]string{"subtotal", strconv.FormatUint(current, 10), serverNames[current],
+			strconv.FormatUint(serverIn, 10), strconv.FormatUint(serverOut, 10)})
+	}
+	// 响应头已发出，读取出错时写入错误行并结束，不写出不完整的合计
+	writeError := func(err error) {
+		c.Error(err)
+		w.Write([]string{"error", err.Error()})
+		w.Flush()
+	}
+	var count int
+	for rows.Next() {
+		var t model.Transfer
+		if err := db.ScanRows(rows, &t); err != nil {
+			writeError(err)
+			return
+		}
+		if count > 0 && t.ServerID != current {
+			writeSubtotal()
+			serverIn, serverOut = 0, 0
+		}
+		current = t.ServerID
+		count++
+		serverIn += t.In
+		serverOut += t.Out
+		totalIn += t.In
+		totalOut += t.Out
+		w.Write([]string{t.CreatedAt.In(singleton.Loc).Format(time.RFC3339), strconv.FormatUint(t.ServerID, 10),
+			serverNames[t.ServerID], strconv.FormatUint(t.In, 10), strconv.FormatUint(t.Out, 10)})
+	}
+	if err := rows.Err(); err != nil {
+		writeError(err)
+		return
+	}
+	if count > 0 {
+		writeSubtotal()
+	}
+	w.Write([]string{"total", "", "", strconv.FormatUint(totalIn, 10), strconv.FormatUint(totalOut, 10)})
+	w.Flush()
+}
@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+const _RecoveryCodeCount = 10
+
+// _TOTPOpts 与 totp.Validate 相同的参数
+var _TOTPOpts = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// Enroll two-factor authentication
+// @Summary Enroll two-factor authentication
+// @Security BearerAuth
+// @Schemes
+// @Description Generate a new TOTP secret and recovery codes, two-factor authentication is enabled after verification. Requires the current password, and a code or recovery code when two-factor authentication is already enabled
+// @Tags auth required
+// @Accept json
+// @param request body model.TwoFactorEnrollForm true "Current password and code"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[model.TwoFactorEnrollResponse]
+// @Router /profile/2fa/enroll [post]
+func enrollTwoFactor(c *gin.Context) (*model.TwoFactorEnrollResponse, error) {
+	var ef model.TwoFactorEnrollForm
+	if err := c.ShouldBindJSON(&ef); err != nil {
+		return nil, err
+	}
+
+	auth, ok := c.Get(model.CtxKeyAuthorizedUser)
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("unauthorized")
+	}
+	user := *auth.(*model.User)
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(ef.Password)); err != nil {
+		return nil, singleton.Localizer.ErrorT("incorrect password")
+	}
+	if user.TOTPEnabled && !verifyTwoFactor(&user, ef.Code) {
+		return nil, singleton.Localizer.ErrorT("incorrect verification code")
+	}
+
+	issuer := singleton.Conf.SiteName
+	if issuer == "" {
+		issuer = "Nezha"
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: user.Username,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 使用 JWT 密钥加密保存，更换 JWT 密钥后需要重新绑定
+	secret, err := utils.EncryptString(singleton.Conf.JWTSecretKey, key.Secret())
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, _RecoveryCodeCount)
+	hashes := make([]string, 0, _RecoveryCodeCount)
+	for i := 0; i < _RecoveryCodeCount; i++ {
+		code, err := utils.GenerateRandomString(10)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, string(hash))
+	}
+	recoveryCodes, err := utils.Json.Marshal(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	// 重新绑定时旧的密钥立即失效，验证通过前不启用两步验证
+	if err := singleton.DB.Model(&user).Updates(map[string]any{
+		"totp_enabled":   false,
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+		"recovery_codes": string(recoveryCodes),
+	}).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	return &model.TwoFactorEnrollResponse{
+		Secret:          key.Secret(),
+		ProvisioningURI: key.URL(),
+		RecoveryCodes:   codes,
+	}, nil
+}
+
+// Verify two-factor authentication
+// @Summary Verify two-factor authentication
+// @Security BearerAuth
+// @Schemes
+// @Description Confirm the enrolled TOTP secret with a code and enable two-factor authentication
+// @Tags auth required
+// @Accept json
+// @param request body model.TwoFactorVerifyForm true "TOTP code"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[any]
+// @Router /profile/2fa/verify [post]
+func verifyTwoFactorEnrollment(c *gin.Context) (any, error) {
+	var tf model.TwoFactorVerifyForm
+	if err := c.ShouldBindJSON(&tf); err != nil {
+		return nil, err
+	}
+
+	auth, ok := c.Get(model.CtxKeyAuthorizedUser)
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("unauthorized")
+	}
+	user := *auth.(*model.User)
+
+	if user.TOTPSecret == "" {
+		return nil, singleton.Localizer.ErrorT("two-factor authentication is not enrolled")
+	}
+
+	if !verifyTOTP(&user, tf.Code) {
+		return nil, singleton.Localizer.ErrorT("incorrect verification code")
+	}
+
+	if err := singleton.DB.Model(&user).Update("totp_enabled", true).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	return nil, nil
+}
+
+// Disable two-factor authentication
+// @Summary Disable two-factor authentication
+// @Security BearerAuth
+// @Schemes
+// @Description Disable two-factor authentication and remove the TOTP secret and recovery codes. Requires the current password and a code or recovery code
+// @Tags auth required
+// @Accept json
+// @param request body model.TwoFactorDisableForm true "Current password and code"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[any]
+// @Router /profile/2fa/disable [post]
+func disableTwoFactor(c *gin.Context) (any, error) {
+	var df model.TwoFactorDisableForm
+	if err := c.ShouldBindJSON(&df); err != nil {
+		return nil, err
+	}
+
+	auth, ok := c.Get(model.CtxKeyAuthorizedUser)
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("unauthorized")
+	}
+	user := *auth.(*model.User)
+
+	if !user.TOTPEnabled {
+		return nil, singleton.Localizer.ErrorT("two-factor authentication is not enabled")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(df.Password)); err != nil {
+		return nil, singleton.Localizer.ErrorT("incorrect password")
+	}
+	if !verifyTwoFactor(&user, df.Code) {
+		return nil, singleton.Localizer.ErrorT("incorrect verification code")
+	}
+
+	if err := singleton.DB.Model(&user).Updates(map[string]any{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+		"recovery_codes": "",
+	}).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	return nil, nil
+}
+
+// verifyTwoFactor 校验动态验证码，失败时尝试使用恢复码，恢复码使用后即失效
+func verifyTwoFactor(user *model.User, code string) bool {
+	if verifyTOTP(user, code) {
+		return true
+	}
+
+	var hashes []string
+	if err := utils.Json.Unmarshal([]byte(user.RecoveryCodes), &hashes); err != nil {
+		return false
+	}
+	for i, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+			continue
+		}
+		hashes = append(hashes[:i], hashes[i+1:]...)
+		data, err := utils.Json.Marshal(hashes)
+		if err != nil {
+			return false
+		}
+		if err := singleton.DB.Model(user).Update("recovery_codes", string(data)).Error; err != nil {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// verifyTOTP 校验动态验证码，允许前后各一个时间步的误差
+// 验证通过后记录所在的时间步，不接受该时间步及更早的验证码，防止验证码被重复使用
+func verifyTOTP(user *model.User, code string) bool {
+	if len(code) != _TOTPOpts.Digits.Length() {
+		return false
+	}
+	secret, err := utils.DecryptString(singleton.Conf.JWTSecretKey, user.TOTPSecret)
+	if err != nil {
+		return false
+	}
+
+	now := time.Now().Unix() / int64(_TOTPOpts.Period)
+	for step := now - int64(_TOTPOpts.Skew); step <= now+int64(_TOTPOpts.Skew); step++ {
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*int64(_TOTPOpts.Period), 0), _TOTPOpts)
+		if err != nil {
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+		// 并发请求使用同一验证码时只有一个能更新成功
+		result := singleton.DB.Model(&model.User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return false
+		}
+		user.TOTPLastStep = step
+		return true
+	}
+	return false
+}
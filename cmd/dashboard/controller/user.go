@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 
 	"github.com/nezhahq/nezha/model"
 	"github.com/nezhahq/nezha/service/singleton"
@@ -56,14 +57,17 @@ func updateProfile(c *gin.Context) (any, error) {
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(pf.OriginalPassword)); err != nil {
 		return nil, singleton.Localizer.ErrorT("incorrect password")
 	}
+	if err := singleton.ValidatePassword(pf.NewPassword); err != nil {
+		return nil, err
+	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(pf.NewPassword), bcrypt.DefaultCost)
+	hash, err := singleton.HashPassword(pf.NewPassword)
 	if err != nil {
 		return nil, err
 	}
 
 	user.Username = pf.NewUsername
-	user.Password = string(hash)
+	user.Password = hash
 	if err := singleton.DB.Save(&user).Error; err != nil {
 		return nil, newGormError("%v", err)
 	}
@@ -71,6 +75,54 @@ func updateProfile(c *gin.Context) (any, error) {
 	return nil, nil
 }
 
+// Change password for current user
+// @Summary Change password for current user
+// @Security BearerAuth
+// @Schemes
+// @Description Change password for current user, also required before using other APIs when the account still uses the initial password
+// @Tags auth required
+// @Accept json
+// @param request body model.ChangePasswordForm true "password"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[any]
+// @Router /profile/password [post]
+func changePassword(c *gin.Context) (any, error) {
+	var cf model.ChangePasswordForm
+	if err := c.ShouldBindJSON(&cf); err != nil {
+		return nil, err
+	}
+
+	auth, ok := c.Get(model.CtxKeyAuthorizedUser)
+	if !ok {
+		return nil, singleton.Localizer.ErrorT("unauthorized")
+	}
+
+	user := *auth.(*model.User)
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(cf.OriginalPassword)); err != nil {
+		return nil, singleton.Localizer.ErrorT("incorrect password")
+	}
+	if err := singleton.ValidatePassword(cf.NewPassword); err != nil {
+		return nil, err
+	}
+	if cf.NewPassword == cf.OriginalPassword {
+		return nil, singleton.Localizer.ErrorT("new password can't be the same as the original password")
+	}
+
+	hash, err := singleton.HashPassword(cf.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := singleton.DB.Model(&user).Updates(map[string]any{
+		"password":             hash,
+		"must_change_password": false,
+	}).Error; err != nil {
+		return nil, newGormError("%v", err)
+	}
+
+	return nil, nil
+}
+
 // List user
 // @Summary List user
 // @Security BearerAuth
@@ -96,6 +148,7 @@ func listUser(c *gin.Context) ([]model.User, error) {
 // @Tags auth required
 // @Accept json
 // @param request body model.UserForm true "User Request"
+// @param Idempotency-Key header string false "Return the original result when the key is replayed within 24 hours"
 // @Produce json
 // @Success 200 {object} model.CommonResponse[uint64]
 // @Router /user [post]
@@ -105,8 +158,8 @@ func createUser(c *gin.Context) (uint64, error) {
 		return 0, err
 	}
 
-	if len(uf.Password) < 6 {
-		return 0, singleton.Localizer.ErrorT("password length must be greater than 6")
+	if err := singleton.ValidatePassword(uf.Password); err != nil {
+		return 0, err
 	}
 	if uf.Username == "" {
 		return 0, singleton.Localizer.ErrorT("username can't be empty")
@@ -115,11 +168,11 @@ func createUser(c *gin.Context) (uint64, error) {
 	var u model.User
 	u.Username = uf.Username
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(uf.Password), bcrypt.DefaultCost)
+	hash, err := singleton.HashPassword(uf.Password)
 	if err != nil {
 		return 0, err
 	}
-	u.Password = string(hash)
+	u.Password = hash
 
 	if err := singleton.DB.Create(&u).Error; err != nil {
 		return 0, err
@@ -149,5 +202,17 @@ func batchDeleteUser(c *gin.Context) (any, error) {
 		return nil, singleton.Localizer.ErrorT("can't delete yourself")
 	}
 
-	return nil, singleton.DB.Where("id IN (?)", ids).Delete(&model.User{}).Error
+	err := singleton.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id IN (?)", ids).Delete(&model.User{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&model.Session{}, "user_id IN (?)", ids).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.Oauth2Bind{}, "user_id IN (?)", ids).Error
+	})
+	if err != nil {
+		return nil, newGormError("%v", err)
+	}
+	return nil, nil
 }
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/service/singleton"
+)
+
+const (
+	viewPasswordCookie = "nz-view"
+	viewPasswordExpire = time.Hour
+)
+
+// Verify view password
+// @Summary Verify view password
+// @Schemes
+// @Description Verify the view password, guests with a valid cookie can see servers and services hidden from guests
+// @Tags common
+// @Accept json
+// @param request body model.ViewPasswordForm true "ViewPasswordForm"
+// @Produce json
+// @Success 200 {object} model.CommonResponse[any]
+// @Router /view-password [post]
+func verifyViewPassword(c *gin.Context) (any, error) {
+	var vf model.ViewPasswordForm
+	if err := c.ShouldBindJSON(&vf); err != nil {
+		return nil, err
+	}
+
+	password := singleton.Conf.ViewPassword
+	if password == "" {
+		return nil, singleton.Localizer.ErrorT("view password is not enabled")
+	}
+	if subtle.ConstantTimeCompare([]byte(vf.Password), []byte(password)) != 1 {
+		model.BlockIP(singleton.DB, c.GetString(model.CtxKeyRealIPStr), model.WAFBlockReasonTypeLoginFail)
+		return nil, singleton.Localizer.ErrorT("incorrect password")
+	}
+
+	expire := time.Now().Add(viewPasswordExpire).Unix()
+	value := strconv.FormatInt(expire, 10) + "." + signViewPassword(expire, password)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(viewPasswordCookie, value, int(viewPasswordExpire.Seconds()), "/", "", false, true)
+	return nil, nil
+}
+
+// isViewPasswordVerified 请求是否携带了有效的访问密码 cookie
+func isViewPasswordVerified(c *gin.Context) bool {
+	password := singleton.Conf.ViewPassword
+	if password == "" {
+		return false
+	}
+	value, err := c.Cookie(viewPasswordCookie)
+	if err != nil {
+		return false
+	}
+	expireStr, sign, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	expire, err := strconv.ParseInt(expireStr, 10, 64)
+	if err != nil || time.Now().Unix() > expire {
+		return false
+	}
+	return hmac.Equal([]byte(sign), []byte(signViewPassword(expire, password)))
+}
+
+// signViewPassword 签名中包含访问密码，修改密码后已签发的 cookie 随之失效
+func signViewPassword(expire int64, password string) string {
+	mac := hmac.New(sha256.New, []byte(singleton.Conf.JWTSecretKey))
+	mac.Write([]byte(strconv.FormatInt(expire, 10)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,45 +14,71 @@ import (
 
 	"github.com/nezhahq/nezha/model"
 	"github.com/nezhahq/nezha/pkg/utils"
+	"github.com/nezhahq/nezha/pkg/websocketx"
 	"github.com/nezhahq/nezha/service/singleton"
 )
 
 var upgrader *websocket.Upgrader
 
 func InitUpgrader() {
-	var checkOrigin func(r *http.Request) bool
+	upgrader = &websocket.Upgrader{
+		ReadBufferSize:  32768,
+		WriteBufferSize: 32768,
+		CheckOrigin:     checkOrigin,
+	}
+}
 
-	// Allow CORS from loopback addresses in debug mode
+// checkOrigin 默认只允许同源的 WebSocket 连接，防止跨站劫持已登录的会话
+// 前端部署在其他域名时可在配置的 WebsocketAllowedOrigins 中添加，debug 模式下允许来自回环地址的连接
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// 非浏览器客户端不会发送 Origin
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(singleton.Conf.WebsocketAllowedOrigins, ",") {
+		if allowed = strings.TrimSuffix(strings.TrimSpace(allowed), "/"); allowed != "" && strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
 	if singleton.Conf.Debug {
-		checkOrigin = func(r *http.Request) bool {
-			hostAddr := r.Host
-			host, _, err := net.SplitHostPort(hostAddr)
-			if err != nil {
-				return false
-			}
-			if ip := net.ParseIP(host); ip != nil {
-				if ip.IsLoopback() {
-					return true
-				}
-			} else {
-				// Handle domains like "localhost"
-				ip, err := net.LookupHost(host)
-				if err != nil || len(ip) == 0 {
-					return false
-				}
-				if netIP := net.ParseIP(ip[0]); netIP != nil && netIP.IsLoopback() {
-					return true
-				}
-			}
+		return isLoopbackHost(r.Host)
+	}
+	return false
+}
 
-			return false
-		}
+func isLoopbackHost(hostAddr string) bool {
+	host, _, err := net.SplitHostPort(hostAddr)
+	if err != nil {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	// Handle domains like "localhost"
+	ip, err := net.LookupHost(host)
+	if err != nil || len(ip) == 0 {
+		return false
 	}
+	netIP := net.ParseIP(ip[0])
+	return netIP != nil && netIP.IsLoopback()
+}
 
-	upgrader = &websocket.Upgrader{
-		ReadBufferSize:  32768,
-		WriteBufferSize: 32768,
-		CheckOrigin:     checkOrigin,
+// streamKeepAlive 定时发送 PING 保活，写入超时或连接关闭后退出
+func streamKeepAlive(conn *websocketx.Conn) {
+	interval := time.Duration(singleton.Conf.StreamPingInterval) * time.Second
+	for {
+		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+			return
+		}
+		time.Sleep(interval)
 	}
 }
 
@@ -94,7 +122,7 @@ var requestGroup singleflight.Group
 
 func getServerStat(c *gin.Context, withPublicNote bool) ([]byte, error) {
 	_, isMember := c.Get(model.CtxKeyAuthorizedUser)
-	authorized := isMember // TODO || isViewPasswordVerfied
+	authorized := isMember || isViewPasswordVerified(c)
 	v, err, _ := requestGroup.Do(fmt.Sprintf("serverStats::%t", authorized), func() (interface{}, error) {
 		singleton.SortedServerLock.RLock()
 		defer singleton.SortedServerLock.RUnlock()
@@ -120,7 +148,7 @@ func getServerStat(c *gin.Context, withPublicNote bool) ([]byte, error) {
 				Host:         server.Host,
 				State:        server.State,
 				CountryCode:  countryCode,
-				LastActive:   server.LastActive,
+				LastActive:   server.LastActiveAt(),
 			})
 		}
 
@@ -2,34 +2,47 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"embed"
 	_ "embed"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 	_ "time/tzdata"
 
 	"github.com/ory/graceful"
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 
 	"github.com/nezhahq/nezha/cmd/dashboard/controller"
 	"github.com/nezhahq/nezha/cmd/dashboard/rpc"
 	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/tlsx"
 	"github.com/nezhahq/nezha/proto"
+	rpcService "github.com/nezhahq/nezha/service/rpc"
 	"github.com/nezhahq/nezha/service/singleton"
 )
 
+// certReloadInterval 检查证书文件是否更新的间隔
+const certReloadInterval = 10 * time.Second
+
 type DashboardCliParam struct {
 	Version          bool   // 当前版本号
-	ConfigFile       string // 配置文件路径
-	DatebaseLocation string // Sqlite3 数据库文件路径
+	CheckConfig      bool   // 仅校验配置文件，不启动服务
+	DataDir          string // 数据目录，配置文件、数据库等持久化文件默认存放于此
+	ConfigFile       string // 配置文件路径，默认为数据目录下的 config.yaml
+	DatebaseLocation string // Sqlite3 数据库文件路径，默认为数据目录下的 sqlite.db
 }
 
 var (
@@ -47,13 +60,15 @@ func initSystem() {
 		panic(err)
 	}
 	if usersCount == 0 {
-		hash, err := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
+		hash, err := singleton.HashPassword("admin")
 		if err != nil {
 			panic(err)
 		}
 		admin := model.User{
 			Username: "admin",
-			Password: string(hash),
+			Password: hash,
+			// 初始账户使用默认密码，首次登录后必须修改
+			MustChangePassword: true,
 		}
 		if err := singleton.DB.Create(&admin).Error; err != nil {
 			panic(err)
@@ -63,8 +78,8 @@ func initSystem() {
 	// 启动 singleton 包下的所有服务
 	singleton.LoadSingleton()
 
-	// 每天的3:30 对 监控记录 和 流量记录 进行清理
-	if _, err := singleton.Cron.AddFunc("0 30 3 * * *", singleton.CleanServiceHistory); err != nil {
+	// 按配置的计划（默认每天 3:30）对 监控记录 和 流量记录 进行清理
+	if err := singleton.SetCleanHistorySchedule(singleton.Conf.CleanHistorySchedule); err != nil {
 		panic(err)
 	}
 
@@ -72,6 +87,11 @@ func initSystem() {
 	if _, err := singleton.Cron.AddFunc("0 0 * * * *", singleton.RecordTransferHourlyUsage); err != nil {
 		panic(err)
 	}
+
+	// 每分钟探测内网穿透目标是否可达
+	if _, err := singleton.Cron.AddFunc("0 * * * * *", rpc.DispatchNATProbe); err != nil {
+		panic(err)
+	}
 }
 
 // @title           Nezha Monitoring API
@@ -97,8 +117,10 @@ func initSystem() {
 // @externalDocs.url          https://swagger.io/resources/open-api/
 func main() {
 	flag.BoolVar(&dashboardCliParam.Version, "v", false, "查看当前版本号")
-	flag.StringVar(&dashboardCliParam.ConfigFile, "c", "data/config.yaml", "配置文件路径")
-	flag.StringVar(&dashboardCliParam.DatebaseLocation, "db", "data/sqlite.db", "Sqlite3数据库文件路径")
+	flag.BoolVar(&dashboardCliParam.CheckConfig, "check-config", false, "校验配置文件后退出，配置有误时返回非 0 状态码")
+	flag.StringVar(&dashboardCliParam.DataDir, "data-dir", "data", "数据目录")
+	flag.StringVar(&dashboardCliParam.ConfigFile, "c", "", "配置文件路径（默认为 <data-dir>/config.yaml）")
+	flag.StringVar(&dashboardCliParam.DatebaseLocation, "db", "", "Sqlite3数据库文件路径（默认为 <data-dir>/sqlite.db）")
 	flag.Parse()
 
 	if dashboardCliParam.Version {
@@ -106,13 +128,38 @@ func main() {
 		os.Exit(0)
 	}
 
+	if dashboardCliParam.ConfigFile == "" {
+		dashboardCliParam.ConfigFile = filepath.Join(dashboardCliParam.DataDir, "config.yaml")
+	}
+	if dashboardCliParam.DatebaseLocation == "" {
+		dashboardCliParam.DatebaseLocation = filepath.Join(dashboardCliParam.DataDir, "sqlite.db")
+	}
+
+	if dashboardCliParam.CheckConfig {
+		errs := singleton.CheckConfig(dashboardCliParam.ConfigFile, dashboardCliParam.DatebaseLocation)
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(errs) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %d problem(s) found\n", dashboardCliParam.ConfigFile, len(errs))
+			os.Exit(1)
+		}
+		fmt.Printf("%s: ok\n", dashboardCliParam.ConfigFile)
+		os.Exit(0)
+	}
+
+	if err := os.MkdirAll(dashboardCliParam.DataDir, 0750); err != nil {
+		log.Fatal(err)
+	}
+	singleton.DataDir = dashboardCliParam.DataDir
+
 	// 初始化 dao 包
 	singleton.InitConfigFromPath(dashboardCliParam.ConfigFile)
 	singleton.InitTimezoneAndCache()
 	singleton.InitDBFromPath(dashboardCliParam.DatebaseLocation)
 	initSystem()
 
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", singleton.Conf.ListenPort))
+	l, err := listen()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -128,37 +175,115 @@ func main() {
 	httpHandler := controller.ServeWeb(adminFrontend, userFrontend)
 	controller.InitUpgrader()
 
+	go reloadConfigOnSignal(dashboardCliParam.ConfigFile)
+
 	muxHandler := newHTTPandGRPCMux(httpHandler, grpcHandler)
 	http2Server := &http2.Server{}
-	muxServer := &http.Server{Handler: h2c.NewHandler(muxHandler, http2Server), ReadHeaderTimeout: time.Second * 5}
-
-	go dispatchReportInfoTask()
+	muxServer := &http.Server{ReadHeaderTimeout: time.Second * 5}
+	if muxServer.TLSConfig, err = newTLSConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if muxServer.TLSConfig != nil {
+		// 直接提供 HTTPS，通过 ALPN 协商 h2 与 http/1.1，Agent 的 gRPC 连接同样走 h2
+		muxServer.Handler = muxHandler
+		if err := http2.ConfigureServer(muxServer, http2Server); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		muxServer.Handler = h2c.NewHandler(muxHandler, http2Server)
+	}
 
 	if err := graceful.Graceful(func() error {
-		log.Println("NEZHA>> Dashboard::START", singleton.Conf.ListenPort)
+		slog.Info("Dashboard::START", "addr", l.Addr().String(), "tls", muxServer.TLSConfig != nil)
+		if muxServer.TLSConfig != nil {
+			return muxServer.ServeTLS(l, "", "")
+		}
 		return muxServer.Serve(l)
 	}, func(c context.Context) error {
-		log.Println("NEZHA>> Graceful::START")
+		slog.Info("Graceful::START")
 		singleton.RecordTransferHourlyUsage()
-		log.Println("NEZHA>> Graceful::END")
+		// 关闭仍在进行的终端与文件管理会话，留出时间给 HTTP 服务关闭
+		drainCtx, cancel := context.WithTimeout(c, 3*time.Second)
+		rpcService.NezhaHandlerSingleton.CloseAllStreams(drainCtx)
+		cancel()
+		slog.Info("Graceful::END")
 		return muxServer.Shutdown(c)
 	}); err != nil {
-		log.Printf("NEZHA>> ERROR: %v", err)
+		slog.Error("Dashboard exited with error", "error", err)
+	}
+}
+
+// listen 按 listen_addr 与 listen_port 创建监听
+// Unix 套接字文件已存在且没有其他进程监听时先删除
+func listen() (net.Listener, error) {
+	network, address, err := singleton.Conf.Listen()
+	if err != nil {
+		return nil, fmt.Errorf("listen_addr: %w", err)
+	}
+	if network == "unix" {
+		if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", address); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("listen_addr: %s is in use", address)
+			}
+			if err := os.Remove(address); err != nil {
+				return nil, err
+			}
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	// 与监听 127.0.0.1 一样允许本机任意用户连接，以便以其他用户运行的反向代理访问
+	if network == "unix" {
+		if err := os.Chmod(address, 0666); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// newTLSConfig 返回面板直接提供 HTTPS 时的 TLS 配置，证书来自 tls_cert_file 或通过 ACME 自动申请，均未配置时返回 nil
+func newTLSConfig() (*tls.Config, error) {
+	switch {
+	case singleton.Conf.TLSCertFile != "":
+		certs, err := tlsx.NewCertReloader(singleton.Conf.TLSCertFile, singleton.Conf.TLSKeyFile, certReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}, nil
+	case singleton.Conf.ACMEHosts != "":
+		cacheDir := singleton.Conf.ACMECacheDir
+		if cacheDir == "" {
+			cacheDir = filepath.Join(singleton.DataDir, "acme")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(singleton.Conf.ACMEHostList()...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      singleton.Conf.ACMEEmail,
+		}
+		if singleton.Conf.ACMEDirectoryURL != "" {
+			m.Client = &acme.Client{DirectoryURL: singleton.Conf.ACMEDirectoryURL}
+		}
+		// TLSConfig 的 NextProtos 包含 TLS-ALPN-01 验证使用的 acme-tls/1
+		conf := m.TLSConfig()
+		conf.MinVersion = tls.VersionTLS12
+		return conf, nil
 	}
+	return nil, nil
 }
 
-func dispatchReportInfoTask() {
-	time.Sleep(time.Second * 15)
-	singleton.ServerLock.RLock()
-	defer singleton.ServerLock.RUnlock()
-	for _, server := range singleton.ServerList {
-		if server == nil || server.TaskStream == nil {
-			continue
-		}
-		server.TaskStream.Send(&proto.Task{
-			Type: model.TaskTypeReportHostInfo,
-			Data: "",
-		})
+// reloadConfigOnSignal 收到 SIGHUP 时重新加载配置文件，已建立的 Agent 连接不受影响
+func reloadConfigOnSignal(path string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if err := singleton.ReloadConfig(path); err != nil {
+			slog.Error("failed to reload config", "path", path, "error", err)
+		}
 	}
 }
 
@@ -2,8 +2,9 @@ package rpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/netip"
 	"time"
@@ -64,17 +65,36 @@ func getRealIp(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
 		}
 	}
 
-	if singleton.Conf.Debug {
-		log.Printf("NEZHA>> gRPC Real IP: %s", ip)
-	}
+	slog.Debug("gRPC Real IP", "ip", ip)
 
 	ctx = context.WithValue(ctx, model.CtxKeyRealIP{}, ip)
 	return handler(ctx, req)
 }
 
+// sendServiceTask 下发监控任务，多目标监控的每个目标单独下发
+func sendServiceTask(server *model.Server, task *model.Service) {
+	for _, t := range task.PBs() {
+		if err := server.TaskStream.Send(t); err != nil {
+			slog.Warn("failed to dispatch service task", "service", task.ID, "server", server.ID, "error", err)
+			return
+		}
+	}
+	singleton.MetricServiceTasksDispatched.Inc()
+	slog.Debug("service task dispatched", "service", task.ID, "server", server.ID)
+}
+
 func DispatchTask(serviceSentinelDispatchBus <-chan model.Service) {
 	workedServerIndex := 0
 	for task := range serviceSentinelDispatchBus {
+		// 已暂停的监控不参与轮询派发
+		if task.Paused {
+			continue
+		}
+		// 内容匹配、证书过期以及带认证信息的 HTTP 监控由面板自身执行
+		if task.IsDashboardService() {
+			go runDashboardService(task)
+			continue
+		}
 		round := 0
 		endIndex := workedServerIndex
 		singleton.SortedServerLock.RLock()
@@ -98,12 +118,12 @@ func DispatchTask(serviceSentinelDispatchBus <-chan model.Service) {
 				continue
 			}
 			if task.Cover == model.ServiceCoverIgnoreAll && task.SkipServers[singleton.SortedServerList[workedServerIndex].ID] {
-				singleton.SortedServerList[workedServerIndex].TaskStream.Send(task.PB())
+				sendServiceTask(singleton.SortedServerList[workedServerIndex], &task)
 				workedServerIndex++
 				continue
 			}
 			if task.Cover == model.ServiceCoverAll && !task.SkipServers[singleton.SortedServerList[workedServerIndex].ID] {
-				singleton.SortedServerList[workedServerIndex].TaskStream.Send(task.PB())
+				sendServiceTask(singleton.SortedServerList[workedServerIndex], &task)
 				workedServerIndex++
 				continue
 			}
@@ -116,6 +136,20 @@ func DispatchTask(serviceSentinelDispatchBus <-chan model.Service) {
 	}
 }
 
+// runDashboardService 执行由面板负责的监控并将结果上报给服务监控，上报者为面板本身（ID 为 0）
+// 多目标监控与 Agent 一样逐个目标检测并分别上报
+func runDashboardService(task model.Service) {
+	for _, t := range task.PBs() {
+		endpoint := task
+		endpoint.ID, endpoint.Target = t.Id, t.Data
+		go func() {
+			result := singleton.ProbeService(context.Background(), &endpoint)
+			singleton.ServiceSentinelShared.Dispatch(singleton.ReportData{Data: result})
+			slog.Debug("dashboard service checked", "service", task.ID, "target", endpoint.Target, "type", task.Type, "successful", result.Successful)
+		}()
+	}
+}
+
 func DispatchKeepalive() {
 	singleton.Cron.AddFunc("@every 60s", func() {
 		singleton.SortedServerLock.RLock()
@@ -130,6 +164,67 @@ func DispatchKeepalive() {
 	})
 }
 
+// DispatchNATProbe 探测所有内网穿透目标是否可达
+func DispatchNATProbe() {
+	if rpcService.NezhaHandlerSingleton == nil {
+		return
+	}
+
+	singleton.NATCacheRwLock.RLock()
+	nats := make([]model.NAT, 0, len(singleton.NATList))
+	for _, n := range singleton.NATList {
+		nats = append(nats, *n)
+	}
+	singleton.NATCacheRwLock.RUnlock()
+
+	for _, n := range nats {
+		go func(n model.NAT) {
+			status := model.NATStatus{Online: true}
+			if err := probeNAT(&n); err != nil {
+				status.Online = false
+				status.Error = err.Error()
+			}
+			status.LastCheckedAt = time.Now()
+			singleton.SetNATStatus(n.ID, status)
+		}(n)
+	}
+}
+
+// probeNAT 让 Agent 连接内网穿透目标，Agent 只有在连接目标成功后才会建立 IOStream
+func probeNAT(natConfig *model.NAT) error {
+	singleton.ServerLock.RLock()
+	server := singleton.ServerList[natConfig.ServerID]
+	singleton.ServerLock.RUnlock()
+	if server == nil || server.TaskStream == nil {
+		return errors.New("server not found or not connected")
+	}
+
+	streamId, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+
+	rpcService.NezhaHandlerSingleton.CreateStream(streamId)
+	defer rpcService.NezhaHandlerSingleton.CloseStream(streamId)
+
+	taskData, err := utils.Json.Marshal(model.TaskNAT{
+		StreamID: streamId,
+		Host:     natConfig.Host,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := server.TaskStream.Send(&proto.Task{
+		Type: model.TaskTypeNAT,
+		Data: string(taskData),
+	}); err != nil {
+		return err
+	}
+
+	return rpcService.NezhaHandlerSingleton.WaitAgentConnected(streamId, time.Second*10)
+}
+
 func ServeNAT(w http.ResponseWriter, r *http.Request, natConfig *model.NAT) {
 	singleton.ServerLock.RLock()
 	server := singleton.ServerList[natConfig.ServerID]
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/nezhahq/nezha/pkg/utils"
+)
+
+// AlertFlapState 报警规则在某服务器上的抖动状态，持久化以避免重启后立即重复通知
+type AlertFlapState struct {
+	Common
+	AlertRuleID    uint64      `json:"alert_rule_id" gorm:"uniqueIndex:idx_alert_flap_state"`
+	ServerID       uint64      `json:"server_id" gorm:"uniqueIndex:idx_alert_flap_state"`
+	Flapping       bool        `json:"flapping"`
+	TransitionsRaw string      `json:"-"`
+	Transitions    []time.Time `gorm:"-" json:"transitions"` // 窗口期内的状态翻转时间
+}
+
+func (s *AlertFlapState) BeforeSave(tx *gorm.DB) error {
+	data, err := utils.Json.Marshal(s.Transitions)
+	if err != nil {
+		return err
+	}
+	s.TransitionsRaw = string(data)
+	return nil
+}
+
+func (s *AlertFlapState) AfterFind(tx *gorm.DB) error {
+	if s.TransitionsRaw == "" {
+		return nil
+	}
+	return utils.Json.Unmarshal([]byte(s.TransitionsRaw), &s.Transitions)
+}
+
+// Prune 清理窗口期之外的状态翻转记录
+func (s *AlertFlapState) Prune(now time.Time, window time.Duration) {
+	i := 0
+	for i < len(s.Transitions) && now.Sub(s.Transitions[i]) > window {
+		i++
+	}
+	s.Transitions = s.Transitions[i:]
+}
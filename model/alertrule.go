@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/nezhahq/nezha/pkg/utils"
 	"gorm.io/gorm"
 )
@@ -17,11 +19,23 @@ type AlertRule struct {
 	Enable                 *bool    `json:"enable,omitempty"`
 	TriggerMode            uint8    `gorm:"default:0" json:"trigger_mode"` // 触发模式: 0-始终触发(默认) 1-单次触发
 	NotificationGroupID    uint64   `json:"notification_group_id"`         // 该报警规则所在的通知组
+	ServerGroupID          uint64   `json:"server_group_id"`               // 仅检查该服务器分组内的服务器，0 为不限制
 	FailTriggerTasksRaw    string   `gorm:"default:'[]'" json:"-"`
 	RecoverTriggerTasksRaw string   `gorm:"default:'[]'" json:"-"`
 	Rules                  []Rule   `gorm:"-" json:"rules"`
 	FailTriggerTasks       []uint64 `gorm:"-" json:"fail_trigger_tasks"`    // 失败时执行的触发任务id
 	RecoverTriggerTasks    []uint64 `gorm:"-" json:"recover_trigger_tasks"` // 恢复时执行的触发任务id
+
+	SilenceUntil    *time.Time `json:"silence_until,omitempty"`     // 在此时间之前不发送通知
+	QuietHoursStart string     `json:"quiet_hours_start,omitempty"` // 每日静默开始时间 HH:MM
+	QuietHoursEnd   string     `json:"quiet_hours_end,omitempty"`   // 每日静默结束时间 HH:MM
+
+	FlapThreshold uint64 `json:"flap_threshold,omitempty"` // 窗口期内状态翻转次数超过此值视为抖动，0 为不检测
+	FlapWindow    uint64 `json:"flap_window,omitempty"`    // 抖动检测窗口 (秒)
+
+	EscalationIntervalsRaw        string   `gorm:"default:'[]'" json:"-"`
+	EscalationIntervals           []uint64 `gorm:"-" json:"escalation_intervals,omitempty"`    // 报警持续未恢复时再次通知的间隔 (秒)，依次使用，最后一个间隔重复使用
+	EscalationNotificationGroupID uint64   `json:"escalation_notification_group_id,omitempty"` // 再次通知时使用的通知组，0 为使用 NotificationGroupID
 }
 
 func (r *AlertRule) BeforeSave(tx *gorm.DB) error {
@@ -40,6 +54,11 @@ func (r *AlertRule) BeforeSave(tx *gorm.DB) error {
 	} else {
 		r.RecoverTriggerTasksRaw = string(data)
 	}
+	if data, err := utils.Json.Marshal(r.EscalationIntervals); err != nil {
+		return err
+	} else {
+		r.EscalationIntervalsRaw = string(data)
+	}
 	return nil
 }
 
@@ -54,6 +73,11 @@ func (r *AlertRule) AfterFind(tx *gorm.DB) error {
 	if err = utils.Json.Unmarshal([]byte(r.RecoverTriggerTasksRaw), &r.RecoverTriggerTasks); err != nil {
 		return err
 	}
+	if r.EscalationIntervalsRaw != "" {
+		if err = utils.Json.Unmarshal([]byte(r.EscalationIntervalsRaw), &r.EscalationIntervals); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -61,13 +85,79 @@ func (r *AlertRule) Enabled() bool {
 	return r.Enable != nil && *r.Enable
 }
 
+// FlapDuration 抖动检测窗口，未设置时为 10 分钟
+func (r *AlertRule) FlapDuration() time.Duration {
+	if r.FlapWindow == 0 {
+		return time.Minute * 10
+	}
+	return time.Duration(r.FlapWindow) * time.Second
+}
+
+// EscalationInterval 第 level 次再次通知前需要等待的时间，level 从 0 开始，超出配置时重复使用最后一个间隔
+func (r *AlertRule) EscalationInterval(level int) time.Duration {
+	if len(r.EscalationIntervals) == 0 {
+		return 0
+	}
+	if level >= len(r.EscalationIntervals) {
+		level = len(r.EscalationIntervals) - 1
+	}
+	return time.Duration(r.EscalationIntervals[level]) * time.Second
+}
+
+// EscalationGroupID 再次通知时使用的通知组
+func (r *AlertRule) EscalationGroupID() uint64 {
+	if r.EscalationNotificationGroupID != 0 {
+		return r.EscalationNotificationGroupID
+	}
+	return r.NotificationGroupID
+}
+
+// Silenced 判断当前是否处于静默期，静默期内规则仍会检查但不发送通知
+func (r *AlertRule) Silenced(now time.Time) bool {
+	if r.SilenceUntil != nil && now.Before(*r.SilenceUntil) {
+		return true
+	}
+	if r.QuietHoursStart == "" || r.QuietHoursEnd == "" {
+		return false
+	}
+	start, err := time.Parse("15:04", r.QuietHoursStart)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", r.QuietHoursEnd)
+	if err != nil {
+		return false
+	}
+	cur := now.Hour()*60 + now.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from <= to {
+		return cur >= from && cur < to
+	}
+	// 跨越零点，如 23:00 - 07:00
+	return cur >= from || cur < to
+}
+
 // Snapshot 对传入的Server进行该报警规则下所有type的检查 返回每项检查结果
-func (r *AlertRule) Snapshot(cycleTransferStats *CycleTransferStats, server *Server, db *gorm.DB) []bool {
+func (r *AlertRule) Snapshot(cycleTransferStats *CycleTransferStats, server *Server, db *gorm.DB, nq NetworkQualityStats) []bool {
+	point, _ := r.Evaluate(cycleTransferStats, server, db, nq)
+	return point
+}
+
+// Evaluate 与 Snapshot 相同，另外返回每项规则用于比较的指标值，不适用或暂无数据时为 nil
+func (r *AlertRule) Evaluate(cycleTransferStats *CycleTransferStats, server *Server, db *gorm.DB, nq NetworkQualityStats) ([]bool, []*float64) {
 	point := make([]bool, 0, len(r.Rules))
+	values := make([]*float64, 0, len(r.Rules))
 	for _, rule := range r.Rules {
-		point = append(point, rule.Snapshot(cycleTransferStats, server, db))
+		passed, value, ok := rule.Evaluate(cycleTransferStats, server, db, nq)
+		point = append(point, passed)
+		if ok {
+			values = append(values, &value)
+		} else {
+			values = append(values, nil)
+		}
 	}
-	return point
+	return point, values
 }
 
 // Check 传入包含当前报警规则下所有type检查结果 返回报警持续时间与是否通过报警检查(通过则返回true)
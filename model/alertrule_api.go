@@ -1,11 +1,55 @@
 package model
 
+import "time"
+
 type AlertRuleForm struct {
 	Name                string   `json:"name" minLength:"1"`
 	Rules               []Rule   `json:"rules"`
 	FailTriggerTasks    []uint64 `json:"fail_trigger_tasks"`    // 失败时触发的任务id
 	RecoverTriggerTasks []uint64 `json:"recover_trigger_tasks"` // 恢复时触发的任务id
 	NotificationGroupID uint64   `json:"notification_group_id"`
+	ServerGroupID       uint64   `json:"server_group_id,omitempty" validate:"optional"` // 仅检查该服务器分组内的服务器
 	TriggerMode         uint8    `json:"trigger_mode" default:"0"`
 	Enable              bool     `json:"enable" validate:"optional"`
+
+	SilenceUntil    *time.Time `json:"silence_until,omitempty" validate:"optional"`
+	QuietHoursStart string     `json:"quiet_hours_start,omitempty" validate:"optional"` // HH:MM，使用面板时区
+	QuietHoursEnd   string     `json:"quiet_hours_end,omitempty" validate:"optional"`
+
+	FlapThreshold uint64 `json:"flap_threshold,omitempty" validate:"optional"` // 窗口期内状态翻转次数超过此值视为抖动
+	FlapWindow    uint64 `json:"flap_window,omitempty" validate:"optional"`    // 抖动检测窗口 (秒)，默认 600
+
+	EscalationIntervals           []uint64 `json:"escalation_intervals,omitempty" validate:"optional"`             // 报警持续未恢复时再次通知的间隔 (秒)，如 [300, 900, 3600]
+	EscalationNotificationGroupID uint64   `json:"escalation_notification_group_id,omitempty" validate:"optional"` // 再次通知时使用的通知组
+
+	UpdatedAt *time.Time `json:"updated_at,omitempty" validate:"optional"` // 加载时的 updated_at，用于检测编辑冲突
+}
+
+const (
+	AlertStateUnknown = "unknown" // 尚未检查或服务器不在规则范围内
+	AlertStateOK      = "ok"
+	AlertStateAlarm   = "alarm"
+)
+
+// AlertRuleValue 单项规则最近一次检查的结果
+type AlertRuleValue struct {
+	Type   string   `json:"type"`
+	Value  *float64 `json:"value,omitempty" validate:"optional"` // 与阈值比较的指标值，规则不适用或暂无数据时为空
+	Passed bool     `json:"passed"`
+}
+
+// AlertServerState 报警规则在单台服务器上的当前状态
+type AlertServerState struct {
+	ServerID       uint64           `json:"server_id"`
+	ServerName     string           `json:"server_name"`
+	State          string           `json:"state" enums:"ok,alarm,unknown"`
+	LastTransition *time.Time       `json:"last_transition,omitempty" validate:"optional"` // 最近一次状态变化的时间
+	CheckedAt      *time.Time       `json:"checked_at,omitempty" validate:"optional"`      // 最近一次检查的时间
+	Values         []AlertRuleValue `json:"values,omitempty" validate:"optional"`
+}
+
+type AlertRuleState struct {
+	ID      uint64             `json:"id"`
+	Enabled bool               `json:"enabled"`
+	Servers []AlertServerState `json:"servers"`
 }
@@ -7,6 +7,9 @@ const (
 type LoginRequest struct {
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+	OTP      string `json:"otp,omitempty"` // 启用两步验证后需要填写的动态验证码或恢复码
+
+	Oauth2Ticket string `json:"oauth2_ticket,omitempty"` // OIDC 登录后跳转地址中的 ticket，与 OTP 一起提交时无需用户名与密码
 }
 
 type CommonResponse[T any] struct {
@@ -15,7 +18,21 @@ type CommonResponse[T any] struct {
 	Error   string `json:"error,omitempty"`
 }
 
+type PaginationQuery struct {
+	Limit  int `form:"limit" json:"limit,omitempty"`
+	Offset int `form:"offset" json:"offset,omitempty"`
+}
+
 type LoginResponse struct {
 	Token  string `json:"token,omitempty"`
 	Expire string `json:"expire,omitempty"`
 }
+
+type LogoutResponse struct {
+	RedirectURL string `json:"redirect_url,omitempty"` // 配置了 OIDC 注销地址时返回，前端需跳转到该地址
+}
+
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"` // 各项检查的结果，ok 或错误信息
+}
@@ -0,0 +1,17 @@
+package model
+
+// AuditLog 会员接口中一次修改操作的记录
+type AuditLog struct {
+	Common
+	UserID     uint64 `json:"user_id" gorm:"index"`
+	Username   string `json:"username"`
+	IP         string `json:"ip,omitempty"`
+	Method     string `json:"method"`
+	Route      string `json:"route"`                 // 匹配的路由，如 /api/v1/service/:id
+	EntityType string `json:"entity_type,omitempty"` // 操作的对象类型，如 service、cron
+	EntityID   string `json:"entity_id,omitempty"`   // 路由中的 ID，创建操作为新记录的 ID
+	Changes    string `json:"changes,omitempty"`     // 更新操作为修改前后有差异的字段，其余操作为提交的内容，密码、密钥、地址等敏感字段已脱敏
+	Status     int    `json:"status"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
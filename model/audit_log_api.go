@@ -0,0 +1,8 @@
+package model
+
+type AuditLogQuery struct {
+	PaginationQuery
+	UserID uint64 `form:"user_id" json:"user_id,omitempty"` // 仅列出该用户的操作
+	From   int64  `form:"from" json:"from,omitempty"`       // 毫秒时间戳
+	To     int64  `form:"to" json:"to,omitempty"`           // 毫秒时间戳
+}
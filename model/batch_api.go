@@ -0,0 +1,19 @@
+package model
+
+import "encoding/json"
+
+type BatchRequest struct {
+	Method string          `json:"method,omitempty"`
+	Path   string          `json:"path,omitempty"` // 相对于 /api/v1 的路径，可带查询参数
+	Body   json.RawMessage `json:"body,omitempty" swaggertype:"object" validate:"optional"`
+}
+
+type BatchForm struct {
+	Requests    []BatchRequest `json:"requests,omitempty"`
+	StopOnError bool           `json:"stop_on_error,omitempty" validate:"optional"` // 出错后不再执行剩余请求
+}
+
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty" swaggertype:"object"`
+}
@@ -1,20 +1,35 @@
 package model
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	kyaml "github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v3"
 
 	"github.com/nezhahq/nezha/pkg/utils"
 )
 
+// ConfigListenUnixPrefix ListenAddr 以此开头时监听 Unix 套接字，如 unix:/run/nezha/dashboard.sock
+const ConfigListenUnixPrefix = "unix:"
+
+var hostnameRegex = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
 const (
 	ConfigUsePeerIP = "NZ::Use-Peer-IP"
 	ConfigCoverAll  = iota
@@ -22,39 +37,126 @@ const (
 )
 
 type Config struct {
-	Debug        bool   `mapstructure:"debug" json:"debug,omitempty"`                   // debug模式开关
+	Debug        bool   `mapstructure:"debug" json:"debug"`                             // debug模式开关
+	LogLevel     string `mapstructure:"log_level" json:"log_level,omitempty"`           // 日志级别 debug/info/warn/error，默认 info，debug 模式下默认 debug
 	RealIPHeader string `mapstructure:"real_ip_header" json:"real_ip_header,omitempty"` // 真实IP
 
 	Language       string `mapstructure:"language" json:"language"` // 系统语言，默认 zh_CN
 	SiteName       string `mapstructure:"site_name" json:"site_name"`
-	JWTSecretKey   string `mapstructure:"jwt_secret_key" json:"jwt_secret_key,omitempty"`
+	JWTSecretKey   string `mapstructure:"jwt_secret_key" json:"-"`
 	AgentSecretKey string `mapstructure:"agent_secret_key" json:"agent_secret_key,omitempty"`
 	ListenPort     uint   `mapstructure:"listen_port" json:"listen_port,omitempty"`
+	ListenAddr     string `mapstructure:"listen_addr" json:"listen_addr,omitempty"` // 监听地址，留空监听所有网卡；以 unix: 开头时监听 Unix 套接字，忽略 ListenPort
 	InstallHost    string `mapstructure:"install_host" json:"install_host,omitempty"`
-	TLS            bool   `mapstructure:"tls" json:"tls,omitempty"`
-	Location       string `mapstructure:"location" json:"location,omitempty"` // 时区，默认为 Asia/Shanghai
+	TLS            bool   `mapstructure:"tls" json:"tls"`
+	TLSCertFile    string `mapstructure:"tls_cert_file" json:"tls_cert_file,omitempty"` // 证书文件，与 TLSKeyFile 同时设置时面板直接提供 HTTPS，文件更新后自动重新加载
+	TLSKeyFile     string `mapstructure:"tls_key_file" json:"tls_key_file,omitempty"`
+	// 通过 ACME 自动申请与续期证书的域名，多个用逗号分隔，不能与 TLSCertFile 同时使用
+	// 使用 TLS-ALPN-01 验证，面板需要能通过这些域名的 443 端口直接访问
+	ACMEHosts        string `mapstructure:"acme_hosts" json:"acme_hosts,omitempty"`
+	ACMEEmail        string `mapstructure:"acme_email" json:"acme_email,omitempty"`                 // 证书到期等通知的联系邮箱，可留空
+	ACMECacheDir     string `mapstructure:"acme_cache_dir" json:"acme_cache_dir,omitempty"`         // 保存账户与证书的目录，默认为数据目录下的 acme
+	ACMEDirectoryURL string `mapstructure:"acme_directory_url" json:"acme_directory_url,omitempty"` // ACME 服务地址，默认为 Let's Encrypt
+	Location         string `mapstructure:"location" json:"location,omitempty"`                     // 时区，默认为 Asia/Shanghai
 
-	EnablePlainIPInNotification bool `mapstructure:"enable_plain_ip_in_notification" json:"enable_plain_ip_in_notification,omitempty"` // 通知信息IP不打码
+	DatabaseDriver string `mapstructure:"database_driver" json:"database_driver,omitempty"` // 数据库类型 sqlite/postgres，未配置时根据 DSN 推断，未配置 DSN 时始终使用 sqlite
+	DatabaseDSN    string `mapstructure:"database_dsn" json:"-"`                            // 如 host=localhost user=nezha password=xxx dbname=nezha port=5432
+
+	EnablePlainIPInNotification bool `mapstructure:"enable_plain_ip_in_notification" json:"enable_plain_ip_in_notification"` // 通知信息IP不打码
 
 	// IP变更提醒
-	EnableIPChangeNotification  bool   `mapstructure:"enable_ip_change_notification" json:"enable_ip_change_notification,omitempty"`
+	EnableIPChangeNotification  bool   `mapstructure:"enable_ip_change_notification" json:"enable_ip_change_notification"`
 	IPChangeNotificationGroupID uint64 `mapstructure:"ip_change_notification_group_id" json:"ip_change_notification_group_id"`
 	Cover                       uint8  `mapstructure:"cover" json:"cover"`                                               // 覆盖范围（0:提醒未被 IgnoredIPNotification 包含的所有服务器; 1:仅提醒被 IgnoredIPNotification 包含的服务器;）
-	IgnoredIPNotification       string `mapstructure:"ignored_ip_notification" json:"ignored_ip_notification,omitempty"` // 特定服务器IP（多个服务器用逗号分隔）
+	IgnoredIPNotification       string `mapstructure:"ignored_ip_notification" json:"ignored_ip_notification,omitempty"` // 特定服务器（服务器 ID、IP 或 CIDR，多个用逗号分隔）
+	// 新 IP 连续上报多少次后才发送变更通知，默认 1
+	IPChangeNotificationConfirmations int `mapstructure:"ip_change_notification_confirmations" json:"ip_change_notification_confirmations,omitempty"`
+
+	// 服务器上下线提醒，Agent 断开超过 ServerOfflineThreshold 秒未重连时通知下线，重连后通知上线
+	EnableServerStateNotification  bool   `mapstructure:"enable_server_state_notification" json:"enable_server_state_notification"`
+	ServerStateNotificationGroupID uint64 `mapstructure:"server_state_notification_group_id" json:"server_state_notification_group_id"`
+	ServerOfflineThreshold         int    `mapstructure:"server_offline_threshold" json:"server_offline_threshold,omitempty"` // 单位秒，默认 60
+
+	// 服务器流量配额的通知阈值（百分比），周期内每个阈值只通知一次，默认 80、90、100
+	TransferQuotaThresholds []int `mapstructure:"transfer_quota_thresholds" json:"transfer_quota_thresholds,omitempty"`
+
+	// 同时发送的通知请求数上限，避免大量告警同时触发时耗尽连接或触发通知服务的限流，默认 10，修改后需重启
+	NotificationMaxConcurrency int `mapstructure:"notification_max_concurrency" json:"notification_max_concurrency,omitempty"`
 
 	IgnoredIPNotificationServerIDs map[uint64]bool `mapstructure:"ignored_ip_notification_server_ids" json:"ignored_ip_notification_server_ids,omitempty"` // [ServerID] -> bool(值为true代表当前ServerID在特定服务器列表内）
 	AvgPingCount                   int             `mapstructure:"avg_ping_count" json:"avg_ping_count,omitempty"`
 	DNSServers                     string          `mapstructure:"dns_servers" json:"dns_servers,omitempty"`
 
+	// 历史数据清理
+	CleanHistorySchedule         string `mapstructure:"clean_history_schedule" json:"clean_history_schedule,omitempty"`                   // 清理任务的 cron 表达式，默认每天 3:30
+	ServiceHistoryRetentionDays  int    `mapstructure:"service_history_retention_days" json:"service_history_retention_days,omitempty"`   // 服务监控记录保留天数，默认 30
+	TransferHistoryRetentionDays int    `mapstructure:"transfer_history_retention_days" json:"transfer_history_retention_days,omitempty"` // 流量记录保留天数，默认 30
+	AuditLogRetentionDays        int    `mapstructure:"audit_log_retention_days" json:"audit_log_retention_days,omitempty"`               // 操作日志保留天数，默认 180
+
+	// 终端与文件管理连接的 PING 间隔，以及等待 Agent 与浏览器双方建立连接的超时，单位为秒，默认均为 10
+	StreamPingInterval   int `mapstructure:"stream_ping_interval" json:"stream_ping_interval,omitempty"`
+	StreamConnectTimeout int `mapstructure:"stream_connect_timeout" json:"stream_connect_timeout,omitempty"`
+
+	// 服务状态页结果的缓存时间，单位为秒，默认 3，负数为不缓存
+	ServiceStatsCacheTTL int `mapstructure:"service_stats_cache_ttl" json:"service_stats_cache_ttl,omitempty"`
+
+	WebsocketAllowedOrigins string `mapstructure:"websocket_allowed_origins" json:"websocket_allowed_origins,omitempty"` // 允许的 WebSocket 来源，如 https://status.example.com，多个用逗号分隔，默认仅允许同源
+
+	MetricsToken string `mapstructure:"metrics_token" json:"-"` // /metrics 的 Bearer Token，留空则不校验
+	ViewPassword string `mapstructure:"view_password" json:"-"` // 访客访问密码，验证后可查看对访客隐藏的服务器与服务
+
+	// 维护模式，开启期间不发送任何通知，MaintenanceUntil 为自动结束的 Unix 时间戳，0 为不自动结束
+	MaintenanceMode  bool  `mapstructure:"maintenance_mode" json:"maintenance_mode,omitempty"`
+	MaintenanceUntil int64 `mapstructure:"maintenance_until" json:"maintenance_until,omitempty"`
+
+	// 登录等敏感接口的限流，单位为每分钟请求数，默认 IP 20 次、用户 10 次，负数为不限制
+	AuthRateLimitPerIP   int `mapstructure:"auth_rate_limit_per_ip" json:"auth_rate_limit_per_ip,omitempty"`
+	AuthRateLimitPerUser int `mapstructure:"auth_rate_limit_per_user" json:"auth_rate_limit_per_user,omitempty"`
+
+	// 密码哈希的 bcrypt cost，默认 10，修改后用户下次登录时自动按新的 cost 重新计算
+	BcryptCost int `mapstructure:"bcrypt_cost" json:"bcrypt_cost,omitempty"`
+	// 密码策略，适用于创建用户与修改密码：最短长度默认 8，至少包含小写字母、大写字母、数字、符号中的几类，默认 2
+	PasswordMinLength      int `mapstructure:"password_min_length" json:"password_min_length,omitempty"`
+	PasswordMinCharClasses int `mapstructure:"password_min_char_classes" json:"password_min_char_classes,omitempty"`
+
 	CustomCode          string `mapstructure:"custom_code" json:"custom_code,omitempty"`
 	CustomCodeDashboard string `mapstructure:"custom_code_dashboard" json:"custom_code_dashboard,omitempty"`
 
+	Oauth2 *Oauth2Config `mapstructure:"oauth2" json:"oauth2,omitempty"` // OIDC 登录
+
 	k        *koanf.Koanf `json:"-"`
 	filePath string       `json:"-"`
+
+	ignoredIPNotificationPrefixes []netip.Prefix // 由 IgnoredIPNotification 解析出的网段
 }
 
-// Read 读取配置文件并应用
-func (c *Config) Read(path string) error {
+type Oauth2Config struct {
+	Issuer         string   `mapstructure:"issuer" json:"issuer,omitempty"`
+	ClientID       string   `mapstructure:"client_id" json:"client_id,omitempty"`
+	ClientSecret   string   `mapstructure:"client_secret" json:"-"`
+	RedirectURL    string   `mapstructure:"redirect_url" json:"redirect_url,omitempty"` // 回调地址，如 https://example.com/api/v1/oauth2/callback
+	Scopes         []string `mapstructure:"scopes" json:"scopes,omitempty"`
+	UserClaim      string   `mapstructure:"user_claim" json:"user_claim,omitempty"`   // 自动创建用户时作为用户名的 claim，默认 preferred_username
+	AutoCreateUser bool     `mapstructure:"auto_create_user" json:"auto_create_user"` // 首次登录时自动创建用户
+	OidcLogoutURL  string   `mapstructure:"oidc_logout_url" json:"oidc_logout_url,omitempty"`
+}
+
+func (c *Oauth2Config) Enabled() bool {
+	return c != nil && c.Issuer != "" && c.ClientID != ""
+}
+
+// ParseLogLevel 解析日志级别，为空时返回 info
+func ParseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if level == "" {
+		return slog.LevelInfo, nil
+	}
+	err := l.UnmarshalText([]byte(level))
+	return l, err
+}
+
+// Load 读取配置文件并填充默认值，与 Read 不同，不会生成密钥或写入配置文件
+func (c *Config) Load(path string) error {
 	c.k = koanf.New(".")
 	c.filePath = path
 
@@ -86,9 +188,79 @@ func (c *Config) Read(path string) error {
 	if c.Location == "" {
 		c.Location = "Asia/Shanghai"
 	}
+	if c.ServerOfflineThreshold == 0 {
+		c.ServerOfflineThreshold = 60
+	}
 	if c.AvgPingCount == 0 {
 		c.AvgPingCount = 2
 	}
+	if c.CleanHistorySchedule == "" {
+		c.CleanHistorySchedule = "0 30 3 * * *"
+	}
+	if c.ServiceHistoryRetentionDays < 1 {
+		c.ServiceHistoryRetentionDays = 30
+	}
+	if c.TransferHistoryRetentionDays < 1 {
+		c.TransferHistoryRetentionDays = 30
+	}
+	if c.AuditLogRetentionDays < 1 {
+		c.AuditLogRetentionDays = 180
+	}
+	if c.IPChangeNotificationConfirmations < 1 {
+		c.IPChangeNotificationConfirmations = 1
+	}
+	if c.StreamPingInterval == 0 {
+		c.StreamPingInterval = 10
+	}
+	if c.StreamConnectTimeout == 0 {
+		c.StreamConnectTimeout = 10
+	}
+	if c.StreamPingInterval < 1 || c.StreamConnectTimeout < 1 {
+		return errors.New("stream_ping_interval and stream_connect_timeout must be at least 1 second")
+	}
+	if c.ServiceStatsCacheTTL == 0 {
+		c.ServiceStatsCacheTTL = 3
+	}
+	if len(c.TransferQuotaThresholds) == 0 {
+		c.TransferQuotaThresholds = []int{80, 90, 100}
+	}
+	if err := ValidateTransferQuotaThresholds(c.TransferQuotaThresholds); err != nil {
+		return err
+	}
+	slices.Sort(c.TransferQuotaThresholds)
+	if c.NotificationMaxConcurrency < 1 {
+		c.NotificationMaxConcurrency = 10
+	}
+	if c.AuthRateLimitPerIP == 0 {
+		c.AuthRateLimitPerIP = 20
+	}
+	if c.AuthRateLimitPerUser == 0 {
+		c.AuthRateLimitPerUser = 10
+	}
+	if c.BcryptCost == 0 {
+		c.BcryptCost = bcrypt.DefaultCost
+	}
+	if c.PasswordMinLength == 0 {
+		c.PasswordMinLength = 8
+	}
+	if c.PasswordMinCharClasses == 0 {
+		c.PasswordMinCharClasses = 2
+	}
+	if _, err := ParseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	c.updateIgnoredIPNotificationID()
+	return nil
+}
+
+// Read 读取配置文件并应用
+func (c *Config) Read(path string) error {
+	err := c.Load(path)
+	if err != nil {
+		return err
+	}
+
 	if c.JWTSecretKey == "" {
 		c.JWTSecretKey, err = utils.GenerateRandomString(1024)
 		if err != nil {
@@ -109,20 +281,167 @@ func (c *Config) Read(path string) error {
 		}
 	}
 
-	c.updateIgnoredIPNotificationID()
 	return nil
 }
 
-// updateIgnoredIPNotificationID 更新用于判断服务器ID是否属于特定服务器的map
+// ValidateTransferQuotaThresholds 检查流量配额的通知阈值均在 1 到 100 之间
+func ValidateTransferQuotaThresholds(thresholds []int) error {
+	for _, t := range thresholds {
+		if t < 1 || t > 100 {
+			return fmt.Errorf("transfer_quota_thresholds: %d is not between 1 and 100", t)
+		}
+	}
+	return nil
+}
+
+// UnknownConfigKeys 返回配置文件中无法对应到任何配置项的键，通常是拼写错误
+func UnknownConfigKeys(path string) ([]string, error) {
+	k := koanf.New(".")
+	if err := k.Load(file.Provider(path), kyaml.Parser()); err != nil {
+		return nil, err
+	}
+	var (
+		c  Config
+		md mapstructure.Metadata
+	)
+	err := k.UnmarshalWithConf("", &c, koanf.UnmarshalConf{
+		DecoderConfig: &mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.TextUnmarshallerHookFunc()),
+			Metadata:         &md,
+			Result:           &c,
+			WeaklyTypedInput: true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(md.Unused)
+	return md.Unused, nil
+}
+
+// updateIgnoredIPNotificationID 更新用于判断服务器ID是否属于特定服务器的map，以及按 IP 匹配的网段
 func (c *Config) updateIgnoredIPNotificationID() {
 	c.IgnoredIPNotificationServerIDs = make(map[uint64]bool)
+	c.ignoredIPNotificationPrefixes = nil
 	splitedIDs := strings.Split(c.IgnoredIPNotification, ",")
 	for i := 0; i < len(splitedIDs); i++ {
-		id, _ := strconv.ParseUint(splitedIDs[i], 10, 64)
-		if id > 0 {
-			c.IgnoredIPNotificationServerIDs[id] = true
+		entry := strings.TrimSpace(splitedIDs[i])
+		if id, err := strconv.ParseUint(entry, 10, 64); err == nil {
+			if id > 0 {
+				c.IgnoredIPNotificationServerIDs[id] = true
+			}
+			continue
+		}
+		if prefix, err := ParseIPOrPrefix(entry); err == nil {
+			c.ignoredIPNotificationPrefixes = append(c.ignoredIPNotificationPrefixes, prefix)
+		}
+	}
+}
+
+// InIgnoredIPNotification 服务器是否属于 IgnoredIPNotification 中的特定服务器，按服务器 ID 或上报的 IP 所在网段匹配
+func (c *Config) InIgnoredIPNotification(serverID uint64, ips ...string) bool {
+	if c.IgnoredIPNotificationServerIDs[serverID] {
+		return true
+	}
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		for _, prefix := range c.ignoredIPNotificationPrefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseIPOrPrefix 解析 CIDR，单个 IP 视为只包含自身的网段
+func ParseIPOrPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// IsValidHostname 判断是否为合法的主机名
+func IsValidHostname(host string) bool {
+	return len(host) <= 253 && hostnameRegex.MatchString(host)
+}
+
+// ACMEHostList 返回需要自动申请证书的域名
+func (c *Config) ACMEHostList() []string {
+	var hosts []string
+	for _, host := range strings.Split(c.ACMEHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, strings.ToLower(host))
 		}
 	}
+	return hosts
+}
+
+// DBDriver 返回实际使用的数据库类型，未配置 database_driver 时根据 DSN 推断
+func (c *Config) DBDriver() string {
+	if c.DatabaseDSN == "" {
+		return "sqlite"
+	}
+	if c.DatabaseDriver != "" {
+		return c.DatabaseDriver
+	}
+	if isPostgresDSN(c.DatabaseDSN) {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+// isPostgresDSN 判断是否为 PostgreSQL 的 URL 或 key=value 形式的连接串
+func isPostgresDSN(dsn string) bool {
+	dsn = strings.ToLower(strings.TrimSpace(dsn))
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return true
+	}
+	for _, field := range strings.Fields(dsn) {
+		key, _, ok := strings.Cut(field, "=")
+		if ok && (key == "host" || key == "dbname" || key == "user") {
+			return true
+		}
+	}
+	return false
+}
+
+// Listen 返回 net.Listen 使用的网络类型与地址
+func (c *Config) Listen() (network, address string, err error) {
+	if path, ok := strings.CutPrefix(c.ListenAddr, ConfigListenUnixPrefix); ok {
+		if path == "" {
+			return "", "", errors.New("unix socket path is empty")
+		}
+		return "unix", path, nil
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(c.ListenAddr, "["), "]")
+	if host != "" {
+		if _, err := netip.ParseAddr(host); err != nil && !IsValidHostname(host) {
+			return "", "", fmt.Errorf("%s is not a valid IP address or host name", c.ListenAddr)
+		}
+	}
+	return "tcp", net.JoinHostPort(host, strconv.FormatUint(uint64(c.ListenPort), 10)), nil
+}
+
+// InMaintenance 判断当前是否处于维护模式
+func (c *Config) InMaintenance(now time.Time) bool {
+	return c.MaintenanceMode && (c.MaintenanceUntil == 0 || now.Unix() < c.MaintenanceUntil)
 }
 
 // Save 保存配置文件
@@ -138,5 +457,10 @@ func (c *Config) Save() error {
 		return err
 	}
 
-	return os.WriteFile(c.filePath, data, 0600)
+	// 先写入临时文件再重命名，避免写入失败时损坏原配置文件
+	tmp := c.filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.filePath)
 }
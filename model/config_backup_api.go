@@ -0,0 +1,58 @@
+package model
+
+import "time"
+
+type ConfigExportQuery struct {
+	ExcludeSecrets bool `form:"exclude_secrets" json:"exclude_secrets,omitempty"` // 清空 DDNS 密钥与 Webhook、通知的地址、请求体、请求头、渠道 Token 与签名密钥、服务监控的请求头等敏感信息
+}
+
+// ConfigBackup 可在面板之间迁移的配置，导入时会重新分配 ID 并修正相互引用
+type ConfigBackup struct {
+	Version    string    `json:"version,omitempty"`
+	ExportedAt time.Time `json:"exported_at,omitempty"`
+
+	Servers                        []ServerBackup                  `json:"servers,omitempty"` // 仅用于导入时按 UUID 对应服务器，不会导入服务器本身
+	Notifications                  []NotificationBackup            `json:"notifications,omitempty"`
+	NotificationGroups             []NotificationGroup             `json:"notification_groups,omitempty"`
+	NotificationGroupNotifications []NotificationGroupNotification `json:"notification_group_notifications,omitempty"`
+	Crons                          []Cron                          `json:"crons,omitempty"`
+	Services                       []ServiceBackup                 `json:"services,omitempty"`
+	AlertRules                     []AlertRule                     `json:"alert_rules,omitempty"`
+	DDNSProfiles                   []DDNSProfile                   `json:"ddns_profiles,omitempty"`
+	NATs                           []NAT                           `json:"nats,omitempty"`
+}
+
+// ServerBackup 导出时服务器 ID 与 UUID 的对应关系，导入时引用的服务器 ID 替换为 UUID 相同的服务器
+type ServerBackup struct {
+	ID   uint64 `json:"id"`
+	UUID string `json:"uuid"`
+	Name string `json:"name,omitempty"`
+}
+
+// NotificationBackup 导出的通知方式，附带 API 不返回的签名密钥
+type NotificationBackup struct {
+	Notification
+	SecretKey string `json:"secret_key,omitempty"`
+}
+
+// ServiceBackup 导出的服务监控，附带 API 不返回的请求头
+type ServiceBackup struct {
+	Service
+	HTTPHeaders map[string]string `json:"http_headers,omitempty"`
+}
+
+// ConfigImportStat 某一类配置的导入结果，Created 为新建后的 ID，Skipped 为导入文件中因冲突而跳过的 ID
+type ConfigImportStat struct {
+	Created []uint64 `json:"created,omitempty" validate:"optional"`
+	Skipped []uint64 `json:"skipped,omitempty" validate:"optional"`
+}
+
+type ConfigImportResponse struct {
+	Notifications      ConfigImportStat `json:"notifications"`
+	NotificationGroups ConfigImportStat `json:"notification_groups"`
+	Crons              ConfigImportStat `json:"crons"`
+	Services           ConfigImportStat `json:"services"`
+	AlertRules         ConfigImportStat `json:"alert_rules"`
+	DDNSProfiles       ConfigImportStat `json:"ddns_profiles"`
+	NATs               ConfigImportStat `json:"nats"`
+}
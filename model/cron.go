@@ -28,11 +28,25 @@ type Cron struct {
 	LastExecutedAt      time.Time `json:"last_executed_at,omitempty"` // 最后一次执行时间
 	LastResult          bool      `json:"last_result,omitempty"`      // 最后一次执行结果
 	Cover               uint8     `json:"cover"`                      // 计划任务覆盖范围 (0:仅覆盖特定服务器 1:仅忽略特定服务器 2:由触发该计划任务的服务器执行)
+	MaxRetries          uint64    `json:"max_retries,omitempty"`      // 下发失败时的最大重试次数
+	RetryInterval       uint64    `json:"retry_interval,omitempty"`   // 首次重试间隔（秒），之后每次翻倍
+	Timeout             uint64    `json:"timeout,omitempty"`          // 执行超时时间（秒），随任务下发给 Agent，0 为不限制
+	SkipIfRunning       bool      `json:"skip_if_running,omitempty"`  // 上一次执行尚未结束时跳过本次执行
+	Timezone            string    `json:"timezone,omitempty"`         // 计划任务使用的时区，为空时使用全局时区
+	Paused              bool      `json:"paused,omitempty"`           // 暂停后不再按计划或被报警触发执行，仍可手动执行
 
 	CronJobID  cron.EntryID `gorm:"-" json:"cron_job_id,omitempty"`
 	ServersRaw string       `json:"-"`
 }
 
+// ScheduleSpec 返回注册到调度器的表达式，设置了时区时附加 CRON_TZ 前缀
+func (c *Cron) ScheduleSpec() string {
+	if c.Timezone == "" {
+		return c.Scheduler
+	}
+	return "CRON_TZ=" + c.Timezone + " " + c.Scheduler
+}
+
 func (c *Cron) BeforeSave(tx *gorm.DB) error {
 	if data, err := utils.Json.Marshal(c.Servers); err != nil {
 		return err
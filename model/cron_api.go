@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type CronForm struct {
 	TaskType            uint8    `json:"task_type,omitempty" default:"0"` // 0:计划任务 1:触发任务
 	Name                string   `json:"name,omitempty" minLength:"1"`
@@ -9,4 +11,37 @@ type CronForm struct {
 	Cover               uint8    `json:"cover,omitempty" default:"0"`
 	PushSuccessful      bool     `json:"push_successful,omitempty" validate:"optional"`
 	NotificationGroupID uint64   `json:"notification_group_id,omitempty"`
+	MaxRetries          uint64   `json:"max_retries,omitempty" validate:"optional"`
+	RetryInterval       uint64   `json:"retry_interval,omitempty" validate:"optional"`
+	Timeout             uint64   `json:"timeout,omitempty" validate:"optional"`
+	SkipIfRunning       bool     `json:"skip_if_running,omitempty" validate:"optional"`
+	Timezone            string   `json:"timezone,omitempty" validate:"optional"` // IANA 时区名，如 Asia/Shanghai
+	Paused              bool     `json:"paused,omitempty" validate:"optional"`
+
+	UpdatedAt *time.Time `json:"updated_at,omitempty" validate:"optional"` // 加载时的 updated_at，用于检测编辑冲突
+}
+
+type CronTriggerQuery struct {
+	Live bool `form:"live"` // 实时回传命令输出，通过 /ws/cron-run/{run_id} 查看
+}
+
+type CronTriggerResponse struct {
+	Success  []uint64 `json:"success,omitempty" validate:"optional"`
+	Failure  []uint64 `json:"failure,omitempty" validate:"optional"`
+	Offline  []uint64 `json:"offline,omitempty" validate:"optional"`
+	RunID    string   `json:"run_id,omitempty" validate:"optional"`   // live 为 true 时返回，用于连接输出流
+	Streamed []uint64 `json:"streamed,omitempty" validate:"optional"` // live 为 true 时返回创建了输出流的服务器，其余服务器按普通命令执行
+}
+
+const (
+	CronRunFrameOutput = "output" // 命令输出
+	CronRunFrameEnd    = "end"    // 该服务器的输出已结束
+	CronRunFrameError  = "error"  // 未能获取输出或 Agent 中途断开，该服务器不会再有输出
+)
+
+// CronRunFrame 计划任务实时输出中的一条消息
+type CronRunFrame struct {
+	ServerID uint64 `json:"server_id"`
+	Type     string `json:"type"`
+	Data     string `json:"data,omitempty"`
 }
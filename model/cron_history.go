@@ -0,0 +1,95 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/nezhahq/nezha/pkg/utils"
+)
+
+const (
+	CronResultStatusRunning  = "running" // 已下发，等待 Agent 上报结果
+	CronResultStatusSuccess  = "success" // 执行成功
+	CronResultStatusFailure  = "failure" // 执行失败或下发失败
+	CronResultStatusOffline  = "offline" // 服务器离线，未能下发
+	CronResultStatusTimeout  = "timeout" // 超过 Timeout 仍未上报结果
+	CronResultStatusUnknown  = "unknown" // 未设置 Timeout 且长时间未上报结果，或同一服务器已开始新的执行
+	CronHistoryOutputMaxSize = 16 * 1024 // 每台服务器保存的输出最大字节数
+)
+
+// CronHistoryResult 计划任务在单台服务器上的执行结果
+type CronHistoryResult struct {
+	ServerID   uint64    `json:"server_id"`
+	Status     string    `json:"status"`
+	Output     string    `json:"output,omitempty"`
+	Delay      float32   `json:"delay,omitempty"` // 执行耗时（秒），由 Agent 上报
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// CronHistory 计划任务的一次执行记录
+type CronHistory struct {
+	Common
+	CronID     uint64              `json:"cron_id" gorm:"index"`
+	Manual     bool                `json:"manual,omitempty"`           // 是否为手动执行
+	Finished   bool                `json:"finished,omitempty"`         // 所有服务器均已得出结果
+	Successful bool                `json:"successful,omitempty"`       // 所有服务器均执行成功
+	FinishedAt time.Time           `json:"finished_at,omitempty"`      // 所有服务器得出结果的时间
+	Results    []CronHistoryResult `gorm:"-" json:"results,omitempty"` // 各服务器的执行结果
+
+	ResultsRaw string `json:"-"`
+}
+
+func (h *CronHistory) BeforeSave(tx *gorm.DB) error {
+	if data, err := utils.Json.Marshal(h.Results); err != nil {
+		return err
+	} else {
+		h.ResultsRaw = string(data)
+	}
+	return nil
+}
+
+func (h *CronHistory) AfterFind(tx *gorm.DB) error {
+	return utils.Json.Unmarshal([]byte(h.ResultsRaw), &h.Results)
+}
+
+// SetResult 更新指定服务器的执行结果，并在所有服务器都得出结果后标记本次执行完成
+func (h *CronHistory) SetResult(serverID uint64, status, output string, delay float32) {
+	if len(output) > CronHistoryOutputMaxSize {
+		output = output[:CronHistoryOutputMaxSize]
+	}
+	now := time.Now()
+	found := false
+	for i := range h.Results {
+		if h.Results[i].ServerID != serverID {
+			continue
+		}
+		found = true
+		h.Results[i].Status = status
+		h.Results[i].Output = output
+		h.Results[i].Delay = delay
+		if status != CronResultStatusRunning {
+			h.Results[i].FinishedAt = now
+		}
+	}
+	if !found {
+		r := CronHistoryResult{ServerID: serverID, Status: status, Output: output, Delay: delay}
+		if status != CronResultStatusRunning {
+			r.FinishedAt = now
+		}
+		h.Results = append(h.Results, r)
+	}
+
+	h.Finished, h.Successful = true, true
+	for _, r := range h.Results {
+		if r.Status == CronResultStatusRunning {
+			h.Finished = false
+		}
+		if r.Status != CronResultStatusSuccess {
+			h.Successful = false
+		}
+	}
+	if h.Finished {
+		h.FinishedAt = now
+	}
+}
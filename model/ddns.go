@@ -34,6 +34,25 @@ type DDNSProfile struct {
 	DomainsRaw         string   `json:"-"`
 }
 
+const (
+	DDNSRecordStatusSuccess          = "success"
+	DDNSRecordStatusFailed           = "failed"            // 提供者返回错误，仍会继续重试
+	DDNSRecordStatusRetriesExhausted = "retries_exhausted" // 达到最大重试次数后放弃
+)
+
+// DDNSRecord DDNS 更新记录
+type DDNSRecord struct {
+	Common
+	DDNSProfileID uint64 `json:"ddns_profile_id" gorm:"column:ddns_profile_id;index"`
+	ServerID      uint64 `json:"server_id"`
+	Provider      string `json:"provider"`
+	Domain        string `json:"domain"`
+	OldIP         string `json:"old_ip,omitempty"`
+	NewIP         string `json:"new_ip"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+}
+
 func (d DDNSProfile) TableName() string {
 	return "ddns"
 }
@@ -15,3 +15,24 @@ type DDNSForm struct {
 	WebhookRequestBody string   `json:"webhook_request_body,omitempty" validate:"optional"`
 	WebhookHeaders     string   `json:"webhook_headers,omitempty" validate:"optional"`
 }
+
+const (
+	DDNSDryRunActionCreate = "create"
+	DDNSDryRunActionUpdate = "update"
+	DDNSDryRunActionNoop   = "noop"
+)
+
+type DDNSDryRunAction struct {
+	Domain        string   `json:"domain"`
+	RecordType    string   `json:"record_type"`
+	CurrentValues []string `json:"current_values,omitempty"`
+	NewValue      string   `json:"new_value"`
+	Action        string   `json:"action"`
+}
+
+type DDNSDryRunResult struct {
+	ServerID   uint64             `json:"server_id"`
+	ServerName string             `json:"server_name"`
+	Actions    []DDNSDryRunAction `json:"actions,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+type CleanHistoryResponse struct {
+	Deleted    int64 `json:"deleted"`     // 删除的记录数
+	SizeBefore int64 `json:"size_before"` // 清理前数据库大小（字节）
+	SizeAfter  int64 `json:"size_after"`  // 清理后数据库大小（字节）
+}
+
+type MaintenanceModeForm struct {
+	Enabled  bool   `json:"enabled"`
+	Duration uint64 `json:"duration,omitempty" validate:"optional"` // 维护模式持续的秒数，到期后自动结束，0 为不自动结束
+}
+
+type MaintenanceModeResponse struct {
+	Enabled bool       `json:"enabled"`
+	Until   *time.Time `json:"until,omitempty" validate:"optional"`
+}
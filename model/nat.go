@@ -1,9 +1,20 @@
 package model
 
+import "time"
+
 type NAT struct {
 	Common
 	Name     string `json:"name"`
 	ServerID uint64 `json:"server_id"`
 	Host     string `json:"host"`
 	Domain   string `json:"domain" gorm:"unique"`
+
+	Status NATStatus `json:"status" gorm:"-"`
+}
+
+// NATStatus 内网穿透目标的探测结果，仅保存在内存中
+type NATStatus struct {
+	Online        bool      `json:"online"`
+	LastCheckedAt time.Time `json:"last_checked_at"`
+	Error         string    `json:"error,omitempty"`
 }
@@ -1,12 +1,18 @@
 package model
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"text/template"
+	"text/template/parse"
 	"time"
 
 	"github.com/nezhahq/nezha/pkg/utils"
@@ -24,12 +30,38 @@ const (
 	NotificationRequestMethodPOST
 )
 
+// 预设的通知渠道，只需填写 Token 或 Webhook 地址，请求方式与请求体由预设生成
+const (
+	NotificationProviderCustom   = ""
+	NotificationProviderTelegram = "telegram"
+	NotificationProviderDiscord  = "discord"
+	NotificationProviderSlack    = "slack"
+)
+
 type NotificationServerBundle struct {
 	Notification *Notification
 	Server       *Server
+	Service      *Service
 	Loc          *time.Location
 }
 
+// NotificationTemplateData 通知模板中可引用的变量，如 {{.Server.Name}}、{{.Service.Target}}
+type NotificationTemplateData struct {
+	Message  string
+	Datetime string
+	Server   *NotificationTemplateServer
+	Service  *Service
+}
+
+// NotificationTemplateServer 模板中的服务器，访问 Host/State/GeoIP 时不会遇到空指针
+type NotificationTemplateServer struct {
+	*Server
+	Host       *Host
+	State      *HostState
+	GeoIP      *GeoIP
+	LastActive time.Time // 最后一次上报状态或主机信息的时间，见 Server.LastActiveAt
+}
+
 type Notification struct {
 	Common
 	Name          string `json:"name"`
@@ -39,13 +71,66 @@ type Notification struct {
 	RequestHeader string `json:"request_header" gorm:"type:longtext"`
 	RequestBody   string `json:"request_body" gorm:"type:longtext"`
 	VerifyTLS     *bool  `json:"verify_tls,omitempty"`
+	SecretKey     string `json:"-"`                   // 用于计算 X-Nezha-Signature 请求头的 HMAC 密钥，不通过 API 返回
+	Timeout       uint64 `json:"timeout,omitempty"`   // 请求超时时间（秒），0 为默认 10 分钟
+	ProxyURL      string `json:"proxy_url,omitempty"` // 发送通知使用的代理，如 http://127.0.0.1:7890、socks5://127.0.0.1:1080
+
+	// 预设渠道，为空时按上面的 URL、请求方式与请求体发送
+	// Telegram 使用 ProviderToken 与 ProviderChatID，URL 可留空或填写自建的 Bot API 地址；Discord 与 Slack 的 URL 为 Webhook 地址
+	Provider       string `json:"provider,omitempty"`
+	ProviderToken  string `json:"provider_token,omitempty"`
+	ProviderChatID string `json:"provider_chat_id,omitempty"`
+}
+
+// withPreset 按预设渠道生成实际发送的请求，自定义通知原样返回
+func (n *Notification) withPreset() (*Notification, error) {
+	preset := *n
+	var body map[string]string
+	switch n.Provider {
+	case NotificationProviderCustom:
+		return n, nil
+	case NotificationProviderTelegram:
+		if n.ProviderToken == "" || n.ProviderChatID == "" {
+			return nil, errors.New("Telegram 需要填写 Bot Token 与 chat_id")
+		}
+		base := strings.TrimSuffix(n.URL, "/")
+		if base == "" {
+			base = "https://api.telegram.org"
+		}
+		preset.URL = base + "/bot" + n.ProviderToken + "/sendMessage"
+		body = map[string]string{"chat_id": n.ProviderChatID, "text": "#NEZHA#"}
+	case NotificationProviderDiscord:
+		body = map[string]string{"content": "#NEZHA#"}
+	case NotificationProviderSlack:
+		body = map[string]string{"text": "#NEZHA#"}
+	default:
+		return nil, fmt.Errorf("不支持的通知渠道: %s", n.Provider)
+	}
+	if u, err := url.Parse(preset.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s 的 Webhook 地址无效: %s", n.Provider, preset.URL)
+	}
+
+	data, err := utils.Json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	preset.RequestMethod = NotificationRequestMethodPOST
+	preset.RequestType = NotificationRequestTypeJSON
+	preset.RequestBody = string(data)
+	return &preset, nil
 }
 
 func (ns *NotificationServerBundle) reqURL(message string) string {
 	n := ns.Notification
-	return ns.replaceParamsInString(n.URL, message, func(msg string) string {
+	mod := func(msg string) string {
 		return url.QueryEscape(msg)
-	})
+	}
+	str, err := ns.renderTemplate(n.URL, message, mod)
+	if err != nil {
+		log.Println("NEZHA>> NotificationServerBundle.reqURL:", err)
+		str = n.URL
+	}
+	return ns.replaceParamsInString(str, message, mod)
 }
 
 func (n *Notification) reqMethod() (string, error) {
@@ -65,24 +150,60 @@ func (ns *NotificationServerBundle) reqBody(message string) (string, error) {
 	}
 	switch n.RequestType {
 	case NotificationRequestTypeJSON:
-		return ns.replaceParamsInString(n.RequestBody, message, func(msg string) string {
+		mod := func(msg string) string {
 			msgBytes, _ := utils.Json.Marshal(msg)
 			return string(msgBytes)[1 : len(msgBytes)-1]
-		}), nil
+		}
+		body, err := ns.renderTemplate(n.RequestBody, message, mod)
+		if err != nil {
+			return "", err
+		}
+		return ns.replaceParamsInString(body, message, mod), nil
 	case NotificationRequestTypeForm:
-		data, err := utils.GjsonParseStringMap(n.RequestBody)
+		data, err := parseFormBody(n.RequestBody)
 		if err != nil {
 			return "", err
 		}
 		params := url.Values{}
-		for k, v := range data {
-			params.Add(k, ns.replaceParamsInString(v, message, nil))
+		for k, vs := range data {
+			for _, v := range vs {
+				v, err = ns.renderTemplate(v, message, nil)
+				if err != nil {
+					return "", err
+				}
+				params.Add(k, ns.replaceParamsInString(v, message, nil))
+			}
 		}
 		return params.Encode(), nil
 	}
 	return "", errors.New("不支持的请求类型")
 }
 
+// parseFormBody 解析表单类型的请求体，支持 JSON 对象或 key=value&key2=value2 两种写法
+func parseFormBody(body string) (url.Values, error) {
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "{") {
+		data, err := utils.GjsonParseStringMap(body)
+		if err != nil {
+			return nil, err
+		}
+		values := url.Values{}
+		for k, v := range data {
+			values.Add(k, v)
+		}
+		return values, nil
+	}
+	for _, pair := range strings.Split(body, "&") {
+		if pair == "" {
+			continue
+		}
+		if k, _, ok := strings.Cut(pair, "="); !ok || k == "" {
+			return nil, fmt.Errorf("表单请求体格式错误: %s", pair)
+		}
+	}
+	return url.ParseQuery(body)
+}
+
 func (n *Notification) setContentType(req *http.Request) {
 	if n.RequestMethod == NotificationRequestMethodGET {
 		return
@@ -108,52 +229,198 @@ func (n *Notification) setRequestHeader(req *http.Request) error {
 	return nil
 }
 
+// setSignature 使用 SecretKey 对请求体计算 HMAC-SHA256 并写入 X-Nezha-Signature 请求头
+func (n *Notification) setSignature(req *http.Request, body string) {
+	if n.SecretKey == "" {
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(n.SecretKey))
+	mac.Write([]byte(body))
+	req.Header.Set("X-Nezha-Signature", hex.EncodeToString(mac.Sum(nil)))
+}
+
 func (ns *NotificationServerBundle) Send(message string) error {
-	var client *http.Client
-	n := ns.Notification
-	if n.VerifyTLS != nil && *n.VerifyTLS {
-		client = utils.HttpClient
-	} else {
-		client = utils.HttpClientSkipTlsVerify
+	statusCode, body, err := ns.SendWithResponse(message)
+	if err != nil {
+		return err
+	}
+	if statusCode < 200 || statusCode > 299 {
+		return fmt.Errorf("%d@%s %s", statusCode, http.StatusText(statusCode), body)
+	}
+	return nil
+}
+
+// SendWithResponse 发送通知并返回目标服务的响应状态码与响应体（最多读取 64KB）
+func (ns *NotificationServerBundle) SendWithResponse(message string) (int, string, error) {
+	n, err := ns.Notification.withPreset()
+	if err != nil {
+		return 0, "", err
+	}
+	bundle := *ns
+	bundle.Notification = n
+	ns = &bundle
+
+	client, err := utils.NewHTTPClient(n.VerifyTLS != nil && *n.VerifyTLS, n.ProxyURL, time.Duration(n.Timeout)*time.Second)
+	if err != nil {
+		return 0, "", err
 	}
 
 	reqBody, err := ns.reqBody(message)
 	if err != nil {
-		return err
+		return 0, "", err
 	}
 
 	reqMethod, err := n.reqMethod()
 	if err != nil {
-		return err
+		return 0, "", err
 	}
 
 	req, err := http.NewRequest(reqMethod, ns.reqURL(message), strings.NewReader(reqBody))
 	if err != nil {
-		return err
+		return 0, "", err
 	}
 
 	n.setContentType(req)
 
 	if err := n.setRequestHeader(req); err != nil {
-		return err
+		return 0, "", err
 	}
 
+	n.setSignature(req, reqBody)
+
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return 0, "", err
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("%d@%s %s", resp.StatusCode, resp.Status, string(body))
-	} else {
-		_, _ = io.Copy(io.Discard, resp.Body)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, string(body), nil
+}
+
+// ValidateTemplate 校验 URL 与请求体中的模板语法及引用的变量
+func (n *Notification) ValidateTemplate() error {
+	n, err := n.withPreset()
+	if err != nil {
+		return err
+	}
+	ns := NotificationServerBundle{
+		Notification: n,
+		Server:       &Server{Host: &Host{}, State: &HostState{}, GeoIP: &GeoIP{}},
+		Service:      &Service{},
+		Loc:          time.Local,
 	}
+	if _, err := ns.renderTemplate(n.URL, "", url.QueryEscape); err != nil {
+		return err
+	}
+	if n.RequestMethod == NotificationRequestMethodGET {
+		return nil
+	}
+	if n.RequestType == NotificationRequestTypeForm {
+		data, err := parseFormBody(n.RequestBody)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return errors.New("表单请求体至少需要一个 key=value")
+		}
+	}
+	_, err = ns.reqBody("validate")
+	return err
+}
 
-	return nil
+// renderTemplate 使用 text/template 渲染字符串，每个输出的值都会经过 mod 转义
+func (ns *NotificationServerBundle) renderTemplate(str string, message string, mod func(string) string) (string, error) {
+	if !strings.Contains(str, "{{") {
+		return str, nil
+	}
+	if mod == nil {
+		mod = func(s string) string {
+			return s
+		}
+	}
+
+	tmpl, err := template.New("notification").Funcs(template.FuncMap{
+		"escape": func(v any) string {
+			return mod(fmt.Sprint(v))
+		},
+	}).Parse(str)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			escapeTemplateActions(t.Tree, t.Tree.Root)
+		}
+	}
+
+	data := NotificationTemplateData{
+		Message:  message,
+		Datetime: time.Now().In(ns.Loc).String(),
+		Server:   templateServer(ns.Server),
+		Service:  ns.Service,
+	}
+	if data.Service == nil {
+		data.Service = &Service{}
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// escapeTemplateActions 在模板每个输出动作的管道末尾追加 escape
+func escapeTemplateActions(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeTemplateActions(tree, child)
+		}
+	case *parse.ActionNode:
+		// 变量声明不产生输出
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		cmd := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: n.Pos}
+		cmd.Args = append(cmd.Args, parse.NewIdentifier("escape").SetTree(tree).SetPos(n.Pos))
+		n.Pipe.Cmds = append(n.Pipe.Cmds, cmd)
+	case *parse.IfNode:
+		escapeTemplateActions(tree, n.List)
+		escapeTemplateActions(tree, n.ElseList)
+	case *parse.RangeNode:
+		escapeTemplateActions(tree, n.List)
+		escapeTemplateActions(tree, n.ElseList)
+	case *parse.WithNode:
+		escapeTemplateActions(tree, n.List)
+		escapeTemplateActions(tree, n.ElseList)
+	}
+}
+
+// templateServer 包装服务器供模板使用，不复制 Server 本身
+func templateServer(s *Server) *NotificationTemplateServer {
+	if s == nil {
+		s = &Server{}
+	}
+	ts := &NotificationTemplateServer{Server: s, Host: s.Host, State: s.State, GeoIP: s.GeoIP, LastActive: s.LastActiveAt()}
+	if ts.Host == nil {
+		ts.Host = &Host{}
+	}
+	if ts.State == nil {
+		ts.State = &HostState{}
+	}
+	if ts.GeoIP == nil {
+		ts.GeoIP = &GeoIP{}
+	}
+	return ts
 }
 
 // replaceParamInString 替换字符串中的占位符
@@ -1,12 +1,29 @@
 package model
 
+import "time"
+
 type NotificationForm struct {
-	Name          string `json:"name,omitempty" minLength:"1"`
-	URL           string `json:"url,omitempty"`
-	RequestMethod uint8  `json:"request_method,omitempty"`
-	RequestType   uint8  `json:"request_type,omitempty"`
-	RequestHeader string `json:"request_header,omitempty"`
-	RequestBody   string `json:"request_body,omitempty"`
-	VerifyTLS     bool   `json:"verify_tls,omitempty" validate:"optional"`
-	SkipCheck     bool   `json:"skip_check,omitempty" validate:"optional"`
+	Name           string `json:"name,omitempty" minLength:"1"`
+	URL            string `json:"url,omitempty"`
+	RequestMethod  uint8  `json:"request_method,omitempty"`
+	RequestType    uint8  `json:"request_type,omitempty"` // 1: JSON 2: 表单（请求体为 JSON 对象或 key=value&key2=value2）
+	RequestHeader  string `json:"request_header,omitempty"`
+	RequestBody    string `json:"request_body,omitempty"`
+	VerifyTLS      bool   `json:"verify_tls,omitempty" validate:"optional"`
+	SecretKey      string `json:"secret_key,omitempty" validate:"optional"`       // 留空时更新不会覆盖已保存的密钥
+	ClearSecretKey bool   `json:"clear_secret_key,omitempty" validate:"optional"` // 清除已保存的密钥，同时填写 SecretKey 时以新密钥为准
+	Timeout        uint64 `json:"timeout,omitempty" validate:"optional"`          // 请求超时时间（秒），0 为默认
+	ProxyURL       string `json:"proxy_url,omitempty" validate:"optional"`        // http/https/socks5 代理地址
+	SkipCheck      bool   `json:"skip_check,omitempty" validate:"optional"`
+
+	Provider       string `json:"provider,omitempty" validate:"optional"`         // telegram/discord/slack，为空时使用自定义请求
+	ProviderToken  string `json:"provider_token,omitempty" validate:"optional"`   // Telegram Bot Token
+	ProviderChatID string `json:"provider_chat_id,omitempty" validate:"optional"` // Telegram chat_id
+
+	UpdatedAt *time.Time `json:"updated_at,omitempty" validate:"optional"` // 加载时的 updated_at，用于检测编辑冲突
+}
+
+type NotificationTestResponse struct {
+	StatusCode int    `json:"status_code"`
+	Body       string `json:"body"`
 }
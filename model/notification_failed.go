@@ -0,0 +1,12 @@
+package model
+
+// FailedNotification 多次重试后仍发送失败的通知，供管理员排查与重放
+type FailedNotification struct {
+	Common
+	NotificationID      uint64 `json:"notification_id"`
+	NotificationGroupID uint64 `json:"notification_group_id"`
+	ServerID            uint64 `json:"server_id,omitempty"`
+	ServiceID           uint64 `json:"service_id,omitempty"`
+	Message             string `json:"message" gorm:"type:longtext"`
+	Error               string `json:"error" gorm:"type:longtext"`
+}
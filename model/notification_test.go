@@ -74,7 +74,6 @@ func execCase(t *testing.T, item testSt) {
 			},
 			CountryCode: "",
 		},
-		LastActive:              time.Time{},
 		TaskClose:               nil,
 		TaskStream:              nil,
 		PrevTransferInSnapshot:  0,
@@ -205,9 +204,106 @@ func TestNotification(t *testing.T) {
 			expectContentType: reqTypeForm,
 			expectBody:        "%23NEZHA%23=" + msg + "&Server=ServerName&ServerIP=1.1.1.1&ServerSWAP=8888",
 		},
+		{
+			url:               "https://example.com/?m=#NEZHA#",
+			body:              `msg=#NEZHA#&server={{.Server.Name}}&tag=a&tag=b`,
+			reqMethod:         NotificationRequestMethodPOST,
+			reqType:           NotificationRequestTypeForm,
+			expectURL:         "https://example.com/?m=" + msg,
+			expectMethod:      http.MethodPost,
+			expectContentType: reqTypeForm,
+			expectBody:        "msg=" + msg + "&server=ServerName&tag=a&tag=b",
+		},
+		{
+			url:               "https://example.com/?m={{.Message}}&s={{.Server.Name}} x",
+			body:              `{"msg":"{{.Message}}","Server":"{{.Server.Name}}","ServerSWAP":{{.Server.State.SwapUsed}}}`,
+			reqMethod:         NotificationRequestMethodPOST,
+			reqType:           NotificationRequestTypeJSON,
+			expectURL:         "https://example.com/?m=" + msg + "&s=ServerName x",
+			expectMethod:      http.MethodPost,
+			expectContentType: reqTypeJSON,
+			expectBody:        `{"msg":"msg","Server":"ServerName","ServerSWAP":8888}`,
+		},
 	}
 
 	for _, c := range cases {
 		execCase(t, c)
 	}
 }
+
+func TestNotificationValidateTemplate(t *testing.T) {
+	n := Notification{
+		URL:           "https://example.com/?m={{.Message}}",
+		RequestMethod: NotificationRequestMethodPOST,
+		RequestType:   NotificationRequestTypeJSON,
+		RequestBody:   `{"target":"{{.Service.Target}}","name":"{{.Server.Name}}"}`,
+	}
+	if err := n.ValidateTemplate(); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	n.RequestBody = `{"name":"{{.Server.Name}"}`
+	if err := n.ValidateTemplate(); err == nil {
+		t.Fatalf("Expected syntax error")
+	}
+
+	n.RequestBody = `{"name":"{{.Server.NoSuchField}}"}`
+	if err := n.ValidateTemplate(); err == nil {
+		t.Fatalf("Expected unknown field error")
+	}
+
+	n.RequestType = NotificationRequestTypeForm
+	for _, body := range []string{`{"name":"{{.Server.Name}}"}`, `name={{.Server.Name}}&msg={{.Message}}`} {
+		n.RequestBody = body
+		if err := n.ValidateTemplate(); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+	for _, body := range []string{"", "=value", "a=%zz", `["name"]`} {
+		n.RequestBody = body
+		if err := n.ValidateTemplate(); err == nil {
+			t.Fatalf("Expected invalid form body error: %q", body)
+		}
+	}
+}
+
+func TestNotificationPreset(t *testing.T) {
+	n := Notification{
+		Provider:       NotificationProviderTelegram,
+		ProviderToken:  "123:abc",
+		ProviderChatID: "-100",
+	}
+	preset, err := n.withPreset()
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	ns := NotificationServerBundle{Notification: preset, Loc: time.Local}
+	if url := ns.reqURL(msg); url != "https://api.telegram.org/bot123:abc/sendMessage" {
+		t.Fatalf("Expected telegram url, but got %s", url)
+	}
+	body, err := ns.reqBody(`a "quoted" msg`)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if body != `{"chat_id":"-100","text":"a \"quoted\" msg"}` {
+		t.Fatalf("Unexpected body %s", body)
+	}
+
+	n = Notification{Provider: NotificationProviderSlack, URL: "https://hooks.slack.com/services/x"}
+	if preset, err = n.withPreset(); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if preset.RequestMethod != NotificationRequestMethodPOST || preset.RequestBody != `{"text":"#NEZHA#"}` {
+		t.Fatalf("Unexpected slack preset %+v", preset)
+	}
+
+	for _, n := range []Notification{
+		{Provider: NotificationProviderTelegram, ProviderToken: "123:abc"},
+		{Provider: NotificationProviderDiscord, URL: "discord.com/api/webhooks/x"},
+		{Provider: "unknown", URL: "https://example.com"},
+	} {
+		if err := n.ValidateTemplate(); err == nil {
+			t.Fatalf("Expected invalid preset error: %+v", n)
+		}
+	}
+}
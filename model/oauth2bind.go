@@ -0,0 +1,13 @@
+package model
+
+// Oauth2Bind OIDC 账号与面板用户的绑定关系
+type Oauth2Bind struct {
+	Common
+	UserID  uint64 `json:"user_id" gorm:"index"`
+	Issuer  string `json:"issuer" gorm:"uniqueIndex:idx_oauth2_bind"`
+	Subject string `json:"subject" gorm:"uniqueIndex:idx_oauth2_bind"`
+}
+
+type Oauth2BindResponse struct {
+	RedirectURL string `json:"redirect_url"` // 跳转到该地址完成绑定
+}
@@ -19,10 +19,17 @@ type NResult struct {
 	N uint64
 }
 
+// NetworkQualityStats 提供服务监控（ICMP/TCP）的丢包率与抖动统计
+type NetworkQualityStats interface {
+	// NetworkQuality 返回指定监控在某服务器上最近 window 个采样点的丢包率（百分比）与抖动（毫秒）
+	NetworkQuality(serviceID, serverID uint64, window int) (loss, jitter float64, ok bool)
+}
+
 type Rule struct {
 	// 指标类型，cpu、memory、swap、disk、net_in_speed、net_out_speed
 	// net_all_speed、transfer_in、transfer_out、transfer_all、offline
 	// transfer_in_cycle、transfer_out_cycle、transfer_all_cycle
+	// packet_loss、jitter
 	Type          string          `json:"type"`
 	Min           float64         `json:"min,omitempty" validate:"optional"`                                                        // 最小阈值 (百分比、字节 kb ÷ 1024)
 	Max           float64         `json:"max,omitempty" validate:"optional"`                                                        // 最大阈值 (百分比、字节 kb ÷ 1024)
@@ -32,6 +39,8 @@ type Rule struct {
 	Duration      uint64          `json:"duration,omitempty" validate:"optional"`                                                   // 持续时间 (秒)
 	Cover         uint64          `json:"cover"`                                                                                    // 覆盖范围 RuleCoverAll/IgnoreAll
 	Ignore        map[uint64]bool `json:"ignore,omitempty" validate:"optional"`                                                     // 覆盖范围的排除
+	ServiceID     uint64          `json:"service_id,omitempty" validate:"optional"`                                                 // 网络质量规则关联的服务监控
+	SampleWindow  uint64          `json:"sample_window,omitempty" validate:"optional"`                                              // 网络质量规则统计的采样点数量
 
 	// 只作为缓存使用，记录下次该检测的时间
 	NextTransferAt  map[uint64]time.Time `json:"-"`
@@ -46,19 +55,28 @@ func percentage(used, total uint64) float64 {
 }
 
 // Snapshot 未通过规则返回 false, 通过返回 true
-func (u *Rule) Snapshot(cycleTransferStats *CycleTransferStats, server *Server, db *gorm.DB) bool {
+func (u *Rule) Snapshot(cycleTransferStats *CycleTransferStats, server *Server, db *gorm.DB, nq NetworkQualityStats) bool {
+	passed, _, _ := u.Evaluate(cycleTransferStats, server, db, nq)
+	return passed
+}
+
+// Evaluate 检查服务器是否满足该规则，同时返回与阈值比较的指标值，ok 为 false 时该规则不适用于此服务器或暂无数据
+func (u *Rule) Evaluate(cycleTransferStats *CycleTransferStats, server *Server, db *gorm.DB, nq NetworkQualityStats) (passed bool, value float64, ok bool) {
 	// 监控全部但是排除了此服务器
 	if u.Cover == RuleCoverAll && u.Ignore[server.ID] {
-		return true
+		return true, 0, false
 	}
 	// 忽略全部但是指定监控了此服务器
 	if u.Cover == RuleCoverIgnoreAll && !u.Ignore[server.ID] {
-		return true
+		return true, 0, false
 	}
 
 	// 循环区间流量检测 · 短期无需重复检测
 	if u.IsTransferDurationRule() && u.NextTransferAt[server.ID].After(time.Now()) {
-		return u.LastCycleStatus[server.ID]
+		if cycleTransferStats != nil {
+			value = float64(cycleTransferStats.Transfer[server.ID])
+		}
+		return u.LastCycleStatus[server.ID], value, cycleTransferStats != nil
 	}
 
 	var src float64
@@ -87,30 +105,30 @@ func (u *Rule) Snapshot(cycleTransferStats *CycleTransferStats, server *Server,
 	case "transfer_all":
 		src = float64(server.State.NetOutTransfer + server.State.NetInTransfer)
 	case "offline":
-		if server.LastActive.IsZero() {
+		if lastActive := server.LastActiveAt(); lastActive.IsZero() {
 			src = 0
 		} else {
-			src = float64(server.LastActive.Unix())
+			src = float64(lastActive.Unix())
 		}
 	case "transfer_in_cycle":
 		src = float64(utils.Uint64SubInt64(server.State.NetInTransfer, server.PrevTransferInSnapshot))
 		if u.CycleInterval != 0 {
 			var res NResult
-			db.Model(&Transfer{}).Select("SUM(`in`) AS n").Where("datetime(`created_at`) >= datetime(?) AND server_id = ?", u.GetTransferDurationStart().UTC(), server.ID).Scan(&res)
+			db.Model(&Transfer{}).Select("SUM("+QuoteColumn(db, "in")+") AS n").Where(DateTimeExpr(db, "created_at")+" >= "+DateTimeExpr(db, "?")+" AND server_id = ?", u.GetTransferDurationStart().UTC(), server.ID).Scan(&res)
 			src += float64(res.N)
 		}
 	case "transfer_out_cycle":
 		src = float64(utils.Uint64SubInt64(server.State.NetOutTransfer, server.PrevTransferOutSnapshot))
 		if u.CycleInterval != 0 {
 			var res NResult
-			db.Model(&Transfer{}).Select("SUM(`out`) AS n").Where("datetime(`created_at`) >= datetime(?) AND server_id = ?", u.GetTransferDurationStart().UTC(), server.ID).Scan(&res)
+			db.Model(&Transfer{}).Select("SUM("+QuoteColumn(db, "out")+") AS n").Where(DateTimeExpr(db, "created_at")+" >= "+DateTimeExpr(db, "?")+" AND server_id = ?", u.GetTransferDurationStart().UTC(), server.ID).Scan(&res)
 			src += float64(res.N)
 		}
 	case "transfer_all_cycle":
 		src = float64(utils.Uint64SubInt64(server.State.NetOutTransfer, server.PrevTransferOutSnapshot) + utils.Uint64SubInt64(server.State.NetInTransfer, server.PrevTransferInSnapshot))
 		if u.CycleInterval != 0 {
 			var res NResult
-			db.Model(&Transfer{}).Select("SUM(`in`+`out`) AS n").Where("datetime(`created_at`) >= datetime(?) AND server_id = ?", u.GetTransferDurationStart().UTC(), server.ID).Scan(&res)
+			db.Model(&Transfer{}).Select("SUM("+QuoteColumn(db, "in")+"+"+QuoteColumn(db, "out")+") AS n").Where(DateTimeExpr(db, "created_at")+" >= "+DateTimeExpr(db, "?")+" AND server_id = ?", u.GetTransferDurationStart().UTC(), server.ID).Scan(&res)
 			src += float64(res.N)
 		}
 	case "load1":
@@ -125,6 +143,20 @@ func (u *Rule) Snapshot(cycleTransferStats *CycleTransferStats, server *Server,
 		src = float64(server.State.UdpConnCount)
 	case "process_count":
 		src = float64(server.State.ProcessCount)
+	case "packet_loss", "jitter":
+		if nq == nil {
+			return true, 0, false
+		}
+		loss, jitter, ok := nq.NetworkQuality(u.ServiceID, server.ID, int(u.SampleWindow))
+		if !ok {
+			// 此服务器没有该监控的采样数据
+			return true, 0, false
+		}
+		if u.Type == "packet_loss" {
+			src = loss
+		} else {
+			src = jitter
+		}
 	case "temperature_max":
 		var temp []float64
 		if server.State.Temperatures != nil {
@@ -166,12 +198,17 @@ func (u *Rule) Snapshot(cycleTransferStats *CycleTransferStats, server *Server,
 	}
 
 	if u.Type == "offline" && float64(time.Now().Unix())-src > 6 {
-		return false
+		return false, src, true
 	} else if (u.Max > 0 && src > u.Max) || (u.Min > 0 && src < u.Min) {
-		return false
+		return false, src, true
 	}
 
-	return true
+	return true, src, true
+}
+
+// IsNetworkQualityRule 判断该规则是否属于网络质量（丢包、抖动）规则
+func (u *Rule) IsNetworkQualityRule() bool {
+	return u.Type == "packet_loss" || u.Type == "jitter"
 }
 
 // IsTransferDurationRule 判断该规则是否属于周期流量规则 属于则返回true
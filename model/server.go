@@ -1,10 +1,13 @@
 package model
 
 import (
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jinzhu/copier"
 	"gorm.io/gorm"
 
 	"github.com/nezhahq/nezha/pkg/utils"
@@ -25,10 +28,24 @@ type Server struct {
 
 	DDNSProfiles []uint64 `gorm:"-" json:"ddns_profiles,omitempty" validate:"optional"` // DDNS配置
 
-	Host       *Host      `gorm:"-" json:"host,omitempty"`
-	State      *HostState `gorm:"-" json:"state,omitempty"`
-	GeoIP      *GeoIP     `gorm:"-" json:"geoip,omitempty"`
-	LastActive time.Time  `gorm:"-" json:"last_active,omitempty"`
+	MetadataRaw string            `gorm:"default:'{}'" json:"-"`
+	Metadata    map[string]string `gorm:"-" json:"metadata,omitempty" validate:"optional"` // 管理员可见的自定义键值信息，如到期时间、联系人
+
+	MuteUntil *time.Time `json:"mute_until,omitempty" validate:"optional"` // 在此之前不发送该服务器的报警通知，报警规则仍正常检查
+
+	// 流量配额，周期内入站与出站流量之和达到配额的各个百分比阈值时发送通知，0 为不限制
+	TransferQuota                    uint64     `json:"transfer_quota,omitempty" validate:"optional"`
+	CycleStart                       *time.Time `json:"cycle_start,omitempty" validate:"optional"`                                                 // 流量配额周期的开始时间
+	CycleInterval                    uint64     `json:"cycle_interval,omitempty" validate:"optional"`                                              // 流量配额周期
+	CycleUnit                        string     `json:"cycle_unit,omitempty" enums:"hour,day,week,month,year" validate:"optional" default:"month"` // 流量配额周期单位
+	TransferQuotaNotificationGroupID uint64     `json:"transfer_quota_notification_group_id,omitempty" validate:"optional"`
+
+	Host   *Host      `gorm:"-" json:"host,omitempty"`
+	State  *HostState `gorm:"-" json:"state,omitempty"`
+	GeoIP  *GeoIP     `gorm:"-" json:"geoip,omitempty"`
+	Online bool       `gorm:"-" json:"online"` // Agent 是否已连接，仅在服务器列表接口中填充
+
+	lastActive atomic.Int64 // 最后一次上报状态或主机信息的时间 (UnixNano)，见 Touch 与 LastActiveAt
 
 	TaskClose     chan error                        `gorm:"-" json:"-"`
 	TaskCloseLock *sync.Mutex                       `gorm:"-" json:"-"`
@@ -36,18 +53,117 @@ type Server struct {
 
 	PrevTransferInSnapshot  int64 `gorm:"-" json:"-"` // 上次数据点时的入站使用量
 	PrevTransferOutSnapshot int64 `gorm:"-" json:"-"` // 上次数据点时的出站使用量
+
+	StableIP       string `gorm:"-" json:"-"` // 已确认的 IP，用于 IP 变更通知
+	PendingIP      string `gorm:"-" json:"-"` // 与 StableIP 不同、尚待确认的 IP
+	PendingIPCount int    `gorm:"-" json:"-"` // PendingIP 连续上报的次数
+}
+
+// TransferQuotaRule 返回用于统计流量配额周期内用量的规则，未设置配额时返回 nil
+func (s *Server) TransferQuotaRule() *Rule {
+	if s.TransferQuota == 0 || s.CycleStart == nil || s.CycleInterval == 0 {
+		return nil
+	}
+	return &Rule{
+		Type:          "transfer_all_cycle",
+		Max:           float64(s.TransferQuota),
+		CycleStart:    s.CycleStart,
+		CycleInterval: s.CycleInterval,
+		CycleUnit:     s.CycleUnit,
+		Cover:         RuleCoverIgnoreAll,
+		Ignore:        map[uint64]bool{s.ID: true},
+	}
+}
+
+// TransferQuotaKey 返回影响流量配额统计的设置，任一设置修改后重新统计与通知
+func (s *Server) TransferQuotaKey() string {
+	var cycleStart int64
+	if s.CycleStart != nil {
+		cycleStart = s.CycleStart.Unix()
+	}
+	return fmt.Sprintf("%d|%d|%d|%s", s.TransferQuota, cycleStart, s.CycleInterval, s.CycleUnit)
+}
+
+// Muted 服务器的报警通知是否处于静音中
+func (s *Server) Muted(now time.Time) bool {
+	return s.MuteUntil != nil && now.Before(*s.MuteUntil)
+}
+
+// Touch 记录 Agent 的上报时间，原子写入，调用方只需持有 ServerLock 读锁
+func (s *Server) Touch(now time.Time) {
+	s.lastActive.Store(now.UnixNano())
+}
+
+// LastActiveAt 返回最后一次上报状态或主机信息的时间，面板启动后未收到上报时为零值
+func (s *Server) LastActiveAt() time.Time {
+	if ns := s.lastActive.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+type serverJSON Server
+
+// MarshalJSON 在 JSON 中输出 last_active
+func (s *Server) MarshalJSON() ([]byte, error) {
+	return utils.Json.Marshal(struct {
+		*serverJSON
+		LastActive time.Time `json:"last_active,omitempty"`
+	}{(*serverJSON)(s), s.LastActiveAt()})
+}
+
+// Snapshot 复制服务器当前的信息，供通知等在释放 ServerLock 后使用
+// copier 不会复制未导出的 lastActive，需要单独复制
+func (s *Server) Snapshot() *Server {
+	ss := &Server{}
+	copier.Copy(ss, s)
+	ss.lastActive.Store(s.lastActive.Load())
+	return ss
+}
+
+// AgentVersionAtLeast 服务器上报的 Agent 版本是否不低于 min，尚未上报主机信息时返回 false
+func (s *Server) AgentVersionAtLeast(min string) bool {
+	return s.Host != nil && utils.VersionAtLeast(s.Host.Version, min)
 }
 
 func (s *Server) CopyFromRunningServer(old *Server) {
 	s.Host = old.Host
 	s.State = old.State
 	s.GeoIP = old.GeoIP
-	s.LastActive = old.LastActive
+	s.lastActive.Store(old.lastActive.Load())
 	s.TaskClose = old.TaskClose
 	s.TaskCloseLock = old.TaskCloseLock
 	s.TaskStream = old.TaskStream
 	s.PrevTransferInSnapshot = old.PrevTransferInSnapshot
 	s.PrevTransferOutSnapshot = old.PrevTransferOutSnapshot
+	s.StableIP = old.StableIP
+	s.PendingIP = old.PendingIP
+	s.PendingIPCount = old.PendingIPCount
+}
+
+// ObserveIP 记录一次上报的 IP，新 IP 连续上报 confirmations 次后才视为变更，返回变更前的 IP
+// 首次上报只记录，不视为变更；调用方需持有 ServerLock 写锁
+func (s *Server) ObserveIP(ip string, confirmations int) (string, bool) {
+	if ip == "" {
+		return "", false
+	}
+	if s.StableIP == "" || ip == s.StableIP {
+		s.StableIP = ip
+		s.PendingIP, s.PendingIPCount = "", 0
+		return "", false
+	}
+	if ip == s.PendingIP {
+		s.PendingIPCount++
+	} else {
+		s.PendingIP, s.PendingIPCount = ip, 1
+	}
+	if s.PendingIPCount < confirmations {
+		return "", false
+	}
+	oldIP := s.StableIP
+	s.StableIP = ip
+	s.PendingIP, s.PendingIPCount = "", 0
+	return oldIP, true
 }
 
 func (s *Server) AfterFind(tx *gorm.DB) error {
@@ -57,5 +173,10 @@ func (s *Server) AfterFind(tx *gorm.DB) error {
 			return nil
 		}
 	}
+	if s.MetadataRaw != "" {
+		if err := utils.Json.Unmarshal([]byte(s.MetadataRaw), &s.Metadata); err != nil {
+			log.Println("NEZHA>> Server.AfterFind:", err)
+		}
+	}
 	return nil
 }
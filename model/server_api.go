@@ -27,10 +27,79 @@ type ServerForm struct {
 	HideForGuest bool     `json:"hide_for_guest,omitempty" validate:"optional"`         // 对游客隐藏
 	EnableDDNS   bool     `json:"enable_ddns,omitempty" validate:"optional"`            // 启用DDNS
 	DDNSProfiles []uint64 `gorm:"-" json:"ddns_profiles,omitempty" validate:"optional"` // DDNS配置
+
+	TransferQuota                    uint64     `json:"transfer_quota,omitempty" validate:"optional"` // 流量配额（字节），0 为不限制
+	CycleStart                       *time.Time `json:"cycle_start,omitempty" validate:"optional"`
+	CycleInterval                    uint64     `json:"cycle_interval,omitempty" validate:"optional"`
+	CycleUnit                        string     `json:"cycle_unit,omitempty" enums:"hour,day,week,month,year" validate:"optional" default:"month"`
+	TransferQuotaNotificationGroupID uint64     `json:"transfer_quota_notification_group_id,omitempty" validate:"optional"`
+}
+
+type ServerListQuery struct {
+	PaginationQuery
+	Q      string `form:"q" json:"q,omitempty"`           // 按名称或 IP 模糊匹配
+	Group  uint64 `form:"group" json:"group,omitempty"`   // 仅列出指定分组内的服务器
+	Online *bool  `form:"online" json:"online,omitempty"` // 按在线状态过滤
+}
+
+type ServerOfflineQuery struct {
+	Threshold string `form:"threshold" json:"threshold,omitempty"` // 超过该时长未上报视为离线，如 5m、1h，默认 5m
+}
+
+type ServerLastSeen struct {
+	ID             uint64     `json:"id"`
+	Name           string     `json:"name"`
+	LastActive     *time.Time `json:"last_active,omitempty" validate:"optional"` // 面板启动后从未收到上报时为空
+	OfflineSeconds int64      `json:"offline_seconds"`                           // 距最后一次上报的秒数，从未上报时自面板启动起计算
+}
+
+type AgentLogQuery struct {
+	Lines int `form:"lines" json:"lines,omitempty" default:"100"` // 返回日志末尾的行数，默认 100，最多 10000
+}
+
+type ServerMetaForm struct {
+	Note     string            `json:"note,omitempty" validate:"optional"`     // 管理员可见备注
+	Metadata map[string]string `json:"metadata,omitempty" validate:"optional"` // 整体替换现有的键值信息
 }
 
+type ServerMuteForm struct {
+	Duration uint64 `json:"duration"` // 静音的秒数，0 为取消静音
+}
+
+type ServerMuteResponse struct {
+	Muted bool       `json:"muted"`
+	Until *time.Time `json:"until,omitempty" validate:"optional"`
+}
+
+type ServerDisplayIndexForm struct {
+	ID           uint64 `json:"id"`
+	DisplayIndex int    `json:"display_index"` // 展示排序，越大越靠前
+}
+
+const (
+	ForceUpdateStatusSuccess = "success"
+	ForceUpdateStatusFailure = "failure"
+	ForceUpdateStatusOffline = "offline"
+)
+
 type ForceUpdateResponse struct {
 	Success []uint64 `json:"success,omitempty" validate:"optional"`
 	Failure []uint64 `json:"failure,omitempty" validate:"optional"`
 	Offline []uint64 `json:"offline,omitempty" validate:"optional"`
 }
+
+func (r *ForceUpdateResponse) Append(serverID uint64, status string) {
+	switch status {
+	case ForceUpdateStatusSuccess:
+		r.Success = append(r.Success, serverID)
+	case ForceUpdateStatusFailure:
+		r.Failure = append(r.Failure, serverID)
+	case ForceUpdateStatusOffline:
+		r.Offline = append(r.Offline, serverID)
+	}
+}
+
+type ForceUpdateResult struct {
+	ServerID uint64 `json:"server_id"`
+	Status   string `json:"status"`
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerAgentVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		host   *Host
+		expect bool
+	}{
+		{nil, false},
+		{&Host{}, false},
+		{&Host{Version: "1.5.9"}, false},
+		{&Host{Version: AgentMinVersionCommandStream}, true},
+		{&Host{Version: "v1.6.2"}, true},
+	}
+
+	for _, c := range cases {
+		s := Server{Host: c.host}
+		if got := s.AgentVersionAtLeast(AgentMinVersionCommandStream); got != c.expect {
+			t.Fatalf("Host %+v: expected %v, but got %v", c.host, c.expect, got)
+		}
+	}
+}
+
+func TestServerSnapshot(t *testing.T) {
+	now := time.Now()
+	s := &Server{Common: Common{ID: 1}, Name: "a", Host: &Host{Version: "1.6.0"}}
+	s.Touch(now)
+
+	ss := s.Snapshot()
+	if ss == s || ss.ID != 1 || ss.Name != "a" || ss.Host == nil {
+		t.Fatalf("Expected a copy of the server, but got %+v", ss)
+	}
+	if !ss.LastActiveAt().Equal(now) {
+		t.Fatalf("Expected last active %v, but got %v", now, ss.LastActiveAt())
+	}
+
+	s.Touch(now.Add(time.Minute))
+	if !ss.LastActiveAt().Equal(now) {
+		t.Fatalf("Expected the snapshot to keep %v, but got %v", now, ss.LastActiveAt())
+	}
+	if ts := templateServer(ss); !ts.LastActive.Equal(now) {
+		t.Fatalf("Expected template last active %v, but got %v", now, ts.LastActive)
+	}
+}
@@ -1,10 +1,21 @@
 package model
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/robfig/cron/v3"
+	"golang.org/x/net/http/httpguts"
 	"gorm.io/gorm"
 
 	"github.com/nezhahq/nezha/pkg/utils"
@@ -24,8 +35,20 @@ const (
 	TaskTypeNAT
 	TaskTypeReportHostInfo
 	TaskTypeFM
+	TaskTypeFetchLogs
+	TaskTypeRestart
+	TaskTypeCommandStream // 手动执行计划任务并实时回传输出，Data 为 TaskCommandStream
 )
 
+// 由面板自身执行的服务监控类型，不会下发给 Agent
+const (
+	TaskTypeHTTPKeyword = 64 + iota // 发起 HTTP GET 并检查响应内容是否匹配 Service.BodyPattern
+	TaskTypeTLSExpiry               // 连接 Target 读取证书，以距离过期的天数作为延迟上报
+)
+
+// ServiceBodyMaxSize 内容匹配监控读取的响应体最大字节数
+const ServiceBodyMaxSize = 1 << 20
+
 type TerminalTask struct {
 	StreamID string
 }
@@ -39,21 +62,62 @@ type TaskFM struct {
 	StreamID string
 }
 
+type TaskFetchLogs struct {
+	StreamID string
+	Lines    int // 需要返回的日志末尾行数
+}
+
+// AgentMinVersionCommandStream 支持 TaskTypeCommandStream 的最低 Agent 版本，更低版本的 Agent 会忽略该任务
+const AgentMinVersionCommandStream = "1.6.0"
+
+// TaskCommandStream Agent 执行 Command 时将 stdout 与 stderr 实时写入 StreamID 对应的流，
+// 执行结束后关闭流，并与 TaskTypeCommand 一样上报执行结果
+type TaskCommandStream struct {
+	StreamID string
+	Command  string
+}
+
 const (
 	ServiceCoverAll = iota
 	ServiceCoverIgnoreAll
 )
 
+const (
+	ServiceDefaultDuration = 30 // 未设置请求间隔时的默认值，单位秒
+	ServiceMinDuration     = 10 // 请求间隔的最小值，单位秒
+)
+
+// 多目标监控的判定方式
+const (
+	ServiceTargetModeAny = iota // 任一目标可用即视为正常
+	ServiceTargetModeAll        // 所有目标可用才视为正常
+)
+
+// HTTP 监控的认证方式
+const (
+	ServiceHTTPAuthNone   = iota
+	ServiceHTTPAuthBasic  // HTTPAuthUsername 与 HTTPAuthSecret 作为用户名与密码
+	ServiceHTTPAuthBearer // HTTPAuthSecret 作为 Bearer Token
+)
+
+// ServiceMaxTargets 单个监控最多包含的目标数
+const ServiceMaxTargets = 16
+
+// serviceEndpointShift 多目标监控的任务 ID 中目标序号所在的位置
+const serviceEndpointShift = 48
+
 type Service struct {
 	Common
 	Name                string `json:"name"`
 	Type                uint8  `json:"type"`
-	Target              string `json:"target"`
+	Target              string `json:"target"`      // 多个目标以逗号分隔，拆分规则见 SplitServiceTargets
+	TargetMode          uint8  `json:"target_mode"` // 多目标监控的判定方式，见 ServiceTargetModeAny
 	SkipServersRaw      string `json:"-"`
-	Duration            uint64 `json:"duration"`
+	Duration            uint64 `json:"duration"` // 请求间隔，单位秒，调度见 Schedule
 	Notify              bool   `json:"notify,omitempty"`
 	NotificationGroupID uint64 `json:"notification_group_id"` // 当前服务监控所属的通知组 ID
 	Cover               uint8  `json:"cover"`
+	Paused              bool   `gorm:"default: false" json:"paused,omitempty"` // 暂停后不再向 Agent 派发此监控任务
 
 	EnableTriggerTask      bool   `gorm:"default: false" json:"enable_trigger_task,omitempty"`
 	EnableShowInService    bool   `gorm:"default: false" json:"enable_show_in_service,omitempty"`
@@ -67,6 +131,18 @@ type Service struct {
 	MaxLatency    float32 `json:"max_latency"`
 	LatencyNotify bool    `json:"latency_notify,omitempty"`
 
+	BodyPattern      string `json:"body_pattern,omitempty"`       // 内容匹配监控期望响应体包含的字符串或正则表达式
+	BodyPatternRegex bool   `json:"body_pattern_regex,omitempty"` // BodyPattern 是否为正则表达式
+
+	HTTPAuthType     uint8  `json:"http_auth_type,omitempty"`     // HTTP 监控的认证方式，见 ServiceHTTPAuthNone
+	HTTPAuthUsername string `json:"http_auth_username,omitempty"` // Basic 认证的用户名
+	HTTPAuthSecret   string `json:"-"`                            // 加密保存的 Basic 认证密码或 Bearer Token，不通过 API 返回
+	HTTPHeadersRaw   string `json:"-"`
+
+	HTTPHeaders     map[string]string `gorm:"-" json:"-"`                           // HTTP 监控附加的请求头，值可能包含 API Key 等密钥，不通过 API 返回
+	HTTPHeaderNames []string          `gorm:"-" json:"http_header_names,omitempty"` // 已保存的请求头名称
+	HTTPAuthIsSet   bool              `gorm:"-" json:"http_auth_is_set,omitempty"`  // 是否已保存密码或 Token
+
 	SkipServers map[uint64]bool `gorm:"-" json:"skip_servers"`
 	CronJobID   cron.EntryID    `gorm:"-" json:"-"`
 }
@@ -79,13 +155,280 @@ func (m *Service) PB() *pb.Task {
 	}
 }
 
-// CronSpec 返回服务监控请求间隔对应的 cron 表达式
-func (m *Service) CronSpec() string {
+// PBs 返回下发给 Agent 的任务，多目标监控每个目标一个任务，任务 ID 见 ServiceTaskID
+func (m *Service) PBs() []*pb.Task {
+	targets := m.Targets()
+	if len(targets) <= 1 {
+		return []*pb.Task{m.PB()}
+	}
+	tasks := make([]*pb.Task, 0, len(targets))
+	for i, target := range targets {
+		tasks = append(tasks, &pb.Task{
+			Id:   ServiceTaskID(m.ID, i),
+			Type: uint64(m.Type),
+			Data: target,
+		})
+	}
+	return tasks
+}
+
+// HasHTTPRequestOptions 判断是否设置了认证信息或自定义请求头
+func (m *Service) HasHTTPRequestOptions() bool {
+	return m.HTTPAuthType != ServiceHTTPAuthNone || len(m.HTTPHeaders) > 0
+}
+
+// HTTPRequestHeaders 返回 HTTP 监控需要附加的请求头，包括由认证信息生成的 Authorization
+func (m *Service) HTTPRequestHeaders(secretKey string) (map[string]string, error) {
+	headers := make(map[string]string, len(m.HTTPHeaders)+1)
+	for k, v := range m.HTTPHeaders {
+		headers[k] = v
+	}
+	if m.HTTPAuthType == ServiceHTTPAuthNone {
+		return headers, nil
+	}
+	var secret string
+	if m.HTTPAuthSecret != "" {
+		var err error
+		if secret, err = utils.DecryptString(secretKey, m.HTTPAuthSecret); err != nil {
+			return nil, fmt.Errorf("failed to decrypt http auth secret: %w", err)
+		}
+	}
+	switch m.HTTPAuthType {
+	case ServiceHTTPAuthBasic:
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(m.HTTPAuthUsername+":"+secret))
+	case ServiceHTTPAuthBearer:
+		headers["Authorization"] = "Bearer " + secret
+	}
+	return headers, nil
+}
+
+// Targets 返回监控的全部目标
+func (m *Service) Targets() []string {
+	var targets []string
+	for _, target := range SplitServiceTargets(m.Target) {
+		if target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// SplitServiceTargets 按逗号拆分监控目标并去除首尾空白，空目标原样保留以便 Validate 报错
+// URL 的查询参数等位置可能包含逗号，因此紧跟在 URL 之后、自身不含 :// 的非空片段仍视为该 URL 的一部分
+func SplitServiceTargets(target string) []string {
+	var targets []string
+	for _, part := range strings.Split(target, ",") {
+		if n := len(targets); n > 0 && strings.Contains(targets[n-1], "://") &&
+			strings.TrimSpace(part) != "" && !strings.Contains(part, "://") {
+			targets[n-1] = strings.TrimSpace(targets[n-1] + "," + part)
+			continue
+		}
+		targets = append(targets, strings.TrimSpace(part))
+	}
+	return targets
+}
+
+// ServiceTaskID 多目标监控按目标拆分为多个任务，目标序号加一后编码在任务 ID 的高位，Agent 上报结果时原样带回
+func ServiceTaskID(serviceID uint64, endpoint int) uint64 {
+	return serviceID | uint64(endpoint+1)<<serviceEndpointShift
+}
+
+// SplitServiceTaskID 从任务 ID 中取出监控 ID 与目标序号，不是按目标拆分的任务时序号为 -1
+func SplitServiceTaskID(id uint64) (serviceID uint64, endpoint int) {
+	return id & (1<<serviceEndpointShift - 1), int(id>>serviceEndpointShift) - 1
+}
+
+// IsDashboardService 判断服务监控是否由面板自身执行
+// 设置了认证信息或请求头的 HTTP 监控同样由面板执行，密钥不会下发给 Agent
+func (m *Service) IsDashboardService() bool {
+	return m.Type == TaskTypeHTTPKeyword || m.Type == TaskTypeTLSExpiry || m.HasHTTPRequestOptions()
+}
+
+// Validate 检查监控类型与目标地址是否有效，多个目标时逐个检查
+func (m *Service) Validate() error {
+	if m.Target == "" {
+		return errors.New("target is empty")
+	}
+	if m.Duration != 0 && m.Duration < ServiceMinDuration {
+		return fmt.Errorf("duration must be at least %d seconds", ServiceMinDuration)
+	}
+	switch m.Type {
+	case TaskTypeHTTPGet, TaskTypeHTTPKeyword, TaskTypeTCPPing, TaskTypeICMPPing, TaskTypeTLSExpiry:
+	default:
+		return fmt.Errorf("unsupported service type %d", m.Type)
+	}
+	if m.TargetMode != ServiceTargetModeAny && m.TargetMode != ServiceTargetModeAll {
+		return fmt.Errorf("unsupported target mode %d", m.TargetMode)
+	}
+
+	targets := SplitServiceTargets(m.Target)
+	if len(targets) > ServiceMaxTargets {
+		return fmt.Errorf("too many targets, at most %d", ServiceMaxTargets)
+	}
+	for _, target := range targets {
+		if target == "" {
+			return errors.New("target list contains an empty entry")
+		}
+		if err := m.validateTarget(target); err != nil {
+			if len(targets) > 1 {
+				return fmt.Errorf("%s: %w", target, err)
+			}
+			return err
+		}
+	}
+
+	if err := m.validateHTTPRequestOptions(); err != nil {
+		return err
+	}
+
+	if m.Type == TaskTypeHTTPKeyword {
+		if m.BodyPattern == "" {
+			return errors.New("body pattern is empty")
+		}
+		if m.BodyPatternRegex {
+			if _, err := regexp.Compile(m.BodyPattern); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateHTTPRequestOptions 认证信息与自定义请求头只能用于 HTTP 监控
+func (m *Service) validateHTTPRequestOptions() error {
+	isHTTP := m.Type == TaskTypeHTTPGet || m.Type == TaskTypeHTTPKeyword
+	if !isHTTP {
+		if m.HTTPAuthType != ServiceHTTPAuthNone || m.HTTPAuthUsername != "" || m.HTTPAuthSecret != "" || len(m.HTTPHeaders) > 0 {
+			return errors.New("http auth and headers are only supported by HTTP services")
+		}
+		return nil
+	}
+	switch m.HTTPAuthType {
+	case ServiceHTTPAuthNone:
+		if m.HTTPAuthUsername != "" || m.HTTPAuthSecret != "" {
+			return errors.New("http auth type is not set")
+		}
+	case ServiceHTTPAuthBasic:
+		if m.HTTPAuthUsername == "" {
+			return errors.New("http auth username is empty")
+		}
+		if strings.Contains(m.HTTPAuthUsername, ":") {
+			return errors.New("http auth username must not contain a colon")
+		}
+	case ServiceHTTPAuthBearer:
+		if m.HTTPAuthSecret == "" {
+			return errors.New("http auth token is empty")
+		}
+	default:
+		return fmt.Errorf("unsupported http auth type %d", m.HTTPAuthType)
+	}
+	for k, v := range m.HTTPHeaders {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return fmt.Errorf("invalid http header name %q", k)
+		}
+		if v == "" {
+			return fmt.Errorf("value for http header %q is empty", k)
+		}
+		if !httpguts.ValidHeaderFieldValue(v) {
+			return fmt.Errorf("invalid value for http header %q", k)
+		}
+		if strings.EqualFold(k, "Authorization") && m.HTTPAuthType != ServiceHTTPAuthNone {
+			return errors.New("authorization header conflicts with http auth")
+		}
+	}
+	return nil
+}
+
+func (m *Service) validateTarget(target string) error {
+	switch m.Type {
+	case TaskTypeHTTPGet, TaskTypeHTTPKeyword:
+		if u, err := url.Parse(target); err != nil {
+			return err
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("target must be an http or https URL")
+		}
+	case TaskTypeTCPPing:
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return err
+		}
+	case TaskTypeICMPPing:
+		if strings.ContainsAny(target, "/: ") && net.ParseIP(target) == nil {
+			return errors.New("target must be a host name or IP address")
+		}
+	case TaskTypeTLSExpiry:
+		if _, _, err := ParseTLSTarget(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TLSTarget 返回证书监控需要连接的地址与 SNI 主机名
+func (m *Service) TLSTarget() (addr, serverName string, err error) {
+	return ParseTLSTarget(m.Target)
+}
+
+// ParseTLSTarget 解析证书监控的目标，可以是 host、host:port 或 URL，默认端口 443
+func ParseTLSTarget(target string) (addr, serverName string, err error) {
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return "", "", err
+		}
+		target = u.Host
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = strings.Trim(target, "[]"), "443"
+	}
+	if host == "" {
+		return "", "", errors.New("target host is empty")
+	}
+	return net.JoinHostPort(host, port), host, nil
+}
+
+// MatchBody 判断响应体是否匹配 BodyPattern
+func (m *Service) MatchBody(body []byte) (bool, error) {
+	if !m.BodyPatternRegex {
+		return bytes.Contains(body, []byte(m.BodyPattern)), nil
+	}
+	re, err := regexp.Compile(m.BodyPattern)
+	if err != nil {
+		return false, err
+	}
+	return re.Match(body), nil
+}
+
+// Interval 返回服务监控的请求间隔，未设置时默认 30 秒，不低于 ServiceMinDuration
+func (m *Service) Interval() time.Duration {
 	if m.Duration == 0 {
-		// 默认间隔 30 秒
-		m.Duration = 30
+		m.Duration = ServiceDefaultDuration
 	}
-	return fmt.Sprintf("@every %ds", m.Duration)
+	return time.Duration(max(m.Duration, ServiceMinDuration)) * time.Second
+}
+
+// Schedule 返回服务监控的调度计划，每个监控按各自的间隔独立派发
+// 派发时刻对齐到间隔的整数倍再加上由 ID 得出的固定偏移，间隔相同的监控分散在间隔内的不同秒，
+// 避免同时创建或面板重启后所有监控在同一秒集中派发
+func (m *Service) Schedule() cron.Schedule {
+	interval := m.Interval()
+	// 按黄金分割比例取偏移，ID 连续的监控在间隔内分布均匀
+	_, frac := math.Modf(float64(m.ID) * 0.6180339887498949)
+	offset := time.Duration(frac*interval.Seconds()) * time.Second
+	return staggeredSchedule{interval: interval, offset: offset}
+}
+
+type staggeredSchedule struct {
+	interval time.Duration
+	offset   time.Duration
+}
+
+func (s staggeredSchedule) Next(t time.Time) time.Time {
+	next := t.Truncate(s.interval).Add(s.offset)
+	if !next.After(t) {
+		next = next.Add(s.interval)
+	}
+	return next
 }
 
 func (m *Service) BeforeSave(tx *gorm.DB) error {
@@ -104,6 +447,13 @@ func (m *Service) BeforeSave(tx *gorm.DB) error {
 	} else {
 		m.RecoverTriggerTasksRaw = string(data)
 	}
+	if len(m.HTTPHeaders) == 0 {
+		m.HTTPHeadersRaw = ""
+	} else if data, err := utils.Json.Marshal(m.HTTPHeaders); err != nil {
+		return err
+	} else {
+		m.HTTPHeadersRaw = string(data)
+	}
 	return nil
 }
 
@@ -121,11 +471,31 @@ func (m *Service) AfterFind(tx *gorm.DB) error {
 	if err := utils.Json.Unmarshal([]byte(m.RecoverTriggerTasksRaw), &m.RecoverTriggerTasks); err != nil {
 		return err
 	}
+	if m.HTTPHeadersRaw != "" {
+		if err := utils.Json.Unmarshal([]byte(m.HTTPHeadersRaw), &m.HTTPHeaders); err != nil {
+			return err
+		}
+	}
+	m.SetHTTPHeaderNames()
+	m.HTTPAuthIsSet = m.HTTPAuthSecret != ""
 
 	return nil
 }
 
+// SetHTTPHeaderNames 根据 HTTPHeaders 更新 API 返回的请求头名称
+func (m *Service) SetHTTPHeaderNames() {
+	m.HTTPHeaderNames = m.HTTPHeaderNames[:0]
+	for k := range m.HTTPHeaders {
+		m.HTTPHeaderNames = append(m.HTTPHeaderNames, k)
+	}
+	sort.Strings(m.HTTPHeaderNames)
+}
+
 // IsServiceSentinelNeeded 判断该任务类型是否需要进行服务监控 需要则返回true
 func IsServiceSentinelNeeded(t uint64) bool {
-	return t != TaskTypeCommand && t != TaskTypeTerminalGRPC && t != TaskTypeUpgrade
+	switch t {
+	case TaskTypeCommand, TaskTypeCommandStream, TaskTypeTerminalGRPC, TaskTypeUpgrade, TaskTypeRestart, TaskTypeFetchLogs:
+		return false
+	}
+	return true
 }
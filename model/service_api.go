@@ -3,21 +3,68 @@ package model
 import "time"
 
 type ServiceForm struct {
-	Name                string          `json:"name,omitempty" minLength:"1"`
-	Target              string          `json:"target,omitempty"`
-	Type                uint8           `json:"type,omitempty"`
-	Cover               uint8           `json:"cover,omitempty"`
-	Notify              bool            `json:"notify,omitempty" validate:"optional"`
-	Duration            uint64          `json:"duration,omitempty"`
-	MinLatency          float32         `json:"min_latency,omitempty" default:"0.0"`
-	MaxLatency          float32         `json:"max_latency,omitempty" default:"0.0"`
-	LatencyNotify       bool            `json:"latency_notify,omitempty" validate:"optional"`
-	EnableTriggerTask   bool            `json:"enable_trigger_task,omitempty" validate:"optional"`
-	EnableShowInService bool            `json:"enable_show_in_service,omitempty" validate:"optional"`
-	FailTriggerTasks    []uint64        `json:"fail_trigger_tasks,omitempty"`
-	RecoverTriggerTasks []uint64        `json:"recover_trigger_tasks,omitempty"`
-	SkipServers         map[uint64]bool `json:"skip_servers,omitempty"`
-	NotificationGroupID uint64          `json:"notification_group_id,omitempty"`
+	Name                string            `json:"name,omitempty" minLength:"1"`
+	Target              string            `json:"target,omitempty"`                          // 多个目标以逗号分隔，最多 16 个
+	TargetMode          uint8             `json:"target_mode,omitempty" validate:"optional"` // 0: 任一目标可用即正常 1: 所有目标可用才正常
+	Type                uint8             `json:"type,omitempty"`
+	Cover               uint8             `json:"cover,omitempty"`
+	Notify              bool              `json:"notify,omitempty" validate:"optional"`
+	Duration            uint64            `json:"duration,omitempty"` // 请求间隔，单位秒，默认 30，最小 10；派发时刻按 ID 在间隔内错开
+	MinLatency          float32           `json:"min_latency,omitempty" default:"0.0"`
+	MaxLatency          float32           `json:"max_latency,omitempty" default:"0.0"`
+	LatencyNotify       bool              `json:"latency_notify,omitempty" validate:"optional"`
+	BodyPattern         string            `json:"body_pattern,omitempty" validate:"optional"`       // 内容匹配监控期望响应体包含的内容
+	BodyPatternRegex    bool              `json:"body_pattern_regex,omitempty" validate:"optional"` // BodyPattern 按正则表达式匹配
+	HTTPAuthType        uint8             `json:"http_auth_type,omitempty" validate:"optional"`     // 0: 无 1: Basic 2: Bearer，仅 HTTP 监控可用
+	HTTPAuthUsername    string            `json:"http_auth_username,omitempty" validate:"optional"` // Basic 认证的用户名
+	HTTPAuthSecret      string            `json:"http_auth_secret,omitempty" validate:"optional"`   // Basic 认证的密码或 Bearer Token，留空时更新不会覆盖已保存的值
+	HTTPHeaders         map[string]string `json:"http_headers,omitempty" validate:"optional"`       // 附加的请求头，值留空时更新不会覆盖已保存的值
+	EnableTriggerTask   bool              `json:"enable_trigger_task,omitempty" validate:"optional"`
+	EnableShowInService bool              `json:"enable_show_in_service,omitempty" validate:"optional"`
+	Paused              bool              `json:"paused,omitempty" validate:"optional"`
+	FailTriggerTasks    []uint64          `json:"fail_trigger_tasks,omitempty"`
+	RecoverTriggerTasks []uint64          `json:"recover_trigger_tasks,omitempty"`
+	SkipServers         map[uint64]bool   `json:"skip_servers,omitempty"`
+	NotificationGroupID uint64            `json:"notification_group_id,omitempty"`
+
+	UpdatedAt *time.Time `json:"updated_at,omitempty" validate:"optional"` // 编辑时传入加载记录时的 updated_at，记录已被修改时拒绝保存
+}
+
+// ServiceBatchCreateItem 批量创建时每个监控各自的配置，其余配置取自 Defaults
+type ServiceBatchCreateItem struct {
+	Name        string `json:"name,omitempty" validate:"optional"` // 留空时使用 Target
+	Target      string `json:"target"`
+	Type        uint8  `json:"type"`
+	BodyPattern string `json:"body_pattern,omitempty" validate:"optional"`
+}
+
+type ServiceBatchCreateForm struct {
+	Services []ServiceBatchCreateItem `json:"services"`
+	Defaults ServiceForm              `json:"defaults"` // 共用的间隔、通知、排除服务器等配置，其中的 name、target、type 会被忽略
+}
+
+type ServiceBatchCreateError struct {
+	Index int    `json:"index"` // 在 Services 中的下标
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+type ServiceBatchCreateResponse struct {
+	Created []uint64                  `json:"created"`
+	Errors  []ServiceBatchCreateError `json:"errors,omitempty" validate:"optional"`
+}
+
+type BatchDeleteServiceResponse struct {
+	Success []uint64 `json:"success,omitempty" validate:"optional"`
+	Failure []uint64 `json:"failure,omitempty" validate:"optional"`
+}
+
+type ServiceHistoryQuery struct {
+	From   int64  `form:"from" json:"from,omitempty"`
+	To     int64  `form:"to" json:"to,omitempty"`
+	Offset int    `form:"offset" json:"offset,omitempty"`
+	Limit  int    `form:"limit" json:"limit,omitempty"`
+	Order  string `form:"order" json:"order,omitempty" enums:"asc,desc"`
 }
 
 type ServiceResponseItem struct {
@@ -53,3 +100,24 @@ type ServiceResponse struct {
 	Services           map[uint64]ServiceResponseItem `json:"services,omitempty"`
 	CycleTransferStats map[uint64]CycleTransferStats  `json:"cycle_transfer_stats,omitempty"`
 }
+
+type ServiceTestForm struct {
+	Target           string `json:"target"`
+	Type             uint8  `json:"type"`
+	TargetMode       uint8  `json:"target_mode,omitempty" validate:"optional"`
+	BodyPattern      string `json:"body_pattern,omitempty" validate:"optional"`
+	BodyPatternRegex bool   `json:"body_pattern_regex,omitempty" validate:"optional"`
+
+	HTTPAuthType     uint8             `json:"http_auth_type,omitempty" validate:"optional"`
+	HTTPAuthUsername string            `json:"http_auth_username,omitempty" validate:"optional"`
+	HTTPAuthSecret   string            `json:"http_auth_secret,omitempty" validate:"optional"`
+	HTTPHeaders      map[string]string `json:"http_headers,omitempty" validate:"optional"`
+}
+
+type ServiceTestResponse struct {
+	Successful bool    `json:"successful"`
+	Delay      float32 `json:"delay"`          // 延迟（毫秒），证书过期监控为距离过期的天数
+	Data       string  `json:"data,omitempty"` // 失败原因或证书信息
+
+	EndpointDelays map[string]float32 `json:"endpoint_delays,omitempty" validate:"optional"` // 多目标时各可用目标的延迟
+}
@@ -14,4 +14,6 @@ type ServiceHistory struct {
 	Up        uint64    `json:"up,omitempty"`                                                                   // 检查状态良好计数
 	Down      uint64    `json:"down,omitempty"`                                                                 // 检查状态异常计数
 	Data      string    `json:"data,omitempty"`
+
+	EndpointDelaysRaw string `json:"-"` // 多目标监控各目标的平均延迟，[target] -> delay 的 JSON
 }
@@ -7,4 +7,6 @@ type ServiceInfos struct {
 	ServerName  string    `json:"server_name"`
 	CreatedAt   []int64   `json:"created_at"`
 	AvgDelay    []float32 `json:"avg_delay"`
+
+	EndpointDelays []map[string]float32 `json:"endpoint_delays,omitempty" validate:"optional"` // 多目标监控各目标的平均延迟，与 AvgDelay 一一对应
 }
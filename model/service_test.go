@@ -0,0 +1,75 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIsServiceSentinelNeeded(t *testing.T) {
+	cases := []struct {
+		taskType uint64
+		needed   bool
+	}{
+		{TaskTypeHTTPGet, true},
+		{TaskTypeICMPPing, true},
+		{TaskTypeTCPPing, true},
+		{TaskTypeHTTPKeyword, true},
+		{TaskTypeTLSExpiry, true},
+		{TaskTypeCommand, false},
+		{TaskTypeCommandStream, false},
+		{TaskTypeTerminalGRPC, false},
+		{TaskTypeUpgrade, false},
+		{TaskTypeRestart, false},
+		{TaskTypeFetchLogs, false},
+	}
+
+	for _, c := range cases {
+		if got := IsServiceSentinelNeeded(c.taskType); got != c.needed {
+			t.Fatalf("Task type %d: expected %v, but got %v", c.taskType, c.needed, got)
+		}
+	}
+}
+
+func TestSplitServiceTargets(t *testing.T) {
+	cases := []struct {
+		target  string
+		targets []string
+	}{
+		{"1.1.1.1", []string{"1.1.1.1"}},
+		{"1.1.1.1, 8.8.8.8", []string{"1.1.1.1", "8.8.8.8"}},
+		{"a.com:443,,b.com:443", []string{"a.com:443", "", "b.com:443"}},
+		{"https://a.com, https://b.com/", []string{"https://a.com", "https://b.com/"}},
+		{"https://a.com/?ids=1,2,3", []string{"https://a.com/?ids=1,2,3"}},
+		{"https://a.com/?ids=1,2,https://b.com/", []string{"https://a.com/?ids=1,2", "https://b.com/"}},
+		{"https://a.com/,", []string{"https://a.com/", ""}},
+	}
+
+	for _, c := range cases {
+		if got := SplitServiceTargets(c.target); !slices.Equal(got, c.targets) {
+			t.Fatalf("Target %q: expected %q, but got %q", c.target, c.targets, got)
+		}
+	}
+}
+
+func TestServiceValidateTargets(t *testing.T) {
+	cases := []struct {
+		taskType uint8
+		target   string
+		valid    bool
+	}{
+		{TaskTypeHTTPGet, "https://a.com/?ids=1,2, https://b.com/", true},
+		{TaskTypeHTTPGet, "https://a.com/, b.com", true},
+		{TaskTypeHTTPGet, "https://a.com/,", false},
+		{TaskTypeHTTPGet, "https://a.com/, ftp://b.com/", false},
+		{TaskTypeTCPPing, "a.com:22, b.com:22", true},
+		{TaskTypeTCPPing, "a.com:22, , b.com:22", false},
+		{TaskTypeICMPPing, "1.1.1.1,8.8.8.8,9.9.9.9", true},
+	}
+
+	for _, c := range cases {
+		m := Service{Type: c.taskType, Target: c.target}
+		if err := m.Validate(); (err == nil) != c.valid {
+			t.Fatalf("Target %q: expected valid %v, but got %v", c.target, c.valid, err)
+		}
+	}
+}
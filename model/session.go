@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// Session 浏览器登录会话，Token 中携带会话 ID，删除记录即可使对应 Token 失效
+type Session struct {
+	Common
+	UserID    uint64    `json:"user_id" gorm:"index"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+
+	Current bool `json:"current" gorm:"-"` // 是否为发起请求的会话
+}
@@ -11,7 +11,21 @@ type SettingForm struct {
 	CustomCode                  string `json:"custom_code,omitempty" validate:"optional"`
 	CustomCodeDashboard         string `json:"custom_code_dashboard,omitempty" validate:"optional"`
 	RealIPHeader                string `json:"real_ip_header,omitempty" validate:"optional"` // 真实IP
+	LogLevel                    string `json:"log_level,omitempty" validate:"optional"`      // debug/info/warn/error，留空则不修改
+
+	CleanHistorySchedule         string `json:"clean_history_schedule,omitempty" validate:"optional"`          // 留空则不修改
+	ServiceHistoryRetentionDays  int    `json:"service_history_retention_days,omitempty" validate:"optional"`  // 为 0 则不修改
+	TransferHistoryRetentionDays int    `json:"transfer_history_retention_days,omitempty" validate:"optional"` // 为 0 则不修改
+	AuditLogRetentionDays        int    `json:"audit_log_retention_days,omitempty" validate:"optional"`        // 为 0 则不修改
+
+	TransferQuotaThresholds []int `json:"transfer_quota_thresholds,omitempty" validate:"optional"` // 流量配额的通知阈值（百分比），留空则不修改
 
 	EnableIPChangeNotification  bool `json:"enable_ip_change_notification,omitempty" validate:"optional"`
 	EnablePlainIPInNotification bool `json:"enable_plain_ip_in_notification,omitempty" validate:"optional"`
+
+	IPChangeNotificationConfirmations int `json:"ip_change_notification_confirmations,omitempty" validate:"optional"` // 新 IP 连续上报多少次后才通知，为 0 则不修改
+
+	EnableServerStateNotification  bool   `json:"enable_server_state_notification,omitempty" validate:"optional"`
+	ServerStateNotificationGroupID uint64 `json:"server_state_notification_group_id,omitempty" validate:"optional"` // 服务器上下线提醒的通知组
+	ServerOfflineThreshold         int    `json:"server_offline_threshold,omitempty" validate:"optional"`           // 断开多少秒后通知下线，为 0 则不修改
 }
@@ -0,0 +1,17 @@
+package model
+
+import "gorm.io/gorm"
+
+// DateTimeExpr 返回可在当前数据库中比较时间的表达式
+// sqlite 中时间以带时区偏移的文本保存，需要先用 datetime() 统一为 UTC
+func DateTimeExpr(db *gorm.DB, expr string) string {
+	if db.Dialector.Name() == "sqlite" {
+		return "datetime(" + expr + ")"
+	}
+	return expr
+}
+
+// QuoteColumn 按当前数据库的方言引用列名，用于 in/out 这类保留字
+func QuoteColumn(db *gorm.DB, column string) string {
+	return db.Statement.Quote(column)
+}
@@ -0,0 +1,7 @@
+package model
+
+type TransferExportQuery struct {
+	From     int64  `form:"from" json:"from,omitempty"`           // 毫秒时间戳，默认为 To 之前 30 天
+	To       int64  `form:"to" json:"to,omitempty"`               // 毫秒时间戳，默认为当前时间
+	ServerID uint64 `form:"server_id" json:"server_id,omitempty"` // 仅导出指定服务器，默认导出全部
+}
@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// TransferQuotaNotice 服务器流量配额在当前周期内的通知状态，持久化以避免重启后在同一周期内重复通知
+type TransferQuotaNotice struct {
+	Common
+	ServerID   uint64    `json:"server_id" gorm:"uniqueIndex"`
+	QuotaKey   string    `json:"quota_key"`   // 配额设置，修改后重新通知，见 Server.TransferQuotaKey
+	CycleStart time.Time `json:"cycle_start"` // 通知所在周期的开始时间
+	Threshold  int       `json:"threshold"`   // 周期内已通知的最高阈值
+}
+
+// Reset 配额设置修改或进入新周期时清除已通知的阈值，返回是否发生了变化
+func (n *TransferQuotaNotice) Reset(quotaKey string, cycleStart time.Time) bool {
+	if n.QuotaKey == quotaKey && n.CycleStart.Equal(cycleStart) {
+		return false
+	}
+	n.QuotaKey = quotaKey
+	n.CycleStart = cycleStart
+	n.Threshold = 0
+	return true
+}
+
+// Next 返回用量达到的、尚未通知过的最高阈值，没有时返回 0
+// thresholds 需按升序排列
+func (n *TransferQuotaNotice) Next(used, quota uint64, thresholds []int) int {
+	if quota == 0 {
+		return 0
+	}
+	percent := float64(used) * 100 / float64(quota)
+	threshold := 0
+	for _, t := range thresholds {
+		if percent >= float64(t) {
+			threshold = t
+		}
+	}
+	if threshold <= n.Threshold {
+		return 0
+	}
+	return threshold
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferQuotaNoticeNext(t *testing.T) {
+	thresholds := []int{80, 90, 100}
+	cases := []struct {
+		used     uint64
+		notified int
+		expect   int
+	}{
+		{used: 0, expect: 0},
+		{used: 79, expect: 0},
+		{used: 80, expect: 80},
+		{used: 95, expect: 90},
+		{used: 150, expect: 100},
+		{used: 85, notified: 80, expect: 0},
+		{used: 90, notified: 80, expect: 90},
+		{used: 100, notified: 100, expect: 0},
+	}
+
+	for _, c := range cases {
+		n := TransferQuotaNotice{Threshold: c.notified}
+		if got := n.Next(c.used, 100, thresholds); got != c.expect {
+			t.Fatalf("used %d, notified %d: expected %d, but got %d", c.used, c.notified, c.expect, got)
+		}
+	}
+
+	n := TransferQuotaNotice{}
+	if got := n.Next(100, 0, thresholds); got != 0 {
+		t.Fatalf("Expected 0 without a quota, but got %d", got)
+	}
+}
+
+func TestTransferQuotaNoticeReset(t *testing.T) {
+	cycleStart := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	n := TransferQuotaNotice{QuotaKey: "a", CycleStart: cycleStart, Threshold: 90}
+
+	// 从数据库读出的时间可能带有不同的时区
+	if n.Reset("a", cycleStart.In(time.FixedZone("UTC+8", 8*3600))) || n.Threshold != 90 {
+		t.Fatalf("Expected the notice of the same cycle to be kept, but got %+v", n)
+	}
+	if !n.Reset("a", cycleStart.AddDate(0, 1, 0)) || n.Threshold != 0 {
+		t.Fatalf("Expected the notice to be reset in a new cycle, but got %+v", n)
+	}
+	n.Threshold = 80
+	if !n.Reset("b", n.CycleStart) || n.Threshold != 0 || n.QuotaKey != "b" {
+		t.Fatalf("Expected the notice to be reset after the quota changed, but got %+v", n)
+	}
+}
+
+func TestServerTransferQuotaKey(t *testing.T) {
+	cycleStart := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	s := Server{TransferQuota: 1 << 30, CycleStart: &cycleStart, CycleInterval: 1, CycleUnit: "month"}
+	if s.TransferQuotaRule() == nil {
+		t.Fatal("Expected a transfer quota rule")
+	}
+
+	key := s.TransferQuotaKey()
+	s.CycleInterval = 2
+	if s.TransferQuotaKey() == key {
+		t.Fatal("Expected the key to change with the cycle interval")
+	}
+
+	s.TransferQuota = 0
+	if s.TransferQuotaRule() != nil {
+		t.Fatal("Expected no transfer quota rule without a quota")
+	}
+	s.TransferQuota, s.CycleStart = 1<<30, nil
+	if s.TransferQuotaRule() != nil || s.TransferQuotaKey() == "" {
+		t.Fatal("Expected no transfer quota rule without a cycle start")
+	}
+}
@@ -4,6 +4,13 @@ type User struct {
 	Common
 	Username string `json:"username,omitempty" gorm:"uniqueIndex"`
 	Password string `json:"password,omitempty" gorm:"type:char(72)"`
+
+	MustChangePassword bool `json:"must_change_password"` // 仍在使用初始密码，修改前仅允许访问改密接口
+
+	TOTPEnabled   bool   `json:"totp_enabled"`
+	TOTPSecret    string `json:"-"` // 加密保存的 TOTP 密钥
+	TOTPLastStep  int64  `json:"-"` // 最近一次验证通过的 TOTP 时间步，同一时间步的验证码不能重复使用
+	RecoveryCodes string `json:"-"` // 恢复码的 bcrypt 哈希列表 (JSON)
 }
 
 type Profile struct {
@@ -10,3 +10,33 @@ type ProfileForm struct {
 	NewUsername      string `json:"new_username,omitempty"`
 	NewPassword      string `json:"new_password,omitempty"`
 }
+
+type ChangePasswordForm struct {
+	OriginalPassword string `json:"original_password,omitempty"`
+	NewPassword      string `json:"new_password,omitempty"`
+}
+
+type ViewPasswordForm struct {
+	Password string `json:"password,omitempty"`
+}
+
+type TwoFactorEnrollResponse struct {
+	Secret          string   `json:"secret"`
+	ProvisioningURI string   `json:"provisioning_uri"`
+	RecoveryCodes   []string `json:"recovery_codes"`
+}
+
+// TwoFactorEnrollForm 已启用两步验证时重新绑定需要同时提供验证码或恢复码
+type TwoFactorEnrollForm struct {
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code,omitempty" validate:"optional"`
+}
+
+type TwoFactorDisableForm struct {
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code,omitempty"` // 验证码或恢复码
+}
+
+type TwoFactorVerifyForm struct {
+	Code string `json:"code" minLength:"6"`
+}
@@ -2,9 +2,13 @@ package ddns
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/libdns/libdns"
@@ -16,7 +20,9 @@ import (
 
 var (
 	dnsTimeOut       = 10 * time.Second
+	dnsProbeTimeout  = 3 * time.Second
 	customDNSServers []string
+	dnsServersLock   sync.RWMutex
 )
 
 type IP struct {
@@ -37,31 +43,160 @@ type Provider struct {
 	Setter      libdns.RecordSetter
 }
 
+// InitDNSServers 设置自定义 DNS 服务器（ip:port，多个用逗号分隔），为空时使用默认 DNS 服务器
 func InitDNSServers(s string) {
-	if s != "" {
-		customDNSServers = strings.Split(s, ",")
+	var servers []string
+	for _, server := range strings.Split(s, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	dnsServersLock.Lock()
+	customDNSServers = servers
+	dnsServersLock.Unlock()
+}
+
+// dnsServers 返回按顺序尝试的 DNS 服务器，自定义 DNS 服务器全部失败时回退到系统 DNS
+func dnsServers() []string {
+	dnsServersLock.RLock()
+	custom := customDNSServers
+	dnsServersLock.RUnlock()
+	if len(custom) == 0 {
+		return utils.DNSServers
+	}
+	servers := slices.Clone(custom)
+	for _, server := range systemDNSServers() {
+		if !slices.Contains(servers, server) {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// systemDNSServers 读取系统配置的 DNS 服务器，无法读取时（如 Windows）使用内置的公共 DNS
+func systemDNSServers() []string {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(conf.Servers) == 0 {
+		return utils.DNSServers
+	}
+	servers := make([]string, 0, len(conf.Servers))
+	for _, server := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(server, conf.Port))
+	}
+	return servers
+}
+
+// exchange 依次向各个 DNS 服务器发送查询，返回第一个成功的结果
+// SERVFAIL、REFUSED 等响应视为该服务器失败，NXDOMAIN 是有效的结果
+func exchange(m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Timeout: dnsTimeOut}
+	var lastErr error
+	for _, server := range dnsServers() {
+		r, _, err := c.Exchange(m, server)
+		if err == nil {
+			err = checkRcode(r, server)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return r, nil
+	}
+	return nil, lastErr
+}
+
+// ProbeDNSServer 向 DNS 服务器查询根域的 NS 记录，用于保存设置前确认服务器可用
+func ProbeDNSServer(server string) error {
+	c := &dns.Client{Timeout: dnsProbeTimeout}
+	m := new(dns.Msg)
+	m.SetQuestion(".", dns.TypeNS)
+	r, _, err := c.Exchange(m, server)
+	if err != nil {
+		return err
+	}
+	return checkRcode(r, server)
+}
+
+// checkRcode 除 NOERROR 与 NXDOMAIN 外的响应码均说明该服务器无法给出可信的结果
+func checkRcode(r *dns.Msg, server string) error {
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return fmt.Errorf("dns server %s responded with %s", server, dns.RcodeToString[r.Rcode])
 	}
+	return nil
 }
 
-func (provider *Provider) UpdateDomain(ctx context.Context) {
+// permanentError 重试也无法恢复的错误，如凭据无效或权限不足
+type permanentError struct {
+	error
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.error
+}
+
+// UpdateDomain 更新所有域名的解析记录，返回每次尝试的结果
+func (provider *Provider) UpdateDomain(ctx context.Context) []model.DDNSRecord {
 	provider.ctx = ctx
+	var records []model.DDNSRecord
+	// 至少尝试一次，避免 MaxRetries 为 0 时不更新也不报错
+	maxAttempts := max(int(provider.DDNSProfile.MaxRetries), 1)
 	for _, domain := range provider.DDNSProfile.Domains {
-		for retries := 0; retries < int(provider.DDNSProfile.MaxRetries); retries++ {
+		var succeeded, permanent bool
+		var attempts int
+		for attempts < maxAttempts {
+			attempts++
 			provider.domain = domain
-			log.Printf("NEZHA>> 正在尝试更新域名(%s)DDNS(%d/%d)", provider.domain, retries+1, provider.DDNSProfile.MaxRetries)
+			log.Printf("NEZHA>> 正在尝试更新域名(%s)DDNS(%d/%d)", provider.domain, attempts, maxAttempts)
 			if err := provider.updateDomain(); err != nil {
 				log.Printf("NEZHA>> 尝试更新域名(%s)DDNS失败: %v", provider.domain, err)
+				records = append(records, provider.newRecord(domain, model.DDNSRecordStatusFailed, err))
+				var pe *permanentError
+				if errors.As(err, &pe) {
+					permanent = true
+					break
+				}
 			} else {
 				log.Printf("NEZHA>> 尝试更新域名(%s)DDNS成功", provider.domain)
+				records = append(records, provider.newRecord(domain, model.DDNSRecordStatusSuccess, nil))
+				succeeded = true
 				break
 			}
 		}
+		if !succeeded {
+			err := fmt.Errorf("gave up after %d of %d attempts", attempts, maxAttempts)
+			if permanent {
+				err = fmt.Errorf("gave up after %d of %d attempts, the error is not retryable", attempts, maxAttempts)
+			}
+			records = append(records, provider.newRecord(domain, model.DDNSRecordStatusRetriesExhausted, err))
+		}
+	}
+	return records
+}
+
+func (provider *Provider) newRecord(domain, status string, err error) model.DDNSRecord {
+	var ips []string
+	if *provider.DDNSProfile.EnableIPv4 && provider.IPAddrs.Ipv4Addr != "" {
+		ips = append(ips, provider.IPAddrs.Ipv4Addr)
+	}
+	if *provider.DDNSProfile.EnableIPv6 && provider.IPAddrs.Ipv6Addr != "" {
+		ips = append(ips, provider.IPAddrs.Ipv6Addr)
+	}
+	record := model.DDNSRecord{
+		DDNSProfileID: provider.DDNSProfile.ID,
+		Provider:      provider.DDNSProfile.Provider,
+		Domain:        domain,
+		NewIP:         strings.Join(ips, "/"),
+		Status:        status,
+	}
+	if err != nil {
+		record.Error = err.Error()
 	}
+	return record
 }
 
 func (provider *Provider) updateDomain() error {
 	var err error
-	provider.prefix, provider.zone, err = splitDomainSOA(provider.domain)
+	provider.prefix, provider.zone, err = provider.splitDomain(provider.domain)
 	if err != nil {
 		return err
 	}
@@ -96,36 +231,147 @@ func (provider *Provider) addDomainRecord() error {
 				TTL:   time.Minute,
 			},
 		})
+	if err != nil && provider.DDNSProfile.Provider == model.ProviderCloudflare {
+		return cloudflareError(provider.zone, err)
+	}
 	return err
 }
 
-func splitDomainSOA(domain string) (prefix string, zone string, err error) {
-	c := &dns.Client{Timeout: dnsTimeOut}
+// cloudflareError 将 Cloudflare 的鉴权与区域查找错误转换为更明确的提示，这类错误不再重试
+func cloudflareError(zone string, err error) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "HTTP 401"), strings.Contains(msg, "HTTP 403"):
+		return &permanentError{fmt.Errorf("cloudflare rejected the API token, make sure it is valid and has Zone:Read and DNS:Edit permissions for %s: %w", zone, err)}
+	case strings.Contains(msg, "expected 1 zone, got 0"):
+		return &permanentError{fmt.Errorf("cloudflare zone %s is not accessible, make sure the API token has Zone:Read permission for it: %w", zone, err)}
+	}
+	return err
+}
 
-	domain += "."
-	indexes := dns.Split(domain)
+// DryRun 对比域名当前的解析记录与将要写入的 IP，返回更新时会执行的操作，不会调用提供者的写接口
+func (provider *Provider) DryRun(ctx context.Context) ([]model.DDNSDryRunAction, error) {
+	var actions []model.DDNSDryRunAction
+	for _, domain := range provider.DDNSProfile.Domains {
+		prefix, zone, err := provider.splitDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, isIpv4 := range []bool{true, false} {
+			var ipAddr string
+			if isIpv4 {
+				if !*provider.DDNSProfile.EnableIPv4 {
+					continue
+				}
+				ipAddr = provider.IPAddrs.Ipv4Addr
+			} else {
+				if !*provider.DDNSProfile.EnableIPv6 {
+					continue
+				}
+				ipAddr = provider.IPAddrs.Ipv6Addr
+			}
+			if ipAddr == "" {
+				continue
+			}
+
+			recordType := getRecordString(isIpv4)
+			current, err := provider.currentRecords(ctx, domain, prefix, zone, recordType)
+			if err != nil {
+				return nil, err
+			}
+
+			action := model.DDNSDryRunAction{
+				Domain:        domain,
+				RecordType:    recordType,
+				CurrentValues: current,
+				NewValue:      ipAddr,
+			}
+			switch {
+			case len(current) == 0:
+				action.Action = model.DDNSDryRunActionCreate
+			case len(current) == 1 && current[0] == ipAddr:
+				action.Action = model.DDNSDryRunActionNoop
+			default:
+				action.Action = model.DDNSDryRunActionUpdate
+			}
+			actions = append(actions, action)
+		}
+	}
+	return actions, nil
+}
+
+// currentRecords 优先通过提供者的读接口获取当前记录，不支持时回退到 DNS 查询
+func (provider *Provider) currentRecords(ctx context.Context, domain, prefix, zone, recordType string) ([]string, error) {
+	if getter, ok := provider.Setter.(libdns.RecordGetter); ok {
+		recs, err := getter.GetRecords(ctx, zone)
+		if err != nil {
+			if provider.DDNSProfile.Provider == model.ProviderCloudflare {
+				return nil, cloudflareError(zone, err)
+			}
+			return nil, err
+		}
+		var values []string
+		for _, rec := range recs {
+			name := rec.Name
+			if name == "@" {
+				name = ""
+			}
+			if rec.Type == recordType && name == prefix {
+				values = append(values, rec.Value)
+			}
+		}
+		return values, nil
+	}
+	return lookupRecords(domain, recordType)
+}
+
+func lookupRecords(domain, recordType string) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), dns.StringToType[recordType])
+
+	r, err := exchange(m)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, ans := range r.Answer {
+		switch rr := ans.(type) {
+		case *dns.A:
+			values = append(values, rr.A.String())
+		case *dns.AAAA:
+			values = append(values, rr.AAAA.String())
+		}
+	}
+	return values, nil
+}
 
-	servers := utils.DNSServers
-	if len(customDNSServers) > 0 {
-		servers = customDNSServers
+// splitDomain 拆分域名的前缀与所在区域
+// WebHook 模式下由用户自行处理解析，不要求域名在公共 DNS 中存在，整个域名即作为区域
+func (provider *Provider) splitDomain(domain string) (prefix string, zone string, err error) {
+	if provider.DDNSProfile.Provider == model.ProviderWebHook {
+		return "", dns.Fqdn(domain), nil
 	}
+	return splitDomainSOA(domain)
+}
+
+func splitDomainSOA(domain string) (prefix string, zone string, err error) {
+	domain += "."
+	indexes := dns.Split(domain)
 
-	var r *dns.Msg
 	for _, idx := range indexes {
 		m := new(dns.Msg)
 		m.SetQuestion(domain[idx:], dns.TypeSOA)
 
-		for _, server := range servers {
-			r, _, err = c.Exchange(m, server)
-			if err != nil {
-				return
-			}
-			if len(r.Answer) > 0 {
-				if soa, ok := r.Answer[0].(*dns.SOA); ok {
-					zone = soa.Hdr.Name
-					prefix = libdns.RelativeName(domain, zone)
-					return
-				}
+		r, err := exchange(m)
+		if err != nil {
+			return "", "", err
+		}
+		if len(r.Answer) > 0 {
+			if soa, ok := r.Answer[0].(*dns.SOA); ok {
+				zone = soa.Hdr.Name
+				prefix = libdns.RelativeName(domain, zone)
+				return prefix, zone, nil
 			}
 		}
 	}
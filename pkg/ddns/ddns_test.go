@@ -1,8 +1,15 @@
 package ddns
 
 import (
+	"errors"
+	"net"
 	"os"
+	"slices"
 	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/nezhahq/nezha/pkg/utils"
 )
 
 type testSt struct {
@@ -47,3 +54,92 @@ func TestSplitDomainSOA(t *testing.T) {
 		}
 	}
 }
+
+func TestDNSServers(t *testing.T) {
+	defer InitDNSServers("")
+
+	InitDNSServers(" 10.0.0.1:53, [2001:db8::1]:53 ,")
+	servers := dnsServers()
+	if len(servers) < 3 {
+		t.Fatalf("Expected custom servers followed by fallback servers, but got %v", servers)
+	}
+	if servers[0] != "10.0.0.1:53" || servers[1] != "[2001:db8::1]:53" {
+		t.Fatalf("Expected custom servers first, but got %v", servers)
+	}
+
+	InitDNSServers("")
+	if servers := dnsServers(); !slices.Equal(servers, utils.DNSServers) {
+		t.Fatalf("Expected default servers %v, but got %v", utils.DNSServers, servers)
+	}
+}
+
+// startDNSServer 启动只返回指定 rcode 的本地 DNS 服务器
+func startDNSServer(t *testing.T, rcode int) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetRcode(r, rcode)
+			w.WriteMsg(m)
+		}),
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestExchangeRcode(t *testing.T) {
+	defer InitDNSServers("")
+
+	servfail := startDNSServer(t, dns.RcodeServerFailure)
+	refused := startDNSServer(t, dns.RcodeRefused)
+	nxdomain := startDNSServer(t, dns.RcodeNameError)
+
+	if err := ProbeDNSServer(servfail); err == nil {
+		t.Fatal("Expected probing a server answering SERVFAIL to fail")
+	}
+	if err := ProbeDNSServer(nxdomain); err != nil {
+		t.Fatalf("Expected NXDOMAIN to be a valid answer, but got %v", err)
+	}
+
+	InitDNSServers(servfail + "," + refused + "," + nxdomain)
+	m := new(dns.Msg)
+	m.SetQuestion("example.invalid.", dns.TypeSOA)
+	r, err := exchange(m)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if r.Rcode != dns.RcodeNameError {
+		t.Fatalf("Expected the answer of the NXDOMAIN server, but got %s", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestCloudflareError(t *testing.T) {
+	cases := []struct {
+		err       error
+		permanent bool
+	}{
+		{errors.New("got error status: HTTP 403: [{Code:10000 Message:Authentication error}]"), true},
+		{errors.New("got error status: HTTP 401: [{Code:9109 Message:Invalid access token}]"), true},
+		{errors.New("expected 1 zone, got 0 for example.com."), true},
+		{errors.New("got error status: HTTP 502: []"), false},
+	}
+
+	for _, c := range cases {
+		err := cloudflareError("example.com.", c.err)
+		var pe *permanentError
+		if errors.As(err, &pe) != c.permanent {
+			t.Fatalf("Expected permanent %v for %q, but got %v", c.permanent, c.err, err)
+		}
+		if !errors.Is(err, c.err) {
+			t.Fatalf("Expected %v to wrap %v", err, c.err)
+		}
+	}
+}
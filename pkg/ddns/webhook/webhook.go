@@ -46,13 +46,60 @@ type Provider struct {
 	DDNSProfile *model.DDNSProfile
 }
 
+// ValidateProfile 在保存前检查 Webhook 配置，错误信息中会指明出错的字段
+func ValidateProfile(profile *model.DDNSProfile) error {
+	if _, ok := requestTypes[profile.WebhookMethod]; !ok {
+		return fmt.Errorf("webhook_method: unsupported method %d", profile.WebhookMethod)
+	}
+
+	if profile.WebhookURL == "" {
+		return errors.New("webhook_url: must not be empty")
+	}
+	if _, err := url.Parse(strings.ReplaceAll(profile.WebhookURL, "#", "%23")); err != nil {
+		return fmt.Errorf("webhook_url: %v", err)
+	}
+
+	// 使用示例值替换占位符，检查替换后的内容
+	pw := Provider{
+		ipAddr:      "127.0.0.1",
+		ipType:      "ipv4",
+		recordType:  "A",
+		domain:      "example.com",
+		DDNSProfile: profile,
+	}
+
+	if _, err := utils.GjsonParseStringMap(pw.formatWebhookString(profile.WebhookHeaders)); err != nil {
+		return fmt.Errorf("webhook_headers: must be a JSON object: %v", err)
+	}
+
+	if profile.WebhookMethod == methodGET || profile.WebhookMethod == methodDELETE {
+		return nil
+	}
+
+	switch profile.WebhookRequestType {
+	case requestTypeJSON:
+		body := pw.formatWebhookString(profile.WebhookRequestBody)
+		if body != "" && !utils.Json.Valid([]byte(body)) {
+			return errors.New("webhook_request_body: invalid JSON")
+		}
+	case requestTypeForm:
+		if _, err := utils.GjsonParseStringMap(profile.WebhookRequestBody); err != nil {
+			return fmt.Errorf("webhook_request_body: must be a JSON object: %v", err)
+		}
+	default:
+		return fmt.Errorf("webhook_request_type: unsupported request type %d", profile.WebhookRequestType)
+	}
+
+	return nil
+}
+
 func (provider *Provider) SetRecords(ctx context.Context, zone string,
 	recs []libdns.Record) ([]libdns.Record, error) {
 	for _, rec := range recs {
 		provider.recordType = rec.Type
 		provider.ipType = recordToIPType(provider.recordType)
 		provider.ipAddr = rec.Value
-		provider.domain = fmt.Sprintf("%s.%s", rec.Name, strings.TrimSuffix(zone, "."))
+		provider.domain = strings.TrimSuffix(libdns.AbsoluteName(rec.Name, zone), ".")
 
 		req, err := provider.prepareRequest(ctx)
 		if err != nil {
@@ -2,8 +2,13 @@ package webhook
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
 	"testing"
 
+	"github.com/libdns/libdns"
+
 	"github.com/nezhahq/nezha/model"
 )
 
@@ -114,3 +119,91 @@ func TestWebhookRequest(t *testing.T) {
 		execCase(t, c)
 	}
 }
+
+func TestValidateProfile(t *testing.T) {
+	cases := []struct {
+		profile   model.DDNSProfile
+		expectErr bool
+	}{
+		{
+			profile: model.DDNSProfile{
+				WebhookURL:         "http://ddns.example.com/api",
+				WebhookMethod:      methodPOST,
+				WebhookRequestType: requestTypeJSON,
+				WebhookRequestBody: `{"ip":"#ip#","record":"#record#"}`,
+				WebhookHeaders:     `{"ip":"#ip#"}`,
+			},
+		},
+		{
+			profile: model.DDNSProfile{
+				WebhookURL:    "http://ddns.example.com/?ip=#ip#",
+				WebhookMethod: methodGET,
+			},
+		},
+		{
+			profile: model.DDNSProfile{
+				WebhookURL:    "http://ddns.example.com/api",
+				WebhookMethod: 0,
+			},
+			expectErr: true,
+		},
+		{
+			profile: model.DDNSProfile{
+				WebhookURL:         "http://ddns.example.com/api",
+				WebhookMethod:      methodPOST,
+				WebhookRequestType: requestTypeJSON,
+				WebhookRequestBody: `{"ip":#ip#}`,
+			},
+			expectErr: true,
+		},
+		{
+			profile: model.DDNSProfile{
+				WebhookURL:         "http://ddns.example.com/api",
+				WebhookMethod:      methodPOST,
+				WebhookRequestType: requestTypeForm,
+				WebhookRequestBody: `ip=#ip#`,
+			},
+			expectErr: true,
+		},
+		{
+			profile: model.DDNSProfile{
+				WebhookURL:     "http://ddns.example.com/api",
+				WebhookMethod:  methodGET,
+				WebhookHeaders: `["ip"]`,
+			},
+			expectErr: true,
+		},
+	}
+
+	for i, c := range cases {
+		err := ValidateProfile(&c.profile)
+		if (err != nil) != c.expectErr {
+			t.Fatalf("case %d: expected error %v, but got %v", i, c.expectErr, err)
+		}
+	}
+}
+
+func TestSetRecordsDomain(t *testing.T) {
+	var domains []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains = append(domains, r.URL.Query().Get("domain"))
+	}))
+	defer ts.Close()
+
+	pw := Provider{DDNSProfile: &model.DDNSProfile{
+		WebhookURL:    ts.URL + "/?domain=#domain#",
+		WebhookMethod: methodGET,
+	}}
+	recs := []libdns.Record{
+		{Type: "A", Name: "", Value: "1.1.1.1"},
+		{Type: "A", Name: "www", Value: "1.1.1.1"},
+	}
+	if _, err := pw.SetRecords(context.Background(), "example.com.", recs); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	expected := []string{"example.com", "www.example.com"}
+	if !slices.Equal(domains, expected) {
+		t.Fatalf("Expected %v, but got %v", expected, domains)
+	}
+}
@@ -0,0 +1,111 @@
+package tlsx
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader 提供 tls.Config.GetCertificate 使用的证书，证书或私钥文件变化后自动重新加载
+// 通过定期检查文件的修改时间与大小判断变化，可以跟随 certbot 续期时替换的符号链接
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod fileStamp
+	keyMod  fileStamp
+}
+
+// loadX509KeyPair 测试中替换以模拟加载期间文件发生变化
+var loadX509KeyPair = tls.LoadX509KeyPair
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stat(path string) (fileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// NewCertReloader 加载证书与私钥，并每隔 interval 检查一次文件是否变化
+func NewCertReloader(certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go r.watch(interval)
+	return r, nil
+}
+
+// reload 加载证书与私钥，加载前后文件的状态不一致时说明读到的可能是替换到一半的文件，返回错误等待下次检查
+func (r *CertReloader) reload() error {
+	certMod, keyMod, err := r.stamps()
+	if err != nil {
+		return err
+	}
+	cert, err := loadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if certAfter, keyAfter, err := r.stamps(); err != nil || certAfter != certMod || keyAfter != keyMod {
+		return errors.New("certificate or key file changed while loading")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+	return nil
+}
+
+// stamps 返回证书与私钥文件当前的状态
+func (r *CertReloader) stamps() (certMod, keyMod fileStamp, err error) {
+	if certMod, err = stat(r.certFile); err != nil {
+		return fileStamp{}, fileStamp{}, err
+	}
+	if keyMod, err = stat(r.keyFile); err != nil {
+		return fileStamp{}, fileStamp{}, err
+	}
+	return certMod, keyMod, nil
+}
+
+// watch 检查到文件变化时重新加载，加载失败（如证书与私钥只更新了一个）时继续使用原有的证书，
+// 文件再次变化后重试
+func (r *CertReloader) watch(interval time.Duration) {
+	var failedCert, failedKey fileStamp
+	for range time.Tick(interval) {
+		// 文件暂时无法读取（如正在替换）时等待下次检查
+		certMod, keyMod, err := r.stamps()
+		if err != nil || (certMod == failedCert && keyMod == failedKey) {
+			continue
+		}
+		r.mu.RLock()
+		changed := certMod != r.certMod || keyMod != r.keyMod
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			failedCert, failedKey = certMod, keyMod
+			slog.Warn("failed to reload TLS certificate", "cert", r.certFile, "key", r.keyFile, "error", err)
+			continue
+		}
+		slog.Info("TLS certificate reloaded", "cert", r.certFile)
+	}
+}
+
+// GetCertificate 返回当前的证书，用于 tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
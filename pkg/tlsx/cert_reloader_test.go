@@ -0,0 +1,148 @@
+package tlsx
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair 生成以 name 为 CommonName 的自签名证书，写入文件并设置修改时间
+func writeKeyPair(t *testing.T, certFile, keyFile, name string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modTime)
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	// 显式设置修改时间，避免文件系统的时间精度导致变化检测不到
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+}
+
+func commonName(t *testing.T, r *CertReloader) string {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func waitCommonName(t *testing.T, r *CertReloader, expect string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for commonName(t, r) != expect {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected certificate %s, but got %s", expect, commonName(t, r))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	base := time.Now().Add(-time.Hour)
+	writeKeyPair(t, certFile, keyFile, "first", base)
+
+	r, err := NewCertReloader(certFile, keyFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if name := commonName(t, r); name != "first" {
+		t.Fatalf("Expected certificate first, but got %s", name)
+	}
+
+	writeKeyPair(t, certFile, keyFile, "second", base.Add(time.Minute))
+	waitCommonName(t, r, "second")
+
+	// 只替换了证书时与私钥不匹配，继续使用原有的证书
+	otherCert, otherKey := filepath.Join(dir, "other.pem"), filepath.Join(dir, "other.key")
+	writeKeyPair(t, otherCert, otherKey, "third", base.Add(2*time.Minute))
+	data, err := os.ReadFile(otherCert)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	writeFile(t, certFile, data, base.Add(2*time.Minute))
+	time.Sleep(100 * time.Millisecond)
+	if name := commonName(t, r); name != "second" {
+		t.Fatalf("Expected certificate second to be kept, but got %s", name)
+	}
+
+	// 私钥随后也完成替换
+	data, err = os.ReadFile(otherKey)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	writeFile(t, keyFile, data, base.Add(3*time.Minute))
+	waitCommonName(t, r, "third")
+}
+
+func TestCertReloaderChangedWhileLoading(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	base := time.Now().Add(-time.Hour)
+	writeKeyPair(t, certFile, keyFile, "first", base)
+
+	defer func() { loadX509KeyPair = tls.LoadX509KeyPair }()
+	loadX509KeyPair = func(certFile, keyFile string) (tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		writeKeyPair(t, certFile, keyFile, "second", base.Add(time.Minute))
+		return cert, err
+	}
+
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err == nil {
+		t.Fatal("Expected an error when the files change while loading")
+	}
+	if r.cert != nil {
+		t.Fatal("Expected the certificate not to be replaced")
+	}
+
+	loadX509KeyPair = tls.LoadX509KeyPair
+	if err := r.reload(); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if name := commonName(t, r); name != "second" {
+		t.Fatalf("Expected certificate second, but got %s", name)
+	}
+}
+
+func TestNewCertReloaderMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), time.Minute); err == nil {
+		t.Fatal("Expected an error for missing files")
+	}
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -26,6 +28,56 @@ func init() {
 	http.DefaultClient.Timeout = time.Minute * 10
 }
 
+// NewHTTPClient 按 TLS 校验、代理与超时设置创建 http.Client，不使用代理时复用全局的连接池
+func NewHTTPClient(verifyTLS bool, proxy string, timeout time.Duration) (*http.Client, error) {
+	if timeout <= 0 {
+		timeout = time.Minute * 10
+	}
+
+	var transport http.RoundTripper
+	if proxy == "" {
+		if verifyTLS {
+			transport = HttpClient.Transport
+		} else {
+			transport = HttpClientSkipTlsVerify.Transport
+		}
+	} else {
+		proxyURL, err := ParseProxyURL(proxy)
+		if err != nil {
+			return nil, err
+		}
+		// 关闭 TLS 校验时同样作用于经代理建立的连接，自签名证书的内网地址仍可访问
+		t := httpTransport(_httpTransport{
+			SkipVerifyTLS: !verifyTLS,
+		})
+		t.Proxy = http.ProxyURL(proxyURL)
+		t.DisableKeepAlives = true
+		transport = t
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+// ParseProxyURL 解析代理地址，支持 http、https 与 socks5
+func ParseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy host is empty")
+	}
+	return u, nil
+}
+
 type _httpTransport struct {
 	SkipVerifyTLS bool
 }
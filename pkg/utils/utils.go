@@ -148,6 +148,44 @@ func Itoa[T constraints.Integer](i T) string {
 
 // From go1.23
 
+// VersionAtLeast 判断形如 v1.2.3 的版本号是否不低于 min，忽略 - 或 + 之后的预发布与构建信息，无法解析时返回 false
+func VersionAtLeast(version, min string) bool {
+	v, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+	m, ok := parseVersion(min)
+	if !ok {
+		return false
+	}
+	for i := range v {
+		if v[i] != m[i] {
+			return v[i] > m[i]
+		}
+	}
+	return true
+}
+
+func parseVersion(version string) ([3]uint64, bool) {
+	var v [3]uint64
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
 // Compare returns
 //
 //	-1 if x is less than y,
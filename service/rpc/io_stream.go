@@ -1,12 +1,15 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"io"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/websocket"
+
 	"github.com/nezhahq/nezha/service/singleton"
 )
 
@@ -69,6 +72,48 @@ func (s *NezhaHandler) CloseStream(streamId string) error {
 	return nil
 }
 
+// CloseAllStreams 通知所有已连接的客户端关闭流，并在客户端断开或 ctx 超时后关闭全部流
+func (s *NezhaHandler) CloseAllStreams(ctx context.Context) {
+	s.ioStreamMutex.RLock()
+	for _, stream := range s.ioStreams {
+		// websocket 连接发送关闭帧，WriteControl 自带超时，不会被卡住的客户端阻塞
+		if conn, ok := stream.userIo.(interface {
+			WriteControl(messageType int, data []byte, deadline time.Time) error
+		}); ok {
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(time.Second))
+		}
+	}
+	s.ioStreamMutex.RUnlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+WAIT:
+	for {
+		s.ioStreamMutex.RLock()
+		remaining := len(s.ioStreams)
+		s.ioStreamMutex.RUnlock()
+		if remaining == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			break WAIT
+		case <-ticker.C:
+		}
+	}
+
+	s.ioStreamMutex.RLock()
+	streamIds := make([]string, 0, len(s.ioStreams))
+	for streamId := range s.ioStreams {
+		streamIds = append(streamIds, streamId)
+	}
+	s.ioStreamMutex.RUnlock()
+	for _, streamId := range streamIds {
+		s.CloseStream(streamId)
+	}
+}
+
 func (s *NezhaHandler) UserConnected(streamId string, userIo io.ReadWriteCloser) error {
 	stream, err := s.GetStream(streamId)
 	if err != nil {
@@ -97,6 +142,61 @@ func (s *NezhaHandler) AgentConnected(streamId string, agentIo io.ReadWriteClose
 	return nil
 }
 
+// WaitAgentConnected 等待 Agent 连接到指定的流
+func (s *NezhaHandler) WaitAgentConnected(streamId string, timeout time.Duration) error {
+	stream, err := s.GetStream(streamId)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-stream.agentIoConnectCh:
+		return nil
+	case <-time.After(timeout):
+		return singleton.Localizer.ErrorT("timeout: agent connection not established")
+	}
+}
+
+// AgentReader 等待 Agent 连接到指定的流，返回读取 Agent 写入数据的 Reader
+// Agent 正常关闭流时读取返回 io.EOF，连接中断时返回其他错误
+func (s *NezhaHandler) AgentReader(streamId string, timeout time.Duration) (io.Reader, error) {
+	if err := s.WaitAgentConnected(streamId, timeout); err != nil {
+		return nil, err
+	}
+	stream, err := s.GetStream(streamId)
+	if err != nil {
+		return nil, err
+	}
+	return stream.agentIo, nil
+}
+
+// ReadAgentStream 等待 Agent 连接到指定的流，读取其写入的全部数据直到流结束，最多读取 limit 字节
+func (s *NezhaHandler) ReadAgentStream(streamId string, limit int64, timeout time.Duration) ([]byte, error) {
+	agentIo, err := s.AgentReader(streamId, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		data, err := io.ReadAll(io.LimitReader(agentIo, limit))
+		resultCh <- result{data, err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		return r.data, r.err
+	case <-time.After(timeout):
+		// 关闭流以结束仍在阻塞的读取
+		s.CloseStream(streamId)
+		return nil, singleton.Localizer.ErrorT("timeout: agent did not finish sending data")
+	}
+}
+
 func (s *NezhaHandler) StartStream(streamId string, timeout time.Duration) error {
 	stream, err := s.GetStream(streamId)
 	if err != nil {
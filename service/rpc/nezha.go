@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
@@ -12,8 +13,6 @@ import (
 	geoipx "github.com/nezhahq/nezha/pkg/geoip"
 	"github.com/nezhahq/nezha/pkg/grpcx"
 
-	"github.com/jinzhu/copier"
-
 	"github.com/nezhahq/nezha/model"
 	pb "github.com/nezhahq/nezha/proto"
 	"github.com/nezhahq/nezha/service/singleton"
@@ -23,6 +22,9 @@ var _ pb.NezhaServiceServer = (*NezhaHandler)(nil)
 
 var NezhaHandlerSingleton *NezhaHandler
 
+// reportHostInfoInterval Agent 重连时请求上报主机信息的最小间隔
+const reportHostInfoInterval = time.Minute
+
 type NezhaHandler struct {
 	Auth          *authHandler
 	ioStreams     map[string]*ioStreamContext
@@ -43,28 +45,29 @@ func (s *NezhaHandler) ReportTask(c context.Context, r *pb.TaskResult) (*pb.Rece
 	if clientID, err = s.Auth.Check(c); err != nil {
 		return nil, err
 	}
-	if r.GetType() == model.TaskTypeCommand {
+	if r.GetType() == model.TaskTypeCommand || r.GetType() == model.TaskTypeCommandStream {
 		// 处理上报的计划任务
 		singleton.CronLock.RLock()
 		defer singleton.CronLock.RUnlock()
 		cr := singleton.Crons[r.GetId()]
 		if cr != nil {
+			singleton.OnCronResult(cr.ID, clientID, r.GetSuccessful(), r.GetData(), r.GetDelay())
 			singleton.ServerLock.RLock()
 			defer singleton.ServerLock.RUnlock()
 			// 保存当前服务器状态信息
-			curServer := model.Server{}
-			copier.Copy(&curServer, singleton.ServerList[clientID])
+			curServer := singleton.ServerList[clientID].Snapshot()
 			if cr.PushSuccessful && r.GetSuccessful() {
-				singleton.SendNotification(cr.NotificationGroupID, fmt.Sprintf("[%s] %s, %s\n%s", singleton.Localizer.T("Scheduled Task Executed Successfully"),
-					cr.Name, singleton.ServerList[clientID].Name, r.GetData()), nil, &curServer)
+				go singleton.SendNotification(cr.NotificationGroupID, fmt.Sprintf("[%s] %s, %s\n%s", singleton.Localizer.T("Scheduled Task Executed Successfully"),
+					cr.Name, singleton.ServerList[clientID].Name, r.GetData()), nil, curServer)
 			}
 			if !r.GetSuccessful() {
-				singleton.SendNotification(cr.NotificationGroupID, fmt.Sprintf("[%s] %s, %s\n%s", singleton.Localizer.T("Scheduled Task Executed Failed"),
-					cr.Name, singleton.ServerList[clientID].Name, r.GetData()), nil, &curServer)
+				go singleton.SendNotification(cr.NotificationGroupID, fmt.Sprintf("[%s] %s, %s\n%s", singleton.Localizer.T("Scheduled Task Executed Failed"),
+					cr.Name, singleton.ServerList[clientID].Name, r.GetData()), nil, curServer)
 			}
-			singleton.DB.Model(cr).Updates(model.Cron{
-				LastExecutedAt: time.Now().Add(time.Second * -1 * time.Duration(r.GetDelay())),
-				LastResult:     r.GetSuccessful(),
+			// 执行结果不是用户编辑，不更新 updated_at，避免编辑表单提交时误报冲突
+			singleton.DB.Model(cr).UpdateColumns(map[string]any{
+				"last_executed_at": time.Now().Add(time.Second * -1 * time.Duration(r.GetDelay())),
+				"last_result":      r.GetSuccessful(),
 			})
 		}
 	} else if model.IsServiceSentinelNeeded(r.GetType()) {
@@ -93,7 +96,22 @@ func (s *NezhaHandler) RequestTask(h *pb.Host, stream pb.NezhaService_RequestTas
 	singleton.ServerList[clientID].TaskClose = closeCh
 	singleton.ServerList[clientID].TaskCloseLock.Unlock()
 	singleton.ServerLock.RUnlock()
-	return <-closeCh
+	singleton.OnAgentConnect(clientID)
+
+	// 连接建立后请求 Agent 上报主机信息，短时间内重复重连时只请求一次
+	if singleton.Cache.Add(fmt.Sprintf("reportHostInfo::%d", clientID), struct{}{}, reportHostInfoInterval) == nil {
+		if err := stream.Send(&pb.Task{Type: model.TaskTypeReportHostInfo}); err != nil {
+			slog.Warn("RequestTask: failed to request host info", "server", clientID, "error", err)
+		}
+	}
+	select {
+	case err := <-closeCh:
+		// 同一 Agent 建立了新的连接
+		return err
+	case <-stream.Context().Done():
+		singleton.OnAgentDisconnect(clientID, closeCh)
+		return stream.Context().Err()
+	}
 }
 
 func (s *NezhaHandler) ReportSystemState(stream pb.NezhaService_ReportSystemStateServer) error {
@@ -106,13 +124,13 @@ func (s *NezhaHandler) ReportSystemState(stream pb.NezhaService_ReportSystemStat
 	for {
 		state, err = stream.Recv()
 		if err != nil {
-			log.Printf("NEZHA>> ReportSystemState eror: %v, clientID: %d\n", err, clientID)
+			slog.Warn("ReportSystemState error", "server", clientID, "error", err)
 			return nil
 		}
 		state := model.PB2State(state)
 
 		singleton.ServerLock.RLock()
-		singleton.ServerList[clientID].LastActive = time.Now()
+		singleton.ServerList[clientID].Touch(time.Now())
 		singleton.ServerList[clientID].State = &state
 		// 应对 dashboard 重启的情况，如果从未记录过，先打点，等到小时时间点时入库
 		if singleton.ServerList[clientID].PrevTransferInSnapshot == 0 || singleton.ServerList[clientID].PrevTransferOutSnapshot == 0 {
@@ -146,6 +164,7 @@ func (s *NezhaHandler) ReportSystemInfo(c context.Context, r *pb.Host) (*pb.Rece
 	}
 
 	singleton.ServerList[clientID].Host = &host
+	singleton.ServerList[clientID].Touch(time.Now())
 	return &pb.Receipt{Proced: true}, nil
 }
 
@@ -191,11 +210,16 @@ func (s *NezhaHandler) ReportGeoIP(c context.Context, r *pb.GeoIP) (*pb.GeoIP, e
 		(singleton.ServerList[clientID].GeoIP == nil || singleton.ServerList[clientID].GeoIP.IP != geoip.IP) {
 		ipv4 := geoip.IP.IPv4Addr
 		ipv6 := geoip.IP.IPv6Addr
+		var oldIP string
+		if singleton.ServerList[clientID].GeoIP != nil {
+			oldIP = singleton.ServerList[clientID].GeoIP.IP.Join()
+		}
 		providers, err := singleton.GetDDNSProvidersFromProfiles(singleton.ServerList[clientID].DDNSProfiles, &ddns.IP{Ipv4Addr: ipv4, Ipv6Addr: ipv6})
 		if err == nil {
 			for _, provider := range providers {
 				go func(provider *ddns.Provider) {
-					provider.UpdateDomain(context.Background())
+					records := provider.UpdateDomain(context.Background())
+					singleton.SaveDDNSRecords(clientID, oldIP, records)
 				}(provider)
 			}
 		} else {
@@ -203,23 +227,6 @@ func (s *NezhaHandler) ReportGeoIP(c context.Context, r *pb.GeoIP) (*pb.GeoIP, e
 		}
 	}
 
-	// 发送IP变动通知
-	if singleton.ServerList[clientID].GeoIP != nil && singleton.Conf.EnableIPChangeNotification &&
-		((singleton.Conf.Cover == model.ConfigCoverAll && !singleton.Conf.IgnoredIPNotificationServerIDs[clientID]) ||
-			(singleton.Conf.Cover == model.ConfigCoverIgnoreAll && singleton.Conf.IgnoredIPNotificationServerIDs[clientID])) &&
-		singleton.ServerList[clientID].GeoIP.IP.Join() != "" &&
-		joinedIP != "" &&
-		singleton.ServerList[clientID].GeoIP.IP != geoip.IP {
-
-		singleton.SendNotification(singleton.Conf.IPChangeNotificationGroupID,
-			fmt.Sprintf(
-				"[%s] %s, %s => %s",
-				singleton.Localizer.T("IP Changed"),
-				singleton.ServerList[clientID].Name, singleton.IPDesensitize(singleton.ServerList[clientID].GeoIP.IP.Join()),
-				singleton.IPDesensitize(joinedIP),
-			),
-			nil)
-	}
 	singleton.ServerLock.RUnlock()
 
 	// 根据内置数据库查询 IP 地理位置
@@ -239,8 +246,28 @@ func (s *NezhaHandler) ReportGeoIP(c context.Context, r *pb.GeoIP) (*pb.GeoIP, e
 
 	// 将地区码写入到 Host
 	singleton.ServerLock.Lock()
-	defer singleton.ServerLock.Unlock()
-	singleton.ServerList[clientID].GeoIP = &geoip
+	server := singleton.ServerList[clientID]
+	server.GeoIP = &geoip
+	// 新 IP 需要连续上报多次才视为变更，避免 NAT 后的 IP 抖动产生大量通知
+	oldIP, ipChanged := server.ObserveIP(joinedIP, singleton.Conf.IPChangeNotificationConfirmations)
+	serverName := server.Name
+	singleton.ServerLock.Unlock()
+
+	// 发送IP变动通知
+	inIgnoredList := singleton.Conf.InIgnoredIPNotification(clientID, geoip.IP.IPv4Addr, geoip.IP.IPv6Addr)
+	if ipChanged && singleton.Conf.EnableIPChangeNotification &&
+		((singleton.Conf.Cover == model.ConfigCoverAll && !inIgnoredList) ||
+			(singleton.Conf.Cover == model.ConfigCoverIgnoreAll && inIgnoredList)) {
+
+		go singleton.SendNotification(singleton.Conf.IPChangeNotificationGroupID,
+			fmt.Sprintf(
+				"[%s] %s, %s => %s",
+				singleton.Localizer.T("IP Changed"),
+				serverName, singleton.IPDesensitize(oldIP),
+				singleton.IPDesensitize(joinedIP),
+			),
+			nil)
+	}
 
 	return &pb.GeoIP{Ip: nil, CountryCode: location}, nil
 }
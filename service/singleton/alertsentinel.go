@@ -2,12 +2,11 @@ package singleton
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
-	"github.com/jinzhu/copier"
-
 	"github.com/nezhahq/nezha/model"
 )
 
@@ -15,6 +14,7 @@ const (
 	_RuleCheckNoData = iota
 	_RuleCheckFail
 	_RuleCheckPass
+	_RuleCheckFailInSilence // 故障在静默期内才发生，尚未发送过报警
 )
 
 type NotificationHistory struct {
@@ -26,11 +26,34 @@ type NotificationHistory struct {
 var (
 	AlertsLock                    sync.RWMutex
 	Alerts                        []*model.AlertRule
-	alertsStore                   map[uint64]map[uint64][][]bool       // [alert_id][server_id] -> 对应报警规则的检查结果
-	alertsPrevState               map[uint64]map[uint64]uint8          // [alert_id][server_id] -> 对应报警规则的上一次报警状态
-	AlertsCycleTransferStatsStore map[uint64]*model.CycleTransferStats // [alert_id] -> 对应报警规则的周期流量统计
+	alertsStore                   map[uint64]map[uint64][][]bool              // [alert_id][server_id] -> 对应报警规则的检查结果
+	alertsPrevState               map[uint64]map[uint64]uint8                 // [alert_id][server_id] -> 对应报警规则的上一次报警状态
+	alertsSilencedState           map[uint64]map[uint64]uint8                 // [alert_id][server_id] -> 静默期内被抑制的通知状态
+	alertsFlapState               map[uint64]map[uint64]*model.AlertFlapState // [alert_id][server_id] -> 抖动检测状态
+	alertsEscalation              map[uint64]map[uint64]*alertEscalation      // [alert_id][server_id] -> 持续报警时的再次通知状态
+	AlertsCycleTransferStatsStore map[uint64]*model.CycleTransferStats        // [alert_id] -> 对应报警规则的周期流量统计
+
+	alertsEvalState     map[uint64]map[uint64]*alertEvalState // [alert_id][server_id] -> 最近一次检查结果，供 API 查询
+	alertsEvalStateLock sync.RWMutex
 )
 
+// alertEscalation 报警持续未恢复时的再次通知状态，恢复后清除
+type alertEscalation struct {
+	since  time.Time // 开始报警的时间
+	level  int       // 已再次通知的次数
+	nextAt time.Time // 下一次通知的时间
+}
+
+// alertEvalState 报警规则在单台服务器上最近一次的检查结果
+type alertEvalState struct {
+	passed         bool
+	applicable     bool // 至少有一项规则适用于此服务器并取得了数据
+	lastTransition time.Time
+	checkedAt      time.Time
+	points         []bool
+	values         []*float64
+}
+
 // addCycleTransferStatsInfo 向AlertsCycleTransferStatsStore中添加周期流量报警统计信息
 func addCycleTransferStatsInfo(alert *model.AlertRule) {
 	if !alert.Enabled() {
@@ -61,17 +84,37 @@ func addCycleTransferStatsInfo(alert *model.AlertRule) {
 func AlertSentinelStart() {
 	alertsStore = make(map[uint64]map[uint64][][]bool)
 	alertsPrevState = make(map[uint64]map[uint64]uint8)
+	alertsSilencedState = make(map[uint64]map[uint64]uint8)
+	alertsFlapState = make(map[uint64]map[uint64]*model.AlertFlapState)
+	alertsEscalation = make(map[uint64]map[uint64]*alertEscalation)
 	AlertsCycleTransferStatsStore = make(map[uint64]*model.CycleTransferStats)
+	alertsEvalStateLock.Lock()
+	alertsEvalState = make(map[uint64]map[uint64]*alertEvalState)
+	alertsEvalStateLock.Unlock()
 	AlertsLock.Lock()
 	if err := DB.Find(&Alerts).Error; err != nil {
 		panic(err)
 	}
+	// 恢复抖动状态，避免重启后立即重复通知
+	var flapStates []*model.AlertFlapState
+	if err := DB.Find(&flapStates).Error; err != nil {
+		panic(err)
+	}
+	for _, fs := range flapStates {
+		if alertsFlapState[fs.AlertRuleID] == nil {
+			alertsFlapState[fs.AlertRuleID] = make(map[uint64]*model.AlertFlapState)
+		}
+		alertsFlapState[fs.AlertRuleID][fs.ServerID] = fs
+	}
 	for _, alert := range Alerts {
 		alertsStore[alert.ID] = make(map[uint64][][]bool)
 		alertsPrevState[alert.ID] = make(map[uint64]uint8)
+		alertsSilencedState[alert.ID] = make(map[uint64]uint8)
+		alertsEscalation[alert.ID] = make(map[uint64]*alertEscalation)
 		addCycleTransferStatsInfo(alert)
 	}
 	AlertsLock.Unlock()
+	loadTransferQuotaNotices()
 
 	time.Sleep(time.Second * 10)
 	var lastPrint time.Time
@@ -79,11 +122,10 @@ func AlertSentinelStart() {
 	for {
 		startedAt := time.Now()
 		checkStatus()
+		checkTransferQuota()
 		checkCount++
 		if lastPrint.Before(startedAt.Add(-1 * time.Hour)) {
-			if Conf.Debug {
-				log.Println("NEZHA>> 报警规则检测每小时", checkCount, "次", startedAt, time.Now())
-			}
+			slog.Debug("报警规则检测每小时", "count", checkCount, "started_at", startedAt, "now", time.Now())
 			checkCount = 0
 			lastPrint = startedAt
 		}
@@ -108,8 +150,18 @@ func OnRefreshOrAddAlert(alert *model.AlertRule) {
 	}
 	alertsStore[alert.ID] = make(map[uint64][][]bool)
 	alertsPrevState[alert.ID] = make(map[uint64]uint8)
+	alertsSilencedState[alert.ID] = make(map[uint64]uint8)
+	alertsEscalation[alert.ID] = make(map[uint64]*alertEscalation)
 	delete(AlertsCycleTransferStatsStore, alert.ID)
 	addCycleTransferStatsInfo(alert)
+	alertsEvalStateLock.Lock()
+	delete(alertsEvalState, alert.ID)
+	alertsEvalStateLock.Unlock()
+	// 规则修改后重新统计抖动，持有 AlertsLock 时删除，不会与 checkStatus 保存的状态交错
+	delete(alertsFlapState, alert.ID)
+	if err := DB.Delete(&model.AlertFlapState{}, "alert_rule_id = ?", alert.ID).Error; err != nil {
+		slog.Error("清除报警抖动状态失败", "alert", alert.ID, "error", err)
+	}
 }
 
 func OnDeleteAlert(id []uint64) {
@@ -118,6 +170,9 @@ func OnDeleteAlert(id []uint64) {
 	for _, i := range id {
 		delete(alertsStore, i)
 		delete(alertsPrevState, i)
+		delete(alertsSilencedState, i)
+		delete(alertsFlapState, i)
+		delete(alertsEscalation, i)
 		currentAlerts := Alerts[:0]
 		for _, alert := range Alerts {
 			if alert.ID != i {
@@ -126,6 +181,23 @@ func OnDeleteAlert(id []uint64) {
 		}
 		Alerts = currentAlerts
 		delete(AlertsCycleTransferStatsStore, i)
+		alertsEvalStateLock.Lock()
+		delete(alertsEvalState, i)
+		alertsEvalStateLock.Unlock()
+	}
+}
+
+// OnServerDeleteAlertStates 清除已删除服务器的报警抖动状态
+func OnServerDeleteAlertStates(sid []uint64) {
+	AlertsLock.Lock()
+	defer AlertsLock.Unlock()
+	for _, states := range alertsFlapState {
+		for _, id := range sid {
+			delete(states, id)
+		}
+	}
+	if err := DB.Delete(&model.AlertFlapState{}, "server_id in (?)", sid).Error; err != nil {
+		slog.Error("清除报警抖动状态失败", "servers", sid, "error", err)
 	}
 }
 
@@ -133,45 +205,111 @@ func OnDeleteAlert(id []uint64) {
 func checkStatus() {
 	AlertsLock.RLock()
 	defer AlertsLock.RUnlock()
+
+	// 抖动状态在释放 ServerLock 后再写入数据库，避免阻塞 Agent 上报
+	for _, fs := range evaluateAlerts() {
+		if err := DB.Save(fs).Error; err != nil {
+			slog.Error("保存报警抖动状态失败", "alert", fs.AlertRuleID, "server", fs.ServerID, "error", err)
+		}
+	}
+}
+
+// evaluateAlerts 检查各报警规则，返回有变化、需要保存的抖动状态，调用方需持有 AlertsLock
+func evaluateAlerts() (flapChanged []*model.AlertFlapState) {
 	ServerLock.RLock()
 	defer ServerLock.RUnlock()
 
+	var nq model.NetworkQualityStats
+	if ServiceSentinelShared != nil {
+		nq = ServiceSentinelShared
+	}
+
+	now := time.Now().In(Loc)
+	maintenance := Conf.InMaintenance(now)
+
 	for _, alert := range Alerts {
 		// 跳过未启用
 		if !alert.Enabled() {
 			continue
 		}
+		// 维护模式视为所有规则均处于静默期
+		ruleSilenced := maintenance || alert.Silenced(now)
 		for _, server := range ServerList {
+			// 静音的服务器按静默期处理，取消静音后仍在报警的规则会补发一次通知
+			silenced := ruleSilenced || server.Muted(now)
+			// 按分组检查时，实时判断服务器是否仍在分组内
+			if alert.ServerGroupID != 0 && !ServerInGroup(alert.ServerGroupID, server.ID) {
+				delete(alertsStore[alert.ID], server.ID)
+				delete(alertsPrevState[alert.ID], server.ID)
+				delete(alertsSilencedState[alert.ID], server.ID)
+				delete(alertsEscalation[alert.ID], server.ID)
+				removeAlertEvalState(alert.ID, server.ID)
+				continue
+			}
 			// 监测点
-			alertsStore[alert.ID][server.ID] = append(alertsStore[alert.
-				ID][server.ID], alert.Snapshot(AlertsCycleTransferStatsStore[alert.ID], server, DB))
+			point, values := alert.Evaluate(AlertsCycleTransferStatsStore[alert.ID], server, DB, nq)
+			alertsStore[alert.ID][server.ID] = append(alertsStore[alert.ID][server.ID], point)
 			// 发送通知，分为触发报警和恢复通知
 			max, passed := alert.Check(alertsStore[alert.ID][server.ID])
+			recordAlertEvalState(alert.ID, server.ID, passed, point, values, now)
 			// 保存当前服务器状态信息
-			curServer := model.Server{}
-			copier.Copy(&curServer, server)
+			curServer := server.Snapshot()
+
+			// 抖动期间的状态变化合并为一条通知
+			flapping, fs := checkAlertFlapping(alert, curServer, alertsPrevState[alert.ID][server.ID], passed, silenced, now)
+			if fs != nil {
+				flapChanged = append(flapChanged, fs)
+			}
+
+			// 静默期结束后，补发静默期内被抑制且仍然成立的通知（仅一次）
+			var notified bool
+			if silencedState, ok := alertsSilencedState[alert.ID][server.ID]; ok && !silenced && !flapping {
+				delete(alertsSilencedState[alert.ID], server.ID)
+				if (silencedState == _RuleCheckFail || silencedState == _RuleCheckFailInSilence) && !passed {
+					sendAlertIncident(alert, curServer)
+					notified = true
+				} else if silencedState == _RuleCheckPass && passed {
+					sendAlertResolved(alert, curServer)
+				}
+			}
 
 			// 本次未通过检查
 			if !passed {
+				if silenced {
+					// 静默期内持续报警的规则在静默结束后补发一次
+					if alertsPrevState[alert.ID][server.ID] != _RuleCheckFail {
+						alertsSilencedState[alert.ID][server.ID] = _RuleCheckFailInSilence
+					} else if _, ok := alertsSilencedState[alert.ID][server.ID]; !ok {
+						alertsSilencedState[alert.ID][server.ID] = _RuleCheckFail
+					}
+				}
 				// 始终触发模式或上次检查不为失败时触发报警（跳过单次触发+上次失败的情况）
 				if alert.TriggerMode == model.ModeAlwaysTrigger || alertsPrevState[alert.ID][server.ID] != _RuleCheckFail {
 					alertsPrevState[alert.ID][server.ID] = _RuleCheckFail
-					message := fmt.Sprintf("[%s] %s(%s) %s", Localizer.T("Incident"),
-						server.Name, IPDesensitize(server.GeoIP.IP.Join()), alert.Name)
 					go SendTriggerTasks(alert.FailTriggerTasks, curServer.ID)
-					go SendNotification(alert.NotificationGroupID, message, NotificationMuteLabel.ServerIncident(server.ID, alert.ID), &curServer)
-					// 清除恢复通知的静音缓存
-					UnMuteNotification(alert.NotificationGroupID, NotificationMuteLabel.ServerIncidentResolved(server.ID, alert.ID))
+					if !silenced && !notified && !flapping {
+						sendAlertIncident(alert, curServer)
+					}
+				}
+				if !silenced && !flapping {
+					checkAlertEscalation(alert, curServer, now)
 				}
 			} else {
+				// 恢复后重置再次通知的等级
+				delete(alertsEscalation[alert.ID], server.ID)
 				// 本次通过检查但上一次的状态为失败，则发送恢复通知
 				if alertsPrevState[alert.ID][server.ID] == _RuleCheckFail {
-					message := fmt.Sprintf("[%s] %s(%s) %s", Localizer.T("Resolved"),
-						server.Name, IPDesensitize(server.GeoIP.IP.Join()), alert.Name)
 					go SendTriggerTasks(alert.RecoverTriggerTasks, curServer.ID)
-					go SendNotification(alert.NotificationGroupID, message, NotificationMuteLabel.ServerIncidentResolved(server.ID, alert.ID), &curServer)
-					// 清除失败通知的静音缓存
-					UnMuteNotification(alert.NotificationGroupID, NotificationMuteLabel.ServerIncident(server.ID, alert.ID))
+					if !silenced {
+						if !flapping {
+							sendAlertResolved(alert, curServer)
+						}
+					} else if maintenance || alertsSilencedState[alert.ID][server.ID] == _RuleCheckFailInSilence {
+						// 故障发生和恢复都在静默期内，或在维护期间恢复，无需补发
+						delete(alertsSilencedState[alert.ID], server.ID)
+					} else {
+						alertsSilencedState[alert.ID][server.ID] = _RuleCheckPass
+					}
 				}
 				alertsPrevState[alert.ID][server.ID] = _RuleCheckPass
 			}
@@ -181,4 +319,188 @@ func checkStatus() {
 			}
 		}
 	}
+	return flapChanged
+}
+
+func recordAlertEvalState(alertID, serverID uint64, passed bool, points []bool, values []*float64, now time.Time) {
+	alertsEvalStateLock.Lock()
+	defer alertsEvalStateLock.Unlock()
+	if alertsEvalState[alertID] == nil {
+		alertsEvalState[alertID] = make(map[uint64]*alertEvalState)
+	}
+	st := alertsEvalState[alertID][serverID]
+	if st == nil {
+		st = &alertEvalState{passed: passed, lastTransition: now}
+		alertsEvalState[alertID][serverID] = st
+	} else if st.passed != passed {
+		st.passed = passed
+		st.lastTransition = now
+	}
+	st.checkedAt = now
+	st.points = points
+	st.values = values
+	st.applicable = slices.ContainsFunc(values, func(v *float64) bool { return v != nil })
+}
+
+func removeAlertEvalState(alertID, serverID uint64) {
+	alertsEvalStateLock.Lock()
+	defer alertsEvalStateLock.Unlock()
+	delete(alertsEvalState[alertID], serverID)
+}
+
+// GetAlertRuleState 返回报警规则在其覆盖的各服务器上的当前状态
+func GetAlertRuleState(id uint64) (*model.AlertRuleState, bool) {
+	AlertsLock.RLock()
+	defer AlertsLock.RUnlock()
+
+	idx := slices.IndexFunc(Alerts, func(a *model.AlertRule) bool { return a.ID == id })
+	if idx < 0 {
+		return nil, false
+	}
+	alert := Alerts[idx]
+	state := &model.AlertRuleState{
+		ID:      alert.ID,
+		Enabled: alert.Enabled(),
+		Servers: make([]model.AlertServerState, 0),
+	}
+
+	alertsEvalStateLock.RLock()
+	defer alertsEvalStateLock.RUnlock()
+	SortedServerLock.RLock()
+	defer SortedServerLock.RUnlock()
+	for _, server := range SortedServerList {
+		if alert.ServerGroupID != 0 && !ServerInGroup(alert.ServerGroupID, server.ID) {
+			continue
+		}
+		ss := model.AlertServerState{
+			ServerID:   server.ID,
+			ServerName: server.Name,
+			State:      model.AlertStateUnknown,
+		}
+		// 未启用的规则不再检查，之前的结果已经过时
+		if st := alertsEvalState[alert.ID][server.ID]; st != nil && state.Enabled {
+			if st.applicable {
+				ss.State = model.AlertStateOK
+				if !st.passed {
+					ss.State = model.AlertStateAlarm
+				}
+			}
+			lastTransition, checkedAt := st.lastTransition, st.checkedAt
+			ss.LastTransition, ss.CheckedAt = &lastTransition, &checkedAt
+			for i, rule := range alert.Rules {
+				if i >= len(st.points) {
+					break
+				}
+				ss.Values = append(ss.Values, model.AlertRuleValue{
+					Type:   rule.Type,
+					Value:  st.values[i],
+					Passed: st.points[i],
+				})
+			}
+		}
+		state.Servers = append(state.Servers, ss)
+	}
+	return state, true
+}
+
+func sendAlertIncident(alert *model.AlertRule, server *model.Server) {
+	slog.Info("alert incident", "alert", alert.ID, "server", server.ID)
+	message := fmt.Sprintf("[%s] %s(%s) %s", Localizer.T("Incident"),
+		server.Name, IPDesensitize(server.GeoIP.IP.Join()), alert.Name)
+	go SendNotification(alert.NotificationGroupID, message, NotificationMuteLabel.ServerIncident(server.ID, alert.ID), server)
+	// 清除恢复通知的静音缓存
+	UnMuteNotification(alert.NotificationGroupID, NotificationMuteLabel.ServerIncidentResolved(server.ID, alert.ID))
+}
+
+func sendAlertResolved(alert *model.AlertRule, server *model.Server) {
+	slog.Info("alert resolved", "alert", alert.ID, "server", server.ID)
+	message := fmt.Sprintf("[%s] %s(%s) %s", Localizer.T("Resolved"),
+		server.Name, IPDesensitize(server.GeoIP.IP.Join()), alert.Name)
+	go SendNotification(alert.NotificationGroupID, message, NotificationMuteLabel.ServerIncidentResolved(server.ID, alert.ID), server)
+	// 清除失败通知的静音缓存
+	UnMuteNotification(alert.NotificationGroupID, NotificationMuteLabel.ServerIncident(server.ID, alert.ID))
+}
+
+// checkAlertEscalation 报警持续未恢复时，按 EscalationIntervals 依次再次通知
+func checkAlertEscalation(alert *model.AlertRule, server *model.Server, now time.Time) {
+	if len(alert.EscalationIntervals) == 0 {
+		return
+	}
+	esc := alertsEscalation[alert.ID][server.ID]
+	if esc == nil {
+		// 首次报警由 sendAlertIncident 发出，从此刻开始计时
+		alertsEscalation[alert.ID][server.ID] = &alertEscalation{
+			since:  now,
+			nextAt: now.Add(alert.EscalationInterval(0)),
+		}
+		return
+	}
+	if now.Before(esc.nextAt) {
+		return
+	}
+	esc.level++
+	esc.nextAt = now.Add(alert.EscalationInterval(esc.level))
+	slog.Info("alert escalated", "alert", alert.ID, "server", server.ID, "level", esc.level)
+	message := fmt.Sprintf("[%s #%d] %s(%s) %s, %s", Localizer.T("Escalation"), esc.level,
+		server.Name, IPDesensitize(server.GeoIP.IP.Join()), alert.Name,
+		Localizer.Tf("lasting %s", now.Sub(esc.since).Round(time.Second)))
+	go SendNotification(alert.EscalationGroupID(), message, nil, server)
+}
+
+// checkAlertFlapping 记录状态翻转并判断是否处于抖动状态，flapping 为 true 时不再发送报警/恢复通知
+// 状态有变化时返回需要保存的抖动状态
+func checkAlertFlapping(alert *model.AlertRule, server *model.Server, prevState uint8, passed, silenced bool, now time.Time) (flapping bool, changedState *model.AlertFlapState) {
+	if alert.FlapThreshold == 0 {
+		return false, nil
+	}
+	if alertsFlapState[alert.ID] == nil {
+		alertsFlapState[alert.ID] = make(map[uint64]*model.AlertFlapState)
+	}
+	fs := alertsFlapState[alert.ID][server.ID]
+	if fs == nil {
+		fs = &model.AlertFlapState{AlertRuleID: alert.ID, ServerID: server.ID}
+		alertsFlapState[alert.ID][server.ID] = fs
+	}
+
+	curState := uint8(_RuleCheckPass)
+	if !passed {
+		curState = _RuleCheckFail
+	}
+	// 重启后首次检查没有上一次状态，不计为翻转
+	changed := prevState != _RuleCheckNoData && prevState != curState
+	if changed {
+		fs.Transitions = append(fs.Transitions, now)
+	}
+	countBefore := len(fs.Transitions)
+	fs.Prune(now, alert.FlapDuration())
+	changed = changed || countBefore != len(fs.Transitions)
+
+	var suppress bool
+	switch {
+	case !fs.Flapping && uint64(len(fs.Transitions)) > alert.FlapThreshold:
+		fs.Flapping = true
+		changed = true
+		if !silenced {
+			message := fmt.Sprintf("[%s] %s(%s) %s", Localizer.T("Flapping"),
+				server.Name, IPDesensitize(server.GeoIP.IP.Join()), alert.Name)
+			go SendNotification(alert.NotificationGroupID, message, nil, server)
+		}
+	case fs.Flapping && len(fs.Transitions) == 0:
+		// 窗口期内没有再发生翻转，视为已稳定，发送当前状态
+		fs.Flapping = false
+		changed = true
+		if !silenced {
+			if passed {
+				sendAlertResolved(alert, server)
+			} else {
+				sendAlertIncident(alert, server)
+			}
+		}
+		suppress = true
+	}
+
+	if changed {
+		changedState = fs
+	}
+	return suppress || fs.Flapping, changedState
 }
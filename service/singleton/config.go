@@ -0,0 +1,291 @@
+package singleton
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/i18n"
+)
+
+// ValidateConfig 校验配置项的取值，设置接口与 -check-config 共用
+func ValidateConfig(conf *model.Config) error {
+	if _, err := getTranslationArchive(conf.Language); err != nil {
+		return Localizer.ErrorT("language: unsupported language %s", conf.Language)
+	}
+
+	if _, _, err := conf.Listen(); err != nil {
+		return Localizer.ErrorT("listen_addr: %v", err)
+	}
+
+	if (conf.TLSCertFile == "") != (conf.TLSKeyFile == "") {
+		return Localizer.ErrorT("tls_cert_file and tls_key_file must be set together")
+	}
+	if conf.TLSCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(conf.TLSCertFile, conf.TLSKeyFile); err != nil {
+			return Localizer.ErrorT("tls_cert_file: %v", err)
+		}
+	}
+
+	if conf.ACMEHosts != "" {
+		if conf.TLSCertFile != "" {
+			return Localizer.ErrorT("acme_hosts cannot be used together with tls_cert_file")
+		}
+		for _, host := range conf.ACMEHostList() {
+			if _, err := netip.ParseAddr(host); err == nil || !model.IsValidHostname(host) {
+				return Localizer.ErrorT("acme_hosts: %s is not a valid domain name", host)
+			}
+		}
+	}
+	if conf.ACMEDirectoryURL != "" {
+		if u, err := url.Parse(conf.ACMEDirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return Localizer.ErrorT("acme_directory_url: must be an https URL")
+		}
+	}
+
+	if conf.DNSServers != "" {
+		for _, server := range strings.Split(conf.DNSServers, ",") {
+			server = strings.TrimSpace(server)
+			host, port, err := net.SplitHostPort(server)
+			if err == nil {
+				_, err = netip.ParseAddr(host)
+			}
+			if err == nil {
+				_, err = strconv.ParseUint(port, 10, 16)
+			}
+			if err != nil {
+				return Localizer.ErrorT("custom_nameservers: invalid DNS server %s, expected ip:port ([ip]:port for IPv6)", server)
+			}
+		}
+	}
+
+	if conf.IgnoredIPNotification != "" {
+		for _, entry := range strings.Split(conf.IgnoredIPNotification, ",") {
+			entry = strings.TrimSpace(entry)
+			if _, err := strconv.ParseUint(entry, 10, 64); err == nil {
+				continue
+			}
+			if _, err := model.ParseIPOrPrefix(entry); err != nil {
+				return Localizer.ErrorT("ignored_ip_notification: %s is not a server id, IP or CIDR", entry)
+			}
+		}
+	}
+
+	if err := ValidateCronSpec(conf.CleanHistorySchedule); err != nil {
+		return Localizer.ErrorT("clean_history_schedule: %v", err)
+	}
+	if conf.IPChangeNotificationConfirmations < 1 {
+		return Localizer.ErrorT("ip_change_notification_confirmations: must be at least 1")
+	}
+	if _, err := model.ParseLogLevel(conf.LogLevel); err != nil {
+		return Localizer.ErrorT("log_level: invalid log level %s", conf.LogLevel)
+	}
+	if conf.BcryptCost < bcrypt.MinCost || conf.BcryptCost > bcrypt.MaxCost {
+		return Localizer.ErrorT("bcrypt_cost: must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if conf.PasswordMinLength < 6 || conf.PasswordMinLength > passwordMaxLength {
+		return Localizer.ErrorT("password_min_length: must be between %d and %d", 6, passwordMaxLength)
+	}
+	if conf.PasswordMinCharClasses < 1 || conf.PasswordMinCharClasses > 4 {
+		return Localizer.ErrorT("password_min_char_classes: must be between 1 and 4")
+	}
+	if conf.ServerOfflineThreshold < 10 {
+		return Localizer.ErrorT("server_offline_threshold: must be at least 10 seconds")
+	}
+	if conf.ServiceHistoryRetentionDays < 1 {
+		return Localizer.ErrorT("service_history_retention_days: must be at least 1 day")
+	}
+	if conf.TransferHistoryRetentionDays < 1 {
+		return Localizer.ErrorT("transfer_history_retention_days: must be at least 1 day")
+	}
+	if conf.AuditLogRetentionDays < 1 {
+		return Localizer.ErrorT("audit_log_retention_days: must be at least 1 day")
+	}
+	if len(conf.TransferQuotaThresholds) == 0 {
+		return Localizer.ErrorT("transfer_quota_thresholds: need at least one threshold")
+	}
+	if err := model.ValidateTransferQuotaThresholds(conf.TransferQuotaThresholds); err != nil {
+		return err
+	}
+
+	// 离线校验时无法读取数据库，NotificationGroup 为 nil，跳过对通知组的校验
+	if conf.EnableIPChangeNotification && NotificationGroup != nil {
+		NotificationGroupLock.RLock()
+		_, ok := NotificationGroup[conf.IPChangeNotificationGroupID]
+		NotificationGroupLock.RUnlock()
+		if !ok {
+			return Localizer.ErrorT("ip_change_notification_group_id: notification group id %d does not exist", conf.IPChangeNotificationGroupID)
+		}
+	}
+	if conf.EnableServerStateNotification && NotificationGroup != nil {
+		NotificationGroupLock.RLock()
+		_, ok := NotificationGroup[conf.ServerStateNotificationGroupID]
+		NotificationGroupLock.RUnlock()
+		if !ok {
+			return Localizer.ErrorT("server_state_notification_group_id: notification group id %d does not exist", conf.ServerStateNotificationGroupID)
+		}
+	}
+
+	return nil
+}
+
+// CheckConfig 加载并校验配置文件，不会写入配置文件或迁移数据库，返回发现的所有问题
+// 数据库可用时额外检查配置中引用的通知组是否存在
+func CheckConfig(configPath, dbPath string) []error {
+	if _, err := os.Stat(configPath); err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	unknown, err := model.UnknownConfigKeys(configPath)
+	if err != nil {
+		return []error{err}
+	}
+	for _, key := range unknown {
+		errs = append(errs, fmt.Errorf("%s: unknown key", key))
+	}
+
+	Conf = &model.Config{}
+	if err := Conf.Load(configPath); err != nil {
+		return append(errs, err)
+	}
+
+	// 语言无效时使用英文输出校验结果，语言本身的错误由 ValidateConfig 报告
+	lang := Conf.Language
+	if _, err := getTranslationArchive(lang); err != nil {
+		lang = "en_US"
+	}
+	data, err := getTranslationArchive(lang)
+	if err != nil {
+		return append(errs, err)
+	}
+	Localizer = i18n.NewLocalizer(lang, domain, domain+".zip", data)
+
+	if _, err := time.LoadLocation(Conf.Location); err != nil {
+		errs = append(errs, fmt.Errorf("location: %w", err))
+	}
+
+	switch Conf.DatabaseDriver {
+	case "", "sqlite", "postgres":
+		groups, err := loadNotificationGroupIDs(dbPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("database: %w", err))
+		}
+		NotificationGroup = groups
+	default:
+		errs = append(errs, fmt.Errorf("database_driver: unsupported database driver %s", Conf.DatabaseDriver))
+	}
+
+	if err := ValidateConfig(Conf); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// loadNotificationGroupIDs 只读地查询已有的通知组，sqlite 数据库文件不存在时返回 nil
+func loadNotificationGroupIDs(dbPath string) (map[uint64]string, error) {
+	if Conf.DatabaseDSN == "" {
+		if _, err := os.Stat(dbPath); err != nil {
+			return nil, nil
+		}
+	}
+	db, err := gorm.Open(openDialector(dbPath), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	var groups []model.NotificationGroup
+	if err := db.Select("id", "name").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	m := make(map[uint64]string, len(groups))
+	for _, g := range groups {
+		m[g.ID] = g.Name
+	}
+	return m, nil
+}
+
+// ReloadConfig 重新读取配置文件，校验通过后应用可以热更新的配置项
+// 监听端口、数据库等需要重启才能生效的配置项保持当前的值，仅记录日志
+func ReloadConfig(path string) error {
+	conf := &model.Config{}
+	if err := conf.Load(path); err != nil {
+		return err
+	}
+	// 配置文件中未设置密钥时沿用当前的密钥，重新加载不会生成新的密钥
+	if conf.AgentSecretKey == "" {
+		conf.AgentSecretKey = Conf.AgentSecretKey
+	}
+
+	ConfLock.Lock()
+	defer ConfLock.Unlock()
+
+	old := Conf
+	keepRestartOnlyConfig(conf, old)
+	if err := ValidateConfig(conf); err != nil {
+		return err
+	}
+
+	Conf = conf
+	if conf.CleanHistorySchedule != old.CleanHistorySchedule {
+		if err := SetCleanHistorySchedule(conf.CleanHistorySchedule); err != nil {
+			return err
+		}
+	}
+	if err := SetLogLevel(conf.LogLevel); err != nil {
+		return err
+	}
+	OnNameserverUpdate()
+	if err := OnUpdateLang(conf.Language); err != nil {
+		return err
+	}
+	slog.Info("config reloaded", "path", path)
+	return nil
+}
+
+// keepRestartOnlyConfig 将需要重启才能生效的配置项恢复为运行中的值，有变化的记录日志
+// JWT 密钥同时用于加密两步验证密钥等数据，运行中替换会导致已保存的数据无法解密
+func keepRestartOnlyConfig(conf, old *model.Config) {
+	keep := func(key string, changed bool) {
+		if changed {
+			slog.Warn("config changed but requires a restart to take effect", "key", key)
+		}
+	}
+	keep("listen_port", conf.ListenPort != old.ListenPort)
+	keep("listen_addr", conf.ListenAddr != old.ListenAddr)
+	keep("tls_cert_file", conf.TLSCertFile != old.TLSCertFile)
+	keep("tls_key_file", conf.TLSKeyFile != old.TLSKeyFile)
+	keep("acme_hosts", conf.ACMEHosts != old.ACMEHosts)
+	keep("acme_email", conf.ACMEEmail != old.ACMEEmail)
+	keep("acme_cache_dir", conf.ACMECacheDir != old.ACMECacheDir)
+	keep("acme_directory_url", conf.ACMEDirectoryURL != old.ACMEDirectoryURL)
+	keep("debug", conf.Debug != old.Debug)
+	keep("location", conf.Location != old.Location)
+	keep("database_driver", conf.DatabaseDriver != old.DatabaseDriver)
+	keep("database_dsn", conf.DatabaseDSN != old.DatabaseDSN)
+	keep("notification_max_concurrency", conf.NotificationMaxConcurrency != old.NotificationMaxConcurrency)
+	keep("jwt_secret_key", conf.JWTSecretKey != "" && conf.JWTSecretKey != old.JWTSecretKey)
+
+	conf.ListenPort, conf.ListenAddr = old.ListenPort, old.ListenAddr
+	conf.TLSCertFile, conf.TLSKeyFile = old.TLSCertFile, old.TLSKeyFile
+	conf.ACMEHosts, conf.ACMEEmail, conf.ACMECacheDir, conf.ACMEDirectoryURL = old.ACMEHosts, old.ACMEEmail, old.ACMECacheDir, old.ACMEDirectoryURL
+	conf.Debug, conf.Location = old.Debug, old.Location
+	conf.DatabaseDriver, conf.DatabaseDSN = old.DatabaseDriver, old.DatabaseDSN
+	conf.NotificationMaxConcurrency = old.NotificationMaxConcurrency
+	conf.JWTSecretKey = old.JWTSecretKey
+}
@@ -2,11 +2,12 @@ package singleton
 
 import (
 	"fmt"
+	"log/slog"
 	"slices"
 	"strings"
 	"sync"
-
-	"github.com/jinzhu/copier"
+	"sync/atomic"
+	"time"
 
 	"github.com/robfig/cron/v3"
 
@@ -15,17 +16,60 @@ import (
 	pb "github.com/nezhahq/nezha/proto"
 )
 
+const (
+	_DefaultCronRetryInterval = 10 * time.Second
+	_CronTimeoutGrace         = 10 * time.Second
+	_CronStaleRunTimeout      = 24 * time.Hour // 未设置 Timeout 时，运行中标记的最长有效期
+)
+
 var (
 	Cron     *cron.Cron
 	Crons    map[uint64]*model.Cron // [CronID] -> *model.Cron
 	CronLock sync.RWMutex
 
 	CronList []*model.Cron
+
+	cronRunning     map[uint64]map[uint64]cronRun // [CronID] -> [ServerID] -> 运行中的任务
+	cronRunningLock sync.Mutex
+
+	cronHistoryLock sync.Mutex
+
+	cronHeartbeat atomic.Int64 // 调度器最近一次执行心跳任务的时间
 )
 
+type cronRun struct {
+	dispatchedAt time.Time
+	history      *model.CronHistory
+}
+
+// cronParser 与调度器 cron.WithSeconds() 使用的解析规则一致
+var cronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+var cronFieldNames = []string{"second", "minute", "hour", "day of month", "month", "day of week"}
+
+// ValidateCronSpec 校验计划任务表达式，出错时尽量指出是哪一个字段有误
+func ValidateCronSpec(spec string) error {
+	_, err := cronParser.Parse(spec)
+	if err == nil {
+		return nil
+	}
+	fields := strings.Fields(spec)
+	if len(fields) == len(cronFieldNames) {
+		for i, field := range fields {
+			probe := []string{"*", "*", "*", "*", "*", "*"}
+			probe[i] = field
+			if _, fieldErr := cronParser.Parse(strings.Join(probe, " ")); fieldErr != nil {
+				return Localizer.ErrorT("invalid cron expression %q: %s field %q: %v", spec, Localizer.T(cronFieldNames[i]), field, fieldErr)
+			}
+		}
+	}
+	return Localizer.ErrorT("invalid cron expression %q: %v", spec, err)
+}
+
 func InitCronTask() {
 	Cron = cron.New(cron.WithSeconds(), cron.WithLocation(Loc))
 	Crons = make(map[uint64]*model.Cron)
+	cronRunning = make(map[uint64]map[uint64]cronRun)
 }
 
 // loadCronTasks 加载计划任务
@@ -36,13 +80,13 @@ func loadCronTasks() {
 	var notificationGroupList []uint64
 	notificationMsgMap := make(map[uint64]*strings.Builder)
 	for _, cron := range CronList {
-		// 触发任务类型无需注册
-		if cron.TaskType == model.CronTypeTriggerTask {
+		// 触发任务类型与已暂停的任务无需注册
+		if cron.TaskType == model.CronTypeTriggerTask || cron.Paused {
 			Crons[cron.ID] = cron
 			continue
 		}
 		// 注册计划任务
-		cron.CronJobID, err = Cron.AddFunc(cron.Scheduler, CronTrigger(cron))
+		cron.CronJobID, err = Cron.AddFunc(cron.ScheduleSpec(), CronTrigger(cron))
 		if err == nil {
 			Crons[cron.ID] = cron
 		} else {
@@ -58,11 +102,24 @@ func loadCronTasks() {
 	// 向注册错误的计划任务所在通知组发送通知
 	for _, gid := range notificationGroupList {
 		notificationMsgMap[gid].WriteString(Localizer.T("] These tasks will not execute properly. Fix them in the admin dashboard."))
-		SendNotification(gid, notificationMsgMap[gid].String(), nil)
+		go SendNotification(gid, notificationMsgMap[gid].String(), nil)
+	}
+	// 检查执行超时的计划任务
+	if _, err := Cron.AddFunc("@every 10s", checkCronTimeout); err != nil {
+		panic(err)
 	}
+	if _, err := Cron.AddFunc("@every 10s", func() { cronHeartbeat.Store(time.Now().Unix()) }); err != nil {
+		panic(err)
+	}
+	cronHeartbeat.Store(time.Now().Unix())
 	Cron.Start()
 }
 
+// CronAlive 调度器是否仍在正常执行任务
+func CronAlive() bool {
+	return time.Since(time.Unix(cronHeartbeat.Load(), 0)) < 30*time.Second
+}
+
 func OnRefreshOrAddCron(c *model.Cron) {
 	CronLock.Lock()
 	defer CronLock.Unlock()
@@ -100,15 +157,21 @@ func OnDeleteCron(id []uint64) {
 	}
 }
 
-func ManualTrigger(c *model.Cron) {
-	CronTrigger(c)()
+// CronStreamOpener 为下发到指定服务器的命令创建实时输出流，返回流 ID
+type CronStreamOpener func(serverID uint64) (string, error)
+
+// ManualTrigger 手动执行计划任务，返回各服务器的下发结果
+// openStream 不为空时命令以 TaskTypeCommandStream 下发，Agent 将输出实时写入其创建的流，
+// Agent 版本低于 AgentMinVersionCommandStream 的服务器仍以 TaskTypeCommand 下发
+func ManualTrigger(c *model.Cron, openStream CronStreamOpener) *model.CronTriggerResponse {
+	return dispatchCron(c, true, openStream)
 }
 
 func SendTriggerTasks(taskIDs []uint64, triggerServer uint64) {
 	CronLock.RLock()
 	var cronLists []*model.Cron
 	for _, taskID := range taskIDs {
-		if c, ok := Crons[taskID]; ok {
+		if c, ok := Crons[taskID]; ok && !c.Paused {
 			cronLists = append(cronLists, c)
 		}
 	}
@@ -121,55 +184,320 @@ func SendTriggerTasks(taskIDs []uint64, triggerServer uint64) {
 }
 
 func CronTrigger(cr *model.Cron, triggerServer ...uint64) func() {
+	return func() {
+		if cr.SkipIfRunning && cronIsRunning(cr) {
+			slog.Info("cron is still running, skipping this run", "cron", cr.ID, "name", cr.Name)
+			return
+		}
+		dispatchCron(cr, false, nil, triggerServer...)
+	}
+}
+
+// cronIsRunning 计划任务是否仍有服务器未上报结果
+// 超过 Timeout 的标记会由 checkCronTimeout 清除，未设置 Timeout 时超过 _CronStaleRunTimeout 的标记同样会被清除
+func cronIsRunning(cr *model.Cron) bool {
+	cronRunningLock.Lock()
+	defer cronRunningLock.Unlock()
+	return len(cronRunning[cr.ID]) > 0
+}
+
+// dispatchCron 向计划任务覆盖的服务器下发命令，离线的服务器会发送失败通知
+func dispatchCron(cr *model.Cron, manual bool, openStream CronStreamOpener, triggerServer ...uint64) *model.CronTriggerResponse {
+	resp := new(model.CronTriggerResponse)
+	MetricCronExecutions.Inc()
+	slog.Debug("dispatching cron", "cron", cr.ID, "name", cr.Name, "manual", manual)
+
 	crIgnoreMap := make(map[uint64]bool)
 	for j := 0; j < len(cr.Servers); j++ {
 		crIgnoreMap[cr.Servers[j]] = true
 	}
-	return func() {
-		if cr.Cover == model.CronCoverAlertTrigger {
-			if len(triggerServer) == 0 {
-				return
+
+	ServerLock.RLock()
+	defer ServerLock.RUnlock()
+
+	var servers []*model.Server
+	if cr.Cover == model.CronCoverAlertTrigger {
+		if len(triggerServer) == 0 {
+			return resp
+		}
+		if s, ok := ServerList[triggerServer[0]]; ok {
+			servers = append(servers, s)
+		}
+	} else {
+		for _, s := range ServerList {
+			if cr.Cover == model.CronCoverAll && crIgnoreMap[s.ID] {
+				continue
 			}
-			ServerLock.RLock()
-			defer ServerLock.RUnlock()
-			if s, ok := ServerList[triggerServer[0]]; ok {
-				if s.TaskStream != nil {
-					s.TaskStream.Send(&pb.Task{
-						Id:   cr.ID,
-						Data: cr.Command,
-						Type: model.TaskTypeCommand,
-					})
-				} else {
-					// 保存当前服务器状态信息
-					curServer := model.Server{}
-					copier.Copy(&curServer, s)
-					SendNotification(cr.NotificationGroupID, Localizer.Tf("[Task failed] %s: server %s is offline and cannot execute the task", cr.Name, s.Name), nil, &curServer)
+			if cr.Cover == model.CronCoverIgnoreAll && !crIgnoreMap[s.ID] {
+				continue
+			}
+			servers = append(servers, s)
+		}
+	}
+
+	// 确定要执行后再写入执行记录，并在下发前写入，保证 Agent 上报结果时记录已存在
+	history := &model.CronHistory{CronID: cr.ID, Manual: manual}
+	if err := DB.Create(history).Error; err != nil {
+		slog.Error("failed to create cron history", "cron", cr.ID, "error", err)
+	}
+
+	var pending []uint64
+	for _, s := range servers {
+		if s.TaskStream == nil {
+			setCronResult(history, s.ID, model.CronResultStatusOffline, "", 0)
+			resp.Offline = append(resp.Offline, s.ID)
+			pending = append(pending, s.ID)
+			continue
+		}
+		if err := sendCronCommand(cr, s, history, openStream); err != nil {
+			resp.Failure = append(resp.Failure, s.ID)
+			pending = append(pending, s.ID)
+		} else {
+			resp.Success = append(resp.Success, s.ID)
+		}
+	}
+
+	if len(servers) == 0 {
+		history.Finished, history.FinishedAt = true, time.Now()
+	}
+	saveCronHistory(history)
+
+	if len(pending) > 0 {
+		if cr.MaxRetries > 0 {
+			go retryCronDispatch(cr, history, pending)
+		} else {
+			notifyCronDispatchFailure(cr, pending)
+		}
+	}
+
+	return resp
+}
+
+func sendCronCommand(cr *model.Cron, s *model.Server, history *model.CronHistory, openStream CronStreamOpener) error {
+	if s.TaskStream == nil {
+		setCronResult(history, s.ID, model.CronResultStatusOffline, "", 0)
+		return fmt.Errorf("server %d is offline", s.ID)
+	}
+
+	// 在下发前标记为运行中，避免 Agent 上报结果早于标记
+	setCronResult(history, s.ID, model.CronResultStatusRunning, "", 0)
+	cronRunningLock.Lock()
+	if cronRunning[cr.ID] == nil {
+		cronRunning[cr.ID] = make(map[uint64]cronRun)
+	}
+	prev, hasPrev := cronRunning[cr.ID][s.ID]
+	cronRunning[cr.ID][s.ID] = cronRun{dispatchedAt: time.Now(), history: history}
+	cronRunningLock.Unlock()
+	// 上一次执行尚未上报结果，之后上报的结果无法区分属于哪一次，上一次的结果记为未知
+	if hasPrev && prev.history != history {
+		setCronResult(prev.history, s.ID, model.CronResultStatusUnknown, "", 0)
+		saveCronHistory(prev.history)
+	}
+
+	task := &pb.Task{
+		Id:      cr.ID,
+		Data:    cr.Command,
+		Type:    model.TaskTypeCommand,
+		Timeout: cr.Timeout,
+	}
+	// 不支持实时回传的 Agent 按普通命令执行，不创建输出流
+	if openStream != nil && s.AgentVersionAtLeast(model.AgentMinVersionCommandStream) {
+		if streamId, err := openStream(s.ID); err != nil {
+			slog.Warn("failed to open cron output stream", "cron", cr.ID, "server", s.ID, "error", err)
+		} else {
+			data, _ := utils.Json.Marshal(model.TaskCommandStream{StreamID: streamId, Command: cr.Command})
+			task.Data, task.Type = string(data), model.TaskTypeCommandStream
+		}
+	}
+
+	if err := s.TaskStream.Send(task); err != nil {
+		takeCronRun(cr.ID, s.ID)
+		setCronResult(history, s.ID, model.CronResultStatusFailure, err.Error(), 0)
+		return err
+	}
+	return nil
+}
+
+// takeCronRun 清除并返回运行中标记
+func takeCronRun(cronID, serverID uint64) (cronRun, bool) {
+	cronRunningLock.Lock()
+	defer cronRunningLock.Unlock()
+	servers, ok := cronRunning[cronID]
+	if !ok {
+		return cronRun{}, false
+	}
+	run, ok := servers[serverID]
+	delete(servers, serverID)
+	if len(servers) == 0 {
+		delete(cronRunning, cronID)
+	}
+	return run, ok
+}
+
+// OnCronResult 收到 Agent 上报的计划任务执行结果后，清除运行中标记并写入执行记录
+func OnCronResult(cronID, serverID uint64, successful bool, output string, delay float32) {
+	run, ok := takeCronRun(cronID, serverID)
+	if !ok {
+		return
+	}
+	status := model.CronResultStatusFailure
+	if successful {
+		status = model.CronResultStatusSuccess
+	}
+	setCronResult(run.history, serverID, status, output, delay)
+	saveCronHistory(run.history)
+}
+
+func setCronResult(history *model.CronHistory, serverID uint64, status, output string, delay float32) {
+	if history == nil {
+		return
+	}
+	cronHistoryLock.Lock()
+	defer cronHistoryLock.Unlock()
+	history.SetResult(serverID, status, output, delay)
+}
+
+func saveCronHistory(history *model.CronHistory) {
+	if history == nil || history.ID == 0 {
+		return
+	}
+	cronHistoryLock.Lock()
+	defer cronHistoryLock.Unlock()
+	if err := DB.Save(history).Error; err != nil {
+		slog.Error("failed to save cron history", "cron", history.CronID, "error", err)
+	}
+}
+
+// checkCronTimeout 将超过 Timeout 仍未上报结果的计划任务标记为超时，未设置 Timeout 的超过 _CronStaleRunTimeout 后标记为未知
+func checkCronTimeout() {
+	type timedOutRun struct {
+		cron     *model.Cron
+		serverID uint64
+		history  *model.CronHistory
+	}
+
+	now := time.Now()
+	var timedOut, stale []timedOutRun
+
+	CronLock.RLock()
+	cronRunningLock.Lock()
+	for cronID, servers := range cronRunning {
+		cr := Crons[cronID]
+		if cr == nil {
+			delete(cronRunning, cronID)
+			continue
+		}
+		for serverID, run := range servers {
+			if cr.Timeout == 0 {
+				// 未设置 Timeout 且长时间没有上报结果时清除标记，避免一直占用内存
+				if now.Sub(run.dispatchedAt) >= _CronStaleRunTimeout {
+					delete(servers, serverID)
+					stale = append(stale, timedOutRun{cron: cr, serverID: serverID, history: run.history})
 				}
+				continue
 			}
-			return
+			if now.Sub(run.dispatchedAt) < time.Duration(cr.Timeout)*time.Second+_CronTimeoutGrace {
+				continue
+			}
+			delete(servers, serverID)
+			timedOut = append(timedOut, timedOutRun{cron: cr, serverID: serverID, history: run.history})
+		}
+		if len(servers) == 0 {
+			delete(cronRunning, cronID)
 		}
+	}
+	cronRunningLock.Unlock()
+	CronLock.RUnlock()
+
+	for _, run := range stale {
+		setCronResult(run.history, run.serverID, model.CronResultStatusUnknown, "", 0)
+		saveCronHistory(run.history)
+	}
+	if len(timedOut) == 0 {
+		return
+	}
 
+	ServerLock.RLock()
+	defer ServerLock.RUnlock()
+	for _, run := range timedOut {
+		setCronResult(run.history, run.serverID, model.CronResultStatusTimeout, "", 0)
+		saveCronHistory(run.history)
+		DB.Model(run.cron).UpdateColumns(map[string]interface{}{
+			"last_executed_at": now,
+			"last_result":      false,
+		})
+		s, ok := ServerList[run.serverID]
+		if !ok {
+			continue
+		}
+		curServer := s.Snapshot()
+		go SendNotification(run.cron.NotificationGroupID, Localizer.Tf("[Task failed] %s: no result from server %s within %d seconds", run.cron.Name, s.Name, run.cron.Timeout), nil, curServer)
+	}
+}
+
+// retryCronDispatch 按退避间隔重试下发失败的计划任务，服务器重新上线时立即重试
+func retryCronDispatch(cr *model.Cron, history *model.CronHistory, serverIDs []uint64) {
+	interval := time.Duration(cr.RetryInterval) * time.Second
+	if interval == 0 {
+		interval = _DefaultCronRetryInterval
+	}
+
+	for attempt := uint64(0); attempt < cr.MaxRetries && len(serverIDs) > 0; attempt++ {
+		waitForAnyServerOnline(serverIDs, interval<<attempt)
+
+		var failed []uint64
 		ServerLock.RLock()
-		defer ServerLock.RUnlock()
-		for _, s := range ServerList {
-			if cr.Cover == model.CronCoverAll && crIgnoreMap[s.ID] {
+		for _, id := range serverIDs {
+			s, ok := ServerList[id]
+			if !ok {
+				// 服务器已被删除，无需重试
 				continue
 			}
-			if cr.Cover == model.CronCoverIgnoreAll && !crIgnoreMap[s.ID] {
-				continue
+			if err := sendCronCommand(cr, s, history, nil); err != nil {
+				failed = append(failed, id)
 			}
-			if s.TaskStream != nil {
-				s.TaskStream.Send(&pb.Task{
-					Id:   cr.ID,
-					Data: cr.Command,
-					Type: model.TaskTypeCommand,
-				})
-			} else {
-				// 保存当前服务器状态信息
-				curServer := model.Server{}
-				copier.Copy(&curServer, s)
-				SendNotification(cr.NotificationGroupID, Localizer.Tf("[Task failed] %s: server %s is offline and cannot execute the task", cr.Name, s.Name), nil, &curServer)
+		}
+		ServerLock.RUnlock()
+		saveCronHistory(history)
+		serverIDs = failed
+	}
+
+	if len(serverIDs) > 0 {
+		ServerLock.RLock()
+		notifyCronDispatchFailure(cr, serverIDs)
+		ServerLock.RUnlock()
+	}
+}
+
+// waitForAnyServerOnline 等待 timeout，期间任一服务器上线则提前返回
+func waitForAnyServerOnline(serverIDs []uint64, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		ServerLock.RLock()
+		for _, id := range serverIDs {
+			if s, ok := ServerList[id]; ok && s.TaskStream != nil {
+				ServerLock.RUnlock()
+				return
 			}
 		}
+		ServerLock.RUnlock()
+	}
+}
+
+// notifyCronDispatchFailure 向计划任务所属通知组发送下发失败的通知，调用方需持有 ServerLock
+func notifyCronDispatchFailure(cr *model.Cron, serverIDs []uint64) {
+	for _, id := range serverIDs {
+		s, ok := ServerList[id]
+		if !ok {
+			continue
+		}
+		// 保存当前服务器状态信息
+		curServer := s.Snapshot()
+		if s.TaskStream == nil {
+			go SendNotification(cr.NotificationGroupID, Localizer.Tf("[Task failed] %s: server %s is offline and cannot execute the task", cr.Name, s.Name), nil, curServer)
+		} else {
+			go SendNotification(cr.NotificationGroupID, Localizer.Tf("[Task failed] %s: failed to dispatch the task to server %s", cr.Name, s.Name), nil, curServer)
+		}
 	}
 }
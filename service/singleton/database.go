@@ -0,0 +1,173 @@
+package singleton
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/nezhahq/nezha/model"
+)
+
+// dbModels 需要自动迁移的数据表，也决定了从 sqlite 导入数据时的顺序
+var dbModels = []any{
+	model.Server{}, model.User{}, model.ServerGroup{}, model.NotificationGroup{},
+	model.Notification{}, model.AlertRule{}, model.Service{}, model.NotificationGroupNotification{},
+	model.ServiceHistory{}, model.Cron{}, model.Transfer{}, model.ServerGroupServer{}, model.UserGroup{},
+	model.UserGroupUser{}, model.NAT{}, model.DDNSProfile{},
+	model.WAF{}, model.FailedNotification{}, model.DDNSRecord{}, model.AlertFlapState{}, model.Oauth2Bind{},
+	model.CronHistory{}, model.Session{}, model.AuditLog{}, model.TransferQuotaNotice{},
+}
+
+// InitDBFromPath 从给出的文件路径中加载数据库
+// 配置了 DatabaseDSN 时连接 PostgreSQL，此时 path 仅用于首次启动时导入已有的 sqlite 数据
+func InitDBFromPath(path string) {
+	var err error
+	DB, err = gorm.Open(openDialector(path), &gorm.Config{
+		CreateBatchSize: 200,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if Conf.Debug {
+		DB = DB.Debug()
+	}
+	if err = adaptDataTypes(DB); err != nil {
+		panic(err)
+	}
+	err = DB.AutoMigrate(dbModels...)
+	if err != nil {
+		panic(err)
+	}
+
+	if DB.Dialector.Name() != "sqlite" {
+		if err := importFromSQLite(path); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func openDialector(path string) gorm.Dialector {
+	if Conf.DatabaseDSN == "" {
+		if Conf.DatabaseDriver != "" && Conf.DatabaseDriver != "sqlite" {
+			log.Printf("NEZHA>> database_dsn is not set, falling back to sqlite: %s", path)
+		}
+		return sqlite.Open(path)
+	}
+	switch Conf.DBDriver() {
+	case "postgres":
+		return postgres.Open(Conf.DatabaseDSN)
+	case "sqlite":
+		return sqlite.Open(Conf.DatabaseDSN)
+	default:
+		panic(fmt.Sprintf("unsupported database driver: %s", Conf.DatabaseDriver))
+	}
+}
+
+// postgresDataTypes 沿用 sqlite 数据库中的列类型，以免升级时修改已有的表，在 PostgreSQL 中替换为对应的类型
+var postgresDataTypes = map[schema.DataType]schema.DataType{
+	"longtext":   "text",
+	"binary(16)": "bytea",
+}
+
+// adaptDataTypes 按当前数据库替换模型中 PostgreSQL 不支持的列类型，需要在 AutoMigrate 之前调用
+func adaptDataTypes(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	for _, m := range dbModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			return err
+		}
+		for _, field := range stmt.Schema.Fields {
+			if t, ok := postgresDataTypes[field.DataType]; ok {
+				field.DataType = t
+			}
+		}
+	}
+	return nil
+}
+
+// importFromSQLite 新的数据库为空且 sqlite 数据库文件存在时，将其中的数据全部导入
+func importFromSQLite(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	var usersCount int64
+	if err := DB.Model(&model.User{}).Count(&usersCount).Error; err != nil {
+		return err
+	}
+	if usersCount > 0 {
+		return nil
+	}
+
+	src, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if db, err := src.DB(); err == nil {
+			db.Close()
+		}
+	}()
+	if err := src.AutoMigrate(dbModels...); err != nil {
+		return err
+	}
+
+	log.Printf("NEZHA>> importing data from sqlite: %s", path)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, m := range dbModels {
+			if err := copyTable(src, tx, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func copyTable(src, dst *gorm.DB, m any) error {
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(m)))
+	err := src.Model(m).FindInBatches(rows.Interface(), 500, func(_ *gorm.DB, _ int) error {
+		return dst.Create(rows.Interface()).Error
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	// 导入时保留了原有的主键，需要同步自增序列，否则后续插入会产生主键冲突
+	stmt := &gorm.Statement{DB: dst}
+	if err := stmt.Parse(m); err != nil {
+		return err
+	}
+	field := stmt.Schema.PrioritizedPrimaryField
+	if field == nil || !field.AutoIncrement {
+		return nil
+	}
+	table, column := stmt.Quote(stmt.Schema.Table), stmt.Quote(field.DBName)
+	return dst.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence(?, ?), COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false)", column, table),
+		stmt.Schema.Table, field.DBName).Error
+}
+
+// DatabaseSize 返回数据库占用的字节数
+func DatabaseSize() (int64, error) {
+	var size int64
+	if DB.Dialector.Name() == "postgres" {
+		err := DB.Raw("SELECT pg_database_size(current_database())").Scan(&size).Error
+		return size, err
+	}
+
+	var pageCount, pageSize int64
+	if err := DB.Raw("PRAGMA page_count").Scan(&pageCount).Error; err != nil {
+		return 0, err
+	}
+	if err := DB.Raw("PRAGMA page_size").Scan(&pageSize).Error; err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
@@ -0,0 +1,92 @@
+package singleton
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/nezhahq/nezha/model"
+)
+
+func TestAutoMigrateKeepsSQLiteColumnTypes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sqlite.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adaptDataTypes(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(dbModels...); err != nil {
+		t.Fatal(err)
+	}
+
+	tableSQL := func() map[string]string {
+		var rows []struct{ Name, SQL string }
+		if err := db.Raw("SELECT name, sql FROM sqlite_master WHERE type = 'table'").Scan(&rows).Error; err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]string)
+		for _, r := range rows {
+			m[r.Name] = r.SQL
+		}
+		return m
+	}
+
+	before := tableSQL()
+	// 与旧版本一致的列类型，升级时 AutoMigrate 不会重建这些表
+	for table, column := range map[string]string{
+		"notifications": "`request_header` longtext",
+		"users":         "`password` char(72)",
+		"waf":           "`ip` binary(16)",
+	} {
+		if !strings.Contains(before[table], column) {
+			t.Fatalf("Expected %s to contain %s, but got %s", table, column, before[table])
+		}
+	}
+
+	if err := db.AutoMigrate(dbModels...); err != nil {
+		t.Fatal(err)
+	}
+	for table, sql := range tableSQL() {
+		if before[table] != sql {
+			t.Fatalf("Expected %s not to be altered, but got %s", table, sql)
+		}
+	}
+}
+
+func TestAdaptDataTypesPostgres(t *testing.T) {
+	// 不连接数据库，只检查生成的列类型
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1", WithoutQuotingCheck: true}),
+		&gorm.Config{DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adaptDataTypes(db); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		model  any
+		field  string
+		expect string
+	}{
+		{&model.Notification{}, "RequestHeader", "text"},
+		{&model.FailedNotification{}, "Message", "text"},
+		{&model.User{}, "Password", "char(72)"},
+		{&model.WAF{}, "IP", "bytea"},
+	}
+	for _, c := range cases {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(c.model); err != nil {
+			t.Fatal(err)
+		}
+		if got := db.Migrator().FullDataTypeOf(stmt.Schema.LookUpField(c.field)).SQL; !strings.HasPrefix(got, c.expect) {
+			t.Fatalf("%T.%s: expected %s, but got %s", c.model, c.field, c.expect, got)
+		}
+	}
+}
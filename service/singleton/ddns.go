@@ -2,7 +2,9 @@ package singleton
 
 import (
 	"fmt"
+	"log"
 	"slices"
+	"strings"
 	"sync"
 
 	"github.com/libdns/cloudflare"
@@ -61,10 +63,37 @@ func UpdateDDNSList() {
 	})
 }
 
+// SaveDDNSRecords 保存 DDNS 更新记录
+func SaveDDNSRecords(serverID uint64, oldIP string, records []model.DDNSRecord) {
+	if len(records) == 0 {
+		return
+	}
+	for i := range records {
+		records[i].ServerID = serverID
+		records[i].OldIP = oldIP
+	}
+	if err := DB.Create(&records).Error; err != nil {
+		log.Printf("NEZHA>> 保存DDNS更新记录失败: %v", err)
+	}
+}
+
 func OnNameserverUpdate() {
 	ddns2.InitDNSServers(Conf.DNSServers)
 }
 
+// CheckNameservers 依次确认自定义 DNS 服务器可以正常响应查询
+func CheckNameservers(servers string) error {
+	for _, server := range strings.Split(servers, ",") {
+		if server = strings.TrimSpace(server); server == "" {
+			continue
+		}
+		if err := ddns2.ProbeDNSServer(server); err != nil {
+			return Localizer.ErrorT("custom_nameservers: DNS server %s is unreachable: %v", server, err)
+		}
+	}
+	return nil
+}
+
 func GetDDNSProvidersFromProfiles(profileId []uint64, ip *ddns2.IP) ([]*ddns2.Provider, error) {
 	profiles := make([]*model.DDNSProfile, 0, len(profileId))
 	DDNSCacheLock.RLock()
@@ -0,0 +1,31 @@
+package singleton
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/nezhahq/nezha/model"
+)
+
+var logLevel = new(slog.LevelVar)
+
+// InitLogger 初始化带级别的结构化日志，标准库 log 的输出会以 INFO 级别写入同一个 Handler
+func InitLogger() {
+	if err := SetLogLevel(Conf.LogLevel); err != nil {
+		panic(err)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+}
+
+// SetLogLevel 修改日志级别，立即生效；为空时 debug 模式下使用 debug，否则使用 info
+func SetLogLevel(level string) error {
+	if level == "" && Conf.Debug {
+		level = "debug"
+	}
+	l, err := model.ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(l)
+	return nil
+}
@@ -0,0 +1,56 @@
+package singleton
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus 指标，通过 /metrics 暴露
+var (
+	MetricServiceTasksDispatched = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nezha_service_tasks_dispatched_total",
+		Help: "Number of service monitor tasks dispatched to agents.",
+	})
+	MetricNotificationsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nezha_notifications_sent_total",
+		Help: "Number of notification deliveries, partitioned by result.",
+	}, []string{"result"})
+	MetricCronExecutions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nezha_cron_executions_total",
+		Help: "Number of cron task executions.",
+	})
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "nezha_servers",
+		Help: "Number of servers.",
+	}, func() float64 {
+		ServerLock.RLock()
+		defer ServerLock.RUnlock()
+		return float64(len(ServerList))
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "nezha_servers_online",
+		Help: "Number of servers with a connected agent.",
+	}, func() float64 {
+		ServerLock.RLock()
+		defer ServerLock.RUnlock()
+		var online int
+		for _, s := range ServerList {
+			if s.TaskStream != nil {
+				online++
+			}
+		}
+		return float64(online)
+	})
+}
+
+// recordNotificationResult 记录一次通知发送的结果
+func recordNotificationResult(err error) {
+	if err != nil {
+		MetricNotificationsSent.WithLabelValues("failure").Inc()
+		return
+	}
+	MetricNotificationsSent.WithLabelValues("success").Inc()
+}
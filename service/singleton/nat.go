@@ -1,6 +1,7 @@
 package singleton
 
 import (
+	"log"
 	"slices"
 	"sync"
 
@@ -31,8 +32,15 @@ func OnNATUpdate(n *model.NAT) {
 	NATCacheRwLock.Lock()
 	defer NATCacheRwLock.Unlock()
 
-	if oldDomain, ok := NATIDToDomain[n.ID]; ok && oldDomain != n.Domain {
-		delete(NATCache, oldDomain)
+	if oldDomain, ok := NATIDToDomain[n.ID]; ok {
+		// 目标未变化时保留探测状态
+		if old := NATCache[oldDomain]; old != nil && old != n && n.Status.LastCheckedAt.IsZero() &&
+			old.ServerID == n.ServerID && old.Host == n.Host {
+			n.Status = old.Status
+		}
+		if oldDomain != n.Domain {
+			delete(NATCache, oldDomain)
+		}
 	}
 
 	NATCache[n.Domain] = n
@@ -64,6 +72,44 @@ func UpdateNATList() {
 	})
 }
 
+func GetNATConfigByID(id uint64) *model.NAT {
+	NATCacheRwLock.RLock()
+	defer NATCacheRwLock.RUnlock()
+	if domain, ok := NATIDToDomain[id]; ok {
+		return NATCache[domain]
+	}
+	return nil
+}
+
+// SetNATStatus 更新探测状态，在线状态发生变化时通过 OnNATUpdate 刷新缓存
+func SetNATStatus(id uint64, status model.NATStatus) {
+	NATCacheRwLock.Lock()
+	var n *model.NAT
+	if domain, ok := NATIDToDomain[id]; ok {
+		n = NATCache[domain]
+	}
+	if n == nil {
+		NATCacheRwLock.Unlock()
+		return
+	}
+	if n.Status.Online == status.Online && !n.Status.LastCheckedAt.IsZero() {
+		n.Status = status
+		NATCacheRwLock.Unlock()
+		return
+	}
+	updated := *n
+	NATCacheRwLock.Unlock()
+
+	updated.Status = status
+	if status.Online {
+		log.Printf("NEZHA>> 内网穿透 %s(%s) 目标恢复可达", updated.Name, updated.Host)
+	} else {
+		log.Printf("NEZHA>> 内网穿透 %s(%s) 目标不可达: %s", updated.Name, updated.Host, status.Error)
+	}
+	OnNATUpdate(&updated)
+	UpdateNATList()
+}
+
 func GetNATConfigByDomain(domain string) *model.NAT {
 	NATCacheRwLock.RLock()
 	defer NATCacheRwLock.RUnlock()
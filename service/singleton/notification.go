@@ -2,7 +2,7 @@ package singleton
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"slices"
 	"sync"
 	"time"
@@ -13,6 +13,9 @@ import (
 
 const (
 	firstNotificationDelay = time.Minute * 15
+
+	notificationMaxAttempts  = 3
+	notificationRetryBackoff = time.Second * 2
 )
 
 // 通知方式
@@ -26,6 +29,9 @@ var (
 
 	NotificationsLock     sync.RWMutex
 	NotificationGroupLock sync.RWMutex
+
+	// 限制同时进行的通知请求数，容量为 Conf.NotificationMaxConcurrency
+	notificationSendSem chan struct{}
 )
 
 // InitNotification 初始化 GroupID <-> ID <-> Notification 的映射
@@ -33,6 +39,7 @@ func InitNotification() {
 	NotificationList = make(map[uint64]map[uint64]*model.Notification)
 	NotificationIDToGroups = make(map[uint64]map[uint64]struct{})
 	NotificationGroup = make(map[uint64]string)
+	notificationSendSem = make(chan struct{}, Conf.NotificationMaxConcurrency)
 }
 
 // loadNotifications 从 DB 初始化通知方式相关参数
@@ -219,7 +226,25 @@ func UnMuteNotification(notificationGroupID uint64, muteLabel *string) {
 }
 
 // SendNotification 向指定的通知方式组的所有通知方式发送通知
+// 超过 NotificationMaxConcurrency 时会排队等待，持有锁的调用方需在新的 goroutine 中调用
 func SendNotification(notificationGroupID uint64, desc string, muteLabel *string, ext ...*model.Server) {
+	var server *model.Server
+	if len(ext) > 0 {
+		server = ext[0]
+	}
+	sendNotification(notificationGroupID, desc, muteLabel, server, nil)
+}
+
+// SendServiceNotification 发送服务监控相关的通知，通知模板中可引用 .Service 及上报的 .Server
+func SendServiceNotification(notificationGroupID uint64, desc string, muteLabel *string, service *model.Service, reporter *model.Server) {
+	sendNotification(notificationGroupID, desc, muteLabel, reporter, service)
+}
+
+func sendNotification(notificationGroupID uint64, desc string, muteLabel *string, server *model.Server, service *model.Service) {
+	if Conf.InMaintenance(time.Now()) {
+		slog.Debug("维护模式中，不发送通知", "message", desc)
+		return
+	}
 	if muteLabel != nil {
 		// 将通知方式组名称加入静音标志
 		muteLabel := *NotificationMuteLabel.AppendNotificationGroupName(muteLabel, notificationGroupID)
@@ -248,31 +273,32 @@ func SendNotification(notificationGroupID uint64, desc string, muteLabel *string
 		}
 
 		if !flag {
-			if Conf.Debug {
-				log.Println("NEZHA>> 静音的重复通知：", desc, muteLabel)
-			}
+			slog.Debug("静音的重复通知", "message", desc, "mute_label", muteLabel)
 			return
 		}
 	}
-	// 向该通知方式组的所有通知方式发出通知
+	// 向该通知方式组的所有通知方式发出通知，发送可能需要排队，不在持有锁时进行
 	NotificationsLock.RLock()
-	defer NotificationsLock.RUnlock()
+	notifications := make([]*model.Notification, 0, len(NotificationList[notificationGroupID]))
 	for _, n := range NotificationList[notificationGroupID] {
-		log.Println("NEZHA>> 尝试通知", n.Name)
+		slog.Debug("尝试通知", "notification", n.Name)
+		notifications = append(notifications, n)
 	}
-	for _, n := range NotificationList[notificationGroupID] {
+	NotificationsLock.RUnlock()
+	for _, n := range notifications {
 		ns := model.NotificationServerBundle{
 			Notification: n,
-			Server:       nil,
+			Server:       server,
+			Service:      service,
 			Loc:          Loc,
 		}
-		if len(ext) > 0 {
-			ns.Server = ext[0]
-		}
-		if err := ns.Send(desc); err != nil {
-			log.Println("NEZHA>> 向 ", n.Name, " 发送通知失败：", err)
+		err := sendLimited(&ns, desc)
+		recordNotificationResult(err)
+		if err != nil {
+			slog.Warn("发送通知失败", "notification", n.Name, "error", err)
+			go retryNotification(notificationGroupID, ns, desc, err)
 		} else {
-			log.Println("NEZHA>> 向 ", n.Name, " 发送通知成功：")
+			slog.Debug("发送通知成功", "notification", n.Name)
 		}
 	}
 }
@@ -322,3 +348,78 @@ func (_NotificationMuteLabel) ServiceTLS(serviceId uint64, extraInfo string) *st
 	label := fmt.Sprintf("bf::stls-%d-%s", serviceId, extraInfo)
 	return &label
 }
+
+// sendLimited 在并发数未超过 Conf.NotificationMaxConcurrency 时发送通知，否则排队等待
+func sendLimited(ns *model.NotificationServerBundle, message string) error {
+	notificationSendSem <- struct{}{}
+	defer func() { <-notificationSendSem }()
+	return ns.Send(message)
+}
+
+// retryNotification 以指数退避重试发送失败的通知，最终失败时写入 FailedNotification 表
+func retryNotification(notificationGroupID uint64, ns model.NotificationServerBundle, desc string, err error) {
+	backoff := notificationRetryBackoff
+	for attempt := 1; attempt < notificationMaxAttempts; attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = sendLimited(&ns, desc)
+		recordNotificationResult(err)
+		if err == nil {
+			slog.Info("重试发送通知成功", "notification", ns.Notification.Name, "attempt", attempt+1)
+			return
+		}
+		slog.Warn("重试发送通知失败", "notification", ns.Notification.Name, "attempt", attempt+1, "error", err)
+	}
+
+	fn := model.FailedNotification{
+		NotificationID:      ns.Notification.ID,
+		NotificationGroupID: notificationGroupID,
+		Message:             desc,
+		Error:               err.Error(),
+	}
+	if ns.Server != nil {
+		fn.ServerID = ns.Server.ID
+	}
+	if ns.Service != nil {
+		fn.ServiceID = ns.Service.ID
+	}
+	if err := DB.Create(&fn).Error; err != nil {
+		slog.Error("保存发送失败的通知失败", "notification", ns.Notification.Name, "error", err)
+	}
+}
+
+// ReplayFailedNotification 重新发送一条发送失败的通知，成功后将其移出失败列表
+func ReplayFailedNotification(fn *model.FailedNotification) error {
+	NotificationsLock.RLock()
+	n, ok := NotificationMap[fn.NotificationID]
+	NotificationsLock.RUnlock()
+	if !ok {
+		return Localizer.ErrorT("notification id %d does not exist", fn.NotificationID)
+	}
+
+	ns := model.NotificationServerBundle{
+		Notification: n,
+		Loc:          Loc,
+	}
+	if fn.ServerID != 0 {
+		ServerLock.RLock()
+		if s, ok := ServerList[fn.ServerID]; ok {
+			ns.Server = s.Snapshot()
+		}
+		ServerLock.RUnlock()
+	}
+	if fn.ServiceID != 0 {
+		ServiceSentinelShared.ServicesLock.RLock()
+		if s, ok := ServiceSentinelShared.Services[fn.ServiceID]; ok {
+			curService := *s
+			ns.Service = &curService
+		}
+		ServiceSentinelShared.ServicesLock.RUnlock()
+	}
+
+	if err := sendLimited(&ns, fn.Message); err != nil {
+		DB.Model(fn).Update("error", err.Error())
+		return err
+	}
+	return DB.Delete(fn).Error
+}
@@ -0,0 +1,59 @@
+package singleton
+
+import (
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordMaxLength bcrypt 只使用密码的前 72 个字节
+const passwordMaxLength = 72
+
+// HashPassword 按配置的 bcrypt cost 计算密码哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), Conf.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// PasswordNeedsRehash 判断密码哈希的 cost 是否与当前配置不同
+func PasswordNeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost != Conf.BcryptCost
+}
+
+// ValidatePassword 检查密码是否满足密码策略
+func ValidatePassword(password string) error {
+	if len([]rune(password)) < Conf.PasswordMinLength {
+		return Localizer.ErrorT("password must be at least %d characters", Conf.PasswordMinLength)
+	}
+	if len(password) > passwordMaxLength {
+		return Localizer.ErrorT("password must be at most %d bytes", passwordMaxLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	var classes int
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < Conf.PasswordMinCharClasses {
+		return Localizer.ErrorT("password is too weak: it must contain at least %d of lowercase letters, uppercase letters, digits and symbols", Conf.PasswordMinCharClasses)
+	}
+	return nil
+}
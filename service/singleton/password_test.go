@@ -0,0 +1,109 @@
+package singleton
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/i18n"
+)
+
+func setupPasswordPolicy(t *testing.T, minLength, minCharClasses, bcryptCost int) {
+	conf, localizer := Conf, Localizer
+	t.Cleanup(func() {
+		Conf, Localizer = conf, localizer
+	})
+	Conf = &model.Config{
+		PasswordMinLength:      minLength,
+		PasswordMinCharClasses: minCharClasses,
+		BcryptCost:             bcryptCost,
+	}
+	// 未加载翻译时返回原文
+	Localizer = &i18n.Localizer{}
+}
+
+func TestValidatePassword(t *testing.T) {
+	setupPasswordPolicy(t, 8, 3, bcrypt.MinCost)
+
+	cases := []struct {
+		password string
+		valid    bool
+	}{
+		{"", false},
+		{"Ab1!", false},                          // 过短
+		{"Abcdef1", false},                       // 7 个字符
+		{"Abcdefg1", true},                       // 8 个字符，三类
+		{"abcdefgh", false},                      // 只有小写字母
+		{"abcdefg1", false},                      // 两类
+		{"abcdef1!", true},                       // 小写、数字、符号
+		{"ABCDEF1!", true},                       // 大写、数字、符号
+		{"密码密码密码Ab1", true},                      // 按字符而不是字节计算长度，中文计为符号
+		{"密码Ab1", false},                         // 5 个字符
+		{"Aa1" + strings.Repeat("x", 69), true},  // 72 字节
+		{"Aa1" + strings.Repeat("x", 70), false}, // 73 字节
+		{"Aa1" + strings.Repeat("密", 23), true},  // 72 字节
+		{"Aa1" + strings.Repeat("密", 24), false}, // 75 字节，超过 bcrypt 的上限
+		{"Aa" + strings.Repeat(" ", 6), true},    // 空格计为符号
+		{strings.Repeat("1", 7) + "é", false},    // 数字与小写字母
+		{strings.Repeat("1", 6) + "éÉ", true},    // 非 ASCII 的大小写字母同样计数
+	}
+
+	for _, c := range cases {
+		if err := ValidatePassword(c.password); (err == nil) != c.valid {
+			t.Fatalf("Password %q: expected valid %v, but got error %v", c.password, c.valid, err)
+		}
+	}
+}
+
+func TestValidatePasswordMinCharClasses(t *testing.T) {
+	cases := []struct {
+		minCharClasses int
+		password       string
+		valid          bool
+	}{
+		{1, "abcdefgh", true},
+		{2, "abcdefgh", false},
+		{2, "abcdefg1", true},
+		{4, "Abcdefg1", false},
+		{4, "Abcdef1!", true},
+	}
+
+	for _, c := range cases {
+		setupPasswordPolicy(t, 8, c.minCharClasses, bcrypt.MinCost)
+		if err := ValidatePassword(c.password); (err == nil) != c.valid {
+			t.Fatalf("Password %q with %d classes: expected valid %v, but got error %v", c.password, c.minCharClasses, c.valid, err)
+		}
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	setupPasswordPolicy(t, 8, 2, bcrypt.MinCost)
+
+	hash, err := HashPassword("Abcdefg1")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(hash)); cost != bcrypt.MinCost {
+		t.Fatalf("Expected cost %d, but got %d", bcrypt.MinCost, cost)
+	}
+
+	cases := []struct {
+		cost   int
+		hash   string
+		rehash bool
+	}{
+		{bcrypt.MinCost, hash, false},
+		{bcrypt.MinCost + 1, hash, true},
+		{bcrypt.MinCost + 1, "not a bcrypt hash", false},
+		{bcrypt.MinCost, "", false},
+	}
+
+	for _, c := range cases {
+		Conf.BcryptCost = c.cost
+		if got := PasswordNeedsRehash(c.hash); got != c.rehash {
+			t.Fatalf("Hash %q with cost %d: expected %v, but got %v", c.hash, c.cost, c.rehash, got)
+		}
+	}
+}
@@ -27,12 +27,12 @@ func loadServers() {
 	InitServer()
 	var servers []model.Server
 	DB.Find(&servers)
-	for _, s := range servers {
-		innerS := s
+	for i := range servers {
+		innerS := &servers[i]
 		innerS.Host = &model.Host{}
 		innerS.State = &model.HostState{}
 		innerS.TaskCloseLock = new(sync.Mutex)
-		ServerList[innerS.ID] = &innerS
+		ServerList[innerS.ID] = innerS
 		ServerUUIDToID[innerS.UUID] = innerS.ID
 	}
 	ReSortServer()
@@ -70,6 +70,27 @@ func ReSortServer() {
 	})
 }
 
+// ReloadServerSortKeys 从数据库重新读取影响排序的字段并重新排序，用于直接修改数据库之后
+func ReloadServerSortKeys() error {
+	var servers []model.Server
+	if err := DB.Select("id", "display_index", "hide_for_guest").Find(&servers).Error; err != nil {
+		return err
+	}
+
+	ServerLock.Lock()
+	for i := range servers {
+		s := &servers[i]
+		if server, ok := ServerList[s.ID]; ok {
+			server.DisplayIndex = s.DisplayIndex
+			server.HideForGuest = s.HideForGuest
+		}
+	}
+	ServerLock.Unlock()
+
+	ReSortServer()
+	return nil
+}
+
 func OnServerDelete(sid []uint64) {
 	ServerLock.Lock()
 	defer ServerLock.Unlock()
@@ -78,4 +99,12 @@ func OnServerDelete(sid []uint64) {
 		delete(ServerUUIDToID, serverUUID)
 		delete(ServerList, id)
 	}
+
+	ServerGroupLock.Lock()
+	defer ServerGroupLock.Unlock()
+	for _, servers := range ServerGroupToIDList {
+		for _, id := range sid {
+			delete(servers, id)
+		}
+	}
 }
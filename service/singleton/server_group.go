@@ -0,0 +1,69 @@
+package singleton
+
+import (
+	"sync"
+
+	"github.com/nezhahq/nezha/model"
+)
+
+var (
+	ServerGroupToIDList map[uint64]map[uint64]struct{} // [ServerGroupID] -> ServerID 集合
+	ServerGroupLock     sync.RWMutex
+)
+
+// loadServerGroups 加载服务器分组与服务器的对应关系
+func loadServerGroups() {
+	ServerGroupLock.Lock()
+	defer ServerGroupLock.Unlock()
+
+	ServerGroupToIDList = make(map[uint64]map[uint64]struct{})
+	var groups []model.ServerGroup
+	DB.Find(&groups)
+	for _, g := range groups {
+		ServerGroupToIDList[g.ID] = make(map[uint64]struct{})
+	}
+	var sgs []model.ServerGroupServer
+	DB.Find(&sgs)
+	for _, s := range sgs {
+		if servers, ok := ServerGroupToIDList[s.ServerGroupId]; ok {
+			servers[s.ServerId] = struct{}{}
+		}
+	}
+}
+
+func OnServerGroupUpdate(groupID uint64, servers []uint64) {
+	ServerGroupLock.Lock()
+	defer ServerGroupLock.Unlock()
+
+	ids := make(map[uint64]struct{}, len(servers))
+	for _, s := range servers {
+		ids[s] = struct{}{}
+	}
+	ServerGroupToIDList[groupID] = ids
+}
+
+func OnServerGroupDelete(groupIDs []uint64) {
+	ServerGroupLock.Lock()
+	defer ServerGroupLock.Unlock()
+
+	for _, id := range groupIDs {
+		delete(ServerGroupToIDList, id)
+	}
+}
+
+// ServerInGroup 判断服务器当前是否属于指定分组
+func ServerInGroup(groupID, serverID uint64) bool {
+	ServerGroupLock.RLock()
+	defer ServerGroupLock.RUnlock()
+
+	_, ok := ServerGroupToIDList[groupID][serverID]
+	return ok
+}
+
+func ServerGroupExists(groupID uint64) bool {
+	ServerGroupLock.RLock()
+	defer ServerGroupLock.RUnlock()
+
+	_, ok := ServerGroupToIDList[groupID]
+	return ok
+}
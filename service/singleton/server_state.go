@@ -0,0 +1,107 @@
+package singleton
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 服务器上下线通知
+var (
+	serverConnLock   sync.Mutex
+	serverConnStates = make(map[uint64]*serverConnState) // [server_id] -> Agent 连接状态
+)
+
+type serverConnState struct {
+	offlineTimer *time.Timer // 断开后等待 ServerOfflineThreshold 秒再通知，期间重连则取消
+	offline      bool        // 已发送下线通知，重连后发送上线通知
+}
+
+// OnAgentConnect Agent 建立任务连接时调用，取消尚未发出的下线通知，已通知下线的发送上线通知
+func OnAgentConnect(serverID uint64) {
+	serverConnLock.Lock()
+	defer serverConnLock.Unlock()
+
+	st := serverConnStates[serverID]
+	if st == nil {
+		return
+	}
+	if st.offlineTimer != nil {
+		st.offlineTimer.Stop()
+	}
+	delete(serverConnStates, serverID)
+	if st.offline {
+		sendServerStateNotification(serverID, Localizer.T("Server Online"), "")
+	}
+}
+
+// OnAgentDisconnect Agent 的任务连接断开时调用，超过 ServerOfflineThreshold 秒仍未重连时发送下线通知
+// taskClose 为断开的连接对应的 TaskClose，已被新连接替换时忽略
+func OnAgentDisconnect(serverID uint64, taskClose chan error) {
+	serverConnLock.Lock()
+	defer serverConnLock.Unlock()
+
+	// 在 serverConnLock 内检查，新连接替换 TaskClose 后才会调用 OnAgentConnect，不会误发下线通知
+	ServerLock.RLock()
+	server, ok := ServerList[serverID]
+	var current bool
+	if ok {
+		server.TaskCloseLock.Lock()
+		current = server.TaskClose == taskClose
+		server.TaskCloseLock.Unlock()
+	}
+	ServerLock.RUnlock()
+	if !current {
+		return
+	}
+
+	st := serverConnStates[serverID]
+	if st == nil {
+		st = &serverConnState{}
+		serverConnStates[serverID] = st
+	}
+	if st.offline || st.offlineTimer != nil {
+		return
+	}
+
+	threshold := time.Duration(Conf.ServerOfflineThreshold) * time.Second
+	var timer *time.Timer
+	timer = time.AfterFunc(threshold, func() {
+		serverConnLock.Lock()
+		defer serverConnLock.Unlock()
+		if serverConnStates[serverID] != st || st.offlineTimer != timer {
+			return
+		}
+		st.offlineTimer = nil
+		st.offline = true
+		if !sendServerStateNotification(serverID, Localizer.T("Server Offline"), Localizer.Tf("no connection for %s", threshold)) {
+			// 服务器已删除
+			delete(serverConnStates, serverID)
+		}
+	})
+	st.offlineTimer = timer
+}
+
+// sendServerStateNotification 发送上下线通知，未开启通知时忽略，服务器已删除时返回 false
+func sendServerStateNotification(serverID uint64, event, detail string) bool {
+	ServerLock.RLock()
+	server, ok := ServerList[serverID]
+	var name string
+	if ok {
+		name = server.Name
+	}
+	ServerLock.RUnlock()
+	if !ok {
+		return false
+	}
+	if !Conf.EnableServerStateNotification {
+		return true
+	}
+
+	desc := fmt.Sprintf("[%s] %s", event, name)
+	if detail != "" {
+		desc += ", " + detail
+	}
+	go SendNotification(Conf.ServerStateNotificationGroupID, desc, nil)
+	return true
+}
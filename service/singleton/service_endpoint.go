@@ -0,0 +1,166 @@
+package singleton
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	pb "github.com/nezhahq/nezha/proto"
+)
+
+type endpointRoundKey struct {
+	serviceID uint64
+	reporter  uint64
+}
+
+// endpointRound 多目标监控在一台服务器上一轮检测的各目标结果
+type endpointRound struct {
+	targets []string
+	results []*pb.TaskResult
+	pending int
+}
+
+// endpointDelays 多目标监控各目标的平均延迟，[target] -> pingStore
+type endpointDelays map[string]*pingStore
+
+func (d endpointDelays) add(delays map[string]float32) {
+	for target, delay := range delays {
+		s, ok := d[target]
+		if !ok {
+			s = &pingStore{}
+			d[target] = s
+		}
+		s.count++
+		s.ping = (s.ping*float32(s.count-1) + delay) / float32(s.count)
+	}
+}
+
+// take 返回各目标平均延迟的 JSON 并清空统计，没有数据时返回空字符串
+func (d endpointDelays) take() string {
+	if len(d) == 0 {
+		return ""
+	}
+	avg := make(map[string]float32, len(d))
+	for target, s := range d {
+		avg[target] = s.ping
+		delete(d, target)
+	}
+	data, _ := utils.Json.Marshal(avg)
+	return string(data)
+}
+
+// collectEndpointResult 收集多目标监控单个目标的结果，一轮检测的全部目标都上报后返回合并的结果与各目标的延迟
+// 上一轮仍有目标未上报时又收到同一目标的结果，视为上一轮已结束，未上报的目标按失败处理
+func (ss *ServiceSentinel) collectEndpointResult(r ReportData, serviceID uint64, endpoint int) (*pb.TaskResult, map[string]float32, bool) {
+	key := endpointRoundKey{serviceID: serviceID, reporter: r.Reporter}
+
+	ss.ServicesLock.RLock()
+	var service model.Service
+	s, ok := ss.Services[serviceID]
+	if ok {
+		service = *s
+	}
+	ss.ServicesLock.RUnlock()
+	if !ok {
+		delete(ss.endpointRounds, key)
+		return nil, nil, false
+	}
+	targets := service.Targets()
+	if endpoint >= len(targets) {
+		return nil, nil, false
+	}
+
+	var flushed *pb.TaskResult
+	var flushedDelays map[string]float32
+	round := ss.endpointRounds[key]
+	if round != nil && !slices.Equal(round.targets, targets) {
+		// 监控的目标已修改，丢弃未完成的一轮
+		round = nil
+	} else if round != nil && round.results[endpoint] != nil {
+		flushed, flushedDelays = combineEndpointResults(&service, round.targets, round.results)
+		round = nil
+	}
+	if round == nil {
+		round = &endpointRound{
+			targets: targets,
+			results: make([]*pb.TaskResult, len(targets)),
+			pending: len(targets),
+		}
+		ss.endpointRounds[key] = round
+	}
+
+	round.results[endpoint] = r.Data
+	round.pending--
+	if round.pending == 0 {
+		delete(ss.endpointRounds, key)
+		result, delays := combineEndpointResults(&service, round.targets, round.results)
+		return result, delays, true
+	}
+	if flushed != nil {
+		return flushed, flushedDelays, true
+	}
+	return nil, nil, false
+}
+
+// ProbeServiceTargets 在面板上并发检测监控的全部目标，返回按判定方式合并的结果与可用目标的延迟
+func ProbeServiceTargets(ctx context.Context, service *model.Service) (*pb.TaskResult, map[string]float32) {
+	targets := service.Targets()
+	results := make([]*pb.TaskResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			endpoint := *service
+			endpoint.Target = target
+			results[i] = ProbeService(ctx, &endpoint)
+		}()
+	}
+	wg.Wait()
+	return combineEndpointResults(service, targets, results)
+}
+
+// combineEndpointResults 按监控的判定方式合并各目标的结果，返回合并的结果与可用目标的延迟
+// 延迟取任一模式下最快、全部模式下最慢的可用目标；证书监控的延迟为剩余天数，均取最早过期的证书
+func combineEndpointResults(service *model.Service, targets []string, results []*pb.TaskResult) (*pb.TaskResult, map[string]float32) {
+	preferLower := service.TargetMode == model.ServiceTargetModeAny || service.Type == model.TaskTypeTLSExpiry
+
+	delays := make(map[string]float32, len(targets))
+	var best *pb.TaskResult
+	var failures []string
+	for i, target := range targets {
+		res := results[i]
+		if res == nil || !res.Successful {
+			msg := "no result"
+			if res != nil && res.Data != "" {
+				msg = res.Data
+			}
+			failures = append(failures, fmt.Sprintf("%s (%s)", msg, target))
+			continue
+		}
+		delays[target] = res.Delay
+		if best == nil || (preferLower && res.Delay < best.Delay) || (!preferLower && res.Delay > best.Delay) {
+			best = res
+		}
+	}
+
+	combined := &pb.TaskResult{Id: service.ID, Type: uint64(service.Type)}
+	if service.TargetMode == model.ServiceTargetModeAll {
+		combined.Successful = len(failures) == 0
+	} else {
+		combined.Successful = best != nil
+	}
+	if best != nil {
+		combined.Delay = best.Delay
+	}
+	if combined.Successful {
+		combined.Data = best.Data
+	} else {
+		combined.Data = strings.Join(failures, "; ")
+	}
+	return combined, delays
+}
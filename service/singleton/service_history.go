@@ -0,0 +1,57 @@
+package singleton
+
+import (
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/nezhahq/nezha/model"
+)
+
+// ServiceHistoryOrder 服务监控记录的排序方式，仅允许以下取值以避免拼接任意 SQL
+const (
+	ServiceHistoryOrderAsc  = "service_id, created_at"
+	ServiceHistoryOrderDesc = "service_id, created_at DESC"
+)
+
+// GetServiceHistories 按 filter 查询 [from, to] 范围内的服务监控记录
+// offset 与 limit 对每个服务分别生效，避免记录较多的服务占满整页，limit 为 0 时返回全部匹配的记录
+func GetServiceHistories(filter map[string]any, from, to time.Time, offset, limit int, orderBy string) ([]*model.ServiceHistory, error) {
+	query := func() *gorm.DB {
+		return DB.Model(&model.ServiceHistory{}).Select("service_id, created_at, server_id, avg_delay, endpoint_delays_raw").
+			Where(filter).Where("created_at >= ? AND created_at <= ?", from, to)
+	}
+	if orderBy == "" {
+		orderBy = ServiceHistoryOrderAsc
+	}
+
+	var histories []*model.ServiceHistory
+	if offset <= 0 && limit <= 0 {
+		slog.Debug("querying service histories without limit", "filter", filter)
+		if err := query().Order(orderBy).Scan(&histories).Error; err != nil {
+			return nil, err
+		}
+		return histories, nil
+	}
+
+	var serviceIDs []uint64
+	if err := query().Distinct("service_id").Order("service_id").Pluck("service_id", &serviceIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, serviceID := range serviceIDs {
+		tx := query().Where("service_id = ?", serviceID).Order(orderBy)
+		if offset > 0 {
+			tx = tx.Offset(offset)
+		}
+		if limit > 0 {
+			tx = tx.Limit(limit)
+		}
+		var part []*model.ServiceHistory
+		if err := tx.Scan(&part).Error; err != nil {
+			return nil, err
+		}
+		histories = append(histories, part...)
+	}
+	return histories, nil
+}
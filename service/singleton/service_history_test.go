@@ -0,0 +1,75 @@
+package singleton
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/nezhahq/nezha/model"
+)
+
+func TestGetServiceHistoriesLimitPerService(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sqlite.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.ServiceHistory{}); err != nil {
+		t.Fatal(err)
+	}
+	oldDB := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = oldDB
+	})
+
+	now := time.Now()
+	// 服务 1 的记录远多于服务 2，分页时不应挤占服务 2 的记录
+	for serviceID, count := range map[uint64]int{1: 10, 2: 3} {
+		for i := 0; i < count; i++ {
+			h := model.ServiceHistory{ServiceID: serviceID, ServerID: 1, AvgDelay: float32(i), CreatedAt: now.Add(-time.Duration(i) * time.Minute)}
+			if err := db.Create(&h).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cases := []struct {
+		offset, limit int
+		orderBy       string
+		expect        map[uint64]int
+		first         map[uint64]float32
+	}{
+		{0, 0, ServiceHistoryOrderAsc, map[uint64]int{1: 10, 2: 3}, map[uint64]float32{1: 9, 2: 2}},
+		{0, 5, ServiceHistoryOrderAsc, map[uint64]int{1: 5, 2: 3}, map[uint64]float32{1: 9, 2: 2}},
+		{0, 2, ServiceHistoryOrderDesc, map[uint64]int{1: 2, 2: 2}, map[uint64]float32{1: 0, 2: 0}},
+		{2, 2, ServiceHistoryOrderDesc, map[uint64]int{1: 2, 2: 1}, map[uint64]float32{1: 2, 2: 2}},
+		{4, 0, ServiceHistoryOrderAsc, map[uint64]int{1: 6}, map[uint64]float32{1: 5}},
+	}
+	for _, c := range cases {
+		histories, err := GetServiceHistories(map[string]any{"server_id": 1}, now.Add(-time.Hour), now, c.offset, c.limit, c.orderBy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[uint64]int)
+		first := make(map[uint64]float32)
+		for _, h := range histories {
+			if _, ok := first[h.ServiceID]; !ok {
+				first[h.ServiceID] = h.AvgDelay
+			}
+			counts[h.ServiceID]++
+		}
+		if len(counts) != len(c.expect) {
+			t.Fatalf("offset %d, limit %d: expected %v, but got %v", c.offset, c.limit, c.expect, counts)
+		}
+		for id, n := range c.expect {
+			if counts[id] != n || first[id] != c.first[id] {
+				t.Fatalf("offset %d, limit %d, service %d: expected %d rows starting at %v, but got %d rows starting at %v",
+					c.offset, c.limit, id, n, c.first[id], counts[id], first[id])
+			}
+		}
+	}
+}
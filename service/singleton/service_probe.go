@@ -0,0 +1,190 @@
+package singleton
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/nezhahq/nezha/model"
+	"github.com/nezhahq/nezha/pkg/utils"
+	pb "github.com/nezhahq/nezha/proto"
+)
+
+// ServiceProbeTimeout 面板执行单次监控的超时时间
+const ServiceProbeTimeout = time.Second * 10
+
+// ProbeService 在面板上对监控目标执行一次检测，结果格式与 Agent 上报的一致
+func ProbeService(ctx context.Context, task *model.Service) *pb.TaskResult {
+	ctx, cancel := context.WithTimeout(ctx, ServiceProbeTimeout)
+	defer cancel()
+
+	result := &pb.TaskResult{
+		Id:   task.ID,
+		Type: uint64(task.Type),
+	}
+	start := time.Now()
+	var err error
+	switch task.Type {
+	case model.TaskTypeHTTPGet:
+		_, err = fetchServiceBody(ctx, task, false)
+	case model.TaskTypeHTTPKeyword:
+		var body []byte
+		if body, err = fetchServiceBody(ctx, task, true); err == nil {
+			var matched bool
+			if matched, err = task.MatchBody(body); err == nil && !matched {
+				err = errors.New(Localizer.T("response body does not match the expected pattern"))
+			}
+		}
+	case model.TaskTypeTCPPing:
+		var conn net.Conn
+		if conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", task.Target); err == nil {
+			conn.Close()
+		}
+	case model.TaskTypeICMPPing:
+		err = pingICMP(ctx, task.Target)
+	case model.TaskTypeTLSExpiry:
+		checkTLSExpiry(ctx, task, result)
+		return result
+	default:
+		err = fmt.Errorf("unsupported service type %d", task.Type)
+	}
+	result.Delay = float32(time.Since(start).Microseconds()) / 1000.0
+	if err != nil {
+		result.Data = err.Error()
+	}
+	result.Successful = err == nil
+	return result
+}
+
+// fetchServiceBody 请求监控目标，附加监控设置的认证信息与请求头
+func fetchServiceBody(ctx context.Context, task *model.Service, readBody bool) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, task.Target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if task.HasHTTPRequestOptions() {
+		headers, err := task.HTTPRequestHeaders(Conf.JWTSecretKey)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			if strings.EqualFold(k, "Host") {
+				req.Host = v
+				continue
+			}
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := utils.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 399 {
+		return nil, errors.New(resp.Status)
+	}
+	if !readBody {
+		return nil, nil
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, model.ServiceBodyMaxSize))
+}
+
+// checkTLSExpiry 读取目标的叶子证书，Delay 为距离过期的天数
+// 自签名等无法验证的证书同样上报过期时间，Data 格式与 Agent 上报的证书信息一致：颁发者|过期时间|主题
+func checkTLSExpiry(ctx context.Context, task *model.Service, result *pb.TaskResult) {
+	addr, serverName, err := task.TLSTarget()
+	if err != nil {
+		result.Data = err.Error()
+		return
+	}
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		result.Data = "SSL证书错误：" + err.Error()
+		return
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		result.Data = "SSL证书错误：no certificate"
+		return
+	}
+	leaf := certs[0]
+	result.Delay = float32(time.Until(leaf.NotAfter).Hours() / 24)
+	result.Data = fmt.Sprintf("%s|%s|%s", leaf.Issuer.CommonName, leaf.NotAfter.Format("2006-01-02 15:04:05 -0700 MST"), leaf.Subject.CommonName)
+	result.Successful = time.Now().Before(leaf.NotAfter)
+}
+
+// pingICMP 发送一次 ICMP Echo，优先使用无需特权的 ICMP 套接字，不可用时尝试原始套接字
+func pingICMP(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no address found for %s", host)
+	}
+	ip := addrs[0].IP
+
+	network, rawNetwork, listenAddr, protocol := "udp4", "ip4:icmp", "0.0.0.0", 1
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, rawNetwork, listenAddr, protocol = "udp6", "ip6:ipv6-icmp", "::", 58
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	conn, err := icmp.ListenPacket(network, listenAddr)
+	raw := err != nil
+	if raw {
+		if conn, err = icmp.ListenPacket(rawNetwork, listenAddr); err != nil {
+			return err
+		}
+		dst = &net.IPAddr{IP: ip}
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// 非特权套接字的 ID 由内核改写，只有原始套接字需要校验 ID
+	id := os.Getpid() & 0xffff
+	msg := icmp.Message{Type: echoType, Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("nezha")}}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(b, dst); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		reply, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.Seq == 1 && (!raw || echo.ID == id) {
+			return nil
+		}
+	}
+}
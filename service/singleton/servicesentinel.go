@@ -2,25 +2,29 @@ package singleton
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/robfig/cron/v3"
+
 	"github.com/nezhahq/nezha/model"
 	pb "github.com/nezhahq/nezha/proto"
 )
 
 const (
-	_CurrentStatusSize = 30 // 统计 15 分钟内的数据为当前状态
+	_CurrentStatusSize = 30  // 统计 15 分钟内的数据为当前状态
+	QualitySampleSize  = 100 // 每个服务器保留的网络质量采样点数量
 )
 
 var ServiceSentinelShared *ServiceSentinel
 
 type ReportData struct {
 	Data     *pb.TaskResult
-	Reporter uint64
+	Reporter uint64 // 上报结果的服务器 ID，面板自身执行的监控为 0
 }
 
 // _TodayStatsOfService 今日监控记录
@@ -42,8 +46,11 @@ func NewServiceSentinel(serviceSentinelDispatchBus chan<- model.Service) {
 		serviceResponseDataStoreCurrentDown:     make(map[uint64]uint64),
 		serviceResponseDataStoreCurrentAvgDelay: make(map[uint64]float32),
 		serviceResponsePing:                     make(map[uint64]map[uint64]*pingStore),
+		serviceEndpointDelays:                   make(map[uint64]endpointDelays),
+		endpointRounds:                          make(map[endpointRoundKey]*endpointRound),
 		Services:                                make(map[uint64]*model.Service),
 		tlsCertCache:                            make(map[uint64]string),
+		qualitySamples:                          make(map[uint64]map[uint64][]qualitySample),
 		// 30天数据缓存
 		monthlyStatus: make(map[uint64]*model.ServiceResponseItem),
 		dispatchBus:   serviceSentinelDispatchBus,
@@ -101,9 +108,16 @@ type ServiceSentinel struct {
 	serviceResponseDataStoreCurrentDown     map[uint64]uint64                // [service_id] -> 当前服务离线计数
 	serviceResponseDataStoreCurrentAvgDelay map[uint64]float32               // [service_id] -> 当前服务离线计数
 	serviceResponsePing                     map[uint64]map[uint64]*pingStore // [service_id] -> ClientID -> delay
+	serviceEndpointDelays                   map[uint64]endpointDelays        // [service_id] -> 多目标监控各目标的平均延迟
 	lastStatus                              map[uint64]int
 	tlsCertCache                            map[uint64]string
 
+	// 多目标监控尚未合并的检测结果，只在 worker 中访问
+	endpointRounds map[endpointRoundKey]*endpointRound
+
+	qualitySamplesLock sync.RWMutex
+	qualitySamples     map[uint64]map[uint64][]qualitySample // [service_id] -> ClientID -> 最近的采样点
+
 	ServicesLock sync.RWMutex
 	Services     map[uint64]*model.Service
 
@@ -118,8 +132,14 @@ type indexStore struct {
 }
 
 type pingStore struct {
-	count int
-	ping  float32
+	count     int
+	ping      float32
+	endpoints endpointDelays // 多目标监控各目标的平均延迟
+}
+
+type qualitySample struct {
+	successful bool
+	delay      float32
 }
 
 func (ss *ServiceSentinel) refreshMonthlyServiceStatus() {
@@ -187,12 +207,12 @@ func (ss *ServiceSentinel) loadServiceHistory() {
 
 	for i := 0; i < len(services); i++ {
 		task := *services[i]
-		// 通过cron定时将服务监控任务传递给任务调度管道
-		services[i].CronJobID, err = Cron.AddFunc(task.CronSpec(), func() {
-			ss.dispatchBus <- task
-		})
-		if err != nil {
-			panic(err)
+		// 通过cron定时将服务监控任务传递给任务调度管道，已暂停的监控不派发
+		// 每个监控按各自的间隔错开调度，见 model.Service.Schedule
+		if !task.Paused {
+			services[i].CronJobID = Cron.Schedule(task.Schedule(), cron.FuncJob(func() {
+				ss.dispatchBus <- task
+			}))
 		}
 		ss.Services[services[i].ID] = services[i]
 		ss.serviceCurrentStatusData[services[i].ID] = make([]*pb.TaskResult, _CurrentStatusSize)
@@ -237,13 +257,11 @@ func (ss *ServiceSentinel) OnServiceUpdate(m model.Service) error {
 	ss.ServicesLock.Lock()
 	defer ss.ServicesLock.Unlock()
 
-	var err error
-	// 写入新任务
-	m.CronJobID, err = Cron.AddFunc(m.CronSpec(), func() {
-		ss.dispatchBus <- m
-	})
-	if err != nil {
-		return err
+	// 写入新任务，已暂停的监控不再调度
+	if !m.Paused {
+		m.CronJobID = Cron.Schedule(m.Schedule(), cron.FuncJob(func() {
+			ss.dispatchBus <- m
+		}))
 	}
 	if ss.Services[m.ID] != nil {
 		// 停掉旧任务
@@ -261,6 +279,7 @@ func (ss *ServiceSentinel) OnServiceUpdate(m model.Service) error {
 	}
 	// 更新这个任务
 	ss.Services[m.ID] = &m
+	InvalidateServiceStatsCache()
 	return nil
 }
 
@@ -280,14 +299,72 @@ func (ss *ServiceSentinel) OnServiceDelete(ids []uint64) {
 		delete(ss.serviceResponseDataStoreCurrentDown, id)
 		delete(ss.serviceResponseDataStoreCurrentAvgDelay, id)
 		delete(ss.tlsCertCache, id)
+		delete(ss.serviceEndpointDelays, id)
 		delete(ss.serviceStatusToday, id)
 
 		// 停掉定时任务
-		Cron.Remove(ss.Services[id].CronJobID)
+		if service, ok := ss.Services[id]; ok {
+			Cron.Remove(service.CronJobID)
+		}
 		delete(ss.Services, id)
 
 		delete(ss.monthlyStatus, id)
 	}
+
+	ss.qualitySamplesLock.Lock()
+	defer ss.qualitySamplesLock.Unlock()
+	for _, id := range ids {
+		delete(ss.qualitySamples, id)
+	}
+	InvalidateServiceStatsCache()
+}
+
+func (ss *ServiceSentinel) addQualitySample(serviceID, serverID uint64, sample qualitySample) {
+	ss.qualitySamplesLock.Lock()
+	defer ss.qualitySamplesLock.Unlock()
+
+	samples, ok := ss.qualitySamples[serviceID]
+	if !ok {
+		samples = make(map[uint64][]qualitySample)
+		ss.qualitySamples[serviceID] = samples
+	}
+	samples[serverID] = append(samples[serverID], sample)
+	if len(samples[serverID]) > QualitySampleSize {
+		samples[serverID] = samples[serverID][len(samples[serverID])-QualitySampleSize:]
+	}
+}
+
+// NetworkQuality 计算最近 window 个采样点的丢包率与抖动（相邻成功采样延迟差的平均值）
+func (ss *ServiceSentinel) NetworkQuality(serviceID, serverID uint64, window int) (loss, jitter float64, ok bool) {
+	ss.qualitySamplesLock.RLock()
+	defer ss.qualitySamplesLock.RUnlock()
+
+	samples := ss.qualitySamples[serviceID][serverID]
+	if window <= 0 || len(samples) < window {
+		return 0, 0, false
+	}
+	samples = samples[len(samples)-window:]
+
+	var failed, diffCount int
+	var diffSum float64
+	var prev *qualitySample
+	for i := range samples {
+		if !samples[i].successful {
+			failed++
+			continue
+		}
+		if prev != nil {
+			diffSum += math.Abs(float64(samples[i].delay - prev.delay))
+			diffCount++
+		}
+		prev = &samples[i]
+	}
+
+	loss = float64(failed) * 100 / float64(len(samples))
+	if diffCount > 0 {
+		jitter = diffSum / float64(diffCount)
+	}
+	return loss, jitter, true
 }
 
 func (ss *ServiceSentinel) LoadStats() map[uint64]*model.ServiceResponseItem {
@@ -325,12 +402,31 @@ func (ss *ServiceSentinel) LoadStats() map[uint64]*model.ServiceResponseItem {
 	return ss.monthlyStatus
 }
 
+// ServiceStatsCacheKey 服务状态页结果的缓存键，访客与登录用户看到的内容不同，分开缓存
+func ServiceStatsCacheKey(authorized bool) string {
+	return fmt.Sprintf("service::stats::%t", authorized)
+}
+
+// InvalidateServiceStatsCache 监控项变更后清除状态页缓存，使修改尽快生效
+func InvalidateServiceStatsCache() {
+	Cache.Delete(ServiceStatsCacheKey(true))
+	Cache.Delete(ServiceStatsCacheKey(false))
+}
+
 // worker 服务监控的实际工作流程
 func (ss *ServiceSentinel) worker() {
 	// 从服务状态汇报管道获取汇报的服务数据
 	for r := range ss.serviceReportChannel {
+		// 多目标监控的各目标分别上报，一轮检测全部上报后合并为一条结果
+		var delays map[string]float32
+		if serviceID, endpoint := model.SplitServiceTaskID(r.Data.GetId()); endpoint >= 0 {
+			var ok bool
+			if r.Data, delays, ok = ss.collectEndpointResult(r, serviceID, endpoint); !ok {
+				continue
+			}
+		}
 		if ss.Services[r.Data.GetId()] == nil || ss.Services[r.Data.GetId()].ID == 0 {
-			log.Printf("NEZHA>> 错误的服务监控上报 %+v", r)
+			slog.Warn("错误的服务监控上报", "report", r)
 			continue
 		}
 		mh := r.Data
@@ -346,20 +442,34 @@ func (ss *ServiceSentinel) worker() {
 			}
 			ts.count++
 			ts.ping = (ts.ping*float32(ts.count-1) + mh.Delay) / float32(ts.count)
+			if delays != nil {
+				if ts.endpoints == nil {
+					ts.endpoints = make(endpointDelays)
+				}
+				ts.endpoints.add(delays)
+			}
 			if ts.count == Conf.AvgPingCount {
 				ts.count = 0
 				if err := DB.Create(&model.ServiceHistory{
-					ServiceID: mh.GetId(),
-					AvgDelay:  ts.ping,
-					Data:      mh.Data,
-					ServerID:  r.Reporter,
+					ServiceID:         mh.GetId(),
+					AvgDelay:          ts.ping,
+					Data:              mh.Data,
+					ServerID:          r.Reporter,
+					EndpointDelaysRaw: ts.endpoints.take(),
 				}).Error; err != nil {
-					log.Println("NEZHA>> 服务监控数据持久化失败：", err)
+					slog.Error("服务监控数据持久化失败", "error", err)
 				}
 			}
 			serviceTcpMap[r.Reporter] = ts
+			ss.addQualitySample(mh.GetId(), r.Reporter, qualitySample{successful: mh.Successful, delay: mh.Delay})
 		}
 		ss.serviceResponseDataStoreLock.Lock()
+		if delays != nil {
+			if ss.serviceEndpointDelays[mh.GetId()] == nil {
+				ss.serviceEndpointDelays[mh.GetId()] = make(endpointDelays)
+			}
+			ss.serviceEndpointDelays[mh.GetId()].add(delays)
+		}
 		// 写入当天状态
 		if mh.Successful {
 			ss.serviceStatusToday[mh.GetId()].Delay = (ss.serviceStatusToday[mh.
@@ -415,13 +525,14 @@ func (ss *ServiceSentinel) worker() {
 				t:     currentTime,
 			}
 			if err := DB.Create(&model.ServiceHistory{
-				ServiceID: mh.GetId(),
-				AvgDelay:  ss.serviceResponseDataStoreCurrentAvgDelay[mh.GetId()],
-				Data:      mh.Data,
-				Up:        ss.serviceResponseDataStoreCurrentUp[mh.GetId()],
-				Down:      ss.serviceResponseDataStoreCurrentDown[mh.GetId()],
+				ServiceID:         mh.GetId(),
+				AvgDelay:          ss.serviceResponseDataStoreCurrentAvgDelay[mh.GetId()],
+				Data:              mh.Data,
+				Up:                ss.serviceResponseDataStoreCurrentUp[mh.GetId()],
+				Down:              ss.serviceResponseDataStoreCurrentDown[mh.GetId()],
+				EndpointDelaysRaw: ss.serviceEndpointDelays[mh.GetId()].take(),
 			}).Error; err != nil {
-				log.Println("NEZHA>> 服务监控数据持久化失败：", err)
+				slog.Error("服务监控数据持久化失败", "error", err)
 			}
 		}
 
@@ -436,15 +547,17 @@ func (ss *ServiceSentinel) worker() {
 					// 延迟超过最大值
 					ServerLock.RLock()
 					reporterServer := ServerList[r.Reporter]
-					msg := Localizer.Tf("[Latency] %s %2f > %2f, Reporter: %s", ss.Services[mh.GetId()].Name, mh.Delay, ss.Services[mh.GetId()].MaxLatency, reporterServer.Name)
-					go SendNotification(notificationGroupID, msg, minMuteLabel)
+					msg := Localizer.Tf("[Latency] %s %2f > %2f, Reporter: %s", ss.Services[mh.GetId()].Name, mh.Delay, ss.Services[mh.GetId()].MaxLatency, reporterName(reporterServer))
+					curService, curServer := copyServiceAndServer(ss.Services[mh.GetId()], reporterServer)
+					go SendServiceNotification(notificationGroupID, msg, minMuteLabel, curService, curServer)
 					ServerLock.RUnlock()
 				} else if mh.Delay < ss.Services[mh.GetId()].MinLatency {
 					// 延迟低于最小值
 					ServerLock.RLock()
 					reporterServer := ServerList[r.Reporter]
-					msg := Localizer.Tf("[Latency] %s %2f < %2f, Reporter: %s", ss.Services[mh.GetId()].Name, mh.Delay, ss.Services[mh.GetId()].MinLatency, reporterServer.Name)
-					go SendNotification(notificationGroupID, msg, maxMuteLabel)
+					msg := Localizer.Tf("[Latency] %s %2f < %2f, Reporter: %s", ss.Services[mh.GetId()].Name, mh.Delay, ss.Services[mh.GetId()].MinLatency, reporterName(reporterServer))
+					curService, curServer := copyServiceAndServer(ss.Services[mh.GetId()], reporterServer)
+					go SendServiceNotification(notificationGroupID, msg, maxMuteLabel, curService, curServer)
 					ServerLock.RUnlock()
 				} else {
 					// 正常延迟， 清除静音缓存
@@ -469,7 +582,7 @@ func (ss *ServiceSentinel) worker() {
 
 				reporterServer := ServerList[r.Reporter]
 				notificationGroupID := ss.Services[mh.GetId()].NotificationGroupID
-				notificationMsg := Localizer.Tf("[%s] %s Reporter: %s, Error: %s", StatusCodeToString(stateCode), ss.Services[mh.GetId()].Name, reporterServer.Name, mh.Data)
+				notificationMsg := Localizer.Tf("[%s] %s Reporter: %s, Error: %s", StatusCodeToString(stateCode), ss.Services[mh.GetId()].Name, reporterName(reporterServer), mh.Data)
 				muteLabel := NotificationMuteLabel.ServiceStateChanged(mh.GetId())
 
 				// 状态变更时，清除静音缓存
@@ -477,23 +590,21 @@ func (ss *ServiceSentinel) worker() {
 					UnMuteNotification(notificationGroupID, muteLabel)
 				}
 
-				go SendNotification(notificationGroupID, notificationMsg, muteLabel)
+				curService, curServer := copyServiceAndServer(ss.Services[mh.GetId()], reporterServer)
+				go SendServiceNotification(notificationGroupID, notificationMsg, muteLabel, curService, curServer)
 				ServerLock.RUnlock()
 			}
 
 			// 判断是否需要触发任务
 			isNeedTriggerTask := ss.Services[mh.GetId()].EnableTriggerTask && lastStatus != 0
 			if isNeedTriggerTask {
-				ServerLock.RLock()
-				reporterServer := ServerList[r.Reporter]
-				ServerLock.RUnlock()
-
+				// 面板自身执行的监控没有上报服务器，此时 r.Reporter 为 0
 				if stateCode == StatusGood && lastStatus != stateCode {
 					// 当前状态正常 前序状态非正常时 触发恢复任务
-					go SendTriggerTasks(ss.Services[mh.GetId()].RecoverTriggerTasks, reporterServer.ID)
+					go SendTriggerTasks(ss.Services[mh.GetId()].RecoverTriggerTasks, r.Reporter)
 				} else if lastStatus == StatusGood && lastStatus != stateCode {
 					// 前序状态正常 当前状态非正常时 触发失败任务
-					go SendTriggerTasks(ss.Services[mh.GetId()].FailTriggerTasks, reporterServer.ID)
+					go SendTriggerTasks(ss.Services[mh.GetId()].FailTriggerTasks, r.Reporter)
 				}
 			}
 
@@ -577,6 +688,24 @@ func (ss *ServiceSentinel) worker() {
 	}
 }
 
+// reporterName 返回上报监控结果的服务器名称，面板自身执行的监控没有上报服务器
+func reporterName(server *model.Server) string {
+	if server == nil {
+		return Localizer.T("Dashboard")
+	}
+	return server.Name
+}
+
+// copyServiceAndServer 复制服务与上报服务器的当前状态，供异步发送通知时渲染模板使用
+func copyServiceAndServer(service *model.Service, server *model.Server) (*model.Service, *model.Server) {
+	curService := *service
+	var curServer *model.Server
+	if server != nil {
+		curServer = server.Snapshot()
+	}
+	return &curService, curServer
+}
+
 const (
 	_ = iota
 	StatusNoData
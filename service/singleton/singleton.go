@@ -2,10 +2,11 @@ package singleton
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
-	"gorm.io/driver/sqlite"
+	"github.com/robfig/cron/v3"
 	"gorm.io/gorm"
 
 	"github.com/nezhahq/nezha/model"
@@ -14,8 +15,17 @@ import (
 
 var Version = "debug"
 
+// StartedAt 面板的启动时间
+var StartedAt = time.Now()
+
+// DataDir 数据目录，新增的持久化文件应存放于此
+var DataDir = "data"
+
 var (
-	Conf  *model.Config
+	Conf *model.Config
+	// ConfLock 替换 Conf 时持有，修改时先复制当前配置，避免并发的修改相互覆盖
+	ConfLock sync.Mutex
+
 	Cache *cache.Cache
 	DB    *gorm.DB
 	Loc   *time.Location
@@ -36,6 +46,7 @@ func LoadSingleton() {
 	initI18n()          // 加载本地化服务
 	loadNotifications() // 加载通知服务
 	loadServers()       // 加载服务器列表
+	loadServerGroups()  // 加载服务器分组
 	loadCronTasks()     // 加载定时任务
 	initNAT()
 	initDDNS()
@@ -48,28 +59,7 @@ func InitConfigFromPath(path string) {
 	if err != nil {
 		panic(err)
 	}
-}
-
-// InitDBFromPath 从给出的文件路径中加载数据库
-func InitDBFromPath(path string) {
-	var err error
-	DB, err = gorm.Open(sqlite.Open(path), &gorm.Config{
-		CreateBatchSize: 200,
-	})
-	if err != nil {
-		panic(err)
-	}
-	if Conf.Debug {
-		DB = DB.Debug()
-	}
-	err = DB.AutoMigrate(model.Server{}, model.User{}, model.ServerGroup{}, model.NotificationGroup{},
-		model.Notification{}, model.AlertRule{}, model.Service{}, model.NotificationGroupNotification{},
-		model.ServiceHistory{}, model.Cron{}, model.Transfer{}, model.ServerGroupServer{}, model.UserGroup{},
-		model.UserGroupUser{}, model.NAT{}, model.DDNSProfile{}, model.NotificationGroupNotification{},
-		model.WAF{})
-	if err != nil {
-		panic(err)
-	}
+	InitLogger()
 }
 
 // RecordTransferHourlyUsage 对流量记录进行打点
@@ -99,15 +89,56 @@ func RecordTransferHourlyUsage() {
 	log.Println("NEZHA>> Cron 流量统计入库", len(txs), DB.Create(txs).Error)
 }
 
-// CleanServiceHistory 清理无效或过时的 监控记录 和 流量记录
-func CleanServiceHistory() {
+var (
+	cleanHistoryEntryID cron.EntryID
+	cleanHistoryLock    sync.Mutex
+)
+
+// SetCleanHistorySchedule 按给出的 cron 表达式（重新）注册历史数据清理任务
+func SetCleanHistorySchedule(spec string) error {
+	id, err := Cron.AddFunc(spec, func() { CleanServiceHistory() })
+	if err != nil {
+		return err
+	}
+	if cleanHistoryEntryID != 0 {
+		Cron.Remove(cleanHistoryEntryID)
+	}
+	cleanHistoryEntryID = id
+	return nil
+}
+
+// CleanServiceHistory 清理无效或过时的 监控记录 和 流量记录，返回删除的记录数
+func CleanServiceHistory() int64 {
+	cleanHistoryLock.Lock()
+	defer cleanHistoryLock.Unlock()
+	return cleanServiceHistory()
+}
+
+// TryCleanServiceHistory 立即清理历史记录并压缩数据库，已有清理任务在执行时直接返回 false
+func TryCleanServiceHistory() (int64, bool, error) {
+	if !cleanHistoryLock.TryLock() {
+		return 0, false, nil
+	}
+	defer cleanHistoryLock.Unlock()
+	deleted := cleanServiceHistory()
+	// 删除记录后 sqlite 不会自动释放磁盘空间
+	return deleted, true, DB.Exec("VACUUM").Error
+}
+
+func cleanServiceHistory() int64 {
+	var deleted int64
 	// 清理已被删除的服务器的监控记录与流量记录
-	DB.Unscoped().Delete(&model.ServiceHistory{}, "created_at < ? OR service_id NOT IN (SELECT `id` FROM services)", time.Now().AddDate(0, 0, -30))
+	deleted += DB.Unscoped().Delete(&model.ServiceHistory{}, "created_at < ? OR service_id NOT IN (SELECT id FROM services)", time.Now().AddDate(0, 0, -Conf.ServiceHistoryRetentionDays)).RowsAffected
 	// 由于网络监控记录的数据较多，并且前端仅使用了 1 天的数据
 	// 考虑到 sqlite 数据量问题，仅保留一天数据，
 	// server_id = 0 的数据会用于/service页面的可用性展示
-	DB.Unscoped().Delete(&model.ServiceHistory{}, "(created_at < ? AND server_id != 0) OR service_id NOT IN (SELECT `id` FROM services)", time.Now().AddDate(0, 0, -1))
-	DB.Unscoped().Delete(&model.Transfer{}, "server_id NOT IN (SELECT `id` FROM servers)")
+	deleted += DB.Unscoped().Delete(&model.ServiceHistory{}, "(created_at < ? AND server_id != 0) OR service_id NOT IN (SELECT id FROM services)", time.Now().AddDate(0, 0, -1)).RowsAffected
+	deleted += DB.Unscoped().Delete(&model.Transfer{}, "server_id NOT IN (SELECT id FROM servers)").RowsAffected
+	// 计划任务执行记录与监控记录保留相同的天数
+	deleted += DB.Unscoped().Delete(&model.CronHistory{}, "created_at < ? OR cron_id NOT IN (SELECT id FROM crons)", time.Now().AddDate(0, 0, -Conf.ServiceHistoryRetentionDays)).RowsAffected
+	deleted += DB.Unscoped().Delete(&model.AuditLog{}, "created_at < ?", time.Now().AddDate(0, 0, -Conf.AuditLogRetentionDays)).RowsAffected
+	// 清理已过期的登录会话
+	deleted += DB.Unscoped().Delete(&model.Session{}, "expires_at < ?", time.Now()).RowsAffected
 	// 计算可清理流量记录的时长
 	var allServerKeep time.Time
 	specialServerKeep := make(map[uint64]time.Time)
@@ -138,14 +169,25 @@ func CleanServiceHistory() {
 			}
 		}
 	}
+	createdBefore := model.DateTimeExpr(DB, "created_at") + " < " + model.DateTimeExpr(DB, "?")
+	// 流量记录至少保留配置的天数，告警规则需要更早的数据时以规则为准
+	retentionStart := time.Now().AddDate(0, 0, -Conf.TransferHistoryRetentionDays).UTC()
+	if allServerKeep.IsZero() || allServerKeep.After(retentionStart) {
+		allServerKeep = retentionStart
+	}
 	for id, couldRemove := range specialServerKeep {
-		DB.Unscoped().Delete(&model.Transfer{}, "server_id = ? AND datetime(`created_at`) < datetime(?)", id, couldRemove)
+		if couldRemove.After(retentionStart) {
+			couldRemove = retentionStart
+		}
+		deleted += DB.Unscoped().Delete(&model.Transfer{}, "server_id = ? AND "+createdBefore, id, couldRemove).RowsAffected
 	}
-	if allServerKeep.IsZero() {
-		DB.Unscoped().Delete(&model.Transfer{}, "server_id NOT IN (?)", specialServerIDs)
+	if len(specialServerIDs) == 0 {
+		// NOT IN 空列表会被渲染为 NOT IN (NULL)，不会匹配任何记录
+		deleted += DB.Unscoped().Delete(&model.Transfer{}, createdBefore, allServerKeep).RowsAffected
 	} else {
-		DB.Unscoped().Delete(&model.Transfer{}, "server_id NOT IN (?) AND datetime(`created_at`) < datetime(?)", specialServerIDs, allServerKeep)
+		deleted += DB.Unscoped().Delete(&model.Transfer{}, "server_id NOT IN (?) AND "+createdBefore, specialServerIDs, allServerKeep).RowsAffected
 	}
+	return deleted
 }
 
 // IPDesensitize 根据设置选择是否对IP进行打码处理 返回处理后的IP(关闭打码则返回原IP)
@@ -0,0 +1,138 @@
+package singleton
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nezhahq/nezha/model"
+)
+
+// 服务器流量配额
+var (
+	transferQuotaLock   sync.Mutex
+	transferQuotaStates map[uint64]*transferQuotaState // [server_id] -> 当前周期的配额使用情况
+)
+
+type transferQuotaState struct {
+	key    string // 配额设置，修改后重新统计
+	rule   *model.Rule
+	stats  *model.CycleTransferStats
+	notice *model.TransferQuotaNotice // 当前周期内已通知的最高阈值
+}
+
+// loadTransferQuotaNotices 恢复各服务器在当前周期内已通知的阈值，避免重启后重复通知
+func loadTransferQuotaNotices() {
+	var notices []*model.TransferQuotaNotice
+	if err := DB.Find(&notices).Error; err != nil {
+		panic(err)
+	}
+	transferQuotaLock.Lock()
+	defer transferQuotaLock.Unlock()
+	transferQuotaStates = make(map[uint64]*transferQuotaState)
+	for _, n := range notices {
+		transferQuotaStates[n.ServerID] = &transferQuotaState{key: n.QuotaKey, notice: n}
+	}
+}
+
+// checkTransferQuota 统计设置了流量配额的服务器在当前周期内的用量，超过通知阈值时发送通知
+// 复用周期流量报警规则的统计，用量按距离配额的远近间隔数分钟重新查询
+func checkTransferQuota() {
+	// 先复制设置了配额的服务器，查询用量时不持有 ServerLock
+	var servers []*model.Server
+	active := make(map[uint64]bool)
+	ServerLock.RLock()
+	for _, server := range ServerList {
+		if server.TransferQuotaRule() == nil || server.State == nil {
+			continue
+		}
+		servers = append(servers, server.Snapshot())
+		active[server.ID] = true
+	}
+	ServerLock.RUnlock()
+
+	transferQuotaLock.Lock()
+	defer transferQuotaLock.Unlock()
+
+	// 服务器已删除或取消了配额
+	var removed []uint64
+	for id := range transferQuotaStates {
+		if !active[id] {
+			delete(transferQuotaStates, id)
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) > 0 {
+		if err := DB.Delete(&model.TransferQuotaNotice{}, "server_id in (?)", removed).Error; err != nil {
+			slog.Error("failed to delete transfer quota notices", "error", err)
+		}
+	}
+
+	now := time.Now()
+	for _, server := range servers {
+		rule := server.TransferQuotaRule()
+		key := server.TransferQuotaKey()
+		st := transferQuotaStates[server.ID]
+		if st == nil || st.key != key || st.rule == nil {
+			notice := &model.TransferQuotaNotice{ServerID: server.ID}
+			if st != nil {
+				notice = st.notice
+			}
+			st = &transferQuotaState{
+				key:  key,
+				rule: rule,
+				stats: &model.CycleTransferStats{
+					ServerName: make(map[uint64]string),
+					Transfer:   make(map[uint64]uint64),
+					NextUpdate: make(map[uint64]time.Time),
+				},
+				notice: notice,
+			}
+			transferQuotaStates[server.ID] = st
+		}
+
+		// 配额设置修改或进入新周期后重置通知状态，并丢弃上一周期缓存的用量
+		if st.notice.Reset(key, st.rule.GetTransferDurationStart()) {
+			delete(st.rule.NextTransferAt, server.ID)
+		}
+
+		_, used, ok := st.rule.Evaluate(st.stats, server, DB, nil)
+		if !ok {
+			continue
+		}
+		threshold := st.notice.Next(uint64(used), server.TransferQuota, Conf.TransferQuotaThresholds)
+		// 静音期间不记录，取消静音后仍超过阈值时补发
+		if threshold == 0 || server.Muted(now) {
+			continue
+		}
+		st.notice.Threshold = threshold
+		if err := DB.Save(st.notice).Error; err != nil {
+			slog.Error("failed to save transfer quota notice", "server", server.ID, "error", err)
+		}
+
+		slog.Info("transfer quota threshold reached", "server", server.ID, "threshold", threshold, "used", uint64(used))
+		var ip string
+		if server.GeoIP != nil {
+			ip = server.GeoIP.IP.Join()
+		}
+		message := fmt.Sprintf("[%s] %s(%s) %s", Localizer.T("Transfer Quota"),
+			server.Name, IPDesensitize(ip),
+			Localizer.Tf("%d%% of the transfer quota used in this cycle: %s / %s", threshold,
+				formatTransferBytes(uint64(used)), formatTransferBytes(server.TransferQuota)))
+		go SendNotification(server.TransferQuotaNotificationGroupID, message, nil, server)
+	}
+}
+
+func formatTransferBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit && exp < 4; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}